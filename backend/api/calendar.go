@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"knot-backend/mail"
+)
+
+// taskEvent picks the event that dates a task: the first one not cancelled.
+func taskEvent(events []mail.CalendarEvent) *mail.CalendarEvent {
+	for i := range events {
+		if !events[i].Cancelled() && !events[i].Start.IsZero() {
+			return &events[i]
+		}
+	}
+	return nil
+}
+
+// eventDue is the due date of a task dated by event.
+func eventDue(event *mail.CalendarEvent) string {
+	return event.Start.In(time.Local).Format("2006-01-02")
+}
+
+// eventMeetingTime is the start of a timed event; all-day events have none.
+func eventMeetingTime(event *mail.CalendarEvent) string {
+	if event.AllDay {
+		return ""
+	}
+	return event.Start.In(time.Local).Format("2006-01-02 15:04")
+}
+
+func eventTimeRange(event *mail.CalendarEvent) string {
+	start, end := event.Start.In(time.Local), event.End.In(time.Local)
+	if event.AllDay {
+		// DTEND of an all-day event is exclusive.
+		last := end.AddDate(0, 0, -1)
+		if !last.After(start) {
+			return start.Format("2006-01-02") + "（全天）"
+		}
+		return start.Format("2006-01-02") + " ~ " + last.Format("2006-01-02") + "（全天）"
+	}
+	switch {
+	case !end.After(start):
+		return start.Format("2006-01-02 15:04")
+	case end.Format("2006-01-02") == start.Format("2006-01-02"):
+		return start.Format("2006-01-02 15:04") + " ~ " + end.Format("15:04")
+	default:
+		return start.Format("2006-01-02 15:04") + " ~ " + end.Format("2006-01-02 15:04")
+	}
+}
+
+// buildMeetingSection renders the 会议信息 section of a work record.
+func buildMeetingSection(event *mail.CalendarEvent) string {
+	var b strings.Builder
+	b.WriteString("## 会议信息\n\n")
+	if s := strings.TrimSpace(event.Summary); s != "" {
+		fmt.Fprintf(&b, "- 主题：%s\n", s)
+	}
+	fmt.Fprintf(&b, "- 时间：%s\n", eventTimeRange(event))
+	if s := strings.TrimSpace(event.Location); s != "" {
+		fmt.Fprintf(&b, "- 地点：%s\n", s)
+	}
+	if o := event.Organizer; o.Address != "" || o.Name != "" {
+		organizer := o.Address
+		if o.Name != "" && o.Address != "" {
+			organizer = fmt.Sprintf("%s <%s>", o.Name, o.Address)
+		} else if o.Name != "" {
+			organizer = o.Name
+		}
+		fmt.Fprintf(&b, "- 组织者：%s\n", organizer)
+	}
+	if event.RRule != "" {
+		fmt.Fprintf(&b, "- 重复规则：%s\n", event.RRule)
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"knot-backend/mail"
+)
+
+func TestHandleCreateFolder_CalendarEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	router := SetupRoutes()
+
+	start := time.Date(2026, 4, 25, 14, 0, 0, 0, time.Local)
+	rr := postFolderCreate(t, router, FolderRequest{
+		Subject:    "预算评审会",
+		Date:       "Mon, 20 Apr 2026 10:00:00 +0800",
+		FromAddr:   "Zhang San",
+		MessageID:  "invite-1@example.com",
+		BasePath:   tmpDir,
+		FolderName: "2026.04.20_预算评审会",
+		Source:     "email",
+		Events: []mail.CalendarEvent{
+			{Summary: "旧时间", Start: start.AddDate(0, 0, -1), Status: "CANCELLED"},
+			{
+				Summary:   "预算评审会",
+				Start:     start,
+				End:       start.Add(90 * time.Minute),
+				Location:  "3楼会议室",
+				Organizer: mail.Address{Name: "Zhang San", Address: "zhangsan@example.com"},
+				RRule:     "FREQ=WEEKLY;COUNT=3",
+			},
+		},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	wrPath := filepath.Join(tmpDir, "2026.04.20_预算评审会", workRecordFileName)
+	data, _ := os.ReadFile(wrPath)
+	record := string(data)
+	for _, want := range []string{
+		"due: 2026-04-25\n",
+		"meeting_time: 2026-04-25 14:00\n",
+		"## 会议信息",
+		"- 时间：2026-04-25 14:00 ~ 15:30",
+		"- 地点：3楼会议室",
+		"- 组织者：Zhang San <zhangsan@example.com>",
+		"- 重复规则：FREQ=WEEKLY;COUNT=3",
+	} {
+		if !strings.Contains(record, want) {
+			t.Fatalf("work record is missing %q:\n%s", want, record)
+		}
+	}
+
+	info, err := parseWorkRecord(wrPath)
+	if err != nil || info.Due != "2026-04-25" || info.MeetingTime != "2026-04-25 14:00" {
+		t.Fatalf("unexpected parsed record: %+v %v", info, err)
+	}
+}
+
+func TestBuildWorkRecordTemplate_AllDayDeadline(t *testing.T) {
+	due := time.Date(2026, 4, 30, 0, 0, 0, 0, time.Local)
+	req := FolderRequest{
+		Subject: "材料提交",
+		Events:  []mail.CalendarEvent{{Summary: "提交截止", Start: due, End: due.AddDate(0, 0, 1), AllDay: true}},
+	}
+	record := buildWorkRecordTemplate(req, "f", "/tmp/f", "email", nil, time.Now())
+	if !strings.Contains(record, "due: 2026-04-30\n") || strings.Contains(record, "meeting_time:") ||
+		!strings.Contains(record, "- 时间：2026-04-30（全天）") {
+		t.Fatalf("unexpected work record:\n%s", record)
+	}
+
+	plain := buildWorkRecordTemplate(FolderRequest{Subject: "材料提交"}, "f", "/tmp/f", "email", nil, time.Now())
+	if strings.Contains(plain, "due:") || strings.Contains(plain, "## 会议信息") {
+		t.Fatalf("unexpected event fields without events:\n%s", plain)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"knot-backend/mail"
+)
+
+// parseCategoryFilter reads the category query parameter, which may be
+// repeated or comma-separated. An empty result means no filter.
+func parseCategoryFilter(query url.Values) (map[string]bool, error) {
+	known := map[string]bool{}
+	for _, c := range mail.Categories {
+		known[c] = true
+	}
+
+	filter := map[string]bool{}
+	for _, value := range query["category"] {
+		for _, c := range strings.Split(value, ",") {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if c == "" || c == "all" {
+				continue
+			}
+			if !known[c] {
+				return nil, fmt.Errorf("未知的邮件分类: %s", c)
+			}
+			filter[c] = true
+		}
+	}
+	return filter, nil
+}
+
+// filterByCategory keeps the items in one of the filter's categories.
+func filterByCategory(list []MailListItem, filter map[string]bool) []MailListItem {
+	if len(filter) == 0 {
+		return list
+	}
+	kept := make([]MailListItem, 0, len(list))
+	for _, item := range list {
+		if filter[item.Category] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
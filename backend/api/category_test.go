@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"knot-backend/mail"
+)
+
+func TestParseCategoryFilter(t *testing.T) {
+	filter, err := parseCategoryFilter(url.Values{"category": {"personal, notification", "all"}})
+	if err != nil || len(filter) != 2 || !filter["personal"] || !filter["notification"] {
+		t.Fatalf("unexpected filter: %v %v", filter, err)
+	}
+	if _, err := parseCategoryFilter(url.Values{"category": {"spam"}}); err == nil {
+		t.Fatal("expected unknown category to be rejected")
+	}
+}
+
+func TestFilterByCategory(t *testing.T) {
+	list := []MailListItem{
+		{MailItem: mail.MailItem{ID: "1", Category: mail.CategoryPersonal}},
+		{MailItem: mail.MailItem{ID: "2", Category: mail.CategoryBulk}},
+		{MailItem: mail.MailItem{ID: "3", Category: mail.CategoryNotification}},
+	}
+	if got := filterByCategory(list, nil); len(got) != 3 {
+		t.Fatalf("expected no filtering, got %v", got)
+	}
+	got := filterByCategory(list, map[string]bool{mail.CategoryBulk: true, mail.CategoryNotification: true})
+	if len(got) != 2 || got[0].ID != "2" || got[1].ID != "3" {
+		t.Fatalf("unexpected filtered list: %v", got)
+	}
+}
+
+func TestHandleMailList_CategoryFilter(t *testing.T) {
+	router := SetupRoutes()
+	connectDemoProvider(t, router)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/mail/list?days=0&scan_path="+url.QueryEscape(t.TempDir())+query, nil))
+		return rr
+	}
+
+	rr := get("&category=personal")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list failed: %d %s", rr.Code, rr.Body.String())
+	}
+	personal := mustListData(t, rr)
+	if len(personal) == 0 {
+		t.Fatal("expected personal demo mails")
+	}
+	for _, item := range personal {
+		if item.Category != mail.CategoryPersonal {
+			t.Fatalf("unexpected category in filtered list: %+v", item)
+		}
+	}
+
+	if rr := get("&category=bulk"); rr.Code != http.StatusOK || len(mustListData(t, rr)) != 0 {
+		t.Fatalf("expected no bulk demo mails: %s", rr.Body.String())
+	}
+	if rr := get("&category=spam"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown category, got %d", rr.Code)
+	}
+}
+
+func mustListData(t *testing.T, rr *httptest.ResponseRecorder) []MailListItem {
+	t.Helper()
+	var resp struct {
+		Data []MailListItem `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CloneTaskRequest starts a new task from an existing one, for recurring
+// work that begins from last time's folder.
+type CloneTaskRequest struct {
+	SourcePath string `json:"source_path"`
+	// Title of the new task; empty keeps the original title.
+	Title string `json:"title"`
+	// IncludeDirs are sub-folders, relative to the task, whose files are
+	// copied, e.g. 10_过程文件. Other folders are recreated empty and
+	// 20_成果输出 is never copied.
+	IncludeDirs []string `json:"include_dirs"`
+	// BasePath is where the clone is created; empty is next to the source.
+	BasePath         string `json:"base_path"`
+	FolderName       string `json:"folder_name"`
+	FolderNameFormat string `json:"folder_name_format"`
+	OnConflict       string `json:"on_conflict"`
+	// Department of the new task; empty keeps the original department.
+	Department string `json:"department"`
+	Template   string `json:"template"`
+	// Date fills the date variables of the folder name; empty is now.
+	Date string `json:"date"`
+}
+
+// cloneIncludeDirs checks the folders a clone copies files from.
+func cloneIncludeDirs(source string, dirs []string) ([]string, error) {
+	var result []string
+	for _, d := range dirs {
+		rel, err := cleanTemplatePath(d)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." {
+			return nil, fmt.Errorf("请选择要复制的子目录")
+		}
+		if rel == taskOutputDirName || strings.HasPrefix(rel, taskOutputDirName+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s 不会被复制", taskOutputDirName)
+		}
+		info, err := os.Lstat(filepath.Join(source, rel))
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("原任务中没有子目录: %s", d)
+		}
+		result = append(result, rel)
+	}
+	return result, nil
+}
+
+// copyTaskSkeleton recreates the folders of source in target and copies the
+// files below include. The work record, hidden entries and symbolic links,
+// which may point outside the workspace roots, are left out.
+func copyTaskSkeleton(source, target string, include []string) error {
+	included := func(rel string) bool {
+		for _, dir := range include {
+			if strings.HasPrefix(rel, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dest := filepath.Join(target, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dest, 0o755)
+		case d.Type().IsRegular() && included(rel):
+			return copyFile(path, dest)
+		}
+		return nil
+	})
+}
+
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// buildDerivedSummary renders the 来源 section of a clone with a link to
+// the task it was cloned from, relative to the new work record.
+func buildDerivedSummary(source, folderPath string) string {
+	target := source
+	if rel, err := filepath.Rel(folderPath, source); err == nil {
+		target = rel
+	}
+	return "## 来源\n\n- 派生自：" + markdownFileLink(filepath.Base(source), filepath.ToSlash(target)) + "\n"
+}
+
+func handleCloneFolder(w http.ResponseWriter, r *http.Request) {
+	var req CloneTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request parameters")
+		return
+	}
+	if strings.TrimSpace(req.SourcePath) == "" {
+		jsonError(w, http.StatusBadRequest, "source_path is required")
+		return
+	}
+
+	source, err := resolveAllowedFolder(req.SourcePath)
+	if err != nil {
+		jsonError(w, pathErrorStatus(err), err.Error())
+		return
+	}
+	parsed, err := readWorkRecord(filepath.Join(source, workRecordFileName))
+	if err != nil {
+		jsonError(w, http.StatusNotFound, fmt.Sprintf("任务目录不存在或缺少%s", workRecordFileName))
+		return
+	}
+	dirs, err := cloneIncludeDirs(source, req.IncludeDirs)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	basePath := strings.TrimSpace(req.BasePath)
+	if basePath == "" {
+		basePath = filepath.Dir(source)
+	}
+	if base, err := canonicalPath(getBaseFolder(basePath)); err == nil && withinRoot(base, source) {
+		jsonError(w, http.StatusBadRequest, "不能在原任务目录内创建克隆")
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = parsed.Info.Title
+	}
+	department := strings.TrimSpace(req.Department)
+	if department == "" {
+		department = parsed.Info.Department
+	}
+	folderNameFormat := req.FolderNameFormat
+	if strings.TrimSpace(req.FolderName) == "" && strings.TrimSpace(folderNameFormat) == "" {
+		folderNameFormat = defaultFolderNameFormat
+	}
+
+	folderReq := FolderRequest{
+		Subject:          title,
+		Date:             req.Date,
+		BasePath:         basePath,
+		FolderName:       req.FolderName,
+		FolderNameFormat: folderNameFormat,
+		OnConflict:       req.OnConflict,
+		Department:       department,
+		Template:         req.Template,
+		Source:           "manual",
+		Hash:             GenerateHash(source + "\n" + title + "\n" + time.Now().Format(time.RFC3339Nano)),
+		derivedFrom:      source,
+		cloneDirs:        dirs,
+	}
+
+	mailMu.Lock()
+	resp, taskErr := createTaskFolder(folderReq, false)
+	mailMu.Unlock()
+	if taskErr != nil {
+		writeTaskError(w, taskErr)
+		return
+	}
+
+	resp["derived_from"] = source
+	resp["message"] = fmt.Sprintf("已基于 %s 创建任务: %s", filepath.Base(source), resp["folder_name"])
+	jsonResponse(w, http.StatusOK, resp)
+}
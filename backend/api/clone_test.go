@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleCloneFolder(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	workDir := t.TempDir()
+	router := SetupRoutes()
+
+	rr := postFolderCreate(t, router, FolderRequest{Subject: "二月统计", BasePath: workDir, FolderName: "2026.02_统计", Department: "财务部", Source: "manual", Hash: "feb"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	source := filepath.Join(workDir, "2026.02_统计")
+	for rel, content := range map[string]string{
+		filepath.Join(taskProcessDirName, "统计表.xlsx"):     "sheet",
+		filepath.Join(taskProcessDirName, "sub", "b.txt"): "notes",
+		filepath.Join(taskOutputDirName, "报告.docx"):       "report",
+		filepath.Join(taskSourceDirName, "通知.pdf"):        "pdf",
+	} {
+		path := filepath.Join(source, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr = postJSON(t, router, http.MethodPost, "/api/folder/clone", CloneTaskRequest{SourcePath: source, IncludeDirs: []string{taskOutputDirName}})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for the output folder, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = postJSON(t, router, http.MethodPost, "/api/folder/clone", CloneTaskRequest{
+		SourcePath:  source,
+		Title:       "三月统计",
+		FolderName:  "2026.03_统计",
+		IncludeDirs: []string{taskProcessDirName},
+	})
+	var resp struct {
+		Path        string `json:"path"`
+		Hash        string `json:"hash"`
+		DerivedFrom string `json:"derived_from"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("clone: %d %s", rr.Code, rr.Body.String())
+	}
+	clone := filepath.Join(workDir, "2026.03_统计")
+	if resp.Path != clone || resp.DerivedFrom != source || resp.Hash == "" || resp.Hash == "feb" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(clone, taskProcessDirName, "sub", "b.txt")); err != nil || string(got) != "notes" {
+		t.Fatalf("expected the process files to be copied: %q %v", got, err)
+	}
+	for _, rel := range []string{filepath.Join(taskOutputDirName, "报告.docx"), filepath.Join(taskSourceDirName, "通知.pdf")} {
+		if _, err := os.Stat(filepath.Join(clone, rel)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be copied, got %v", rel, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(clone, taskOutputDirName)); err != nil || !info.IsDir() {
+		t.Fatalf("expected the empty output folder: %v", err)
+	}
+
+	parsed, err := readWorkRecord(filepath.Join(clone, workRecordFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := parsed.Info
+	if info.Title != "三月统计" || info.Department != "财务部" || info.DerivedFrom != filepath.ToSlash(source) || info.Hash != resp.Hash {
+		t.Fatalf("unexpected work record: %+v", info)
+	}
+	if !strings.Contains(info.RawContent, "派生自：[2026.02_统计](../2026.02_统计)") {
+		t.Fatalf("expected a link to the original task, got %s", info.RawContent)
+	}
+
+	rr = postJSON(t, router, http.MethodPost, "/api/folder/clone", CloneTaskRequest{SourcePath: source, BasePath: source})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a clone inside the source, got %d", rr.Code)
+	}
+}
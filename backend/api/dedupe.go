@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+)
+
+// mailDateLayouts are the date formats clients and providers send for a mail.
+var mailDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+func normalizeMessageID(id string) string {
+	id = strings.TrimSpace(id)
+	id = strings.TrimPrefix(id, "<")
+	id = strings.TrimSuffix(id, ">")
+	return strings.TrimSpace(id)
+}
+
+func normalizeMailSubject(subject string) string {
+	return strings.ToLower(strings.Join(strings.Fields(cleanMailSubject(subject)), " "))
+}
+
+// normalizeMailFrom prefers the bare address; clients that only know the
+// display name fall back to it.
+func normalizeMailFrom(from string) string {
+	from = strings.TrimSpace(from)
+	if addr, err := netmail.ParseAddress(from); err == nil && addr.Address != "" {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.Join(strings.Fields(from), " "))
+}
+
+// mailTime parses a mail date in any of the formats clients and providers use.
+func mailTime(date string) (time.Time, bool) {
+	date = strings.TrimSpace(date)
+	for _, layout := range mailDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, true
+		}
+	}
+	if t, err := netmail.ParseDate(date); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// normalizeMailDate reduces a date to UTC minutes so the same mail hashes
+// alike whichever format or time zone it was rendered in.
+func normalizeMailDate(date string) string {
+	if t, ok := mailTime(date); ok {
+		return t.UTC().Format("2006-01-02T15:04")
+	}
+	return strings.TrimSpace(date)
+}
+
+// canonicalMailHash is the task hash of a mail: derived from its Message-ID
+// when there is one, otherwise from the normalized subject, sender and date.
+func canonicalMailHash(messageID, subject, from, date string) string {
+	if id := normalizeMessageID(messageID); id != "" {
+		return GenerateHash("message-id:" + id)
+	}
+	return GenerateHash("mail:" + normalizeMailSubject(subject) + "|" + normalizeMailFrom(from) + "|" + normalizeMailDate(date))
+}
+
+// requestMessageID returns the Message-ID of the mail behind req, asking the
+// mail provider when the client did not send it. Must be called with mailMu held.
+func requestMessageID(req FolderRequest) string {
+	if id := strings.TrimSpace(req.MessageID); id != "" {
+		return id
+	}
+	if mailProvider == nil || strings.TrimSpace(req.MailID) == "" {
+		return ""
+	}
+	detail, err := mailProvider.FetchMailDetail(req.MailID)
+	if err != nil {
+		return ""
+	}
+	id, _ := detail["message_id"].(string)
+	return id
+}
+
+// findExistingTasks looks for tasks carrying any of hashes in the workspace
+// and the archive paths.
+func findExistingTasks(baseFolder string, archivePaths []string, hashes []string) []map[string]interface{} {
+	wanted := map[string]bool{}
+	for _, h := range hashes {
+		if h = strings.TrimSpace(h); h != "" {
+			wanted[h] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	matches := scanDirForHashes(baseFolder, wanted, "working")
+	for _, ap := range archivePaths {
+		if ap = strings.TrimSpace(ap); ap != "" {
+			if folder, ok := lookupFolder(ap); ok {
+				matches = append(matches, scanDirForHashes(folder, wanted, "archived")...)
+			}
+		}
+	}
+	return matches
+}
+
+// duplicateTaskError reports that the mail already has a task.
+func duplicateTaskError(matches []map[string]interface{}) *taskError {
+	return &taskError{
+		Status:    http.StatusConflict,
+		Message:   fmt.Sprintf("该邮件已创建过任务: %v", matches[0]["path"]),
+		Existing:  matches,
+		Duplicate: true,
+	}
+}
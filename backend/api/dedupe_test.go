@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanonicalMailHash(t *testing.T) {
+	byID := canonicalMailHash("<abc@example.com>", "One", "a", "x")
+	if byID != canonicalMailHash("abc@example.com", "Other", "b", "y") {
+		t.Fatalf("Message-ID hash should ignore subject, sender and date")
+	}
+
+	a := canonicalMailHash("", "Re: 【通知】 Budget  Review", "Alice <Alice@Example.com>", "Mon, 20 Apr 2026 09:30:00 +0800")
+	b := canonicalMailHash("", "budget review", "alice@example.com", "2026-04-20T01:30:45Z")
+	if a != b {
+		t.Fatalf("normalized fallback hashes differ: %s vs %s", a, b)
+	}
+	if a == canonicalMailHash("", "budget review", "bob@example.com", "2026-04-20T01:30:45Z") {
+		t.Fatalf("different senders should not share a hash")
+	}
+}
+
+func postFolderCreate(t *testing.T, router http.Handler, body FolderRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/folder/create", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleCreateFolder_DuplicateMessageID(t *testing.T) {
+	tmpDir := t.TempDir()
+	archiveDir := t.TempDir()
+	router := SetupRoutes()
+
+	first := FolderRequest{
+		MailID:     "1",
+		MessageID:  "<dup-1@example.com>",
+		Subject:    "Budget",
+		BasePath:   tmpDir,
+		FolderName: "2026.04.20_Budget",
+		Source:     "email",
+	}
+	rr := postFolderCreate(t, router, first)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var created map[string]interface{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	if created["hash"] != canonicalMailHash("dup-1@example.com", "", "", "") {
+		t.Fatalf("expected canonical hash, got %v", created["hash"])
+	}
+
+	// Move the first task into the archive; the duplicate must still be found there.
+	archived := filepath.Join(archiveDir, "2026.04.20_Budget")
+	if err := os.Rename(filepath.Join(tmpDir, "2026.04.20_Budget"), archived); err != nil {
+		t.Fatal(err)
+	}
+
+	second := first
+	second.FolderName = "2026.04.21_Budget"
+	second.ArchivePaths = []string{archiveDir}
+	rr = postFolderCreate(t, router, second)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var conflict struct {
+		Path     string                   `json:"path"`
+		Existing []map[string]interface{} `json:"existing"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &conflict)
+	if conflict.Path != archived || len(conflict.Existing) != 1 || conflict.Existing[0]["status"] != "archived" {
+		t.Fatalf("unexpected conflict response: %s", rr.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "2026.04.21_Budget")); !os.IsNotExist(err) {
+		t.Fatalf("duplicate task folder should not be created")
+	}
+
+	second.Force = true
+	rr = postFolderCreate(t, router, second)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 with force, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandleCreateFolder_DuplicateLegacyClientHash(t *testing.T) {
+	tmpDir := t.TempDir()
+	router := SetupRoutes()
+
+	// A task created before the backend derived hashes carries the client hash.
+	legacy := filepath.Join(tmpDir, "legacy")
+	if err := os.MkdirAll(legacy, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	record := "---\ntitle: Legacy\nhash: clienthash000001\n---\n\n# Legacy\n"
+	if err := os.WriteFile(filepath.Join(legacy, workRecordFileName), []byte(record), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postFolderCreate(t, router, FolderRequest{
+		MailID:     "1",
+		Subject:    "Legacy",
+		BasePath:   tmpDir,
+		FolderName: "new",
+		Source:     "email",
+		Hash:       "clienthash000001",
+	})
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxFolderNameBytes keeps names, suffix included, below the 255 byte
+	// limit of common file systems.
+	maxFolderNameBytes = 200
+	maxFolderSuffix    = 999
+	folderSubjectRunes = 50
+	folderFromRunes    = 20
+	folderSeqWidth     = 3
+
+	// conflictSuffix appends _2, _3, ... to a name taken by another folder;
+	// conflictError refuses with 409.
+	conflictSuffix = "suffix"
+	conflictError  = "error"
+)
+
+// folderNameVariablePattern matches {{name}} and {{name:N}}; N limits text
+// to N runes and is the digit width of seq.
+var folderNameVariablePattern = regexp.MustCompile(`\{\{\s*(\w+)(?::(\d+))?\s*\}\}`)
+
+// folderNameVars are the values a folder name format can use.
+type folderNameVars struct {
+	Subject    string
+	From       string // display name, else the address
+	FromAddr   string
+	Department string
+	Date       time.Time
+}
+
+// requestFolderNameVars reads the name variables of a folder request.
+func requestFolderNameVars(req FolderRequest) folderNameVars {
+	v := folderNameVars{Subject: req.Subject, Department: req.Department}
+	sender := strings.TrimSpace(req.Sender)
+	if sender == "" {
+		sender = strings.TrimSpace(req.FromAddr)
+	}
+	v.From, v.FromAddr = splitSender(sender)
+	if t, ok := mailTime(req.Date); ok {
+		v.Date = t.Local()
+	} else {
+		v.Date = time.Now()
+	}
+	return v
+}
+
+// splitSender splits "Name <addr>" into display name and address.
+func splitSender(sender string) (string, string) {
+	name, addr := sender, ""
+	if i := strings.LastIndex(sender, "<"); i >= 0 && strings.HasSuffix(sender, ">") {
+		name = strings.Trim(strings.TrimSpace(sender[:i]), `"`)
+		addr = strings.TrimSpace(sender[i+1 : len(sender)-1])
+	} else if strings.Contains(sender, "@") {
+		name, addr = "", sender
+	}
+	if name == "" {
+		name = addr
+	}
+	return name, addr
+}
+
+// renderFolderName renders a folder name format. Besides the date
+// ({{YYYY}}, {{MM}}, {{DD}}, {{HH}}, {{mm}}) it knows {{subject}} without
+// Re:/Fwd: prefixes and 【】 tags, {{from}}, {{from_addr}}, {{department}}
+// and {{seq}}, one more than the highest number already used at that place
+// by folders in baseFolder.
+func renderFolderName(format string, v folderNameVars, baseFolder string) string {
+	if strings.TrimSpace(format) == "" {
+		format = defaultFolderNameFormat
+	}
+	text := map[string]string{
+		"YYYY":       v.Date.Format("2006"),
+		"MM":         v.Date.Format("01"),
+		"DD":         v.Date.Format("02"),
+		"HH":         v.Date.Format("15"),
+		"mm":         v.Date.Format("04"),
+		"subject":    cleanMailSubject(v.Subject),
+		"from":       v.From,
+		"from_addr":  v.FromAddr,
+		"department": v.Department,
+	}
+	defaultRunes := map[string]int{"subject": folderSubjectRunes, "from": folderFromRunes, "from_addr": folderFromRunes * 2}
+
+	// Everything before {{seq}}, rendered, is the prefix its number follows.
+	seqWidth := 0
+	name := folderNameVariablePattern.ReplaceAllStringFunc(format, func(m string) string {
+		sub := folderNameVariablePattern.FindStringSubmatch(m)
+		key, limit := sub[1], sub[2]
+		n, _ := strconv.Atoi(limit)
+		if key == "seq" {
+			seqWidth = folderSeqWidth
+			if n > 0 {
+				seqWidth = n
+			}
+			return "\x00"
+		}
+		value, ok := text[key]
+		if !ok {
+			return m
+		}
+		value = strings.TrimSpace(folderInvalidPattern.ReplaceAllString(value, ""))
+		if n == 0 {
+			n = defaultRunes[key]
+		}
+		if n > 0 {
+			value = truncateRunes(value, n)
+		}
+		return value
+	})
+
+	if i := strings.IndexByte(name, 0); i >= 0 {
+		prefix := name[:i]
+		seq := nextFolderSeq(baseFolder, prefix)
+		name = prefix + fmt.Sprintf("%0*d", seqWidth, seq) + strings.ReplaceAll(name[i+1:], "\x00", "")
+	}
+	return name
+}
+
+// nextFolderSeq is one more than the highest number that follows prefix in
+// the names of baseFolder's entries.
+func nextFolderSeq(baseFolder, prefix string) int {
+	entries, err := os.ReadDir(baseFolder)
+	if err != nil {
+		return 1
+	}
+	highest := 0
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok {
+			continue
+		}
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		if n, err := strconv.Atoi(rest[:digits]); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest + 1
+}
+
+// truncateFolderName cuts name to at most max bytes without splitting a
+// character.
+func truncateFolderName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	name = name[:max]
+	for !utf8.ValidString(name) {
+		name = name[:len(name)-1]
+	}
+	return name
+}
+
+// resolveTaskFolder picks the path of a new task below baseFolder. A name
+// taken by a file or a non-empty folder is never reused: it gets the next
+// free _N suffix, or with onConflict "error" a 409. An empty folder may be
+// taken over.
+func resolveTaskFolder(baseFolder, name, onConflict string) (string, *taskError) {
+	for n := 1; n <= maxFolderSuffix; n++ {
+		candidate := name
+		if n > 1 {
+			suffix := "_" + strconv.Itoa(n)
+			candidate = truncateFolderName(name, maxFolderNameBytes-len(suffix)) + suffix
+		}
+		path := filepath.Join(baseFolder, candidate)
+		_, err := os.Lstat(path)
+		if os.IsNotExist(err) || (err == nil && isEmptyDir(path)) {
+			return path, nil
+		}
+		if err != nil {
+			return "", &taskError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("创建目录失败: %v", err)}
+		}
+		if onConflict == conflictError {
+			return "", folderConflictError(path)
+		}
+	}
+	return "", &taskError{Status: http.StatusConflict, Message: fmt.Sprintf("同名文件夹过多: %s", name)}
+}
+
+// folderConflictError refuses a task whose folder path is taken.
+func folderConflictError(path string) *taskError {
+	name := filepath.Base(path)
+	existing := map[string]interface{}{"path": normalizeScanPath(path), "name": name}
+	if folder, ok := readScannedFolder(path, name); ok {
+		existing = folder
+	}
+	return &taskError{
+		Status:   http.StatusConflict,
+		Message:  fmt.Sprintf("文件夹已存在: %s", name),
+		Existing: []map[string]interface{}{existing},
+	}
+}
+
+func isEmptyDir(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	_, err = f.Readdirnames(1)
+	return errors.Is(err, io.EOF)
+}
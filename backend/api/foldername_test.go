@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRenderFolderName_Variables(t *testing.T) {
+	vars := requestFolderNameVars(FolderRequest{
+		Subject:    "Fwd: 回复：合同审批流程说明",
+		Sender:     "张三 <zs@example.com>",
+		Department: "法务部",
+		Date:       time.Date(2026, 4, 20, 9, 5, 0, 0, time.Local).Format(time.RFC3339),
+	})
+	got := renderFolderName("{{YYYY}}{{MM}}{{DD}}-{{HH}}{{mm}}_{{department}}_{{from}}_{{from_addr}}_{{subject:4}}{{unknown}}", vars, "")
+	if got != "20260420-0905_法务部_张三_zs@example.com_合同审批{{unknown}}" {
+		t.Fatalf("unexpected folder name: %s", got)
+	}
+}
+
+func TestRenderFolderName_Seq(t *testing.T) {
+	base := t.TempDir()
+	for _, name := range []string{"2026-001_a", "2026-007_b", "2025-010_c", "2026-x"} {
+		if err := os.Mkdir(filepath.Join(base, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vars := folderNameVars{Subject: "报告", Date: time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)}
+	if got := renderFolderName("{{YYYY}}-{{seq}}_{{subject}}", vars, base); got != "2026-008_报告" {
+		t.Fatalf("unexpected folder name: %s", got)
+	}
+	if got := renderFolderName("{{subject}}_{{seq:2}}", vars, base); got != "报告_01" {
+		t.Fatalf("unexpected folder name: %s", got)
+	}
+}
+
+func TestSanitizeFolderName_Limits(t *testing.T) {
+	long := sanitizeFolderName(strings.Repeat("预算审批", 40) + "...")
+	if len(long) > maxFolderNameBytes || !utf8.ValidString(long) {
+		t.Fatalf("expected a valid name of at most %d bytes, got %d", maxFolderNameBytes, len(long))
+	}
+	if got := sanitizeFolderName("a/b:c\tname. . "); got != "abcname" {
+		t.Fatalf("unexpected name: %q", got)
+	}
+}
+
+func TestHandleCreateFolder_NameCollision(t *testing.T) {
+	workDir := t.TempDir()
+	router := SetupRoutes()
+
+	req := FolderRequest{
+		Subject:          "Re: 周报",
+		Date:             "2026-03-02 10:00",
+		BasePath:         workDir,
+		FolderName:       "ignored",
+		FolderNameFormat: "{{YYYY}}.{{MM}}.{{DD}}_{{subject}}",
+		Source:           "manual",
+	}
+	rr := postFolderCreate(t, router, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	first := filepath.Join(workDir, "2026.03.02_周报")
+	original, err := os.ReadFile(filepath.Join(first, workRecordFileName))
+	if err != nil {
+		t.Fatalf("expected the rendered folder: %v", err)
+	}
+
+	// The name is taken: the new task gets a suffix, the old one is kept.
+	req.Subject = "周报"
+	rr = postFolderCreate(t, router, req)
+	var resp struct {
+		Path       string `json:"path"`
+		FolderName string `json:"folder_name"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	if resp.FolderName != "2026.03.02_周报_2" || resp.Path != filepath.Join(workDir, "2026.03.02_周报_2") {
+		t.Fatalf("expected a suffixed folder, got %+v", resp)
+	}
+	if current, _ := os.ReadFile(filepath.Join(first, workRecordFileName)); string(current) != string(original) {
+		t.Fatal("expected the existing work record to be kept")
+	}
+
+	req.OnConflict = "error"
+	rr = postFolderCreate(t, router, req)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "2026.03.02_周报") {
+		t.Fatalf("expected 409 with the existing folder, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// An empty folder is taken over.
+	if err := os.Mkdir(filepath.Join(workDir, "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "x", BasePath: workDir, FolderName: "empty", Source: "manual", OnConflict: "error"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected an empty folder to be reused, got %d %s", rr.Code, rr.Body.String())
+	}
+}
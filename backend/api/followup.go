@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AppendMailRequest attaches a follow-up mail to an existing task folder.
+type AppendMailRequest struct {
+	FolderPath          string `json:"folder_path"`
+	MailID              string `json:"mail_id"`
+	Subject             string `json:"subject"`
+	Date                string `json:"date"`
+	FromAddr            string `json:"from_addr"`
+	Body                string `json:"body"`
+	MessageID           string `json:"message_id"`
+	DownloadAttachments bool   `json:"download_attachments"`
+}
+
+func handleAppendMail(w http.ResponseWriter, r *http.Request) {
+	var req AppendMailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid request parameters")
+		return
+	}
+
+	mailMu.Lock()
+	resp, err := appendMailToTask(req)
+	mailMu.Unlock()
+	if err != nil {
+		jsonError(w, err.Status, err.Message)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, resp)
+}
+
+// followUpDirName picks a dated, unused sub-folder name of 00_来源资料.
+func followUpDirName(sourceDir string, subject string, date time.Time) string {
+	name := date.Format("2006-01-02")
+	if s := strings.TrimSpace(truncateRunes(folderInvalidPattern.ReplaceAllString(cleanMailSubject(subject), ""), 40)); s != "" {
+		name += "_" + s
+	}
+	candidate := name
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(sourceDir, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d", name, i)
+	}
+}
+
+// appendToSection adds line at the end of the "## header" section, creating
+// the section at the end of the body when it is missing.
+func appendToSection(body string, header string, line string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	start := -1
+	for i, l := range lines {
+		if strings.TrimSpace(l) == header {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return strings.TrimRight(body, "\n") + "\n\n" + header + "\n\n" + line + "\n"
+	}
+
+	end := len(lines)
+	for i := start + 1; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "## ") {
+			end = i
+			break
+		}
+	}
+	// Insert after the last non-blank line of the section.
+	insertAt := end
+	for insertAt > start+1 && strings.TrimSpace(lines[insertAt-1]) == "" {
+		insertAt--
+	}
+	if insertAt == start+1 {
+		// Empty section: keep a blank line under the header.
+		result := append([]string{}, lines[:start+1]...)
+		result = append(result, "", line)
+		if end < len(lines) {
+			result = append(result, "")
+		}
+		return strings.Join(append(result, lines[end:]...), "\n")
+	}
+
+	result := append([]string{}, lines[:insertAt]...)
+	result = append(result, line)
+	return strings.Join(append(result, lines[insertAt:]...), "\n")
+}
+
+// appendMailToTask saves a follow-up mail into a dated sub-folder of the
+// task's source folder and logs it in the work record. Must be called with
+// mailMu held.
+func appendMailToTask(req AppendMailRequest) (map[string]interface{}, *taskError) {
+	folderPath := strings.TrimSpace(req.FolderPath)
+	if folderPath == "" {
+		return nil, &taskError{Status: http.StatusBadRequest, Message: "缺少任务目录"}
+	}
+	folderPath, err := resolveAllowedFolder(folderPath)
+	if err != nil {
+		return nil, &taskError{Status: pathErrorStatus(err), Message: err.Error()}
+	}
+	wrPath := filepath.Join(folderPath, workRecordFileName)
+	parsed, err := readWorkRecord(wrPath)
+	if err != nil {
+		return nil, &taskError{Status: http.StatusNotFound, Message: fmt.Sprintf("任务目录不存在或缺少%s", workRecordFileName)}
+	}
+
+	hash := canonicalMailHash(requestMessageID(FolderRequest{MailID: req.MailID, MessageID: req.MessageID}), req.Subject, req.FromAddr, req.Date)
+	related := parsed.Info.RelatedHashes
+	for _, h := range append([]string{parsed.Info.Hash}, related...) {
+		if h == hash {
+			return nil, &taskError{Status: http.StatusConflict, Message: fmt.Sprintf("该邮件已追加到任务: %s", filepath.Base(folderPath))}
+		}
+	}
+
+	if strings.TrimSpace(req.Body) == "" && mailProvider != nil && strings.TrimSpace(req.MailID) != "" {
+		if detail, err := mailProvider.FetchMailDetail(req.MailID); err == nil {
+			req.Body, _ = detail["body"].(string)
+		}
+	}
+
+	now := time.Now()
+	mailDate, ok := mailTime(req.Date)
+	if !ok {
+		mailDate = now
+	}
+	sourceDir := filepath.Join(folderPath, taskSourceDirName)
+	subDir := followUpDirName(sourceDir, req.Subject, mailDate)
+	subPath := filepath.Join(sourceDir, subDir)
+	// fail removes the sub-folder, which no work record entry points to yet.
+	fail := func(format string, err error) *taskError {
+		if rmErr := os.RemoveAll(subPath); rmErr != nil {
+			log.Printf("Failed to remove %s: %v", subPath, rmErr)
+		}
+		return &taskError{Status: http.StatusInternalServerError, Message: fmt.Sprintf(format, err)}
+	}
+	if err := os.MkdirAll(filepath.Join(subPath, taskAttachmentDir), 0o755); err != nil {
+		return nil, fail("创建目录失败: %v", err)
+	}
+
+	emailReq := FolderRequest{Subject: req.Subject, Date: req.Date, FromAddr: req.FromAddr, Body: req.Body}
+	if err := writeEmailTexts(subPath, emailReq); err != nil {
+		return nil, fail("保存邮件来源失败: %v", err)
+	}
+
+	downloaded := []string{}
+	attachmentsErr := ""
+	if req.DownloadAttachments && mailProvider != nil && strings.TrimSpace(req.MailID) != "" {
+		// Attachments saved before a failure are kept and listed.
+		d, err := mailProvider.DownloadAttachments(req.MailID, filepath.Join(subPath, taskAttachmentDir))
+		if err != nil {
+			log.Printf("Failed to download attachments of mail %s: %v", req.MailID, err)
+			attachmentsErr = err.Error()
+		}
+		if len(d) > 0 {
+			downloaded = d
+		}
+	}
+
+	subject := strings.TrimSpace(req.Subject)
+	if subject == "" {
+		subject = "无主题"
+	}
+	entry := fmt.Sprintf("- %s：追加邮件「%s」", now.Format("2006-01-02"), subject)
+	if from := strings.TrimSpace(req.FromAddr); from != "" {
+		entry += fmt.Sprintf("（发件人：%s）", from)
+	}
+	entry += fmt.Sprintf("，资料见 %s/%s。", taskSourceDirName, subDir)
+
+	front := ensureFrontmatterLines(parsed, folderPath)
+	front = upsertFrontmatterValue(front, []string{"updated"}, "updated", now.Format("2006-01-02"))
+	front = upsertFrontmatterValue(front, []string{"related_hashes"}, "related_hashes", strings.Join(append(related, hash), ","))
+	body := appendToSection(parsed.Body, "## 工作过程", entry)
+	if err := writeWorkRecordFile(wrPath, front, body); err != nil {
+		return nil, fail("写入工作记录失败: %v", err)
+	}
+
+	resp := map[string]interface{}{
+		"success":     true,
+		"path":        folderPath,
+		"source_path": subPath,
+		"work_record": wrPath,
+		"hash":        hash,
+		"message":     fmt.Sprintf("邮件已追加到任务: %s", filepath.Base(folderPath)),
+	}
+	if req.DownloadAttachments {
+		resp["attachments_downloaded"] = downloaded
+	}
+	if attachmentsErr != "" {
+		resp["attachments_error"] = attachmentsErr
+		resp["message"] = fmt.Sprintf("邮件已追加到任务: %s（附件下载失败: %s）", filepath.Base(folderPath), attachmentsErr)
+	}
+	return resp, nil
+}
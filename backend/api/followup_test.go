@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"knot-backend/mail"
+)
+
+func TestAppendToSection(t *testing.T) {
+	body := "# T\n\n## 工作过程\n\n- a\n\n## 当前进展\n\nx\n"
+	got := appendToSection(body, "## 工作过程", "- b")
+	want := "# T\n\n## 工作过程\n\n- a\n- b\n\n## 当前进展\n\nx\n"
+	if got != want {
+		t.Fatalf("unexpected body:\n%q", got)
+	}
+
+	got = appendToSection("# T\n", "## 工作过程", "- b")
+	if !strings.HasSuffix(got, "## 工作过程\n\n- b\n") {
+		t.Fatalf("missing section not created:\n%q", got)
+	}
+}
+
+func TestHandleAppendMail(t *testing.T) {
+	taskDir := filepath.Join(t.TempDir(), "2026.04.20_Budget")
+	if err := os.MkdirAll(filepath.Join(taskDir, taskSourceDirName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	record := "---\ntitle: Budget\nupdated: 2026-04-20\n---\n\n# Budget\n\n## 工作过程\n\n- 2026-04-20：创建任务文件夹。\n\n## 当前进展\n\n进行中\n"
+	wrPath := filepath.Join(taskDir, workRecordFileName)
+	if err := os.WriteFile(wrPath, []byte(record), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	router := SetupRoutes()
+	connectDemoProvider(t, router)
+
+	raw, _ := json.Marshal(AppendMailRequest{
+		FolderPath:          taskDir,
+		MailID:              "1",
+		Subject:             "Re: Budget",
+		Date:                "Tue, 21 Apr 2026 10:00:00 +0800",
+		FromAddr:            "Alice",
+		DownloadAttachments: true,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/folder/append-mail", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+
+	subPath := filepath.Join(taskDir, taskSourceDirName, "2026-04-21_Budget")
+	txt, err := os.ReadFile(filepath.Join(subPath, "email.txt"))
+	if err != nil {
+		t.Fatalf("follow-up email not saved: %v", err)
+	}
+	if !strings.Contains(string(txt), "主题: Re: Budget") || !strings.Contains(string(txt), "预算编制要求") {
+		t.Fatalf("unexpected email.txt (body should come from the provider):\n%s", txt)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(subPath, taskAttachmentDir)); len(entries) == 0 {
+		t.Fatalf("attachments not downloaded")
+	}
+
+	data, _ := os.ReadFile(wrPath)
+	wr := string(data)
+	today := time.Now().Format("2006-01-02")
+	if !strings.Contains(wr, "updated: "+today) {
+		t.Fatalf("updated not bumped:\n%s", wr)
+	}
+	entry := "- " + today + "：追加邮件「Re: Budget」（发件人：Alice），资料见 " + taskSourceDirName + "/2026-04-21_Budget。"
+	if !strings.Contains(wr, "- 2026-04-20：创建任务文件夹。\n"+entry+"\n\n## 当前进展") {
+		t.Fatalf("work process entry not appended:\n%s", wr)
+	}
+
+	if !strings.Contains(wr, "related_hashes: "+canonicalMailHash("demo-1@knot.local", "", "", "")) {
+		t.Fatalf("follow-up hash not recorded:\n%s", wr)
+	}
+
+	// The same mail cannot be appended twice.
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/folder/append-mail", bytes.NewReader(raw)))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a repeated append, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Another follow-up with the same date and subject gets its own folder.
+	raw, _ = json.Marshal(AppendMailRequest{
+		FolderPath: taskDir,
+		MailID:     "2",
+		Subject:    "Re: Budget",
+		Date:       "Tue, 21 Apr 2026 10:00:00 +0800",
+	})
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/folder/append-mail", bytes.NewReader(raw)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("second append failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(subPath + "_2"); err != nil {
+		t.Fatalf("expected second follow-up folder: %v", err)
+	}
+}
+
+// failingDownloadProvider fails every attachment download.
+type failingDownloadProvider struct {
+	mail.Provider
+}
+
+func (p failingDownloadProvider) DownloadAttachments(mailID, savePath string) ([]string, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestHandleAppendMail_ReportsAttachmentError(t *testing.T) {
+	taskDir := filepath.Join(t.TempDir(), "task")
+	if err := os.MkdirAll(filepath.Join(taskDir, taskSourceDirName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(taskDir, workRecordFileName), []byte("---\ntitle: Budget\n---\n\n## 工作过程\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	router := SetupRoutes()
+	connectDemoProvider(t, router)
+	mailProvider = failingDownloadProvider{Provider: mailProvider}
+
+	rr := postJSON(t, router, http.MethodPost, "/api/folder/append-mail", AppendMailRequest{
+		FolderPath: taskDir, MailID: "1", Subject: "Re: Budget", DownloadAttachments: true,
+	})
+	var resp struct {
+		AttachmentsError string `json:"attachments_error"`
+		Message          string `json:"message"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("append: %d %s", rr.Code, rr.Body.String())
+	}
+	if resp.AttachmentsError != "connection reset" || !strings.Contains(resp.Message, "附件下载失败") {
+		t.Fatalf("expected the attachment error in the response, got %+v", resp)
+	}
+}
+
+func TestHandleAppendMail_MissingTask(t *testing.T) {
+	router := SetupRoutes()
+	raw, _ := json.Marshal(AppendMailRequest{FolderPath: filepath.Join(t.TempDir(), "missing"), Subject: "x"})
+	req := httptest.NewRequest(http.MethodPost, "/api/folder/append-mail", bytes.NewReader(raw))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// appTokenHeader carries the per-launch token of the app window. The
+// Electron process generates it, passes it to the backend it starts as
+// KNOT_APP_TOKEN and hands it to its renderer.
+const appTokenHeader = "X-Knot-Token"
+
+// appTokenQuery carries the token for the image proxy instead, because
+// <img> requests cannot set headers.
+const appTokenQuery = "token"
+
+// defaultAllowedOrigins are the pages of the Vite dev server. The packaged
+// window loads from file:// and sends the opaque origin "null", like
+// sandboxed iframes and data: URLs of any site, so it is identified by
+// the app token instead.
+var defaultAllowedOrigins = []string{"http://localhost:5173", "http://127.0.0.1:5173"}
+
+// allowedOrigins adds the comma-separated KNOT_ALLOWED_ORIGINS to the
+// default origins.
+func allowedOrigins() []string {
+	origins := append([]string{}, defaultAllowedOrigins...)
+	for _, o := range strings.Split(os.Getenv("KNOT_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+func appToken() string {
+	return strings.TrimSpace(os.Getenv("KNOT_APP_TOKEN"))
+}
+
+// hasAppToken reports whether r carries the app token. It is false when
+// the backend was started without one.
+func hasAppToken(r *http.Request) bool {
+	token := appToken()
+	if token == "" {
+		return false
+	}
+	got := r.Header.Get(appTokenHeader)
+	if got == "" && r.URL.Path == imageProxyPath {
+		got = r.URL.Query().Get(appTokenQuery)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// corsOriginAllowed lets the listed origins read responses, and the opaque
+// origin of the packaged window when the request carries the app token.
+// Preflights cannot carry it; the request they announce is checked again.
+func corsOriginAllowed(origins []string) func(r *http.Request, origin string) bool {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		allowed[strings.ToLower(o)] = true
+	}
+	return func(r *http.Request, origin string) bool {
+		if allowed[strings.ToLower(origin)] {
+			return true
+		}
+		if appToken() == "" {
+			return false
+		}
+		return r.Method == http.MethodOptions || hasAppToken(r)
+	}
+}
+
+// guardRequests refuses requests that may come from other pages. CORS only
+// hides the response from them; the request itself would still run, and
+// browsers send no Origin header with plain GETs such as <img src>. When
+// the backend was started with an app token, every request must carry it.
+// Otherwise, for a backend started on its own during development, only
+// requests from other origins are refused.
+func guardRequests(origins []string) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		allowed[strings.ToLower(o)] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case hasAppToken(r):
+			case appToken() != "":
+				jsonError(w, http.StatusForbidden, "missing app token")
+				return
+			default:
+				if origin := r.Header.Get("Origin"); origin != "" && !allowed[strings.ToLower(origin)] {
+					jsonError(w, http.StatusForbidden, "origin not allowed")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireAppToken limits a route to the app window, whatever the origin.
+// It guards settings that widen what the other endpoints may touch.
+func requireAppToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasAppToken(r) {
+			jsonError(w, http.StatusForbidden, "只能在应用窗口中修改此设置")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"knot-backend/mail"
+)
+
+const (
+	imageProxyPath     = "/api/mail/image-proxy"
+	imageProxyMaxBytes = 10 << 20
+)
+
+// imageProxyClient fetches remote mail images. It refuses loopback, private,
+// link-local and other special-purpose addresses so a mail cannot make the
+// app probe the local network. It always connects directly: through a proxy, the address
+// checked would be the proxy's rather than the image host's.
+var imageProxyClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: publicAddressOnly,
+		}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %s", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// nonPublicNets are the special-purpose ranges the net.IP predicates miss:
+// "this network", shared carrier-grade NAT space, IETF protocol
+// assignments, benchmarking, the documentation ranges, reserved space and
+// the NAT64 prefix, which maps back onto IPv4 hosts.
+var nonPublicNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("address %s is not public", host)
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("address %s is not public", host)
+		}
+	}
+	return nil
+}
+
+// sanitizeDetail adds the sanitized HTML of a mail detail. Remote images are
+// blocked unless loadImages is set, in which case they point at the image
+// proxy of this server, with the app token in the query.
+func sanitizeDetail(r *http.Request, detail map[string]interface{}, loadImages bool) {
+	htmlBody, _ := detail["html_body"].(string)
+	opts := mail.SanitizeOptions{}
+	if loadImages {
+		query := ""
+		if token := appToken(); token != "" {
+			query = appTokenQuery + "=" + url.QueryEscape(token) + "&"
+		}
+		opts.ImageProxy = fmt.Sprintf("http://%s%s?%surl=", r.Host, imageProxyPath, query)
+	}
+	result := mail.SanitizeHTML(htmlBody, opts)
+	detail["sanitized_html"] = result.HTML
+	detail["remote_images"] = result.RemoteImages
+	detail["remote_images_blocked"] = !loadImages && result.RemoteImages > 0
+	detail["tracking_pixels"] = result.TrackingPixels
+}
+
+// handleImageProxy streams a remote image to the renderer, so it never
+// contacts the remote server itself. Only http(s) images are served and
+// no cookies or referrer are sent.
+func handleImageProxy(w http.ResponseWriter, r *http.Request) {
+	raw := strings.TrimSpace(r.URL.Query().Get("url"))
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		jsonError(w, http.StatusBadRequest, "无效的图片地址")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "无效的图片地址")
+		return
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := imageProxyClient.Do(req)
+	if err != nil {
+		jsonError(w, http.StatusBadGateway, fmt.Sprintf("图片加载失败: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		jsonError(w, http.StatusBadGateway, fmt.Sprintf("图片加载失败: %s", resp.Status))
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(strings.ToLower(contentType), "image/") || strings.Contains(strings.ToLower(contentType), "svg") {
+		jsonError(w, http.StatusUnsupportedMediaType, "不是图片")
+		return
+	}
+	if resp.ContentLength > imageProxyMaxBytes {
+		jsonError(w, http.StatusRequestEntityTooLarge, "图片过大")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, io.LimitReader(resp.Body, imageProxyMaxBytes))
+}
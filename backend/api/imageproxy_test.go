@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandleImageProxy(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cookie") != "" || r.Header.Get("Referer") != "" {
+			t.Errorf("proxy leaked headers: %v", r.Header)
+		}
+		switch r.URL.Path {
+		case "/a.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("PNGDATA"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<script></script>"))
+		}
+	}))
+	defer remote.Close()
+	router := SetupRoutes()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, imageProxyPath+"?url="+url.QueryEscape(target), nil))
+		return rr
+	}
+
+	// A proxy would hide the image host from the address check.
+	if imageProxyClient.Transport.(*http.Transport).Proxy != nil {
+		t.Fatal("expected the image proxy client to connect directly")
+	}
+
+	// The test server listens on loopback, which the real client refuses.
+	if rr := get(remote.URL + "/a.png"); rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected loopback to be refused, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := get("file:///etc/passwd"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for file URL, got %d", rr.Code)
+	}
+
+	saved := imageProxyClient
+	imageProxyClient = remote.Client()
+	t.Cleanup(func() { imageProxyClient = saved })
+
+	rr := get(remote.URL + "/a.png")
+	if rr.Code != http.StatusOK || rr.Body.String() != "PNGDATA" || rr.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("unexpected proxied image: %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+	if rr := get(remote.URL + "/page"); rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected non-images to be refused, got %d", rr.Code)
+	}
+}
+
+func TestSanitizeDetail(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/mail/1/detail", nil)
+	r.Host = "127.0.0.1:8000"
+	html := `<p onclick="x()">hi</p><img src="https://cdn.example.com/a.png">`
+
+	detail := map[string]interface{}{"html_body": html}
+	sanitizeDetail(r, detail, false)
+	if detail["html_body"] != html || detail["sanitized_html"] != `<p>hi</p><img data-remote-src="https://cdn.example.com/a.png">` ||
+		detail["remote_images_blocked"] != true {
+		t.Fatalf("unexpected blocked detail: %+v", detail)
+	}
+
+	detail = map[string]interface{}{"html_body": html}
+	sanitizeDetail(r, detail, true)
+	if !strings.Contains(detail["sanitized_html"].(string), `src="http://127.0.0.1:8000/api/mail/image-proxy?url=https%3A%2F%2Fcdn.example.com%2Fa.png"`) ||
+		detail["remote_images_blocked"] != false {
+		t.Fatalf("unexpected proxied detail: %+v", detail)
+	}
+
+	// Started by the app, the proxied images carry the token.
+	t.Setenv("KNOT_APP_TOKEN", "launch token")
+	detail = map[string]interface{}{"html_body": html}
+	sanitizeDetail(r, detail, true)
+	if !strings.Contains(detail["sanitized_html"].(string), `src="http://127.0.0.1:8000/api/mail/image-proxy?token=launch+token&amp;url=https%3A%2F%2Fcdn.example.com%2Fa.png"`) {
+		t.Fatalf("expected the token in the proxied image URL: %v", detail["sanitized_html"])
+	}
+}
+
+func TestPublicAddressOnly(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34:80":      true,
+		"[2606:4700::1111]:443": true,
+		"127.0.0.1:80":          false,
+		"10.1.2.3:80":           false,
+		"169.254.169.254:80":    false,
+		"100.64.0.1:80":         false,
+		"100.127.255.254:80":    false,
+		"192.0.0.8:80":          false,
+		"198.18.0.1:80":         false,
+		"198.19.255.255:80":     false,
+		"[64:ff9b::7f00:1]:80":  false,
+		"[::1]:80":              false,
+	} {
+		if err := publicAddressOnly("tcp", addr, nil); (err == nil) != public {
+			t.Errorf("%s: public=%v, got %v", addr, public, err)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"knot-backend/scheduler"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const schedulerStateFileName = "scheduler.json"
+
+var (
+	jobScheduler   *scheduler.Scheduler
+	jobSchedulerMu sync.Mutex
+)
+
+// getJobScheduler returns the process-wide scheduler, registering the
+// built-in jobs on first use. It does not start the check loop.
+func getJobScheduler() *scheduler.Scheduler {
+	jobSchedulerMu.Lock()
+	defer jobSchedulerMu.Unlock()
+
+	if jobScheduler != nil {
+		return jobScheduler
+	}
+
+	s := scheduler.New(filepath.Join(appDataDir(), schedulerStateFileName))
+	_ = s.Add("mail_sync", "邮件同步", "@every 15m", map[string]string{
+		"limit": "50",
+		"days":  "7",
+	}, runMailSyncJob)
+	_ = s.Add("rules", "规则评估", "@every 1h", map[string]string{
+		"limit": "200",
+		"days":  "30",
+	}, runRulesJob)
+	_ = s.Add("archive_sweep", "自动归档", "0 19 * * *", map[string]string{
+		"scan_path":    "",
+		"archive_path": "",
+		"statuses":     "done,completed,已完成",
+		"recursive":    "false",
+	}, runArchiveSweepJob)
+	_ = s.Add("daily_report", "日报生成", "0 18 * * 1-5", map[string]string{
+		"scan_path":  "",
+		"output_dir": "",
+	}, runDailyReportJob)
+
+	jobScheduler = s
+	return s
+}
+
+// StartScheduler starts running the background jobs.
+func StartScheduler() {
+	getJobScheduler().Start()
+}
+
+func intParam(params map[string]string, key string, fallback int) int {
+	if v, err := strconv.Atoi(strings.TrimSpace(params[key])); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+func syncAndRunRules(params map[string]string, trigger string) (string, error) {
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
+	if mailProvider == nil {
+		return "skipped: mail not connected", nil
+	}
+	mails, err := mailProvider.FetchMailList(intParam(params, "limit", 50), intParam(params, "days", 7))
+	if err != nil {
+		return "", err
+	}
+
+	run := runMailRules(mails, currentMailbox, trigger)
+	if run == nil {
+		return fmt.Sprintf("fetched %d mails, no new rule evaluations", len(mails)), nil
+	}
+	return fmt.Sprintf("fetched %d mails, rules matched %d (run %s)", len(mails), run.Matched, run.ID), nil
+}
+
+// runMailSyncJob refreshes the selected mailbox. For POP3 accounts this is
+// what downloads new mail; rules run as on any other sync.
+func runMailSyncJob(params map[string]string) (string, error) {
+	return syncAndRunRules(params, "sync")
+}
+
+// runRulesJob evaluates rules over a wider window than the regular sync.
+func runRulesJob(params map[string]string) (string, error) {
+	return syncAndRunRules(params, "schedule")
+}
+
+// runArchiveSweepJob archives workspace tasks whose status is finished.
+// "archive_path.<department>" params override archive_path per department.
+func runArchiveSweepJob(params map[string]string) (string, error) {
+	scanPath := strings.TrimSpace(params["scan_path"])
+	if scanPath == "" {
+		return "", fmt.Errorf("scan_path not configured")
+	}
+
+	scanFolder, err := resolveAllowedPath(getBaseFolder(scanPath))
+	if err != nil {
+		return "", err
+	}
+	folders, err := collectScannedFolders(scanFolder, params["recursive"] == "true")
+	if err != nil {
+		return "", err
+	}
+
+	statuses := map[string]bool{}
+	for _, st := range strings.Split(params["statuses"], ",") {
+		if st = strings.ToLower(strings.TrimSpace(st)); st != "" {
+			statuses[st] = true
+		}
+	}
+
+	moved, skipped, failed := 0, 0, 0
+	var errs []string
+	for _, folder := range folders {
+		if !statuses[strings.ToLower(fmt.Sprint(folder["status"]))] {
+			continue
+		}
+		archivePath := strings.TrimSpace(params["archive_path."+fmt.Sprint(folder["department"])])
+		if archivePath == "" {
+			archivePath = strings.TrimSpace(params["archive_path"])
+		}
+		if archivePath == "" {
+			skipped++
+			continue
+		}
+		if _, err := doArchiveMove(fmt.Sprint(folder["path"]), archivePath); err != nil {
+			failed++
+			errs = append(errs, fmt.Sprintf("%s: %v", folder["name"], err))
+			continue
+		}
+		moved++
+	}
+
+	summary := fmt.Sprintf("archived %d, skipped %d (no archive path), failed %d", moved, skipped, failed)
+	if failed > 0 {
+		return summary, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return summary, nil
+}
+
+// runDailyReportJob writes a Markdown daily report for the tasks updated
+// today. Scheduled reports use the local fallback wording; AI generation
+// stays an interactive action so the API key is never stored on disk.
+func runDailyReportJob(params map[string]string) (string, error) {
+	scanPath := strings.TrimSpace(params["scan_path"])
+	if scanPath == "" {
+		return "", fmt.Errorf("scan_path not configured")
+	}
+
+	scanFolder, err := resolveAllowedPath(getBaseFolder(scanPath))
+	if err != nil {
+		return "", err
+	}
+	folders, err := collectScannedFolders(scanFolder, true)
+	if err != nil {
+		return "", err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	var items []DailyReportItem
+	for _, folder := range folders {
+		if strings.HasPrefix(fmt.Sprint(folder["update_time"]), today) {
+			items = append(items, DailyReportItem{FolderPath: fmt.Sprint(folder["path"])})
+		}
+	}
+	if len(items) == 0 {
+		return "no tasks updated today", nil
+	}
+
+	logs := buildDailyReportLogs(today, items, DailyReportAIConfig{})
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# %s 工作日报\n\n", today))
+	for _, l := range logs {
+		b.WriteString(fmt.Sprintf("- **%s**：%s\n", l.Title, l.Content))
+	}
+
+	outputDir := strings.TrimSpace(params["output_dir"])
+	if outputDir == "" {
+		outputDir = filepath.Join(appDataDir(), "reports")
+	} else if outputDir, err = resolveAllowedPath(getBaseFolder(outputDir)); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return "", err
+	}
+	reportPath := filepath.Join(outputDir, today+".md")
+	if err := os.WriteFile(reportPath, []byte(b.String()), 0o644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d tasks written to %s", len(logs), reportPath), nil
+}
+
+// -- Scheduler Handlers --
+
+func handleGetJobs(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": getJobScheduler().Jobs()})
+}
+
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJobScheduler().Job(chi.URLParam(r, "job_id"))
+	if !ok {
+		jsonError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": job})
+}
+
+type UpdateJobRequest struct {
+	Spec   string            `json:"spec"`
+	Params map[string]string `json:"params"`
+}
+
+func handleUpdateJob(w http.ResponseWriter, r *http.Request) {
+	var req UpdateJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	jobID := chi.URLParam(r, "job_id")
+	s := getJobScheduler()
+	if _, ok := s.Job(jobID); !ok {
+		jsonError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if err := s.Update(jobID, strings.TrimSpace(req.Spec), req.Params); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job, _ := s.Job(jobID)
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": job})
+}
+
+func setJobPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	jobID := chi.URLParam(r, "job_id")
+	s := getJobScheduler()
+	if err := s.SetPaused(jobID, paused); err != nil {
+		jsonError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	job, _ := s.Job(jobID)
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": job})
+}
+
+func handlePauseJob(w http.ResponseWriter, r *http.Request) {
+	setJobPaused(w, r, true)
+}
+
+func handleResumeJob(w http.ResponseWriter, r *http.Request) {
+	setJobPaused(w, r, false)
+}
+
+// handleTriggerJob runs a job now. With wait=true the response carries the
+// finished run; otherwise it returns as soon as the run has started.
+func handleTriggerJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "job_id")
+	s := getJobScheduler()
+	if _, ok := s.Job(jobID); !ok {
+		jsonError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	wait := r.URL.Query().Get("wait") == "true"
+	if err := s.Trigger(jobID, wait); err != nil {
+		jsonError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	job, _ := s.Job(jobID)
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": job})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"knot-backend/scheduler"
+)
+
+func useTestScheduler(t *testing.T) {
+	t.Helper()
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	jobScheduler = nil
+	t.Cleanup(func() { jobScheduler = nil })
+}
+
+func TestHandleTriggerJob_DailyReportWritesMarkdown(t *testing.T) {
+	useTestScheduler(t)
+	router := SetupRoutes()
+
+	workspace := t.TempDir()
+	today := time.Now().Format("2006-01-02")
+	taskDir := filepath.Join(workspace, "2026.04.20_report_task")
+	_ = os.MkdirAll(taskDir, 0o755)
+	record := "---\ntitle: Report Task\nstatus: active\ncreated: " + today + "\nupdated: " + today + "\n---\n# Report Task\n\n## 当前进展\n\n完成接口联调。\n"
+	_ = os.WriteFile(filepath.Join(taskDir, workRecordFileName), []byte(record), 0o644)
+
+	outputDir := t.TempDir()
+	raw, _ := json.Marshal(UpdateJobRequest{Params: map[string]string{"scan_path": workspace, "output_dir": outputDir}})
+	req := httptest.NewRequest(http.MethodPut, "/api/scheduler/jobs/daily_report", bytes.NewReader(raw))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/scheduler/jobs/daily_report/trigger?wait=true", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var resp struct {
+		Data scheduler.JobStatus `json:"data"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Data.LastStatus != "ok" || len(resp.Data.History) != 1 {
+		t.Fatalf("unexpected job status: %s", rr.Body.String())
+	}
+
+	report, err := os.ReadFile(filepath.Join(outputDir, today+".md"))
+	if err != nil {
+		t.Fatalf("expected report file: %v", err)
+	}
+	if !strings.Contains(string(report), "Report Task") {
+		t.Fatalf("unexpected report:\n%s", report)
+	}
+}
+
+func TestHandlePauseJob_AndUnknownJob(t *testing.T) {
+	useTestScheduler(t)
+	router := SetupRoutes()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/scheduler/jobs/mail_sync/pause", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var resp struct {
+		Data scheduler.JobStatus `json:"data"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || !resp.Data.Paused {
+		t.Fatalf("expected paused job, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/scheduler/jobs/nope/trigger", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestRunArchiveSweepJob_MovesFinishedTasks(t *testing.T) {
+	workspace := t.TempDir()
+	archive := t.TempDir()
+	for name, status := range map[string]string{"2026.04.01_done": "done", "2026.04.02_active": "active"} {
+		dir := filepath.Join(workspace, name)
+		_ = os.MkdirAll(dir, 0o755)
+		_ = os.WriteFile(filepath.Join(dir, workRecordFileName), []byte("---\nstatus: "+status+"\ndepartment: ops\n---\n# t\n"), 0o644)
+	}
+
+	summary, err := runArchiveSweepJob(map[string]string{
+		"scan_path":        workspace,
+		"archive_path.ops": archive,
+		"statuses":         "done",
+	})
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if !strings.HasPrefix(summary, "archived 1") {
+		t.Fatalf("unexpected summary: %s", summary)
+	}
+	if _, err := os.Stat(filepath.Join(archive, "2026", "2026.04.01_done")); err != nil {
+		t.Fatalf("expected archived folder: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "2026.04.02_active")); err != nil {
+		t.Fatalf("active task should stay: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// TestMain keeps the tests away from the real app data and makes the
+// temporary directory, where tests create their task folders, a workspace
+// root.
+func TestMain(m *testing.M) {
+	dataDir, err := os.MkdirTemp("", "knot-test-data-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("KNOT_DATA_DIR", dataDir)
+	os.Setenv("KNOT_WORKSPACE_ROOTS", os.TempDir())
+	code := m.Run()
+	os.RemoveAll(dataDir)
+	os.Exit(code)
+}
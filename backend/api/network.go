@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"knot-backend/mail"
+	"knot-backend/proxy"
+)
+
+const networkFileName = "network.json"
+
+// NetworkSettings are the app-wide connection settings. Proxy is used by
+// every mail account and AI endpoint that does not set its own; when it
+// is empty too, HTTPS_PROXY / ALL_PROXY / NO_PROXY apply.
+type NetworkSettings struct {
+	Proxy proxy.Config `json:"proxy"`
+}
+
+var networkMu sync.Mutex
+
+func networkFilePath() string {
+	return filepath.Join(appDataDir(), networkFileName)
+}
+
+func loadNetworkSettings() (NetworkSettings, error) {
+	var settings NetworkSettings
+	networkMu.Lock()
+	err := loadJSONFile(networkFilePath(), &settings)
+	networkMu.Unlock()
+	return settings, err
+}
+
+// resolveProxy picks the proxy of a connection from its own setting, the
+// global settings and the environment.
+func resolveProxy(account *proxy.Config) (proxy.Config, error) {
+	if account != nil {
+		if err := account.Validate(); err != nil {
+			return proxy.Config{}, err
+		}
+	}
+	settings, err := loadNetworkSettings()
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("读取网络设置失败: %v", err)
+	}
+	cfg := proxy.Resolve(account, settings.Proxy)
+	if err := cfg.Validate(); err != nil {
+		return proxy.Config{}, err
+	}
+	return cfg, nil
+}
+
+// configureMailProxy makes provider connect through the proxy of config.
+// Providers that read local files are left alone.
+func configureMailProxy(provider mail.Provider, config MailConfig) error {
+	proxied, ok := provider.(mail.Proxied)
+	if !ok {
+		return nil
+	}
+	cfg, err := resolveProxy(config.Proxy)
+	if err != nil {
+		return err
+	}
+	proxied.SetProxy(cfg)
+	return nil
+}
+
+// aiHTTPClient is the client AI requests are sent with.
+func aiHTTPClient(cfg DailyReportAIConfig, timeout time.Duration) (*http.Client, error) {
+	proxyCfg, err := resolveProxy(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Timeout: timeout, Transport: proxyCfg.Transport()}, nil
+}
+
+func handleGetNetworkSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := loadNetworkSettings()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("读取网络设置失败: %v", err))
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    settings,
+		"env":     proxy.FromEnvironment(),
+	})
+}
+
+func handleSaveNetworkSettings(w http.ResponseWriter, r *http.Request) {
+	var settings NetworkSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	settings.Proxy.URL = strings.TrimSpace(settings.Proxy.URL)
+	settings.Proxy.NoProxy = strings.TrimSpace(settings.Proxy.NoProxy)
+	if err := settings.Proxy.Validate(); err != nil {
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("代理设置无效: %v", err))
+		return
+	}
+
+	networkMu.Lock()
+	err := saveJSONFile(networkFilePath(), settings)
+	networkMu.Unlock()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("保存网络设置失败: %v", err))
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": settings})
+}
+
+// ConnectionTestRequest names the connection to test: the mail account
+// or the AI endpoint. Each is tested with its own proxy setting, falling
+// back to the global one.
+type ConnectionTestRequest struct {
+	Target string              `json:"target"`
+	Mail   MailConfig          `json:"mail"`
+	AI     DailyReportAIConfig `json:"ai"`
+}
+
+// ConnectionTestResult lists the hops of a connection test in order.
+// FailedHop names the first hop that failed and is empty on success.
+type ConnectionTestResult struct {
+	Target    string      `json:"target"`
+	Address   string      `json:"address"`
+	Proxy     string      `json:"proxy"`
+	OK        bool        `json:"ok"`
+	FailedHop string      `json:"failed_hop"`
+	Hops      []proxy.Hop `json:"hops"`
+}
+
+const connectionTestTimeout = 20 * time.Second
+
+// handleTestConnection connects to a mail server or AI endpoint hop by
+// hop - proxy, tunnel, TLS, then login or an HTTP request - and reports
+// which hop failed.
+func handleTestConnection(w http.ResponseWriter, r *http.Request) {
+	var req ConnectionTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var result ConnectionTestResult
+	var err error
+	switch strings.ToLower(strings.TrimSpace(req.Target)) {
+	case "", "mail":
+		result, err = testMailConnection(r.Context(), req.Mail)
+	case "ai":
+		result, err = testAIConnection(r.Context(), req.AI)
+	default:
+		err = fmt.Errorf("unknown target: %s", req.Target)
+	}
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": result})
+}
+
+func newConnectionTestResult(target, addr string, cfg proxy.Config) ConnectionTestResult {
+	result := ConnectionTestResult{Target: target, Address: addr, Proxy: "direct"}
+	if u, _ := cfg.ProxyURL(addr); u != nil {
+		result.Proxy = u.Redacted()
+	}
+	return result
+}
+
+// addHop runs a hop after the connection hops and records it.
+func (result *ConnectionTestResult) addHop(name, addr string, fn func() error) {
+	start := time.Now()
+	err := fn()
+	hop := proxy.Hop{Hop: name, Address: addr, OK: err == nil, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		hop.Error = err.Error()
+	}
+	result.Hops = append(result.Hops, hop)
+}
+
+func (result *ConnectionTestResult) finish() {
+	result.OK = true
+	for _, hop := range result.Hops {
+		if !hop.OK {
+			result.OK = false
+			result.FailedHop = hop.Hop
+			return
+		}
+	}
+}
+
+func testMailConnection(ctx context.Context, config MailConfig) (ConnectionTestResult, error) {
+	protocol := strings.ToLower(strings.TrimSpace(config.Provider))
+	switch protocol {
+	case "", "imap":
+		protocol = "imap"
+	case "pop3", "pop":
+		protocol = "pop3"
+	default:
+		return ConnectionTestResult{}, fmt.Errorf("%s 邮箱无需测试网络连接", config.Provider)
+	}
+	if strings.TrimSpace(config.Server) == "" || config.Port <= 0 {
+		return ConnectionTestResult{}, fmt.Errorf("请填写服务器地址和端口")
+	}
+	cfg, err := resolveProxy(config.Proxy)
+	if err != nil {
+		return ConnectionTestResult{}, fmt.Errorf("代理设置无效: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectionTestTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(strings.TrimSpace(config.Server), strconv.Itoa(config.Port))
+	result := newConnectionTestResult("mail", addr, cfg)
+	hops, conn := proxy.Probe(ctx, cfg, addr, config.UseSSL)
+	result.Hops = hops
+	if conn != nil {
+		result.addHop("login", config.Username, func() error {
+			return mail.CheckLogin(conn, protocol, config.Username, config.Password)
+		})
+	}
+	result.finish()
+	return result, nil
+}
+
+func testAIConnection(ctx context.Context, cfg DailyReportAIConfig) (ConnectionTestResult, error) {
+	endpoint := normalizeAIEndpoint(cfg.APIURL)
+	target, err := url.Parse(endpoint)
+	if endpoint == "" || err != nil || target.Host == "" {
+		return ConnectionTestResult{}, fmt.Errorf("请填写有效的 AI 接口地址")
+	}
+	proxyCfg, err := resolveProxy(cfg.Proxy)
+	if err != nil {
+		return ConnectionTestResult{}, fmt.Errorf("代理设置无效: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectionTestTimeout)
+	defer cancel()
+	addr := target.Host
+	if target.Port() == "" {
+		port := "443"
+		if target.Scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(target.Hostname(), port)
+	}
+	result := newConnectionTestResult("ai", addr, proxyCfg)
+	hops, conn := proxy.Probe(ctx, proxyCfg, addr, target.Scheme == "https")
+	result.Hops = hops
+	if conn != nil {
+		conn.Close()
+		models := strings.TrimSuffix(endpoint, "/chat/completions") + "/models"
+		result.addHop("http", models, func() error {
+			return checkAIEndpoint(ctx, proxyCfg, models, cfg.APIKey)
+		})
+	}
+	result.finish()
+	return result, nil
+}
+
+// checkAIEndpoint lists the models of an OpenAI-compatible endpoint, which
+// checks the API key without spending tokens.
+func checkAIEndpoint(ctx context.Context, cfg proxy.Config, endpoint, apiKey string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(apiKey) != "" {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(apiKey))
+	}
+	resp, err := (&http.Client{Transport: cfg.Transport()}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
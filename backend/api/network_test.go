@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+
+	"knot-backend/proxy"
+)
+
+func clearProxyEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+}
+
+// startTunnelProxy starts an HTTP CONNECT proxy and returns its address.
+func startTunnelProxy(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+				go io.Copy(target, br)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func postConnectionTest(t *testing.T, router http.Handler, req ConnectionTestRequest) (int, ConnectionTestResult) {
+	t.Helper()
+	raw, _ := json.Marshal(req)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/network/test", bytes.NewReader(raw)))
+	var resp struct {
+		Data ConnectionTestResult `json:"data"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp.Data
+}
+
+func hopNames(hops []proxy.Hop) string {
+	names := make([]string, len(hops))
+	for i, hop := range hops {
+		names[i] = hop.Hop
+	}
+	return strings.Join(names, ",")
+}
+
+func TestNetworkSettings_SaveAndLoad(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	router := SetupRoutes()
+
+	put := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/api/settings/network", strings.NewReader(body)))
+		return rr
+	}
+	if rr := put(`{"proxy":{"url":"ftp://proxy:21"}}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid proxy to be rejected, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := put(`{"proxy":{"url":" socks5://proxy.corp:1080 ","no_proxy":"localhost,.corp"}}`); rr.Code != http.StatusOK {
+		t.Fatalf("save failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/settings/network", nil))
+	var resp struct {
+		Data NetworkSettings `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Proxy.URL != "socks5://proxy.corp:1080" || resp.Data.Proxy.NoProxy != "localhost,.corp" {
+		t.Fatalf("unexpected settings: %+v", resp.Data)
+	}
+
+	cfg, err := resolveProxy(&proxy.Config{})
+	if err != nil || cfg.URL != "socks5://proxy.corp:1080" {
+		t.Fatalf("expected global proxy for an account without one, got %+v %v", cfg, err)
+	}
+}
+
+func TestHandleTestConnection_MailThroughProxy(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	clearProxyEnv(t)
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	router := SetupRoutes()
+	account := MailConfig{
+		Server:   addr.IP.String(),
+		Port:     addr.Port,
+		Username: "username",
+		Password: "password",
+		Proxy:    &proxy.Config{URL: "http://" + startTunnelProxy(t)},
+	}
+	code, result := postConnectionTest(t, router, ConnectionTestRequest{Target: "mail", Mail: account})
+	if code != http.StatusOK || !result.OK || result.FailedHop != "" || hopNames(result.Hops) != "proxy,tunnel,login" {
+		t.Fatalf("unexpected result: %d %+v", code, result)
+	}
+
+	account.Password = "wrong"
+	_, result = postConnectionTest(t, router, ConnectionTestRequest{Target: "mail", Mail: account})
+	if result.OK || result.FailedHop != "login" {
+		t.Fatalf("expected login to fail, got %+v", result)
+	}
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	account.Proxy = &proxy.Config{URL: "socks5://user:secret@" + closed.Addr().String()}
+	_, result = postConnectionTest(t, router, ConnectionTestRequest{Target: "mail", Mail: account})
+	if result.OK || result.FailedHop != "proxy" || len(result.Hops) != 1 {
+		t.Fatalf("expected proxy hop to fail, got %+v", result)
+	}
+	if strings.Contains(result.Proxy, "secret") {
+		t.Fatalf("proxy password leaked: %s", result.Proxy)
+	}
+}
+
+func TestHandleTestConnection_AI(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	clearProxyEnv(t)
+	ai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"data":[]}`)
+	}))
+	defer ai.Close()
+
+	router := SetupRoutes()
+	code, result := postConnectionTest(t, router, ConnectionTestRequest{Target: "ai", AI: DailyReportAIConfig{APIURL: ai.URL, APIKey: "sk-test"}})
+	if code != http.StatusOK || !result.OK || hopNames(result.Hops) != "target,http" || result.Proxy != "direct" {
+		t.Fatalf("unexpected result: %d %+v", code, result)
+	}
+
+	_, result = postConnectionTest(t, router, ConnectionTestRequest{Target: "ai", AI: DailyReportAIConfig{APIURL: ai.URL, APIKey: "wrong"}})
+	if result.OK || result.FailedHop != "http" || !strings.Contains(result.Hops[1].Error, "401") {
+		t.Fatalf("expected http hop to fail, got %+v", result)
+	}
+
+	if code, _ := postConnectionTest(t, router, ConnectionTestRequest{Target: "ai"}); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without an endpoint, got %d", code)
+	}
+}
+
+func TestGenerateDailyLogWithAI_UsesGlobalProxy(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	clearProxyEnv(t)
+	var proxied []string
+	forward := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		io.WriteString(w, `{"choices":[{"message":{"content":"完成材料审核，下一步提交汇总。"}}]}`)
+	}))
+	defer forward.Close()
+	if err := saveJSONFile(networkFilePath(), NetworkSettings{Proxy: proxy.Config{URL: forward.URL}}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := generateDailyLogWithAI(DailyReportAIConfig{APIURL: "http://ai.example.invalid/v1"}, "材料")
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	if content != "完成材料审核，下一步提交汇总。" || len(proxied) != 1 || proxied[0] != "POST http://ai.example.invalid/v1/chat/completions" {
+		t.Fatalf("expected request through the proxy, got %q %q", content, proxied)
+	}
+}
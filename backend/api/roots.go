@@ -0,0 +1,262 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const rootsFileName = "roots.json"
+
+// WorkspaceRoots are the folders the app may read and change. Task
+// folders are created, scanned, renamed and archived only inside them;
+// any other path from a request is refused. KNOT_WORKSPACE_ROOTS and
+// KNOT_ARCHIVE_ROOTS (separated like PATH) add roots to the saved ones.
+type WorkspaceRoots struct {
+	Workspace []string `json:"workspace"`
+	Archive   []string `json:"archive"`
+}
+
+var rootsMu sync.Mutex
+
+// errPathDenied marks paths outside the workspace roots.
+var errPathDenied = errors.New("路径不在允许的工作区目录内")
+
+// pathErrorStatus is the HTTP status of a path refused by resolveAllowedPath.
+func pathErrorStatus(err error) int {
+	if errors.Is(err, errPathDenied) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func rootsFilePath() string {
+	return filepath.Join(appDataDir(), rootsFileName)
+}
+
+// loadWorkspaceRoots returns the saved roots and whether any were saved.
+func loadWorkspaceRoots() (WorkspaceRoots, bool, error) {
+	var roots WorkspaceRoots
+	rootsMu.Lock()
+	defer rootsMu.Unlock()
+	if _, err := os.Stat(rootsFilePath()); os.IsNotExist(err) {
+		return roots, false, nil
+	}
+	err := loadJSONFile(rootsFilePath(), &roots)
+	return roots, err == nil, err
+}
+
+func envRoots(key string) []string {
+	var roots []string
+	for _, p := range filepath.SplitList(os.Getenv(key)) {
+		if p = strings.TrimSpace(p); p != "" {
+			roots = append(roots, p)
+		}
+	}
+	return roots
+}
+
+// allowedRoots are the canonical workspace and archive roots. Until roots
+// are configured, the default work folder is the only one.
+func allowedRoots() ([]string, error) {
+	saved, _, err := loadWorkspaceRoots()
+	if err != nil {
+		return nil, fmt.Errorf("读取工作区目录设置失败: %v", err)
+	}
+	var configured []string
+	configured = append(configured, saved.Workspace...)
+	configured = append(configured, saved.Archive...)
+	configured = append(configured, envRoots("KNOT_WORKSPACE_ROOTS")...)
+	configured = append(configured, envRoots("KNOT_ARCHIVE_ROOTS")...)
+	if len(configured) == 0 {
+		configured = []string{getBaseFolder("")}
+	}
+
+	var roots []string
+	for _, r := range configured {
+		if canonical, err := canonicalPath(r); err == nil {
+			roots = append(roots, canonical)
+		}
+	}
+	return roots, nil
+}
+
+// canonicalPath expands ~ and returns the absolute, cleaned path with
+// symbolic links resolved. Parts that do not exist yet are kept as given
+// below their deepest existing parent.
+func canonicalPath(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "~") {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("路径必须是绝对路径: %s", path)
+	}
+	path = filepath.Clean(path)
+
+	existing, rest := path, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+// withinRoot reports whether path is root or below it. Both are canonical.
+func withinRoot(path, root string) bool {
+	rel, err := filepath.Rel(normalizePathKey(root), normalizePathKey(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// resolveAllowedPath returns the canonical form of path when it is a root
+// or lies inside one.
+func resolveAllowedPath(path string) (string, error) {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return "", err
+	}
+	roots, err := allowedRoots()
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		if withinRoot(canonical, root) {
+			return canonical, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errPathDenied, path)
+}
+
+// resolveAllowedFolder is resolveAllowedPath for a folder that is renamed,
+// moved or written to: a root itself is refused.
+func resolveAllowedFolder(path string) (string, error) {
+	canonical, err := resolveAllowedPath(path)
+	if err != nil {
+		return "", err
+	}
+	roots, err := allowedRoots()
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		if normalizePathKey(canonical) == normalizePathKey(root) {
+			return "", fmt.Errorf("%w，不能操作根目录本身: %s", errPathDenied, path)
+		}
+	}
+	return canonical, nil
+}
+
+// resolveBaseFolder checks a work folder from a request, the default one
+// when empty, and creates it.
+func resolveBaseFolder(basePath string) (string, error) {
+	folder, err := resolveAllowedPath(getBaseFolder(basePath))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		return "", err
+	}
+	return folder, nil
+}
+
+// lookupFolder is the canonical form of a folder only searched for
+// existing tasks, or false when it is outside the roots.
+func lookupFolder(path string) (string, bool) {
+	folder, err := resolveAllowedPath(getBaseFolder(path))
+	return folder, err == nil
+}
+
+func handleGetRoots(w http.ResponseWriter, r *http.Request) {
+	roots, configured, err := loadWorkspaceRoots()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("读取工作区目录设置失败: %v", err))
+		return
+	}
+	if roots.Workspace == nil {
+		roots.Workspace = []string{}
+	}
+	if roots.Archive == nil {
+		roots.Archive = []string{}
+	}
+	effective, err := allowedRoots()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"data":       roots,
+		"configured": configured,
+		"effective":  effective,
+	})
+}
+
+func handleSaveRoots(w http.ResponseWriter, r *http.Request) {
+	var roots WorkspaceRoots
+	if err := json.NewDecoder(r.Body).Decode(&roots); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	clean := func(paths []string) ([]string, error) {
+		result := []string{}
+		seen := map[string]bool{}
+		for _, p := range paths {
+			if strings.TrimSpace(p) == "" {
+				continue
+			}
+			canonical, err := canonicalPath(p)
+			if err != nil {
+				return nil, err
+			}
+			if filepath.Dir(canonical) == canonical {
+				return nil, fmt.Errorf("不能把文件系统根目录设为工作区: %s", p)
+			}
+			if key := normalizePathKey(canonical); !seen[key] {
+				seen[key] = true
+				result = append(result, canonical)
+			}
+		}
+		return result, nil
+	}
+	var err error
+	if roots.Workspace, err = clean(roots.Workspace); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if roots.Archive, err = clean(roots.Archive); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rootsMu.Lock()
+	err = saveJSONFile(rootsFilePath(), roots)
+	rootsMu.Unlock()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("保存工作区目录设置失败: %v", err))
+		return
+	}
+	invalidateTaskIndex()
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": roots})
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func postJSON(t *testing.T, router http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	if token := appToken(); token != "" {
+		req.Header.Set(appTokenHeader, token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestResolveAllowedPath_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	t.Setenv("KNOT_WORKSPACE_ROOTS", root)
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if got, err := resolveAllowedPath(filepath.Join(root, "tasks", "new")); err != nil || got != filepath.Join(root, "tasks", "new") {
+		t.Fatalf("expected a path inside the root, got %q: %v", got, err)
+	}
+	for _, p := range []string{
+		outside,
+		filepath.Join(root, "link"),
+		filepath.Join(root, "link", "not-yet-created"),
+		root + string(filepath.Separator) + ".." + string(filepath.Separator) + filepath.Base(outside),
+	} {
+		if _, err := resolveAllowedPath(p); !errors.Is(err, errPathDenied) {
+			t.Errorf("expected %s to be refused, got %v", p, err)
+		}
+	}
+	if _, err := resolveAllowedFolder(root); !errors.Is(err, errPathDenied) {
+		t.Fatalf("expected the root itself to be refused, got %v", err)
+	}
+}
+
+func TestWorkspaceRoots_Handlers(t *testing.T) {
+	root := t.TempDir()
+	archive := t.TempDir()
+	outside := t.TempDir()
+	t.Setenv("KNOT_WORKSPACE_ROOTS", root)
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	router := SetupRoutes()
+
+	rr := postFolderCreate(t, router, FolderRequest{Subject: "x", BasePath: outside, FolderName: "x", Source: "manual"})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 outside the roots, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "任务", BasePath: root, FolderName: "task", Source: "manual"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	task := filepath.Join(root, "task")
+
+	// The archive is not a root yet.
+	rr = postJSON(t, router, http.MethodPost, "/api/archive/move", ArchiveMoveRequest{FolderPath: task, ArchivePath: archive})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an archive outside the roots, got %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(task); err != nil {
+		t.Fatalf("expected the task to stay: %v", err)
+	}
+	rr = postJSON(t, router, http.MethodPost, "/api/archive/update-work-record", UpdateWorkRecordRequest{FolderPath: root, Title: "x", RenameFolder: true})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for the root itself, got %d %s", rr.Code, rr.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/archive/scan?scan_path="+outside, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a scan outside the roots, got %d", rec.Code)
+	}
+
+	rr = postJSON(t, router, http.MethodPut, "/api/settings/roots", WorkspaceRoots{Archive: []string{archive}})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without the app token, got %d", rr.Code)
+	}
+	t.Setenv("KNOT_APP_TOKEN", "launch-token")
+
+	rr = postJSON(t, router, http.MethodPut, "/api/settings/roots", WorkspaceRoots{Archive: []string{"relative"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a relative root, got %d", rr.Code)
+	}
+	rr = postJSON(t, router, http.MethodPut, "/api/settings/roots", WorkspaceRoots{Archive: []string{archive}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("save roots: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = postJSON(t, router, http.MethodPost, "/api/archive/move", ArchiveMoveRequest{FolderPath: task, ArchivePath: archive})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the move to a configured archive, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/settings/roots", nil)
+	req.Header.Set(appTokenHeader, "launch-token")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var resp struct {
+		Data       WorkspaceRoots `json:"data"`
+		Configured bool           `json:"configured"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Configured || len(resp.Data.Archive) != 1 {
+		t.Fatalf("unexpected roots: %s", rec.Body.String())
+	}
+}
+
+func TestSetupRoutes_RejectsForeignOrigins(t *testing.T) {
+	router := SetupRoutes()
+	get := func(origin, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/templates", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if token != "" {
+			req.Header.Set(appTokenHeader, token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for origin, want := range map[string]bool{"https://evil.example": false, "null": false, "file://": false, "http://localhost:5173": true, "": true} {
+		if allowed := get(origin, "").Code != http.StatusForbidden; allowed != want {
+			t.Errorf("origin %q without a token: allowed=%v", origin, allowed)
+		}
+	}
+
+	// Started by the app, every request needs the token, with or without
+	// an Origin header.
+	t.Setenv("KNOT_APP_TOKEN", "launch-token")
+	for _, origin := range []string{"", "null", "http://localhost:5173"} {
+		if rr := get(origin, ""); rr.Code != http.StatusForbidden {
+			t.Errorf("origin %q without the token: got %d", origin, rr.Code)
+		}
+		if rr := get(origin, "wrong"); rr.Code != http.StatusForbidden {
+			t.Errorf("origin %q with a wrong token: got %d", origin, rr.Code)
+		}
+	}
+	rr := get("null", "launch-token")
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "null" {
+		t.Fatalf("expected the packaged window to be served, got %d %v", rr.Code, rr.Header())
+	}
+
+	// The image proxy takes the token from the query, as <img> cannot set
+	// headers; other routes do not.
+	for path, want := range map[string]bool{
+		imageProxyPath:                         false,
+		imageProxyPath + "?token=wrong":        false,
+		imageProxyPath + "?token=launch-token": true,
+		"/api/templates?token=launch-token":    false,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if allowed := rec.Code != http.StatusForbidden; allowed != want {
+			t.Errorf("%s: allowed=%v, got %d", path, allowed, rec.Code)
+		}
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,9 +19,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"knot-backend/mail"
+	"knot-backend/proxy"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -33,7 +39,14 @@ const (
 	taskAttachmentDir  = "\u9644\u4ef6"
 )
 
-var mailClient *mail.MailClient
+var mailProvider mail.Provider
+
+// currentMailbox is the folder last selected on mailProvider.
+var currentMailbox = "INBOX"
+
+// mailMu serializes use of mailProvider. HTTP handlers and background jobs
+// share a single connection.
+var mailMu sync.Mutex
 
 // SetupRoutes initializes the chi router with common middleware and configures endpoints.
 func SetupRoutes() *chi.Mux {
@@ -42,24 +55,36 @@ func SetupRoutes() *chi.Mux {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
+	origins := allowedOrigins()
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
+		AllowOriginFunc:  corsOriginAllowed(origins),
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", appTokenHeader},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
+	r.Use(guardRequests(origins))
 
 	r.Route("/api", func(r chi.Router) {
 		r.Post("/mail/connect", handleConnectMail)
 		r.Get("/mail/list", handleGetMailList)
+		r.Post("/mail/sync", handleSyncMail)
+		r.Get("/mail/folders", handleGetMailFolders)
+		r.Get("/mail/search", handleSearchMail)
 		r.Get("/mail/{mail_id}/attachments", handleGetAttachments)
 		r.Get("/mail/{mail_id}/detail", handleGetMailDetail)
+		r.Get("/mail/image-proxy", handleImageProxy)
+		r.Get("/mail/status", handleMailStatus)
+		r.Get("/mail/trace", handleGetMailTrace)
 
 		r.Post("/folder/create", handleCreateFolder)
 		r.Post("/folder/create-with-attachments", handleCreateFolderWithAttachments)
 		r.Get("/folder/check-hash", handleCheckHash)
+		r.Post("/folder/append-mail", handleAppendMail)
+		r.Post("/folder/clone", handleCloneFolder)
+		r.Get("/templates", handleGetTemplates)
+		r.Put("/templates", handleSaveTemplates)
 
 		r.Get("/archive/scan", handleScanWorkFolders)
 		r.Post("/archive/move", handleArchiveMove)
@@ -67,6 +92,25 @@ func SetupRoutes() *chi.Mux {
 		r.Post("/archive/update-work-record", handleUpdateWorkRecord)
 
 		r.Post("/report/daily/generate", handleGenerateDailyReport)
+
+		r.Get("/settings/roots", handleGetRoots)
+		r.With(requireAppToken).Put("/settings/roots", handleSaveRoots)
+		r.Get("/settings/network", handleGetNetworkSettings)
+		r.Put("/settings/network", handleSaveNetworkSettings)
+		r.Post("/network/test", handleTestConnection)
+
+		r.Get("/rules", handleGetRules)
+		r.Put("/rules", handleSaveRules)
+		r.Post("/rules/run", handleRunRules)
+		r.Get("/rules/runs", handleGetRuleRuns)
+		r.Get("/rules/runs/{run_id}", handleGetRuleRun)
+
+		r.Get("/scheduler/jobs", handleGetJobs)
+		r.Get("/scheduler/jobs/{job_id}", handleGetJob)
+		r.Put("/scheduler/jobs/{job_id}", handleUpdateJob)
+		r.Post("/scheduler/jobs/{job_id}/pause", handlePauseJob)
+		r.Post("/scheduler/jobs/{job_id}/resume", handleResumeJob)
+		r.Post("/scheduler/jobs/{job_id}/trigger", handleTriggerJob)
 	})
 
 	return r
@@ -89,11 +133,52 @@ func jsonError(w http.ResponseWriter, status int, message string) {
 // -- Mail Handlers --
 
 type MailConfig struct {
+	Provider string `json:"provider"`
 	Server   string `json:"server"`
 	Port     int    `json:"port"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 	UseSSL   bool   `json:"use_ssl"`
+	Path     string `json:"path"`
+	// TrustStore is a certificate file or directory S/MIME signers must
+	// chain to; empty means the system roots. SMIMEKey is a PEM file with
+	// the private key encrypted mail is opened with.
+	TrustStore string `json:"trust_store"`
+	SMIMEKey   string `json:"smime_key"`
+	// Proxy overrides the global proxy for this account; nil uses it.
+	Proxy *proxy.Config `json:"proxy,omitempty"`
+	// Trace logs the IMAP exchange, without passwords, to the trace file.
+	Trace bool `json:"trace"`
+	// AllowInsecure lets a POP3 account skip the certificate check and log
+	// in without encryption when the server offers no STLS.
+	AllowInsecure bool `json:"allow_insecure"`
+}
+
+// newMailProvider picks the mail backend named by config.Provider. IMAP is
+// the default so existing clients keep working.
+func newMailProvider(config MailConfig) (mail.Provider, error) {
+	switch strings.ToLower(strings.TrimSpace(config.Provider)) {
+	case "", "imap":
+		client := mail.NewMailClient(config.Server, config.Port, config.Username, config.Password, config.UseSSL)
+		if limit := messageCacheBytes(); limit > 0 {
+			client.SetCache(mail.NewMessageCache(filepath.Join(appDataDir(), "cache", "messages"), limit))
+		}
+		return client, nil
+	case "pop3", "pop":
+		storeDir := filepath.Join(appDataDir(), "pop3", sanitizeFolderName(config.Username+"@"+config.Server))
+		client := mail.NewPOP3Client(config.Server, config.Port, config.Username, config.Password, config.UseSSL, storeDir)
+		client.SetAllowInsecure(config.AllowInsecure)
+		return client, nil
+	case "maildir", "eml", "local":
+		if strings.TrimSpace(config.Path) == "" {
+			return nil, fmt.Errorf("path is required for maildir provider")
+		}
+		return mail.NewMaildirProvider(getBaseFolder(config.Path)), nil
+	case "demo", "mock":
+		return mail.NewDemoProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider: %s", config.Provider)
+	}
 }
 
 func handleConnectMail(w http.ResponseWriter, r *http.Request) {
@@ -103,12 +188,40 @@ func handleConnectMail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if mailClient != nil {
-		mailClient.Disconnect()
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
+	if mailProvider != nil {
+		mailProvider.Disconnect()
 	}
 
-	mailClient = mail.NewMailClient(config.Server, config.Port, config.Username, config.Password, config.UseSSL)
-	if err := mailClient.Connect(); err != nil {
+	provider, err := newMailProvider(config)
+	if err != nil {
+		mailProvider = nil
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := configureMailSecurity(provider, config); err != nil {
+		mailProvider = nil
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("加载证书失败: %v", err))
+		return
+	}
+	if err := configureMailProxy(provider, config); err != nil {
+		mailProvider = nil
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("代理设置无效: %v", err))
+		return
+	}
+	if err := configureMailTrace(provider, config); err != nil {
+		log.Printf("IMAP trace disabled: %v", err)
+	}
+
+	mailProvider = provider
+	currentMailbox = "INBOX"
+	if err := mailProvider.Connect(); err != nil {
+		if status, msg := mailErrorResponse(err); status == http.StatusUnauthorized {
+			jsonError(w, status, msg)
+			return
+		}
 		jsonError(w, http.StatusBadRequest, fmt.Sprintf("连接失败: %v", err))
 		return
 	}
@@ -116,38 +229,154 @@ func handleConnectMail(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "message": "连接成功"})
 }
 
+// handleGetMailList lists the selected mailbox. It only reads: rules and
+// auto_append run on POST /mail/sync, and rules also on the sync job.
 func handleGetMailList(w http.ResponseWriter, r *http.Request) {
-	if mailClient == nil {
+	serveMailList(w, r, false)
+}
+
+// handleSyncMail fetches the mailbox like handleGetMailList, runs the mail
+// rules on it and, with auto_append=true, appends follow-up replies to
+// their thread's task, then returns the list.
+func handleSyncMail(w http.ResponseWriter, r *http.Request) {
+	serveMailList(w, r, true)
+}
+
+func serveMailList(w http.ResponseWriter, r *http.Request, sync bool) {
+	if mailProvider == nil {
 		jsonError(w, http.StatusBadRequest, "请先连接邮箱")
 		return
 	}
 
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 	if limit <= 0 {
 		limit = 50
 	}
 
 	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	categories, err := parseCategoryFilter(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if mailbox := strings.TrimSpace(r.URL.Query().Get("mailbox")); mailbox != "" {
+		if err := mailProvider.SelectFolder(mailbox); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		currentMailbox = mailbox
+	}
 
-	mails, err := mailClient.FetchMailList(limit, days)
+	mails, err := mailProvider.FetchMailList(limit, days)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+		mailError(w, err)
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": mails})
+	if sync {
+		runMailRules(mails, currentMailbox, "sync")
+	}
+
+	query := r.URL.Query()
+	list := withTaskStatus(mails, newTaskLookup(query.Get("scan_path"), query["archive_path"]))
+	threads := mailThreads(mails)
+	linkThreadTasks(list, threads, sync && query.Get("auto_append") == "true")
+	// Filter after threading so replies still find their conversation.
+	list = filterByCategory(list, categories)
+
+	if query.Get("group_by") == "thread" {
+		jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": groupByThread(list, threads)})
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": list})
+}
+
+func handleGetMailFolders(w http.ResponseWriter, r *http.Request) {
+	if mailProvider == nil {
+		jsonError(w, http.StatusBadRequest, "请先连接邮箱")
+		return
+	}
+
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
+	folders, err := mailProvider.ListFolders()
+	if err != nil {
+		mailError(w, err)
+		return
+	}
+	if folders == nil {
+		folders = []string{}
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": folders})
+}
+
+func handleSearchMail(w http.ResponseWriter, r *http.Request) {
+	if mailProvider == nil {
+		jsonError(w, http.StatusBadRequest, "请先连接邮箱")
+		return
+	}
+
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
+	q := r.URL.Query()
+	criteria := mail.SearchCriteria{
+		Text:    q.Get("q"),
+		From:    q.Get("from"),
+		Subject: q.Get("subject"),
+	}
+	criteria.Days, _ = strconv.Atoi(q.Get("days"))
+	criteria.Limit, _ = strconv.Atoi(q.Get("limit"))
+	if criteria.Limit <= 0 {
+		criteria.Limit = 50
+	}
+	categories, err := parseCategoryFilter(q)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if mailbox := strings.TrimSpace(q.Get("mailbox")); mailbox != "" {
+		if err := mailProvider.SelectFolder(mailbox); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		currentMailbox = mailbox
+	}
+
+	mails, err := mailProvider.Search(criteria)
+	if err != nil {
+		mailError(w, err)
+		return
+	}
+	if mails == nil {
+		mails = []mail.MailItem{}
+	}
+
+	lookup := newTaskLookup(r.URL.Query().Get("scan_path"), r.URL.Query()["archive_path"])
+	list := filterByCategory(withTaskStatus(mails, lookup), categories)
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": list})
 }
 
 func handleGetAttachments(w http.ResponseWriter, r *http.Request) {
-	if mailClient == nil {
+	if mailProvider == nil {
 		jsonError(w, http.StatusBadRequest, "请先连接邮箱")
 		return
 	}
 
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
 	mailID := chi.URLParam(r, "mail_id")
-	attachments, err := mailClient.FetchAttachments(mailID)
+	attachments, err := mailProvider.FetchAttachments(mailID)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+		mailError(w, err)
 		return
 	}
 
@@ -155,17 +384,21 @@ func handleGetAttachments(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleGetMailDetail(w http.ResponseWriter, r *http.Request) {
-	if mailClient == nil {
+	if mailProvider == nil {
 		jsonError(w, http.StatusBadRequest, "请先连接邮箱")
 		return
 	}
 
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
 	mailID := chi.URLParam(r, "mail_id")
-	detail, err := mailClient.FetchMailDetail(mailID)
+	detail, err := mailProvider.FetchMailDetail(mailID)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+		mailError(w, err)
 		return
 	}
+	sanitizeDetail(r, detail, r.URL.Query().Get("load_images") == "true")
 
 	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": detail})
 }
@@ -178,6 +411,10 @@ type FolderRequest struct {
 	Date                string                   `json:"date"`
 	FromAddr            string                   `json:"from_addr"`
 	Body                string                   `json:"body"`
+	NewContent          string                   `json:"new_content"`
+	Sender              string                   `json:"sender"` // "Name <addr>" of the From header
+	To                  []string                 `json:"to"`
+	Cc                  []string                 `json:"cc"`
 	BasePath            string                   `json:"base_path"`
 	FolderName          string                   `json:"folder_name"`
 	UseSubFolder        bool                     `json:"use_sub_folder"`
@@ -190,6 +427,36 @@ type FolderRequest struct {
 	Department          string                   `json:"department"`
 	Source              string                   `json:"source"`
 	Hash                string                   `json:"hash"`
+	MessageID           string                   `json:"message_id"`
+	ArchivePaths        []string                 `json:"archive_paths"`
+	Force               bool                     `json:"force"`
+	// Events are the calendar events of the mail; when empty they are read
+	// from the provider's mail detail.
+	Events []mail.CalendarEvent `json:"events"`
+	// Security is the signing and encryption of the mail, read from the
+	// provider's mail detail when not given.
+	Security *mail.MessageSecurity `json:"security"`
+	// Template is the ID of the task template; empty picks the template of
+	// the department, else the default.
+	Template string `json:"template"`
+	// FolderNameFormat, when set, is rendered by renderFolderName and
+	// replaces FolderName.
+	FolderNameFormat string `json:"folder_name_format"`
+	// OnConflict decides what happens when the folder name is taken:
+	// "suffix" (default) or "error" for a 409.
+	OnConflict string `json:"on_conflict"`
+	// SourcePaths are local files put into 00_来源资料 of a manual task.
+	// SourceMode "move" removes them once the task is created; the default
+	// "copy" keeps them.
+	SourcePaths []string `json:"source_paths"`
+	SourceMode  string   `json:"source_mode"`
+
+	// uploads are the files of a multipart request.
+	uploads []*multipart.FileHeader
+	// derivedFrom is the task folder a clone copies its skeleton and
+	// cloneDirs from.
+	derivedFrom string
+	cloneDirs   []string
 }
 
 func getBaseFolder(basePath string) string {
@@ -200,7 +467,6 @@ func getBaseFolder(basePath string) string {
 			basePath = filepath.Join(home, strings.TrimPrefix(basePath, "~"))
 		}
 		if filepath.IsAbs(basePath) {
-			_ = os.MkdirAll(basePath, 0o755)
 			return filepath.Clean(basePath)
 		}
 	}
@@ -213,14 +479,75 @@ func getBaseFolder(basePath string) string {
 	return desktop
 }
 
+const defaultMessageCacheMB = 512
+
+// messageCacheBytes is the size limit of the local message cache.
+// KNOT_MESSAGE_CACHE_MB overrides the default; 0 turns the cache off.
+func messageCacheBytes() int64 {
+	mb := int64(defaultMessageCacheMB)
+	if v, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("KNOT_MESSAGE_CACHE_MB")), 10, 64); err == nil && v >= 0 {
+		mb = v
+	}
+	return mb << 20
+}
+
+// appDataDir is where the backend keeps its own state (downloaded POP3 mail,
+// caches, settings). KNOT_DATA_DIR overrides the per-user config directory.
+func appDataDir() string {
+	if dir := strings.TrimSpace(os.Getenv("KNOT_DATA_DIR")); dir != "" {
+		return filepath.Clean(dir)
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "knot")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".knot")
+}
+
+// loadJSONFile decodes path into v. A missing file leaves v untouched.
+func loadJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSONFile writes v to path through a temporary file so readers never see
+// a half-written document.
+func saveJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sanitizeFolderName drops characters file systems refuse, trailing dots
+// and spaces, and cuts the name to maxFolderNameBytes.
 func sanitizeFolderName(name string) string {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return "task_" + time.Now().Format("20060102150405")
 	}
-	invalid := regexp.MustCompile(`[\\/:*?"<>|]`)
-	name = invalid.ReplaceAllString(name, "")
-	name = strings.TrimSpace(name)
+	name = folderInvalidPattern.ReplaceAllString(name, "")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(strings.TrimSpace(truncateFolderName(name, maxFolderNameBytes)), ". ")
 	if name == "" {
 		return "task_" + time.Now().Format("20060102150405")
 	}
@@ -241,27 +568,40 @@ func normalizeSource(source string, hasMailID bool) string {
 	return "manual"
 }
 
-func createTaskStructure(folderPath string) error {
-	dirs := []string{
-		filepath.Join(folderPath, taskSourceDirName),
-		filepath.Join(folderPath, taskProcessDirName),
-		filepath.Join(folderPath, taskOutputDirName),
-	}
-	for _, d := range dirs {
-		if err := os.MkdirAll(d, 0o755); err != nil {
-			return err
-		}
+// emailFullFileName keeps the full body, quoted history included, when
+// email.txt only holds the new content of a reply.
+const emailFullFileName = "email_full.txt"
+
+// emailNewContent is what the sender wrote in req's mail, without quoted
+// replies and signature.
+func emailNewContent(req FolderRequest) string {
+	if content := strings.TrimSpace(req.NewContent); content != "" {
+		return content
 	}
-	return nil
+	return mail.ExtractNewContent(req.Body)
+}
+
+// emailHasQuotes reports whether the new content of req's mail differs from
+// its full body.
+func emailHasQuotes(req FolderRequest) bool {
+	return emailNewContent(req) != strings.TrimSpace(req.Body)
 }
 
 func buildEmailTXT(req FolderRequest) string {
+	body := emailNewContent(req)
+	if emailHasQuotes(req) {
+		body += "\n\n（以上为本封邮件的新内容，含引用历史的完整邮件见 " + emailFullFileName + "）"
+	}
+	return formatEmailTXT(req, body)
+}
+
+func formatEmailTXT(req FolderRequest, body string) string {
 	return fmt.Sprintf(
 		"主题: %s\n发件人: %s\n日期: %s\n\n%s\n",
 		req.Subject,
 		req.FromAddr,
 		req.Date,
-		req.Body,
+		body,
 	)
 }
 
@@ -346,145 +686,264 @@ func writeEmailSourceFiles(folderPath string, req FolderRequest) error {
 		return err
 	}
 
-	if err := os.WriteFile(filepath.Join(sourceDir, "email.txt"), []byte(buildEmailTXT(req)), 0o644); err != nil {
+	return writeEmailTexts(sourceDir, req)
+}
+
+// writeEmailTexts writes email.txt and email.pdf with the new content of the
+// mail, plus email_full.txt with the full body when quotes were stripped.
+func writeEmailTexts(dir string, req FolderRequest) error {
+	if err := os.WriteFile(filepath.Join(dir, "email.txt"), []byte(buildEmailTXT(req)), 0o644); err != nil {
 		return err
 	}
+	if emailHasQuotes(req) {
+		if err := os.WriteFile(filepath.Join(dir, emailFullFileName), []byte(formatEmailTXT(req, req.Body)), 0o644); err != nil {
+			return err
+		}
+	}
 
 	pdfTitle := req.Subject
 	if strings.TrimSpace(pdfTitle) == "" {
 		pdfTitle = "Email Source"
 	}
-	if err := writePlainTextPDF(filepath.Join(sourceDir, "email.pdf"), pdfTitle, buildEmailTXT(req)); err != nil {
-		return err
-	}
-
-	return nil
+	return writePlainTextPDF(filepath.Join(dir, "email.pdf"), pdfTitle, buildEmailTXT(req))
 }
 
-func writeManualSourceFiles(folderPath string) error {
-	sourceDir := filepath.Join(folderPath, taskSourceDirName)
-	return os.MkdirAll(sourceDir, 0o755)
+// buildWorkRecordTemplate renders the work record of the built-in template.
+func buildWorkRecordTemplate(req FolderRequest, folderName, folderPath, sourceType string, attachments []string, now time.Time) string {
+	return renderWorkRecord(builtinTaskTemplate(), req, taskTemplateVars(req, folderName, folderPath, sourceType, attachments, now))
 }
 
-func buildWorkRecordTemplate(req FolderRequest, folderName, folderPath, sourceType string, now time.Time) string {
-	createdDate := now.Format("2006-01-02")
-	title := strings.TrimSpace(req.Subject)
-	if title == "" {
-		title = folderName
-	}
-	if strings.TrimSpace(req.Department) == "" {
-		req.Department = ""
-	}
-	hash := strings.TrimSpace(req.Hash)
-	projectPath := filepath.ToSlash(folderPath)
-
-	return fmt.Sprintf(`---
-type: task
-schema_version: 3
-title: %s
-status: active
-created: %s
-updated: %s
-source: %s
-department: %s
-project_path: %s
-folder_name: %s
-archive_status: local_active
-hash: %s
-tags:
-  - 工作材料
----
+func handleCreateFolder(w http.ResponseWriter, r *http.Request) {
+	processFolderCreation(w, r, false)
+}
 
-# %s
+func handleCreateFolderWithAttachments(w http.ResponseWriter, r *http.Request) {
+	processFolderCreation(w, r, true)
+}
 
-## 工作内容
+func processFolderCreation(w http.ResponseWriter, r *http.Request, downloadAttachments bool) {
+	req, cleanup, decodeErr := decodeFolderRequest(w, r)
+	if decodeErr != nil {
+		jsonError(w, decodeErr.Status, decodeErr.Message)
+		return
+	}
+	defer cleanup()
 
-围绕“%s”开展任务资料整理与输出准备工作。
+	mailMu.Lock()
+	resp, err := createTaskFolder(req, downloadAttachments)
+	mailMu.Unlock()
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
 
-## 工作过程
+	jsonResponse(w, http.StatusOK, resp)
+}
 
-- %s：创建任务文件夹并完成基础材料归集。
+// writeTaskError answers with the detail of err, the existing tasks of a
+// conflict and the steps run before a failure.
+func writeTaskError(w http.ResponseWriter, err *taskError) {
+	body := map[string]interface{}{"detail": err.Message}
+	if len(err.Existing) > 0 {
+		body["path"] = err.Existing[0]["path"]
+		body["existing"] = err.Existing
+	}
+	if len(err.Steps) > 0 {
+		body["steps"] = err.Steps
+	}
+	jsonResponse(w, err.Status, body)
+}
 
-## 当前进展
+// taskError is a task-creation failure together with the HTTP status it maps to.
+type taskError struct {
+	Status  int
+	Message string
+	// Existing lists the tasks already created for the same mail on a 409.
+	Existing []map[string]interface{}
+	// Duplicate is set when the mail already has a task, rather than a
+	// folder name being taken.
+	Duplicate bool
+	// Steps are the creation steps run before the failure.
+	Steps []TaskStep
+}
 
-已完成任务初始化，正在持续完善过程记录与输出内容。
+func (e *taskError) Error() string {
+	return e.Message
+}
 
-## 下一步
+// createTaskFolder builds the task folder, source files and work record for
+// req. It backs the folder endpoints and rule-driven task creation.
+//
+// Mail tasks get the canonical mail hash; unless req.Force is set, a mail
+// that already has a task in the workspace or an archive path is refused.
+func createTaskFolder(req FolderRequest, downloadAttachments bool) (map[string]interface{}, *taskError) {
+	baseFolder, err := resolveBaseFolder(req.BasePath)
+	if err != nil {
+		return nil, &taskError{Status: pathErrorStatus(err), Message: err.Error()}
+	}
+	onConflict := strings.ToLower(strings.TrimSpace(req.OnConflict))
+	if onConflict != "" && onConflict != conflictSuffix && onConflict != conflictError {
+		return nil, &taskError{Status: http.StatusBadRequest, Message: fmt.Sprintf("未知的 on_conflict: %s", req.OnConflict)}
+	}
 
-继续补充过程材料，完成成果文件并放入 20_成果输出。
-`, title, createdDate, createdDate, sourceType, req.Department, projectPath, folderName, hash, title, title, createdDate)
-}
+	sourceType := normalizeSource(req.Source, strings.TrimSpace(req.MailID) != "")
+	var suggestions []DepartmentSuggestion
+	if sourceType == "email" {
+		// The client hash is kept as a lookup key for tasks created before
+		// the backend derived hashes itself.
+		clientHash := req.Hash
+		req = withMailDetail(req)
+		req.Hash = canonicalMailHash(requestMessageID(req), req.Subject, req.FromAddr, req.Date)
+		if !req.Force {
+			if existing := findExistingTasks(baseFolder, req.ArchivePaths, []string{req.Hash, clientHash}); len(existing) > 0 {
+				return nil, duplicateTaskError(existing)
+			}
+		}
+		// Ranked before the new task joins the model it is checked against.
+		suggestions = suggestDepartments(req)
+	}
 
-func handleCreateFolder(w http.ResponseWriter, r *http.Request) {
-	processFolderCreation(w, r, false)
-}
+	tpl, err := selectTaskTemplate(req.Template, req.Department)
+	if err != nil {
+		return nil, &taskError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
 
-func handleCreateFolderWithAttachments(w http.ResponseWriter, r *http.Request) {
-	processFolderCreation(w, r, true)
-}
+	var sources []taskSource
+	if len(req.uploads) > 0 || len(req.SourcePaths) > 0 {
+		if sourceType == "email" {
+			return nil, &taskError{Status: http.StatusBadRequest, Message: "只有手动任务可以附带来源文件"}
+		}
+		var srcErr *taskError
+		if sources, srcErr = collectTaskSources(req); srcErr != nil {
+			return nil, srcErr
+		}
+	}
 
-func processFolderCreation(w http.ResponseWriter, r *http.Request, downloadAttachments bool) {
-	var req FolderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonError(w, http.StatusBadRequest, "Invalid request parameters")
-		return
+	folderName := req.FolderName
+	if format := strings.TrimSpace(req.FolderNameFormat); format != "" {
+		folderName = renderFolderName(format, requestFolderNameVars(req), baseFolder)
 	}
+	folderPath, claimErr := resolveTaskFolder(baseFolder, sanitizeFolderName(folderName), onConflict)
+	if claimErr != nil {
+		return nil, claimErr
+	}
+	folderName = filepath.Base(folderPath)
 
-	baseFolder := getBaseFolder(req.BasePath)
-	folderName := sanitizeFolderName(req.FolderName)
-	folderPath := filepath.Join(baseFolder, folderName)
+	// The task is built in a staging folder and renamed into place once
+	// complete, so a failed step leaves nothing half-written behind.
+	staging, err := newStagingDir(baseFolder)
+	if err != nil {
+		return nil, &taskError{Status: http.StatusInternalServerError, Message: fmt.Sprintf("创建目录失败: %v", err)}
+	}
+	var steps taskSteps
+	fail := func(status int, format string, err error) (map[string]interface{}, *taskError) {
+		if rmErr := os.RemoveAll(staging); rmErr != nil {
+			log.Printf("Failed to remove staging folder %s: %v", staging, rmErr)
+		}
+		return nil, &taskError{Status: status, Message: fmt.Sprintf(format, err), Steps: steps}
+	}
 
-	if err := os.MkdirAll(folderPath, 0o755); err != nil {
-		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("创建目录失败: %v", err))
-		return
+	structure := func() error { return createTaskStructure(staging, tpl) }
+	if req.derivedFrom != "" {
+		structure = func() error { return copyTaskSkeleton(req.derivedFrom, staging, req.cloneDirs) }
 	}
-	if err := createTaskStructure(folderPath); err != nil {
-		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("创建标准结构失败: %v", err))
-		return
+	if err := steps.record("structure", structure()); err != nil {
+		return fail(http.StatusInternalServerError, "创建目录结构失败: %v", err)
 	}
 
-	sourceType := normalizeSource(req.Source, strings.TrimSpace(req.MailID) != "")
+	var sourceFiles []string
+
 	if sourceType == "email" {
-		if err := writeEmailSourceFiles(folderPath, req); err != nil {
-			jsonError(w, http.StatusInternalServerError, fmt.Sprintf("保存邮件来源失败: %v", err))
-			return
+		if err := steps.record("source", writeEmailSourceFiles(staging, req)); err != nil {
+			return fail(http.StatusInternalServerError, "保存邮件来源失败: %v", err)
 		}
 	} else {
-		if err := writeManualSourceFiles(folderPath); err != nil {
-			jsonError(w, http.StatusInternalServerError, fmt.Sprintf("保存需求来源失败: %v", err))
-			return
+		saved, err := writeManualSourceFiles(staging, sources)
+		if err := steps.record("source", err); err != nil {
+			if errors.Is(err, mail.ErrAttachmentTooLarge) {
+				return fail(http.StatusRequestEntityTooLarge, "保存需求来源失败: %v", err)
+			}
+			return fail(http.StatusInternalServerError, "保存需求来源失败: %v", err)
 		}
+		sourceFiles = saved
 	}
 
+	// Attachments are not required for the task: a failed download is
+	// reported and the attachments saved so far are kept.
 	var downloaded []string
-	if downloadAttachments && sourceType == "email" && mailClient != nil && strings.TrimSpace(req.MailID) != "" {
-		attachmentsPath := filepath.Join(folderPath, taskSourceDirName, taskAttachmentDir)
-		d, err := mailClient.DownloadAttachments(req.MailID, attachmentsPath)
-		if err == nil {
-			downloaded = d
+	if downloadAttachments && sourceType == "email" && strings.TrimSpace(req.MailID) != "" {
+		var err error
+		if mailProvider == nil {
+			err = errors.New("邮箱未连接")
+		} else {
+			downloaded, err = mailProvider.DownloadAttachments(req.MailID, filepath.Join(staging, taskSourceDirName, taskAttachmentDir))
 		}
+		steps.record("attachments", err)
 	}
 
-	now := time.Now()
-	workRecord := buildWorkRecordTemplate(req, folderName, folderPath, sourceType, now)
+	listed := downloaded
+	if sourceType != "email" {
+		listed = sourceFiles
+	}
+	vars := taskTemplateVars(req, folderName, folderPath, sourceType, listed, time.Now())
+	if err := steps.record("template_files", writeTemplateFiles(staging, tpl, vars)); err != nil {
+		return fail(http.StatusInternalServerError, "写入模板文件失败: %v", err)
+	}
+	workRecord := renderWorkRecord(tpl, req, vars)
+	if err := steps.record("work_record", os.WriteFile(filepath.Join(staging, workRecordFileName), []byte(workRecord), 0o644)); err != nil {
+		return fail(http.StatusInternalServerError, "写入工作记录失败: %v", err)
+	}
+
+	if err := steps.record("commit", commitTaskFolder(staging, folderPath)); err != nil {
+		if errors.Is(err, errFolderTaken) {
+			// Another task took the name while this one was being built.
+			fail(http.StatusConflict, "%v", err)
+			conflict := folderConflictError(folderPath)
+			conflict.Steps = steps
+			return nil, conflict
+		}
+		return fail(http.StatusInternalServerError, "创建任务文件夹失败: %v", err)
+	}
 	wrPath := filepath.Join(folderPath, workRecordFileName)
-	if err := os.WriteFile(wrPath, []byte(workRecord), 0o644); err != nil {
-		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("写入工作记录失败: %v", err))
-		return
+
+	// Moved files are removed only once the task holds their copies.
+	if len(req.SourcePaths) > 0 && strings.EqualFold(strings.TrimSpace(req.SourceMode), sourceModeMove) {
+		steps.record("remove_sources", removeMovedSources(sources))
 	}
 
+	invalidateTaskIndex()
+
 	resp := map[string]interface{}{
 		"success":      true,
 		"path":         folderPath,
 		"content_path": folderPath,
 		"work_record":  wrPath,
+		"hash":         req.Hash,
+		"template":     tpl.ID,
+		"folder_name":  folderName,
 		"message":      fmt.Sprintf("任务文件夹已创建: %s", folderName),
+		"steps":        steps,
+	}
+	if msg := steps.failed("attachments"); msg != "" {
+		resp["message"] = fmt.Sprintf("任务文件夹已创建: %s（附件下载失败: %s）", folderName, msg)
+	}
+	if msg := steps.failed("remove_sources"); msg != "" {
+		resp["message"] = fmt.Sprintf("任务文件夹已创建: %s（原文件未能删除: %s）", folderName, msg)
 	}
 	if downloadAttachments {
 		resp["attachments_downloaded"] = downloaded
 	}
+	if len(sources) > 0 {
+		resp["source_files"] = sourceFiles
+	}
+	if sourceType == "email" {
+		if suggestions == nil {
+			suggestions = []DepartmentSuggestion{}
+		}
+		resp["department_suggestions"] = suggestions
+	}
 
-	jsonResponse(w, http.StatusOK, resp)
+	return resp, nil
 }
 
 // -- Archive Handlers --
@@ -504,6 +963,12 @@ type WorkRecordInfo struct {
 	SchemaVersion int    `json:"schema_version"`
 	ProjectPath   string `json:"project_path"`
 	FolderName    string `json:"folder_name"`
+	// RelatedHashes are the hashes of follow-up mails appended to the task.
+	RelatedHashes []string `json:"related_hashes,omitempty"`
+	Due           string   `json:"due,omitempty"`
+	MeetingTime   string   `json:"meeting_time,omitempty"`
+	// DerivedFrom is the folder of the task this one was cloned from.
+	DerivedFrom string `json:"derived_from,omitempty"`
 }
 
 type parsedWorkRecord struct {
@@ -713,6 +1178,10 @@ func readWorkRecord(filePath string) (*parsedWorkRecord, error) {
 	info.ArchiveStatus = get("archive_status", "archiveStatus")
 	info.ProjectPath = get("project_path", "projectPath")
 	info.FolderName = get("folder_name", "folderName")
+	info.RelatedHashes = splitFrontmatterList(get("related_hashes"))
+	info.Due = get("due")
+	info.MeetingTime = get("meeting_time")
+	info.DerivedFrom = get("derived_from")
 
 	schemaValue := get("schema_version")
 	if schemaValue != "" {
@@ -820,6 +1289,17 @@ func ensureFrontmatterLines(parsed *parsedWorkRecord, folderPath string) []strin
 	}
 }
 
+// splitFrontmatterList reads a comma-separated frontmatter value.
+func splitFrontmatterList(value string) []string {
+	var items []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			items = append(items, v)
+		}
+	}
+	return items
+}
+
 func upsertFrontmatterValue(frontLines []string, aliases []string, preferredKey string, value string) []string {
 	aliasMap := make(map[string]bool, len(aliases))
 	for _, alias := range aliases {
@@ -855,7 +1335,11 @@ func upsertFrontmatterValue(frontLines []string, aliases []string, preferredKey
 
 func writeWorkRecordFile(filePath string, frontLines []string, body string) error {
 	content := fmt.Sprintf("---\n%s\n---\n%s", strings.Join(frontLines, "\n"), strings.TrimLeft(body, "\n"))
-	return os.WriteFile(filePath, []byte(content), 0o644)
+	if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+		return err
+	}
+	invalidateTaskIndex()
+	return nil
 }
 
 func appendArchiveInfoSection(body string, destination string, archivedAt time.Time) string {
@@ -974,6 +1458,10 @@ func readScannedFolder(folderPath, name string) (map[string]interface{}, bool) {
 		"project_path":    info.ProjectPath,
 		"folder_name":     info.FolderName,
 		"title":           info.Title,
+		"related_hashes":  info.RelatedHashes,
+		"due":             info.Due,
+		"meeting_time":    info.MeetingTime,
+		"derived_from":    info.DerivedFrom,
 	}, true
 }
 
@@ -1004,6 +1492,9 @@ func collectScannedFolders(scanPath string, recursive bool) ([]map[string]interf
 			if normalizePathKey(path) == normalizePathKey(scanPath) {
 				return nil
 			}
+			if isStagingDir(d.Name()) {
+				return filepath.SkipDir
+			}
 			if _, err := os.Stat(filepath.Join(path, workRecordFileName)); err == nil {
 				appendFolder(path)
 				return filepath.SkipDir
@@ -1019,7 +1510,7 @@ func collectScannedFolders(scanPath string, recursive bool) ([]map[string]interf
 			return nil, err
 		}
 		for _, entry := range entries {
-			if !entry.IsDir() {
+			if !entry.IsDir() || isStagingDir(entry.Name()) {
 				continue
 			}
 			appendFolder(filepath.Join(scanPath, entry.Name()))
@@ -1046,11 +1537,11 @@ func collectScannedFolders(scanPath string, recursive bool) ([]map[string]interf
 }
 
 func handleScanWorkFolders(w http.ResponseWriter, r *http.Request) {
-	scanPath := r.URL.Query().Get("scan_path")
-	if scanPath == "" {
-		scanPath = "~/Desktop"
+	scanPath, err := resolveAllowedPath(getBaseFolder(r.URL.Query().Get("scan_path")))
+	if err != nil {
+		jsonError(w, pathErrorStatus(err), err.Error())
+		return
 	}
-	scanPath = normalizeScanPath(getBaseFolder(scanPath))
 	recursive := r.URL.Query().Get("recursive") == "true"
 
 	folders, err := collectScannedFolders(scanPath, recursive)
@@ -1077,8 +1568,16 @@ type ArchiveMoveRequest struct {
 }
 
 func doArchiveMove(folderPath, archivePath string) (string, error) {
-	archivePath = getBaseFolder(archivePath)
-	folderPath = filepath.Clean(folderPath)
+	folderPath, err := resolveAllowedFolder(folderPath)
+	if err != nil {
+		return "", err
+	}
+	if archivePath, err = resolveAllowedPath(getBaseFolder(archivePath)); err != nil {
+		return "", err
+	}
+	if withinRoot(archivePath, folderPath) {
+		return "", fmt.Errorf("归档目录不能位于任务目录内: %s", archivePath)
+	}
 	folderName := filepath.Base(folderPath)
 
 	year := "其他"
@@ -1101,6 +1600,7 @@ func doArchiveMove(folderPath, archivePath string) (string, error) {
 	if err := os.Rename(folderPath, destPath); err != nil {
 		return "", fmt.Errorf("移动失败: %v", err)
 	}
+	invalidateTaskIndex()
 
 	wrPath := filepath.Join(destPath, workRecordFileName)
 	if _, err := os.Stat(wrPath); err == nil {
@@ -1118,6 +1618,10 @@ func handleArchiveMove(w http.ResponseWriter, r *http.Request) {
 	}
 
 	destPath, err := doArchiveMove(req.FolderPath, req.ArchivePath)
+	if errors.Is(err, errPathDenied) {
+		jsonError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	if err != nil {
 		jsonError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -1234,11 +1738,15 @@ func handleUpdateWorkRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentFolderPath := filepath.Clean(strings.TrimSpace(req.FolderPath))
-	if currentFolderPath == "" {
+	if strings.TrimSpace(req.FolderPath) == "" {
 		jsonError(w, http.StatusBadRequest, "folder_path is required")
 		return
 	}
+	currentFolderPath, err := resolveAllowedFolder(req.FolderPath)
+	if err != nil {
+		jsonError(w, pathErrorStatus(err), err.Error())
+		return
+	}
 
 	wrPath := filepath.Join(currentFolderPath, workRecordFileName)
 	if _, err := os.Stat(wrPath); os.IsNotExist(err) {
@@ -1333,6 +1841,10 @@ func GenerateHash(input string) string {
 // scanDirForHash scans a directory for work records matching the given hash.
 // Returns list of matching folder info maps with a "status" field.
 func scanDirForHash(dirPath, hash, status string) []map[string]interface{} {
+	return scanDirForHashes(dirPath, map[string]bool{hash: true}, status)
+}
+
+func scanDirForHashes(dirPath string, hashes map[string]bool, status string) []map[string]interface{} {
 	results := []map[string]interface{}{}
 	folders, err := collectScannedFolders(dirPath, true)
 	if err != nil {
@@ -1340,7 +1852,7 @@ func scanDirForHash(dirPath, hash, status string) []map[string]interface{} {
 	}
 
 	for _, folder := range folders {
-		if fmt.Sprint(folder["hash"]) != hash {
+		if !hashes[fmt.Sprint(folder["hash"])] {
 			continue
 		}
 		results = append(results, map[string]interface{}{
@@ -1361,11 +1873,11 @@ func handleCheckHash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	scanPath := r.URL.Query().Get("scan_path")
-	if scanPath == "" {
-		scanPath = "~/Desktop"
+	scanPath, err := resolveAllowedPath(getBaseFolder(r.URL.Query().Get("scan_path")))
+	if err != nil {
+		jsonError(w, pathErrorStatus(err), err.Error())
+		return
 	}
-	scanPath = getBaseFolder(scanPath)
 
 	archivePaths := r.URL.Query()["archive_path"]
 
@@ -1377,7 +1889,9 @@ func handleCheckHash(w http.ResponseWriter, r *http.Request) {
 		if ap == "" {
 			continue
 		}
-		matches = append(matches, scanDirForHash(getBaseFolder(ap), hash, "archived")...)
+		if folder, ok := lookupFolder(ap); ok {
+			matches = append(matches, scanDirForHash(folder, hash, "archived")...)
+		}
 	}
 
 	jsonResponse(w, http.StatusOK, map[string]interface{}{
@@ -1395,6 +1909,8 @@ type DailyReportAIConfig struct {
 	APIKey  string `json:"api_key"`
 	Model   string `json:"model"`
 	Enabled bool   `json:"enabled"`
+	// Proxy overrides the global proxy for the AI endpoint; nil uses it.
+	Proxy *proxy.Config `json:"proxy,omitempty"`
 }
 
 type DailyReportItem struct {
@@ -1579,7 +2095,10 @@ func generateDailyLogWithAI(cfg DailyReportAIConfig, reportInput string) (string
 		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(cfg.APIKey))
 	}
 
-	client := &http.Client{Timeout: 45 * time.Second}
+	client, err := aiHTTPClient(cfg, 45*time.Second)
+	if err != nil {
+		return "", err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
@@ -1634,13 +2153,25 @@ func handleGenerateDailyReport(w http.ResponseWriter, r *http.Request) {
 		reportDate = time.Now().Format("2006-01-02")
 	}
 
-	aiEnabled := req.AI.Enabled &&
-		strings.TrimSpace(req.AI.APIURL) != "" &&
-		strings.TrimSpace(req.AI.Model) != "" &&
-		strings.TrimSpace(req.AI.APIKey) != ""
+	logs := buildDailyReportLogs(reportDate, req.Items, req.AI)
 
-	logs := make([]DailyReportLog, 0, len(req.Items))
-	for _, item := range req.Items {
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"date":    reportDate,
+		"logs":    logs,
+	})
+}
+
+// buildDailyReportLogs produces one log line per item, using the AI endpoint
+// when it is fully configured and the local fallback otherwise.
+func buildDailyReportLogs(reportDate string, items []DailyReportItem, ai DailyReportAIConfig) []DailyReportLog {
+	aiEnabled := ai.Enabled &&
+		strings.TrimSpace(ai.APIURL) != "" &&
+		strings.TrimSpace(ai.Model) != "" &&
+		strings.TrimSpace(ai.APIKey) != ""
+
+	logs := make([]DailyReportLog, 0, len(items))
+	for _, item := range items {
 		title := fallbackTitleFromFolderName(filepath.Base(item.FolderPath))
 		department := ""
 		coreContent := ""
@@ -1653,8 +2184,9 @@ func handleGenerateDailyReport(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		if strings.TrimSpace(item.FolderPath) != "" {
-			wrPath := filepath.Join(item.FolderPath, workRecordFileName)
+		// Only work records inside the workspace roots are read.
+		if folder, err := resolveAllowedPath(item.FolderPath); strings.TrimSpace(item.FolderPath) != "" && err == nil {
+			wrPath := filepath.Join(folder, workRecordFileName)
 			if parsed, err := readWorkRecord(wrPath); err == nil {
 				if strings.TrimSpace(parsed.Info.Title) != "" {
 					title = parsed.Info.Title
@@ -1673,7 +2205,7 @@ func handleGenerateDailyReport(w http.ResponseWriter, r *http.Request) {
 		reportInput := buildDailyReportInput(reportDate, title, department, coreContent)
 		content := fallbackDailyLog(title, coreContent)
 		if aiEnabled {
-			if generated, err := generateDailyLogWithAI(req.AI, reportInput); err == nil {
+			if generated, err := generateDailyLogWithAI(ai, reportInput); err == nil {
 				content = generated
 			}
 		}
@@ -1689,10 +2221,5 @@ func handleGenerateDailyReport(w http.ResponseWriter, r *http.Request) {
 			Content:    content,
 		})
 	}
-
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"date":    reportDate,
-		"logs":    logs,
-	})
+	return logs
 }
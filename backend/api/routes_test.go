@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+
+	"knot-backend/mail"
 )
 
 func TestGetBaseFolder_WithAbsolutePath(t *testing.T) {
@@ -38,16 +41,36 @@ func TestSetupRoutes_AllEndpointsRegistered(t *testing.T) {
 	}{
 		{"POST", "/api/mail/connect"},
 		{"GET", "/api/mail/list"},
+		{"POST", "/api/mail/sync"},
+		{"GET", "/api/mail/folders"},
+		{"GET", "/api/mail/search"},
 		{"GET", "/api/mail/123/attachments"},
 		{"GET", "/api/mail/123/detail"},
+		{"GET", "/api/mail/image-proxy"},
+		{"GET", "/api/mail/status"},
+		{"GET", "/api/mail/trace"},
 		{"POST", "/api/folder/create"},
 		{"POST", "/api/folder/create-with-attachments"},
+		{"POST", "/api/folder/append-mail"},
+		{"POST", "/api/folder/clone"},
 		{"GET", "/api/folder/check-hash"},
+		{"GET", "/api/templates"},
+		{"PUT", "/api/templates"},
 		{"GET", "/api/archive/scan"},
 		{"POST", "/api/archive/move"},
 		{"POST", "/api/archive/batch-move"},
 		{"POST", "/api/archive/update-work-record"},
 		{"POST", "/api/report/daily/generate"},
+		{"GET", "/api/settings/roots"},
+		{"PUT", "/api/settings/roots"},
+		{"GET", "/api/settings/network"},
+		{"PUT", "/api/settings/network"},
+		{"POST", "/api/network/test"},
+		{"GET", "/api/rules"},
+		{"PUT", "/api/rules"},
+		{"POST", "/api/rules/run"},
+		{"GET", "/api/rules/runs"},
+		{"GET", "/api/scheduler/jobs"},
 	}
 
 	for _, ep := range endpoints {
@@ -453,3 +476,97 @@ hash: childhash
 		t.Fatalf("expected %s, got %s", parent, gotPath)
 	}
 }
+
+func connectDemoProvider(t *testing.T, router http.Handler) {
+	t.Helper()
+	raw, _ := json.Marshal(MailConfig{Provider: "demo"})
+	req := httptest.NewRequest(http.MethodPost, "/api/mail/connect", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("connect demo failed: %d %s", rr.Code, rr.Body.String())
+	}
+	t.Cleanup(func() { mailProvider = nil })
+}
+
+func TestHandleMail_DemoProviderListAndSearch(t *testing.T) {
+	router := SetupRoutes()
+	connectDemoProvider(t, router)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/mail/list?limit=3&days=30", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var listResp struct {
+		Data []mail.MailItem `json:"data"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &listResp)
+	if len(listResp.Data) != 3 {
+		t.Fatalf("expected 3 mails, got %d", len(listResp.Data))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/mail/search?q="+url.QueryEscape("预算"), nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var searchResp struct {
+		Data []mail.MailItem `json:"data"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &searchResp)
+	if len(searchResp.Data) != 1 || searchResp.Data[0].ID != "1" {
+		t.Fatalf("unexpected search result: %+v", searchResp.Data)
+	}
+}
+
+func TestHandleConnectMail_UnknownProvider(t *testing.T) {
+	router := SetupRoutes()
+	raw, _ := json.Marshal(MailConfig{Provider: "exchange"})
+	req := httptest.NewRequest(http.MethodPost, "/api/mail/connect", bytes.NewReader(raw))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestHandleCreateFolder_StripsQuotedHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	router := SetupRoutes()
+
+	rr := postFolderCreate(t, router, FolderRequest{
+		Subject:    "Re: 预算",
+		Date:       "Tue, 21 Apr 2026 09:00:00 +0800",
+		FromAddr:   "Li Si",
+		Body:       "已修改，请查收。\n\n李四\n-- \n运维组\n\n-----原始邮件-----\n发件人: 张三\n\n请修改预算。\n",
+		BasePath:   tmpDir,
+		FolderName: "2026.04.21_预算",
+		Source:     "email",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	sourceDir := filepath.Join(tmpDir, "2026.04.21_预算", taskSourceDirName)
+	txt, _ := os.ReadFile(filepath.Join(sourceDir, "email.txt"))
+	if !strings.Contains(string(txt), "已修改，请查收。\n\n李四") || strings.Contains(string(txt), "请修改预算") ||
+		strings.Contains(string(txt), "运维组") {
+		t.Fatalf("unexpected email.txt:\n%s", txt)
+	}
+	full, err := os.ReadFile(filepath.Join(sourceDir, emailFullFileName))
+	if err != nil || !strings.Contains(string(full), "请修改预算") {
+		t.Fatalf("full body not kept: %v\n%s", err, full)
+	}
+
+	// Mails without quotes get no separate full copy.
+	rr = postFolderCreate(t, router, FolderRequest{
+		Subject: "新需求", Body: "请整理材料。", BasePath: tmpDir, FolderName: "2026.04.21_新需求", Source: "email",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "2026.04.21_新需求", taskSourceDirName, emailFullFileName)); !os.IsNotExist(err) {
+		t.Fatalf("unexpected %s: %v", emailFullFileName, err)
+	}
+}
@@ -0,0 +1,580 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"knot-backend/mail"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	rulesFileName      = "rules.json"
+	rulesStateFileName = "rules_state.json"
+	maxRuleRuns        = 50
+	ruleProcessedTTL   = 180 * 24 * time.Hour
+)
+
+const defaultFolderNameFormat = "{{YYYY}}.{{MM}}.{{DD}}_{{subject}}"
+
+// MailRule turns matching mail into tasks. Every condition that is set must
+// match; list conditions match when any entry matches.
+type MailRule struct {
+	ID             string         `json:"id"`
+	Name           string         `json:"name"`
+	Enabled        bool           `json:"enabled"`
+	StopProcessing bool           `json:"stop_processing"`
+	Conditions     RuleConditions `json:"conditions"`
+	Actions        RuleActions    `json:"actions"`
+}
+
+type RuleConditions struct {
+	From           []string `json:"from"`
+	To             []string `json:"to"`
+	SubjectRegex   string   `json:"subject_regex"`
+	Mailbox        string   `json:"mailbox"`
+	HasAttachments *bool    `json:"has_attachments"`
+	Keywords       []string `json:"keywords"`
+}
+
+type RuleActions struct {
+	CreateTask          bool   `json:"create_task"`
+	BasePath            string `json:"base_path"`
+	Department          string `json:"department"`
+	FolderNameFormat    string `json:"folder_name_format"`
+	DownloadAttachments bool   `json:"download_attachments"`
+	Tag                 string `json:"tag"`
+	MoveTo              string `json:"move_to"`
+	// Template is the task template of created tasks; empty picks the
+	// template of Department.
+	Template string `json:"template"`
+}
+
+// RuleRun is the log of one evaluation pass over a batch of mails.
+type RuleRun struct {
+	ID         string         `json:"id"`
+	Trigger    string         `json:"trigger"`
+	Mailbox    string         `json:"mailbox"`
+	StartedAt  string         `json:"started_at"`
+	FinishedAt string         `json:"finished_at"`
+	Evaluated  int            `json:"evaluated"`
+	Matched    int            `json:"matched"`
+	Errors     int            `json:"errors"`
+	Entries    []RuleRunEntry `json:"entries"`
+}
+
+type RuleRunEntry struct {
+	RuleID   string   `json:"rule_id"`
+	RuleName string   `json:"rule_name"`
+	MailID   string   `json:"mail_id"`
+	Subject  string   `json:"subject"`
+	Actions  []string `json:"actions"`
+	TaskPath string   `json:"task_path,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type rulesState struct {
+	// Processed maps rule ID + mail hash to the time the rule fired, so a
+	// rule acts on each mail only once across syncs.
+	Processed map[string]string `json:"processed"`
+	// Skipped records mails a rule did not match, keyed by rule fingerprint
+	// so editing a rule makes it look at those mails again.
+	Skipped map[string]string `json:"skipped"`
+	Runs    []RuleRun         `json:"runs"`
+}
+
+var rulesMu sync.Mutex
+
+func rulesFilePath() string {
+	return filepath.Join(appDataDir(), rulesFileName)
+}
+
+func rulesStatePath() string {
+	return filepath.Join(appDataDir(), rulesStateFileName)
+}
+
+func loadRules() ([]MailRule, error) {
+	rules := []MailRule{}
+	if err := loadJSONFile(rulesFilePath(), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func loadRulesState() rulesState {
+	state := rulesState{}
+	if err := loadJSONFile(rulesStatePath(), &state); err != nil {
+		log.Printf("Failed to read rules state: %v", err)
+	}
+	if state.Processed == nil {
+		state.Processed = map[string]string{}
+	}
+	if state.Skipped == nil {
+		state.Skipped = map[string]string{}
+	}
+	return state
+}
+
+func ruleFingerprint(rule MailRule) string {
+	data, _ := json.Marshal(rule)
+	return GenerateHash(string(data))
+}
+
+func validateRule(rule MailRule) error {
+	if strings.TrimSpace(rule.ID) == "" {
+		return fmt.Errorf("rule id is required")
+	}
+	if rule.Conditions.SubjectRegex != "" {
+		if _, err := regexp.Compile(rule.Conditions.SubjectRegex); err != nil {
+			return fmt.Errorf("rule %s: invalid subject_regex: %v", rule.ID, err)
+		}
+	}
+	if rule.Actions.CreateTask && strings.TrimSpace(rule.Actions.BasePath) == "" {
+		return fmt.Errorf("rule %s: base_path is required to create tasks", rule.ID)
+	}
+	return nil
+}
+
+// mailItemHash matches the frontend's generateMailHash so tasks created by
+// rules are recognised by the mail list's duplicate check.
+func mailItemHash(item mail.MailItem) string {
+	return GenerateHash(item.Subject + "|" + item.Date + "|" + item.From)
+}
+
+var (
+	subjectBracketPattern = regexp.MustCompile(`【[^】]*】`)
+	subjectPrefixPattern  = regexp.MustCompile(`(?i)^(转发|转寄|回复|答复|fwd?|re|fw)[：:]\s*`)
+	folderInvalidPattern  = regexp.MustCompile(`[\\/:*?"<>|]`)
+)
+
+// cleanMailSubject mirrors cleanSubjectForFolder in the frontend settings.
+func cleanMailSubject(subject string) string {
+	cleaned := subjectBracketPattern.ReplaceAllString(subject, "")
+	for {
+		cleaned = strings.TrimSpace(cleaned)
+		next := subjectPrefixPattern.ReplaceAllString(cleaned, "")
+		if next == cleaned {
+			break
+		}
+		cleaned = next
+	}
+	return cleaned
+}
+
+// ruleFolderNameFormat is the folder name format of tasks a rule creates.
+func ruleFolderNameFormat(a RuleActions) string {
+	if strings.TrimSpace(a.FolderNameFormat) == "" {
+		return defaultFolderNameFormat
+	}
+	return a.FolderNameFormat
+}
+
+// ruleMail lazily loads the mail detail the first time a condition or action
+// needs the body or recipients.
+type ruleMail struct {
+	item   mail.MailItem
+	detail map[string]interface{}
+	err    error
+	loaded bool
+}
+
+func (m *ruleMail) load() (map[string]interface{}, error) {
+	if !m.loaded {
+		m.loaded = true
+		m.detail, m.err = mailProvider.FetchMailDetail(m.item.ID)
+	}
+	return m.detail, m.err
+}
+
+func (m *ruleMail) body() string {
+	detail, err := m.load()
+	if err != nil {
+		return ""
+	}
+	body, _ := detail["body"].(string)
+	return body
+}
+
+func (m *ruleMail) messageID() string {
+	if m.item.MessageID != "" {
+		return m.item.MessageID
+	}
+	detail, err := m.load()
+	if err != nil {
+		return ""
+	}
+	id, _ := detail["message_id"].(string)
+	return id
+}
+
+func (m *ruleMail) addresses(keys ...string) []string {
+	detail, err := m.load()
+	if err != nil {
+		return nil
+	}
+	var result []string
+	for _, key := range keys {
+		list, _ := detail[key].([]mail.Address)
+		for _, a := range list {
+			result = append(result, a.String())
+		}
+	}
+	return result
+}
+
+func containsAnyFold(values []string, needles []string) bool {
+	for _, needle := range needles {
+		needle = strings.ToLower(strings.TrimSpace(needle))
+		if needle == "" {
+			continue
+		}
+		for _, v := range values {
+			if strings.Contains(strings.ToLower(v), needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleMatches reports whether the mail meets the rule's conditions. An
+// error means the mail detail a condition needs could not be loaded, so
+// there is no verdict yet.
+func ruleMatches(rule MailRule, m *ruleMail, mailbox string) (bool, error) {
+	c := rule.Conditions
+
+	if c.Mailbox != "" && !strings.EqualFold(strings.TrimSpace(c.Mailbox), mailbox) {
+		return false, nil
+	}
+	if c.SubjectRegex != "" {
+		re, err := regexp.Compile(c.SubjectRegex)
+		if err != nil || !re.MatchString(m.item.Subject) {
+			return false, nil
+		}
+	}
+	needsDetail := len(c.From) > 0 || len(c.To) > 0 || len(c.Keywords) > 0 ||
+		(c.HasAttachments != nil && *c.HasAttachments != (m.item.AttachmentCount > 0))
+	if !needsDetail {
+		return true, nil
+	}
+	detail, err := m.load()
+	if err != nil {
+		return false, err
+	}
+
+	if c.HasAttachments != nil && *c.HasAttachments != (m.item.AttachmentCount > 0) {
+		// The IMAP list does not count attachments, so confirm with the detail.
+		attachments, _ := detail["attachments"].([]map[string]interface{})
+		if *c.HasAttachments != (len(attachments) > 0) {
+			return false, nil
+		}
+	}
+	if len(c.From) > 0 {
+		senders := append([]string{m.item.From}, m.addresses("from")...)
+		if !containsAnyFold(senders, c.From) {
+			return false, nil
+		}
+	}
+	if len(c.To) > 0 && !containsAnyFold(m.addresses("to", "cc"), c.To) {
+		return false, nil
+	}
+	if len(c.Keywords) > 0 && !containsAnyFold([]string{m.item.Subject, m.body()}, c.Keywords) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// applyRuleActions performs the actions of a matched rule. It reports whether
+// the mail left the current mailbox and whether every action succeeded, so
+// a mail with a failed action is tried again on the next run.
+func applyRuleActions(rule MailRule, m *ruleMail, entry *RuleRunEntry) (moved, complete bool) {
+	a := rule.Actions
+	complete = true
+	fail := func(step string, err error) {
+		complete = false
+		msg := fmt.Sprintf("%s: %v", step, err)
+		if entry.Error == "" {
+			entry.Error = msg
+		} else {
+			entry.Error += "; " + msg
+		}
+	}
+
+	if a.CreateTask {
+		req := FolderRequest{
+			MailID:     m.item.ID,
+			Subject:    m.item.Subject,
+			Date:       m.item.Date,
+			FromAddr:   m.item.From,
+			Body:       m.body(),
+			BasePath:   a.BasePath,
+			Department: a.Department,
+			Template:   a.Template,
+			Source:     "email",
+			Hash:       mailItemHash(m.item),
+			MessageID:  m.messageID(),
+
+			// Rendered by createTaskFolder so {{seq}} and name collisions
+			// see the folders of BasePath.
+			FolderNameFormat: ruleFolderNameFormat(a),
+		}
+		resp, err := createTaskFolder(req, a.DownloadAttachments)
+		switch {
+		case err != nil && err.Duplicate:
+			// Filed by an earlier run, or by hand; retrying cannot help.
+			entry.TaskPath = fmt.Sprint(err.Existing[0]["path"])
+			entry.Actions = append(entry.Actions, "create_task:exists")
+		case err != nil:
+			fail("create_task", err)
+		default:
+			entry.TaskPath = fmt.Sprint(resp["path"])
+			entry.Actions = append(entry.Actions, "create_task")
+			steps, _ := resp["steps"].(taskSteps)
+			if msg := steps.failed("attachments"); msg != "" {
+				fail("download_attachments", errors.New(msg))
+			} else if a.DownloadAttachments {
+				entry.Actions = append(entry.Actions, "download_attachments")
+			}
+		}
+	}
+
+	organizer, canOrganize := mailProvider.(mail.Organizer)
+	if tag := strings.TrimSpace(a.Tag); tag != "" {
+		if !canOrganize {
+			fail("tag", fmt.Errorf("not supported by this mail provider"))
+		} else if err := organizer.TagMail(m.item.ID, tag); err != nil {
+			fail("tag", err)
+		} else {
+			entry.Actions = append(entry.Actions, "tag:"+tag)
+		}
+	}
+	if dest := strings.TrimSpace(a.MoveTo); dest != "" {
+		if !canOrganize {
+			fail("move", fmt.Errorf("not supported by this mail provider"))
+		} else if err := organizer.MoveMail(m.item.ID, dest); err != nil {
+			fail("move", err)
+		} else {
+			entry.Actions = append(entry.Actions, "move:"+dest)
+			return true, complete
+		}
+	}
+	return false, complete
+}
+
+// runMailRules evaluates the enabled rules against items and records the run.
+// It returns nil when there is nothing to evaluate.
+func runMailRules(items []mail.MailItem, mailbox, trigger string) *RuleRun {
+	if mailProvider == nil || len(items) == 0 {
+		return nil
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	rules, err := loadRules()
+	if err != nil {
+		log.Printf("Failed to read rules: %v", err)
+		return nil
+	}
+	enabled := make([]MailRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled && validateRule(rule) == nil {
+			enabled = append(enabled, rule)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil
+	}
+
+	state := loadRulesState()
+	now := time.Now()
+	run := RuleRun{
+		ID:        now.Format("20060102150405.000"),
+		Trigger:   trigger,
+		Mailbox:   mailbox,
+		StartedAt: now.Format(time.RFC3339),
+		Entries:   []RuleRunEntry{},
+	}
+
+	for _, item := range items {
+		m := &ruleMail{item: item}
+		hash := mailItemHash(item)
+		evaluated := false
+		for _, rule := range enabled {
+			key := rule.ID + ":" + hash
+			skipKey := ruleFingerprint(rule) + ":" + hash
+			if _, done := state.Processed[key]; done {
+				continue
+			}
+			if _, done := state.Skipped[skipKey]; done {
+				continue
+			}
+			evaluated = true
+			matched, err := ruleMatches(rule, m, mailbox)
+			if err != nil {
+				// Not a verdict: the mail stays unrecorded and is tried again
+				// on the next sync.
+				run.Errors++
+				run.Entries = append(run.Entries, RuleRunEntry{
+					RuleID: rule.ID, RuleName: rule.Name, MailID: item.ID, Subject: item.Subject,
+					Actions: []string{}, Error: fmt.Sprintf("load_detail: %v", err),
+				})
+				continue
+			}
+			if !matched {
+				state.Skipped[skipKey] = time.Now().Format(time.RFC3339)
+				continue
+			}
+
+			entry := RuleRunEntry{RuleID: rule.ID, RuleName: rule.Name, MailID: item.ID, Subject: item.Subject, Actions: []string{}}
+			moved, complete := applyRuleActions(rule, m, &entry)
+			if complete {
+				state.Processed[key] = time.Now().Format(time.RFC3339)
+			}
+			run.Matched++
+			if entry.Error != "" {
+				run.Errors++
+			}
+			run.Entries = append(run.Entries, entry)
+
+			if moved || rule.StopProcessing {
+				break
+			}
+		}
+		if evaluated {
+			run.Evaluated++
+		}
+	}
+
+	run.FinishedAt = time.Now().Format(time.RFC3339)
+	if run.Evaluated == 0 {
+		return nil
+	}
+
+	for _, seen := range []map[string]string{state.Processed, state.Skipped} {
+		for key, at := range seen {
+			if t, err := time.Parse(time.RFC3339, at); err == nil && now.Sub(t) > ruleProcessedTTL {
+				delete(seen, key)
+			}
+		}
+	}
+	state.Runs = append([]RuleRun{run}, state.Runs...)
+	if len(state.Runs) > maxRuleRuns {
+		state.Runs = state.Runs[:maxRuleRuns]
+	}
+	if err := saveJSONFile(rulesStatePath(), state); err != nil {
+		log.Printf("Failed to save rules state: %v", err)
+	}
+	return &run
+}
+
+func handleGetRules(w http.ResponseWriter, r *http.Request) {
+	rulesMu.Lock()
+	rules, err := loadRules()
+	rulesMu.Unlock()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("读取规则失败: %v", err))
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": rules})
+}
+
+func handleSaveRules(w http.ResponseWriter, r *http.Request) {
+	var rules []MailRule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if err := validateRule(rule); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if seen[rule.ID] {
+			jsonError(w, http.StatusBadRequest, fmt.Sprintf("duplicate rule id: %s", rule.ID))
+			return
+		}
+		seen[rule.ID] = true
+	}
+	if rules == nil {
+		rules = []MailRule{}
+	}
+
+	rulesMu.Lock()
+	err := saveJSONFile(rulesFilePath(), rules)
+	rulesMu.Unlock()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("保存规则失败: %v", err))
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "count": len(rules)})
+}
+
+// handleRunRules fetches the current mail list and evaluates rules on it
+// without waiting for the next sync.
+func handleRunRules(w http.ResponseWriter, r *http.Request) {
+	if mailProvider == nil {
+		jsonError(w, http.StatusBadRequest, "请先连接邮箱")
+		return
+	}
+
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+
+	mails, err := mailProvider.FetchMailList(limit, days)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	run := runMailRules(mails, currentMailbox, "manual")
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": run})
+}
+
+func handleGetRuleRuns(w http.ResponseWriter, r *http.Request) {
+	rulesMu.Lock()
+	state := loadRulesState()
+	rulesMu.Unlock()
+
+	runs := state.Runs
+	if runs == nil {
+		runs = []RuleRun{}
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": runs})
+}
+
+func handleGetRuleRun(w http.ResponseWriter, r *http.Request) {
+	runID := chi.URLParam(r, "run_id")
+
+	rulesMu.Lock()
+	state := loadRulesState()
+	rulesMu.Unlock()
+
+	for _, run := range state.Runs {
+		if run.ID == runID {
+			jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "data": run})
+			return
+		}
+	}
+	jsonError(w, http.StatusNotFound, "run not found")
+}
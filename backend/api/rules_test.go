@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"knot-backend/mail"
+)
+
+func TestRenderFolderName_CleansSubject(t *testing.T) {
+	vars := requestFolderNameVars(FolderRequest{
+		Subject:  "Re: 【紧急】预算/审批",
+		FromAddr: "财务部",
+		Date:     time.Date(2026, 4, 20, 10, 0, 0, 0, time.Local).Format(time.RFC1123Z),
+	})
+	got := renderFolderName("{{YYYY}}.{{MM}}.{{DD}}_{{subject}}_{{from}}", vars, "")
+	if got != "2026.04.20_预算审批_财务部" {
+		t.Fatalf("unexpected folder name: %s", got)
+	}
+}
+
+func TestRunMailRules_CreatesTaskOnceAndLogsRun(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	workspace := t.TempDir()
+	router := SetupRoutes()
+
+	hasAttachments := true
+	rules := []MailRule{{
+		ID:      "budget",
+		Name:    "Budget notices",
+		Enabled: true,
+		Conditions: RuleConditions{
+			From:           []string{"caiwu@company.com"},
+			SubjectRegex:   "预算",
+			HasAttachments: &hasAttachments,
+		},
+		Actions: RuleActions{
+			CreateTask:          true,
+			BasePath:            workspace,
+			Department:          "finance",
+			DownloadAttachments: true,
+		},
+	}}
+	raw, _ := json.Marshal(rules)
+	req := httptest.NewRequest(http.MethodPut, "/api/rules", bytes.NewReader(raw))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("save rules failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	connectDemoProvider(t, router)
+	req = httptest.NewRequest(http.MethodGet, "/api/mail/list?days=30", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if entries, _ := os.ReadDir(workspace); rr.Code != http.StatusOK || len(entries) != 0 {
+		t.Fatalf("expected the list to only read, got %d and %d folders", rr.Code, len(entries))
+	}
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest(http.MethodPost, "/api/mail/sync?days=30", nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("sync failed: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	entries, _ := os.ReadDir(workspace)
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), "_关于2025年度预算审批的通知") {
+		t.Fatalf("expected one budget task folder, got %v", entries)
+	}
+	taskDir := filepath.Join(workspace, entries[0].Name())
+	record, _ := os.ReadFile(filepath.Join(taskDir, workRecordFileName))
+	if !strings.Contains(string(record), "department: finance") {
+		t.Fatalf("expected department in work record:\n%s", record)
+	}
+	attachments, _ := os.ReadDir(filepath.Join(taskDir, taskSourceDirName, taskAttachmentDir))
+	if len(attachments) != 2 {
+		t.Fatalf("expected 2 downloaded attachments, got %d", len(attachments))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/rules/runs", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var runsResp struct {
+		Data []RuleRun `json:"data"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &runsResp)
+	if len(runsResp.Data) != 1 {
+		t.Fatalf("expected one logged run, got %d", len(runsResp.Data))
+	}
+	run := runsResp.Data[0]
+	if run.Matched != 1 || run.Errors != 0 || run.Entries[0].TaskPath != taskDir {
+		t.Fatalf("unexpected run log: %+v", run)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/rules/runs/"+run.ID, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected run detail, got %d", rr.Code)
+	}
+}
+
+func TestRunMailRules_RetriesFailedActions(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	workspace := t.TempDir()
+	router := SetupRoutes()
+
+	// A file where the base folder should be makes create_task fail.
+	blocker := filepath.Join(workspace, "tasks")
+	if err := os.WriteFile(blocker, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules := []MailRule{{
+		ID:         "budget",
+		Enabled:    true,
+		Conditions: RuleConditions{SubjectRegex: "预算"},
+		Actions:    RuleActions{CreateTask: true, BasePath: blocker},
+	}}
+	if err := saveJSONFile(rulesFilePath(), rules); err != nil {
+		t.Fatal(err)
+	}
+	connectDemoProvider(t, router)
+	items, err := mailProvider.FetchMailList(50, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := runMailRules(items, "INBOX", "sync")
+	if run == nil || run.Matched != 1 || run.Errors != 1 {
+		t.Fatalf("expected one failed match, got %+v", run)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	run = runMailRules(items, "INBOX", "sync")
+	if run == nil || run.Matched != 1 || run.Errors != 0 || run.Entries[0].TaskPath == "" {
+		t.Fatalf("expected the failed mail to be retried, got %+v", run)
+	}
+
+	// Once every action succeeded the mail is not evaluated again.
+	if run = runMailRules(items, "INBOX", "sync"); run != nil {
+		t.Fatalf("expected nothing left to do, got %+v", run)
+	}
+}
+
+// flakyDetailProvider fails FetchMailDetail while fail is set.
+type flakyDetailProvider struct {
+	mail.Provider
+	fail bool
+}
+
+func (p *flakyDetailProvider) FetchMailDetail(mailID string) (map[string]interface{}, error) {
+	if p.fail {
+		return nil, errors.New("connection reset")
+	}
+	return p.Provider.FetchMailDetail(mailID)
+}
+
+func TestRunMailRules_RetriesAfterDetailError(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	workspace := t.TempDir()
+	router := SetupRoutes()
+
+	rules := []MailRule{{
+		ID:         "budget",
+		Enabled:    true,
+		Conditions: RuleConditions{From: []string{"caiwu@company.com"}, SubjectRegex: "预算"},
+		Actions:    RuleActions{CreateTask: true, BasePath: workspace},
+	}}
+	if err := saveJSONFile(rulesFilePath(), rules); err != nil {
+		t.Fatal(err)
+	}
+	connectDemoProvider(t, router)
+	flaky := &flakyDetailProvider{Provider: mailProvider, fail: true}
+	mailProvider = flaky
+	items, err := flaky.FetchMailList(50, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := runMailRules(items, "INBOX", "sync")
+	if run == nil || run.Matched != 0 || run.Errors != 1 || !strings.Contains(run.Entries[0].Error, "connection reset") {
+		t.Fatalf("expected the load error in the run, got %+v", run)
+	}
+
+	flaky.fail = false
+	run = runMailRules(items, "INBOX", "sync")
+	if run == nil || run.Matched != 1 || run.Errors != 0 {
+		t.Fatalf("expected the mail to be evaluated again, got %+v", run)
+	}
+}
+
+func TestHandleSaveRules_RejectsInvalidRegex(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	router := SetupRoutes()
+
+	raw, _ := json.Marshal([]MailRule{{ID: "bad", Conditions: RuleConditions{SubjectRegex: "("}}})
+	req := httptest.NewRequest(http.MethodPut, "/api/rules", bytes.NewReader(raw))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
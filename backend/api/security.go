@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"knot-backend/mail"
+)
+
+// configureMailSecurity gives provider the trust store and decryption key
+// of config. Providers that cannot read secured mail are left alone.
+func configureMailSecurity(provider mail.Provider, config MailConfig) error {
+	reader, ok := provider.(mail.SecureReader)
+	if !ok {
+		return nil
+	}
+	sec, err := mail.LoadSecurity(expandHomePath(config.TrustStore), expandHomePath(config.SMIMEKey))
+	if err != nil {
+		return err
+	}
+	reader.SetSecurity(sec)
+	return nil
+}
+
+func expandHomePath(path string) string {
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "~") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+	return path
+}
+
+func securityProtocolName(protocol string) string {
+	if protocol == "pgp" {
+		return "PGP"
+	}
+	return "S/MIME"
+}
+
+// securityLines describes the signature and encryption of a mail for the
+// 来源摘要 section.
+func securityLines(sec *mail.MessageSecurity) []string {
+	if sec == nil {
+		return nil
+	}
+	var lines []string
+	if sig := sec.Signature; sec.Signed && sig != nil {
+		var details []string
+		signer := sig.Signer
+		if sig.Email != "" && sig.Email != signer {
+			signer = strings.TrimSpace(fmt.Sprintf("%s <%s>", signer, sig.Email))
+		}
+		if signer != "" {
+			details = append(details, "签名人 "+signer)
+		}
+		if sig.Issuer != "" {
+			details = append(details, "颁发者 "+sig.Issuer)
+		}
+		if t, err := time.Parse(time.RFC3339, sig.SignedAt); err == nil {
+			details = append(details, "签名时间 "+t.In(time.Local).Format("2006-01-02 15:04"))
+		}
+		status := "有效"
+		if !sig.Valid {
+			status = "无效"
+			if sig.Error != "" {
+				details = append([]string{sig.Error}, details...)
+			}
+		}
+		line := fmt.Sprintf("签名：%s %s", securityProtocolName(sec.Protocol), status)
+		if len(details) > 0 {
+			line += "（" + strings.Join(details, "，") + "）"
+		}
+		lines = append(lines, line)
+	}
+	if sec.Encrypted {
+		line := fmt.Sprintf("加密：%s，", securityProtocolName(sec.Protocol))
+		if sec.Decrypted {
+			line += "已解密"
+		} else {
+			line += "未解密"
+			if sec.Error != "" {
+				line += "（" + sec.Error + "）"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
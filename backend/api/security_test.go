@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"knot-backend/mail"
+)
+
+func TestSecurityLines(t *testing.T) {
+	signedAt := time.Date(2026, 4, 20, 2, 0, 0, 0, time.UTC)
+	lines := securityLines(&mail.MessageSecurity{
+		Protocol: "smime",
+		Signed:   true,
+		Signature: &mail.SignatureInfo{
+			Valid:    true,
+			Signer:   "张三",
+			Email:    "zhangsan@example.gov.cn",
+			Issuer:   "政务 CA",
+			SignedAt: signedAt.Format(time.RFC3339),
+		},
+	})
+	want := "签名：S/MIME 有效（签名人 张三 <zhangsan@example.gov.cn>，颁发者 政务 CA，签名时间 " +
+		signedAt.In(time.Local).Format("2006-01-02 15:04") + "）"
+	if len(lines) != 1 || lines[0] != want {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+
+	lines = securityLines(&mail.MessageSecurity{
+		Protocol:  "smime",
+		Signed:    true,
+		Encrypted: true,
+		Signature: &mail.SignatureInfo{Signer: "李四", Error: "签名与内容不符，邮件可能被篡改"},
+		Error:     "邮件已加密，未配置解密私钥",
+	})
+	if len(lines) != 2 ||
+		lines[0] != "签名：S/MIME 无效（签名与内容不符，邮件可能被篡改，签名人 李四）" ||
+		lines[1] != "加密：S/MIME，未解密（邮件已加密，未配置解密私钥）" {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+
+	if lines := securityLines(nil); len(lines) != 0 {
+		t.Fatalf("expected no lines for plain mail, got %q", lines)
+	}
+}
+
+func TestBuildSourceSummary_Security(t *testing.T) {
+	summary := buildSourceSummary(FolderRequest{
+		Sender:   "张三 <zhangsan@example.gov.cn>",
+		Security: &mail.MessageSecurity{Protocol: "smime", Encrypted: true, Decrypted: true},
+	}, nil)
+	if !strings.Contains(summary, "- 发件人：张三 <zhangsan@example.gov.cn>\n- 加密：S/MIME，已解密\n") {
+		t.Fatalf("summary is missing the encryption line:\n%s", summary)
+	}
+}
+
+func TestHandleConnectMail_InvalidTrustStore(t *testing.T) {
+	router := SetupRoutes()
+	raw, _ := json.Marshal(MailConfig{
+		Provider:   "maildir",
+		Path:       t.TempDir(),
+		TrustStore: filepath.Join(t.TempDir(), "missing.pem"),
+	})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/mail/connect", bytes.NewReader(raw)))
+	t.Cleanup(func() { mailProvider = nil })
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "加载证书失败") {
+		t.Fatalf("expected certificate error, got %d %s", rr.Code, rr.Body.String())
+	}
+	if mailProvider != nil {
+		t.Fatal("expected no provider after a failed connect")
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"knot-backend/mail"
+)
+
+const (
+	sourceModeCopy = "copy"
+	sourceModeMove = "move"
+
+	// maxSourceFiles bounds the files of one manual task.
+	maxSourceFiles = 50
+	// uploadMemoryBytes is how much of a multipart request is kept in
+	// memory; larger files are spooled to temporary files.
+	uploadMemoryBytes = 32 << 20
+)
+
+// taskSource is a file put into 00_来源资料 of a manual task: an upload or a
+// local file.
+type taskSource struct {
+	name string
+	open func() (io.ReadCloser, error)
+	// path is the local file, removed after the task is created when it
+	// was moved.
+	path string
+}
+
+// decodeFolderRequest reads a folder request from a JSON body, or from a
+// multipart form with the request as JSON in the "request" field and the
+// source files in "files". The returned cleanup removes spooled uploads.
+func decodeFolderRequest(w http.ResponseWriter, r *http.Request) (FolderRequest, func(), *taskError) {
+	var req FolderRequest
+	noop := func() {}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, noop, &taskError{Status: http.StatusBadRequest, Message: "Invalid request parameters"}
+		}
+		return req, noop, nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSourceFiles*mail.MaxAttachmentBytes())
+	if err := r.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, noop, &taskError{Status: http.StatusRequestEntityTooLarge, Message: "上传文件过大"}
+		}
+		return req, noop, &taskError{Status: http.StatusBadRequest, Message: "Invalid request parameters"}
+	}
+	cleanup := func() { r.MultipartForm.RemoveAll() }
+	if raw := r.FormValue("request"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &req); err != nil {
+			cleanup()
+			return req, noop, &taskError{Status: http.StatusBadRequest, Message: "Invalid request parameters"}
+		}
+	}
+	req.uploads = r.MultipartForm.File["files"]
+	return req, cleanup, nil
+}
+
+// collectTaskSources checks the uploads and source paths of req against
+// the attachment size limit before anything is written.
+func collectTaskSources(req FolderRequest) ([]taskSource, *taskError) {
+	mode := strings.ToLower(strings.TrimSpace(req.SourceMode))
+	if mode != "" && mode != sourceModeCopy && mode != sourceModeMove {
+		return nil, &taskError{Status: http.StatusBadRequest, Message: fmt.Sprintf("未知的 source_mode: %s", req.SourceMode)}
+	}
+	if n := len(req.uploads) + len(req.SourcePaths); n > maxSourceFiles {
+		return nil, &taskError{Status: http.StatusBadRequest, Message: fmt.Sprintf("来源文件过多: %d（最多 %d 个）", n, maxSourceFiles)}
+	}
+
+	limit := mail.MaxAttachmentBytes()
+	tooLarge := func(name string) *taskError {
+		return &taskError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("文件过大: %s（最大 %d MB）", name, limit>>20)}
+	}
+
+	var sources []taskSource
+	for _, fh := range req.uploads {
+		if fh.Size > limit {
+			return nil, tooLarge(fh.Filename)
+		}
+		fh := fh
+		sources = append(sources, taskSource{
+			name: fh.Filename,
+			open: func() (io.ReadCloser, error) { return fh.Open() },
+		})
+	}
+	for _, p := range req.SourcePaths {
+		// Moved files are deleted, so only files in the roots are taken.
+		path, err := resolveAllowedPath(p)
+		if err != nil {
+			return nil, &taskError{Status: pathErrorStatus(err), Message: err.Error()}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, &taskError{Status: http.StatusBadRequest, Message: fmt.Sprintf("无法读取来源文件: %v", err)}
+		}
+		if !info.Mode().IsRegular() {
+			return nil, &taskError{Status: http.StatusBadRequest, Message: fmt.Sprintf("来源路径不是文件: %s", p)}
+		}
+		if info.Size() > limit {
+			return nil, tooLarge(filepath.Base(path))
+		}
+		source := taskSource{
+			name: filepath.Base(path),
+			open: func() (io.ReadCloser, error) { return os.Open(path) },
+		}
+		if mode == sourceModeMove {
+			source.path = path
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// writeManualSourceFiles creates 00_来源资料 and saves sources into it under
+// the attachment file name policy. It returns the names used.
+func writeManualSourceFiles(folderPath string, sources []taskSource) ([]string, error) {
+	sourceDir := filepath.Join(folderPath, taskSourceDirName)
+	if err := os.MkdirAll(sourceDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var saved []string
+	for _, s := range sources {
+		name, err := saveTaskSource(sourceDir, s)
+		if err != nil {
+			return saved, err
+		}
+		saved = append(saved, name)
+	}
+	return saved, nil
+}
+
+func saveTaskSource(dir string, s taskSource) (string, error) {
+	rc, err := s.open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	return mail.SaveAttachment(dir, s.name, rc)
+}
+
+// removeMovedSources deletes the local files of sources that were moved
+// into the task.
+func removeMovedSources(sources []taskSource) error {
+	var failed []string
+	for _, s := range sources {
+		if s.path == "" {
+			continue
+		}
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// buildManualSourceSummary renders the 来源资料 section listing the files a
+// manual task was created with.
+func buildManualSourceSummary(files []string) string {
+	var b strings.Builder
+	b.WriteString("## 来源资料\n\n")
+	for _, name := range files {
+		b.WriteString("- " + sourceFileLink(name) + "\n")
+	}
+	return b.String()
+}
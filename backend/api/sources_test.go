@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func postFolderUpload(t *testing.T, router http.Handler, body FolderRequest, files map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	raw, _ := json.Marshal(body)
+	if err := mw.WriteField("request", string(raw)); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/folder/create", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleCreateFolder_Upload(t *testing.T) {
+	workDir := t.TempDir()
+	router := SetupRoutes()
+
+	rr := postFolderUpload(t, router, FolderRequest{Subject: "交接", BasePath: workDir, FolderName: "handover", Source: "manual"},
+		map[string]string{"需求 说明.docx": "spec", "a:b.txt": "notes"})
+	var resp struct {
+		SourceFiles []string `json:"source_files"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	if len(resp.SourceFiles) != 2 {
+		t.Fatalf("unexpected source files: %v", resp.SourceFiles)
+	}
+
+	sourceDir := filepath.Join(workDir, "handover", taskSourceDirName)
+	if got, err := os.ReadFile(filepath.Join(sourceDir, "需求 说明.docx")); err != nil || string(got) != "spec" {
+		t.Fatalf("unexpected upload %q: %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "a_b.txt")); err != nil {
+		t.Fatalf("expected the cleaned file name: %v", err)
+	}
+	record, _ := os.ReadFile(filepath.Join(workDir, "handover", workRecordFileName))
+	if !strings.Contains(string(record), "## 来源资料") || !strings.Contains(string(record), "[需求 说明.docx](00_来源资料/需求%20说明.docx)") {
+		t.Fatalf("expected the files in the work record, got %s", record)
+	}
+}
+
+func TestHandleCreateFolder_SourcePaths(t *testing.T) {
+	workDir := t.TempDir()
+	localDir := t.TempDir()
+	router := SetupRoutes()
+
+	kept := filepath.Join(localDir, "kept.pdf")
+	moved := filepath.Join(localDir, "moved.pdf")
+	for _, p := range []string{kept, moved} {
+		if err := os.WriteFile(p, []byte("pdf"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := postFolderCreate(t, router, FolderRequest{Subject: "复制", BasePath: workDir, FolderName: "copy", Source: "manual", SourcePaths: []string{kept}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("copy: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("expected a copied file to be kept: %v", err)
+	}
+
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "移动", BasePath: workDir, FolderName: "move", Source: "manual", SourcePaths: []string{moved}, SourceMode: "move"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("move: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(moved); !os.IsNotExist(err) {
+		t.Fatalf("expected a moved file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "move", taskSourceDirName, "moved.pdf")); err != nil {
+		t.Fatalf("expected the moved file in the task: %v", err)
+	}
+
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "x", BasePath: workDir, FolderName: "rel", Source: "manual", SourcePaths: []string{"kept.pdf"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a relative path, got %d", rr.Code)
+	}
+	rr = postFolderCreate(t, router, FolderRequest{MailID: "1", Subject: "x", BasePath: workDir, FolderName: "mail", Source: "email", SourcePaths: []string{kept}})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a mail task with source paths, got %d", rr.Code)
+	}
+}
+
+func TestHandleCreateFolder_SourceTooLarge(t *testing.T) {
+	t.Setenv("KNOT_MAX_ATTACHMENT_MB", "1")
+	workDir := t.TempDir()
+	router := SetupRoutes()
+
+	rr := postFolderUpload(t, router, FolderRequest{Subject: "大文件", BasePath: workDir, FolderName: "big", Source: "manual"},
+		map[string]string{"big.bin": strings.Repeat("x", 1<<20+1)})
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d %s", rr.Code, rr.Body.String())
+	}
+	if entries, _ := os.ReadDir(workDir); len(entries) != 0 {
+		t.Fatalf("expected nothing to be created, found %s", entries[0].Name())
+	}
+}
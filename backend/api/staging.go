@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// stagingDirPrefix names the hidden folders new tasks are built in,
+	// next to their final place so the closing rename stays on one file
+	// system.
+	stagingDirPrefix = ".knot-staging-"
+	// stagingMaxAge is how long a staging folder may exist before it is
+	// taken as the leftover of a crashed creation and removed.
+	stagingMaxAge = time.Hour
+)
+
+// TaskStep is the outcome of one step of task creation.
+type TaskStep struct {
+	Step  string `json:"step"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// taskSteps records the steps of one task creation in order.
+type taskSteps []TaskStep
+
+// record adds the outcome of step and returns err.
+func (s *taskSteps) record(step string, err error) error {
+	entry := TaskStep{Step: step, OK: err == nil}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	*s = append(*s, entry)
+	return err
+}
+
+// failed returns the error of step, or "" when it succeeded or did not run.
+func (s taskSteps) failed(step string) string {
+	for _, entry := range s {
+		if entry.Step == step && !entry.OK {
+			return entry.Error
+		}
+	}
+	return ""
+}
+
+func isStagingDir(name string) bool {
+	return strings.HasPrefix(name, stagingDirPrefix)
+}
+
+// newStagingDir creates an empty staging folder in baseFolder, removing
+// stale ones first. It becomes the task folder, so it gets the usual 0755
+// rather than the 0700 of os.MkdirTemp.
+func newStagingDir(baseFolder string) (string, error) {
+	if err := os.MkdirAll(baseFolder, 0o755); err != nil {
+		return "", err
+	}
+	removeStaleStaging(baseFolder)
+	dir, err := os.MkdirTemp(baseFolder, stagingDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dir, 0o755); err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func removeStaleStaging(baseFolder string) {
+	entries, err := os.ReadDir(baseFolder)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !isStagingDir(e.Name()) {
+			continue
+		}
+		if fi, err := e.Info(); err == nil && time.Since(fi.ModTime()) > stagingMaxAge {
+			path := filepath.Join(baseFolder, e.Name())
+			if err := os.RemoveAll(path); err != nil {
+				log.Printf("Failed to remove stale staging folder %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// errFolderTaken is returned by commitTaskFolder when the target appeared
+// while the task was being built.
+var errFolderTaken = errors.New("folder already exists")
+
+// commitTaskFolder moves a finished staging folder to target, replacing an
+// empty folder but nothing else.
+func commitTaskFolder(staging, target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		if !isEmptyDir(target) {
+			return errFolderTaken
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(staging, target); err != nil {
+		if _, statErr := os.Lstat(target); statErr == nil {
+			return errFolderTaken
+		}
+		return fmt.Errorf("move into place: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type createStepsResponse struct {
+	Detail  string     `json:"detail"`
+	Message string     `json:"message"`
+	Steps   []TaskStep `json:"steps"`
+}
+
+func TestHandleCreateFolder_RollsBackFailedStep(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	workDir := t.TempDir()
+	router := SetupRoutes()
+
+	// The second seed file needs "notes" to be a directory, but the first
+	// one wrote it as a file.
+	rr := putTemplates(t, router, []TaskTemplate{{
+		ID:    "broken",
+		Dirs:  []string{"docs"},
+		Files: []TemplateFile{{Path: "notes", Content: "x"}, {Path: "notes/today.md", Content: "y"}},
+	}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("save templates: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "x", BasePath: workDir, FolderName: "broken", Template: "broken", Source: "manual"})
+	var resp createStepsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(resp.Steps) != 3 || !resp.Steps[0].OK || !resp.Steps[1].OK || resp.Steps[2].Step != "template_files" || resp.Steps[2].OK {
+		t.Fatalf("unexpected steps: %+v", resp.Steps)
+	}
+
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected nothing left behind, found %s", entries[0].Name())
+	}
+}
+
+func TestHandleCreateFolder_CommittedFolderMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions")
+	}
+	workDir := t.TempDir()
+	router := SetupRoutes()
+
+	rr := postFolderCreate(t, router, FolderRequest{Subject: "权限", BasePath: workDir, FolderName: "mode", Source: "manual"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	info, err := os.Stat(filepath.Join(workDir, "mode"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o755 {
+		t.Fatalf("expected the task folder to be 0755, got %o", perm)
+	}
+}
+
+func TestHandleCreateFolder_ReportsAttachmentFailure(t *testing.T) {
+	workDir := t.TempDir()
+	mailProvider = nil
+	router := SetupRoutes()
+
+	raw, _ := json.Marshal(FolderRequest{MailID: "1", Subject: "附件", BasePath: workDir, FolderName: "mail", Source: "email"})
+	req := httptest.NewRequest(http.MethodPost, "/api/folder/create-with-attachments", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var resp createStepsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	if msg := taskSteps(resp.Steps).failed("attachments"); msg == "" || !strings.Contains(resp.Message, msg) {
+		t.Fatalf("expected the attachment failure to be reported, got %+v", resp)
+	}
+	last := resp.Steps[len(resp.Steps)-1]
+	if last.Step != "commit" || !last.OK {
+		t.Fatalf("expected the task to be committed, got %+v", resp.Steps)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "mail", workRecordFileName)); err != nil {
+		t.Fatalf("expected the task folder: %v", err)
+	}
+}
+
+func TestCollectScannedFolders_SkipsStaging(t *testing.T) {
+	workDir := t.TempDir()
+	staging, err := newStagingDir(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(staging, workRecordFileName), []byte("# x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, recursive := range []bool{false, true} {
+		folders, err := collectScannedFolders(workDir, recursive)
+		if err != nil || len(folders) != 0 {
+			t.Fatalf("expected staging folders to be hidden, got %v: %v", folders, err)
+		}
+	}
+
+	// A staging folder left by a crash is removed once it is old.
+	old := time.Now().Add(-2 * stagingMaxAge)
+	if err := os.Chtimes(staging, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newStagingDir(workDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Fatalf("expected the stale staging folder to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"knot-backend/mail"
+)
+
+const (
+	imapTraceFileName = "imap-trace.log"
+	// imapTraceMaxBytes caps the trace file; it is started over when a
+	// connection finds it larger.
+	imapTraceMaxBytes = 5 << 20
+	// imapTraceTailBytes is how much of the trace /api/mail/trace returns.
+	imapTraceTailBytes = 256 << 10
+)
+
+// mailTraceFile is the open IMAP trace of the current provider, if any.
+// Guarded by mailMu.
+var mailTraceFile *os.File
+
+func imapTracePath() string {
+	return filepath.Join(appDataDir(), "logs", imapTraceFileName)
+}
+
+// imapTraceEnabled reports whether config or KNOT_IMAP_TRACE asks for an
+// IMAP protocol trace.
+func imapTraceEnabled(config MailConfig) bool {
+	if config.Trace {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("KNOT_IMAP_TRACE")))
+	return enabled
+}
+
+// configureMailTrace makes provider log its protocol exchange, without
+// passwords, to the trace file. Callers hold mailMu.
+func configureMailTrace(provider mail.Provider, config MailConfig) error {
+	closeMailTrace()
+	tracer, ok := provider.(mail.Tracer)
+	if !ok || !imapTraceEnabled(config) {
+		return nil
+	}
+	path := imapTracePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if fi, err := os.Stat(path); err == nil && fi.Size() > imapTraceMaxBytes {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return err
+	}
+	mailTraceFile = f
+	tracer.SetTrace(f)
+	return nil
+}
+
+func closeMailTrace() {
+	if mailTraceFile != nil {
+		mailTraceFile.Close()
+		mailTraceFile = nil
+	}
+}
+
+// mailErrorResponse turns a provider error into a status code and message:
+// a refused login and a connection waiting to reconnect are told apart
+// from other failures.
+func mailErrorResponse(err error) (int, string) {
+	var authErr *mail.AuthError
+	var pending *mail.ReconnectPendingError
+	switch {
+	case errors.As(err, &authErr):
+		return http.StatusUnauthorized, fmt.Sprintf("邮箱登录失败，请检查用户名和密码: %v", authErr)
+	case errors.As(err, &pending):
+		wait := time.Until(pending.Retry).Round(time.Second)
+		if wait < time.Second {
+			wait = time.Second
+		}
+		return http.StatusServiceUnavailable, fmt.Sprintf("邮箱连接已断开，%s 后自动重连: %s", wait, pending.LastErr)
+	}
+	return http.StatusInternalServerError, err.Error()
+}
+
+func mailError(w http.ResponseWriter, err error) {
+	status, msg := mailErrorResponse(err)
+	jsonError(w, status, msg)
+}
+
+// handleMailStatus reports the state of each connected account. With
+// check=true the connection is checked first, which reconnects it when a
+// reconnect is due and measures the latency.
+func handleMailStatus(w http.ResponseWriter, r *http.Request) {
+	mailMu.Lock()
+	defer mailMu.Unlock()
+
+	accounts := []mail.ConnectionStatus{}
+	if reporter, ok := mailProvider.(mail.StatusReporter); ok {
+		if r.URL.Query().Get("check") == "true" {
+			_ = reporter.Ping()
+		}
+		accounts = append(accounts, reporter.Status())
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    accounts,
+		"trace":   map[string]interface{}{"enabled": mailTraceFile != nil, "path": imapTracePath()},
+	})
+}
+
+// handleGetMailTrace returns the end of the IMAP trace as plain text,
+// empty when no trace was written yet.
+func handleGetMailTrace(w http.ResponseWriter, r *http.Request) {
+	f, err := os.Open(imapTracePath())
+	if os.IsNotExist(err) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("读取跟踪日志失败: %v", err))
+		return
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil && fi.Size() > imapTraceTailBytes {
+		_, _ = f.Seek(-imapTraceTailBytes, io.SeekEnd)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, f)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+
+	"knot-backend/mail"
+)
+
+func getMailStatus(t *testing.T, router http.Handler, query string) []mail.ConnectionStatus {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/mail/status"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data []mail.ConnectionStatus `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func TestHandleMailStatus_Demo(t *testing.T) {
+	router := SetupRoutes()
+	mailProvider = nil
+	if accounts := getMailStatus(t, router, ""); len(accounts) != 0 {
+		t.Fatalf("expected no accounts before connecting, got %+v", accounts)
+	}
+
+	connectDemoProvider(t, router)
+	accounts := getMailStatus(t, router, "?check=true")
+	if len(accounts) != 1 || accounts[0].Provider != "demo" || accounts[0].State != mail.StateConnected {
+		t.Fatalf("unexpected status: %+v", accounts)
+	}
+}
+
+func TestMailErrorResponse(t *testing.T) {
+	if status, msg := mailErrorResponse(errors.New("select INBOX error: no such mailbox")); status != http.StatusInternalServerError ||
+		msg != "select INBOX error: no such mailbox" {
+		t.Fatalf("expected other errors to stay 500, got %d %s", status, msg)
+	}
+
+	wrapped := errors.Join(errors.New("reconnect failed"), &mail.AuthError{Err: errors.New("bad credentials")})
+	if status, msg := mailErrorResponse(wrapped); status != http.StatusUnauthorized || !strings.Contains(msg, "登录失败") {
+		t.Fatalf("expected 401 for a refused login, got %d %s", status, msg)
+	}
+
+	pending := &mail.ReconnectPendingError{Retry: time.Now().Add(8 * time.Second), LastErr: "connection refused"}
+	if status, msg := mailErrorResponse(pending); status != http.StatusServiceUnavailable ||
+		!strings.Contains(msg, "自动重连") || !strings.Contains(msg, "connection refused") {
+		t.Fatalf("expected 503 while reconnecting, got %d %s", status, msg)
+	}
+}
+
+func TestHandleConnectMail_StatusAndTrace(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	t.Setenv("KNOT_MESSAGE_CACHE_MB", "0")
+	clearProxyEnv(t)
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	router := SetupRoutes()
+	t.Cleanup(func() {
+		mailMu.Lock()
+		if mailProvider != nil {
+			mailProvider.Disconnect()
+		}
+		mailProvider = nil
+		closeMailTrace()
+		mailMu.Unlock()
+	})
+	connect := func(password string) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(MailConfig{Server: addr.IP.String(), Port: addr.Port, Username: "username", Password: password, Trace: true})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/mail/connect", bytes.NewReader(raw)))
+		return rr
+	}
+
+	if rr := connect("wrong"); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "登录失败") {
+		t.Fatalf("expected 401 for a wrong password, got %d %s", rr.Code, rr.Body.String())
+	}
+	if accounts := getMailStatus(t, router, ""); len(accounts) != 1 || accounts[0].State != mail.StateAuthFailed {
+		t.Fatalf("expected auth_failed, got %+v", accounts)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/mail/list", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected list to report the refused login, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := connect("password"); rr.Code != http.StatusOK {
+		t.Fatalf("connect failed: %d %s", rr.Code, rr.Body.String())
+	}
+	accounts := getMailStatus(t, router, "?check=true")
+	if len(accounts) != 1 || accounts[0].State != mail.StateConnected || accounts[0].Provider != "imap" || len(accounts[0].Capabilities) == 0 {
+		t.Fatalf("unexpected status: %+v", accounts)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/mail/trace", nil))
+	trace := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(trace, "LOGIN") || !strings.Contains(trace, "S: ") {
+		t.Fatalf("unexpected trace: %d %s", rr.Code, trace)
+	}
+	if strings.Contains(trace, "wrong") || strings.Contains(trace, `"password"`) {
+		t.Fatalf("trace leaks a password:\n%s", trace)
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"knot-backend/mail"
+)
+
+// Department suggestions learn from the tasks already filed: each task with
+// a department teaches that its sender, the sender's domain and the
+// keywords of its title belong there.
+//
+// A feature seen in n tasks, c of them in department d, votes c/(n+1) for
+// d, so one task is weaker evidence than many. Votes are weighted by kind
+// and divided by the weight of the features that could have voted; an
+// unknown sender or domain counts against the confidence, unknown keywords
+// do not.
+const (
+	senderFeatureWeight  = 4
+	domainFeatureWeight  = 2
+	keywordFeatureWeight = 1
+
+	maxDepartmentSuggestions = 3
+	minSuggestionConfidence  = 0.1
+)
+
+// DepartmentSuggestion is a department a mail probably belongs to.
+type DepartmentSuggestion struct {
+	Department string  `json:"department"`
+	Confidence float64 `json:"confidence"` // 0..1
+	// Basis lists the features that pointed to the department, such as
+	// "sender:a@example.com", "domain:example.com" or "keyword:合同".
+	Basis []string `json:"basis"`
+}
+
+// departmentSample is what one filed task tells about its department.
+type departmentSample struct {
+	department string
+	features   []string
+}
+
+// publicMailDomains say nothing about the department of their users.
+var publicMailDomains = map[string]bool{
+	"qq.com": true, "foxmail.com": true, "163.com": true, "126.com": true, "yeah.net": true,
+	"sina.com": true, "sohu.com": true, "aliyun.com": true, "139.com": true,
+	"gmail.com": true, "outlook.com": true, "hotmail.com": true, "live.com": true,
+	"yahoo.com": true, "icloud.com": true,
+}
+
+// suggestionStopwords are keywords too common in mail subjects to tell
+// departments apart.
+var suggestionStopwords = map[string]bool{
+	"fwd": true, "the": true, "and": true, "for": true, "about": true, "re": true,
+	"关于": true, "回复": true, "答复": true, "转发": true, "通知": true, "查收": true,
+	"邮件": true,
+}
+
+var (
+	sourceSenderLinePattern = regexp.MustCompile(`(?m)^-\s*发件人[：:]\s*(.+)$`)
+	keywordWordPattern      = regexp.MustCompile(`[\p{Han}]+|[\p{L}\p{N}]+`)
+)
+
+// mailFeatures returns the sender, domain and subject keyword features of
+// a mail.
+func mailFeatures(sender, subject string) []string {
+	var features []string
+	if from := normalizeMailFrom(sender); from != "" {
+		features = append(features, "sender:"+from)
+		if at := strings.LastIndex(from, "@"); at >= 0 {
+			if domain := from[at+1:]; domain != "" && !publicMailDomains[domain] {
+				features = append(features, "domain:"+domain)
+			}
+		}
+	}
+	for _, keyword := range subjectKeywords(subject) {
+		features = append(features, "keyword:"+keyword)
+	}
+	return features
+}
+
+// subjectKeywords splits a subject into distinct keywords: latin words of
+// three or more letters and the character bigrams of Chinese text. Reply
+// prefixes are dropped, 【】 tags are kept since they often name a team.
+func subjectKeywords(subject string) []string {
+	tags := strings.Join(subjectBracketPattern.FindAllString(subject, -1), " ")
+	text := strings.ToLower(tags + " " + cleanMailSubject(subject))
+
+	seen := map[string]bool{}
+	var keywords []string
+	add := func(k string) {
+		if !seen[k] && !suggestionStopwords[k] {
+			seen[k] = true
+			keywords = append(keywords, k)
+		}
+	}
+	for _, word := range keywordWordPattern.FindAllString(text, -1) {
+		runes := []rune(word)
+		if !unicode.Is(unicode.Han, runes[0]) {
+			if len(runes) >= 3 && !isAllDigits(word) {
+				add(word)
+			}
+			continue
+		}
+		if len(runes) == 1 {
+			continue
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			add(string(runes[i : i+2]))
+		}
+	}
+	return keywords
+}
+
+func isAllDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// folderDepartmentSample reads the department, sender and title of a
+// scanned task. ok is false for tasks without a department.
+func folderDepartmentSample(folder map[string]interface{}) (departmentSample, bool) {
+	department, _ := folder["department"].(string)
+	department = strings.TrimSpace(department)
+	if department == "" {
+		return departmentSample{}, false
+	}
+	title, _ := folder["title"].(string)
+	raw, _ := folder["raw_content"].(string)
+	path, _ := folder["path"].(string)
+	return departmentSample{department: department, features: mailFeatures(taskSender(raw, path), title)}, true
+}
+
+// taskSender is the sender of the mail a task was created from: the 来源摘要
+// of its work record, or the email.txt of older tasks.
+func taskSender(workRecordBody, folderPath string) string {
+	if m := sourceSenderLinePattern.FindStringSubmatch(workRecordBody); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	f, err := os.Open(filepath.Join(folderPath, taskSourceDirName, "email.txt"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "发件人:"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// departmentModel counts, per feature, the tasks of each department.
+type departmentModel struct {
+	counts map[string]map[string]int
+	totals map[string]int
+}
+
+func newDepartmentModel(samples []departmentSample) *departmentModel {
+	m := &departmentModel{counts: map[string]map[string]int{}, totals: map[string]int{}}
+	for _, s := range samples {
+		for _, f := range s.features {
+			if m.counts[f] == nil {
+				m.counts[f] = map[string]int{}
+			}
+			m.counts[f][s.department]++
+			m.totals[f]++
+		}
+	}
+	return m
+}
+
+func featureWeight(feature string) float64 {
+	switch {
+	case strings.HasPrefix(feature, "sender:"):
+		return senderFeatureWeight
+	case strings.HasPrefix(feature, "domain:"):
+		return domainFeatureWeight
+	}
+	return keywordFeatureWeight
+}
+
+// suggest ranks the departments of a mail from sender and subject, best
+// first. It returns nil when no department reaches minSuggestionConfidence.
+func (m *departmentModel) suggest(sender, subject string) []DepartmentSuggestion {
+	if m == nil || len(m.totals) == 0 {
+		return nil
+	}
+	scores := map[string]float64{}
+	basis := map[string][]string{}
+	var possible float64
+	for _, f := range mailFeatures(sender, subject) {
+		n := m.totals[f]
+		if n == 0 && strings.HasPrefix(f, "keyword:") {
+			continue
+		}
+		w := featureWeight(f)
+		possible += w
+		for department, c := range m.counts[f] {
+			scores[department] += w * float64(c) / float64(n+1)
+			basis[department] = append(basis[department], f)
+		}
+	}
+
+	var suggestions []DepartmentSuggestion
+	for department, score := range scores {
+		confidence := math.Round(score/possible*100) / 100
+		if confidence < minSuggestionConfidence {
+			continue
+		}
+		suggestions = append(suggestions, DepartmentSuggestion{Department: department, Confidence: confidence, Basis: basis[department]})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].Department < suggestions[j].Department
+	})
+	if len(suggestions) > maxDepartmentSuggestions {
+		suggestions = suggestions[:maxDepartmentSuggestions]
+	}
+	return suggestions
+}
+
+// mailItemSender is the sender address of a listed mail, or its display
+// name when the address is unknown.
+func mailItemSender(item mail.MailItem) string {
+	if len(item.FromAddresses) > 0 && item.FromAddresses[0].Address != "" {
+		return item.FromAddresses[0].Address
+	}
+	return item.From
+}
+
+// suggestDepartments ranks the departments of the mail behind req against
+// the tasks in its workspace and archive paths.
+func suggestDepartments(req FolderRequest) []DepartmentSuggestion {
+	sender := req.Sender
+	if strings.TrimSpace(sender) == "" {
+		sender = req.FromAddr
+	}
+	return newTaskLookup(req.BasePath, req.ArchivePaths).departments().suggest(sender, req.Subject)
+}
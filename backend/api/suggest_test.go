@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestDepartmentModel_Suggest(t *testing.T) {
+	model := newDepartmentModel([]departmentSample{
+		{"财务部", mailFeatures("王会计 <wang@fin.example.com>", "【财务】三月报销单据")},
+		{"财务部", mailFeatures("wang@fin.example.com", "发票报销提醒")},
+		{"财务部", mailFeatures("li@fin.example.com", "预算调整")},
+		{"人事部", mailFeatures("hr@corp.example", "面试安排")},
+		{"人事部", mailFeatures("someone@qq.com", "Re: 招聘需求")},
+	})
+
+	got := model.suggest("wang@fin.example.com", "四月报销")
+	if len(got) != 1 || got[0].Department != "财务部" || got[0].Confidence < 0.6 {
+		t.Fatalf("expected confident 财务部, got %+v", got)
+	}
+	if got[0].Basis[0] != "sender:wang@fin.example.com" {
+		t.Fatalf("expected sender as first basis, got %q", got[0].Basis)
+	}
+
+	// A new colleague in the same domain is a weaker hint.
+	if got := model.suggest("zhao@fin.example.com", "会议"); len(got) != 1 || got[0].Department != "财务部" || got[0].Confidence >= 0.6 {
+		t.Fatalf("expected weaker 财务部 by domain, got %+v", got)
+	}
+	// Public mail domains are not learned.
+	if got := model.suggest("other@qq.com", "你好"); got != nil {
+		t.Fatalf("expected no suggestion for a public domain, got %+v", got)
+	}
+	if got := model.suggest("someone@else.example", "招聘面试"); len(got) != 1 || got[0].Department != "人事部" {
+		t.Fatalf("expected 人事部 by keywords, got %+v", got)
+	}
+}
+
+func TestSubjectKeywords(t *testing.T) {
+	got := subjectKeywords("回复：【财务】Budget 2025 报销")
+	want := []string{"财务", "budget", "报销"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestHandleMailList_DepartmentSuggestions(t *testing.T) {
+	workDir := t.TempDir()
+	router := SetupRoutes()
+	connectDemoProvider(t, router)
+
+	rr := postFolderCreate(t, router, FolderRequest{
+		MailID:     "4",
+		Subject:    "关于组织部门团建活动的通知",
+		FromAddr:   "人事部",
+		BasePath:   workDir,
+		FolderName: "team_building",
+		Department: "人事部",
+		Source:     "email",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create task: %d %s", rr.Code, rr.Body.String())
+	}
+
+	q := url.Values{"limit": {"20"}, "days": {"30"}, "scan_path": {workDir}}
+	req := httptest.NewRequest(http.MethodGet, "/api/mail/list?"+q.Encode(), nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Data []MailListItem `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range resp.Data {
+		switch item.ID {
+		case "4":
+			if item.DepartmentSuggestions != nil {
+				t.Fatalf("expected no suggestion for a mail with a task, got %+v", item.DepartmentSuggestions)
+			}
+		case "8":
+			s := item.DepartmentSuggestions
+			if len(s) == 0 || s[0].Department != "人事部" || s[0].Confidence < 0.5 {
+				t.Fatalf("expected 人事部 for the same sender, got %+v", s)
+			}
+		}
+	}
+
+	// The create response ranks departments too.
+	rr = postFolderCreate(t, router, FolderRequest{
+		MailID:     "8",
+		Subject:    "新员工入职培训安排",
+		BasePath:   workDir,
+		FolderName: "onboarding",
+		Source:     "email",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create task: %d %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Suggestions []DepartmentSuggestion `json:"department_suggestions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Suggestions) == 0 || created.Suggestions[0].Department != "人事部" {
+		t.Fatalf("expected 人事部 in create response, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+
+	"knot-backend/mail"
+)
+
+const (
+	summaryExcerptRunes = 600
+	summaryExcerptLines = 20
+)
+
+// withMailDetail fills the fields of req the client left empty from the
+// provider's mail detail, fetched once per task.
+func withMailDetail(req FolderRequest) FolderRequest {
+	if mailProvider == nil || strings.TrimSpace(req.MailID) == "" {
+		return req
+	}
+	detail, err := mailProvider.FetchMailDetail(req.MailID)
+	if err != nil {
+		return req
+	}
+
+	str := func(key string) string {
+		v, _ := detail[key].(string)
+		return v
+	}
+	list := func(key string) []string {
+		addrs, _ := detail[key].([]mail.Address)
+		var v []string
+		for _, a := range addrs {
+			v = append(v, a.String())
+		}
+		return v
+	}
+	if strings.TrimSpace(req.MessageID) == "" {
+		req.MessageID = str("message_id")
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		req.Body = str("body")
+		req.NewContent = str("new_content")
+	}
+	if strings.TrimSpace(req.Sender) == "" {
+		if from := list("from"); len(from) > 0 {
+			req.Sender = from[0]
+		}
+	}
+	if len(req.To) == 0 && len(req.Cc) == 0 {
+		req.To, req.Cc = list("to"), list("cc")
+	}
+	if len(req.Events) == 0 {
+		req.Events, _ = detail["events"].([]mail.CalendarEvent)
+	}
+	if req.Security == nil {
+		req.Security, _ = detail["security"].(*mail.MessageSecurity)
+	}
+	return req
+}
+
+var (
+	summaryTagPattern   = regexp.MustCompile(`(?s)<(style|script)[^>]*>.*?</(style|script)>|<[^>]*>`)
+	summaryBlankPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// summaryExcerpt cleans the new content of the mail into a bounded excerpt.
+func summaryExcerpt(req FolderRequest) string {
+	text := emailNewContent(req)
+	if strings.Contains(text, "</") {
+		text = html.UnescapeString(summaryTagPattern.ReplaceAllString(text, "\n"))
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.TrimSpace(summaryBlankPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+
+	truncated := false
+	if lines := strings.Split(text, "\n"); len(lines) > summaryExcerptLines {
+		text, truncated = strings.Join(lines[:summaryExcerptLines], "\n"), true
+	}
+	if runes := []rune(text); len(runes) > summaryExcerptRunes {
+		text, truncated = string(runes[:summaryExcerptRunes]), true
+	}
+	if truncated {
+		text = strings.TrimSpace(text) + "…"
+	}
+	return text
+}
+
+// attachmentLink is a Markdown link to a saved attachment, relative to the
+// work record.
+func attachmentLink(name string) string {
+	return markdownFileLink(name, path.Join(taskSourceDirName, taskAttachmentDir, name))
+}
+
+// sourceFileLink is a Markdown link to a file in 00_来源资料, relative to the
+// work record.
+func sourceFileLink(name string) string {
+	return markdownFileLink(name, path.Join(taskSourceDirName, name))
+}
+
+func markdownFileLink(name, target string) string {
+	target = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(target)
+	return fmt.Sprintf("[%s](%s)", name, target)
+}
+
+// buildSourceSummary renders the 来源摘要 section of a mail task: who sent
+// the mail to whom and when, an excerpt of what it says and the attachments
+// saved with the task.
+func buildSourceSummary(req FolderRequest, attachments []string) string {
+	var b strings.Builder
+	b.WriteString("## 来源摘要\n\n")
+
+	sender := strings.TrimSpace(req.Sender)
+	if sender == "" {
+		sender = strings.TrimSpace(req.FromAddr)
+	}
+	if sender != "" {
+		fmt.Fprintf(&b, "- 发件人：%s\n", sender)
+	}
+	if t, ok := mailTime(req.Date); ok {
+		fmt.Fprintf(&b, "- 日期：%s\n", t.Format("2006-01-02 15:04"))
+	} else if strings.TrimSpace(req.Date) != "" {
+		fmt.Fprintf(&b, "- 日期：%s\n", strings.TrimSpace(req.Date))
+	}
+	if len(req.To) > 0 {
+		fmt.Fprintf(&b, "- 收件人：%s\n", strings.Join(req.To, "，"))
+	}
+	if len(req.Cc) > 0 {
+		fmt.Fprintf(&b, "- 抄送：%s\n", strings.Join(req.Cc, "，"))
+	}
+	for _, line := range securityLines(req.Security) {
+		b.WriteString("- " + line + "\n")
+	}
+
+	if excerpt := summaryExcerpt(req); excerpt != "" {
+		b.WriteString("\n")
+		for _, line := range strings.Split(excerpt, "\n") {
+			if line == "" {
+				b.WriteString(">\n")
+			} else {
+				b.WriteString("> " + line + "\n")
+			}
+		}
+	}
+
+	if len(attachments) > 0 {
+		b.WriteString("\n附件：\n\n")
+		for _, name := range attachments {
+			b.WriteString("- " + attachmentLink(name) + "\n")
+		}
+	}
+	return b.String()
+}
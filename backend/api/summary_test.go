@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateTaskFolder_SourceSummary(t *testing.T) {
+	tmpDir := t.TempDir()
+	router := SetupRoutes()
+	connectDemoProvider(t, router)
+
+	raw, _ := json.Marshal(FolderRequest{
+		MailID:     "1",
+		Subject:    "关于2025年度预算审批的通知",
+		Date:       "Mon, 20 Apr 2026 10:30:00 +0800",
+		FromAddr:   "财务部",
+		BasePath:   tmpDir,
+		FolderName: "2026.04.20_预算审批",
+		Source:     "email",
+	})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/folder/create-with-attachments", bytes.NewReader(raw)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "2026.04.20_预算审批", workRecordFileName))
+	record := string(data)
+	for _, want := range []string{
+		"## 来源摘要\n\n- 发件人：财务部 <caiwu@company.com>\n- 日期：2026-04-20 10:30\n",
+		"> 各部门：\n>\n> 根据公司年度工作计划",
+		"- [2025年度预算表.xlsx](00_来源资料/附件/2025年度预算表.xlsx)",
+		"- [预算说明.docx](00_来源资料/附件/预算说明.docx)",
+	} {
+		if !strings.Contains(record, want) {
+			t.Fatalf("work record is missing %q:\n%s", want, record)
+		}
+	}
+	// The body came from the provider since the request had none.
+	txt, _ := os.ReadFile(filepath.Join(tmpDir, "2026.04.20_预算审批", taskSourceDirName, "email.txt"))
+	if !strings.Contains(string(txt), "预算编制要求") {
+		t.Fatalf("email.txt is missing the body:\n%s", txt)
+	}
+}
+
+func TestBuildSourceSummary(t *testing.T) {
+	req := FolderRequest{
+		FromAddr: "Li Si",
+		Date:     "not a date",
+		To:       []string{"张三 <zhangsan@example.com>", "ops@example.com"},
+		Cc:       []string{"王五 <wangwu@example.com>"},
+		Body:     "<p>Hello &amp; welcome</p>" + strings.Repeat("<p>line</p>", 30),
+	}
+	summary := buildSourceSummary(req, []string{"plan (v2).pdf"})
+	for _, want := range []string{
+		"- 发件人：Li Si\n- 日期：not a date\n",
+		"- 收件人：张三 <zhangsan@example.com>，ops@example.com\n- 抄送：王五 <wangwu@example.com>\n",
+		"> Hello & welcome\n",
+		"- [plan (v2).pdf](00_来源资料/附件/plan%20%28v2%29.pdf)",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Fatalf("summary is missing %q:\n%s", want, summary)
+		}
+	}
+	if strings.Count(summary, "\n> ") > summaryExcerptLines || !strings.Contains(summary, "…") {
+		t.Fatalf("excerpt not bounded:\n%s", summary)
+	}
+}
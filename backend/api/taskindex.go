@@ -0,0 +1,163 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"knot-backend/mail"
+)
+
+// taskIndexTTL bounds how long a root's index is trusted. Changes made
+// through the API invalidate it right away; the TTL catches edits made in
+// the file manager.
+const taskIndexTTL = time.Minute
+
+type taskIndexEntry struct {
+	built time.Time
+	paths map[string]string // hash -> task folder path
+	// samples are the tasks with a department, for department suggestions.
+	samples []departmentSample
+}
+
+var (
+	taskIndexMu    sync.Mutex
+	taskIndexCache = map[string]*taskIndexEntry{}
+)
+
+// rootTaskIndex returns the index of every task below root, scanning it
+// only when the cached index is missing or stale.
+func rootTaskIndex(root string) *taskIndexEntry {
+	key := normalizePathKey(normalizeScanPath(root))
+
+	taskIndexMu.Lock()
+	entry := taskIndexCache[key]
+	taskIndexMu.Unlock()
+	if entry != nil && time.Since(entry.built) < taskIndexTTL {
+		return entry
+	}
+
+	entry = &taskIndexEntry{built: time.Now(), paths: map[string]string{}}
+	folders, _ := collectScannedFolders(root, true)
+	for _, folder := range folders {
+		if sample, ok := folderDepartmentSample(folder); ok {
+			entry.samples = append(entry.samples, sample)
+		}
+		hash := strings.TrimSpace(fmt.Sprint(folder["hash"]))
+		if hash == "" {
+			continue
+		}
+		if _, exists := entry.paths[hash]; !exists {
+			entry.paths[hash] = fmt.Sprint(folder["path"])
+		}
+	}
+	// Follow-up mails resolve to their task unless they started one themselves.
+	for _, folder := range folders {
+		related, _ := folder["related_hashes"].([]string)
+		for _, hash := range related {
+			if _, exists := entry.paths[hash]; !exists {
+				entry.paths[hash] = fmt.Sprint(folder["path"])
+			}
+		}
+	}
+
+	taskIndexMu.Lock()
+	taskIndexCache[key] = entry
+	taskIndexMu.Unlock()
+	return entry
+}
+
+// invalidateTaskIndex drops all cached indexes after tasks were created,
+// renamed, re-hashed or archived.
+func invalidateTaskIndex() {
+	taskIndexMu.Lock()
+	taskIndexCache = map[string]*taskIndexEntry{}
+	taskIndexMu.Unlock()
+}
+
+// taskLookup resolves mails to existing tasks across the workspace and the
+// archive roots. Workspace tasks win over archived ones.
+type taskLookup struct {
+	roots []taskLookupRoot
+	model *departmentModel
+}
+
+type taskLookupRoot struct {
+	status string
+	index  *taskIndexEntry
+}
+
+func newTaskLookup(scanPath string, archivePaths []string) *taskLookup {
+	l := &taskLookup{}
+	// Folders outside the workspace roots are not searched.
+	if folder, ok := lookupFolder(scanPath); ok {
+		l.roots = append(l.roots, taskLookupRoot{"working", rootTaskIndex(folder)})
+	}
+	for _, ap := range archivePaths {
+		if ap = strings.TrimSpace(ap); ap != "" {
+			if folder, ok := lookupFolder(ap); ok {
+				l.roots = append(l.roots, taskLookupRoot{"archived", rootTaskIndex(folder)})
+			}
+		}
+	}
+	return l
+}
+
+// find returns the status and path of the first task carrying any of
+// hashes, or "none".
+func (l *taskLookup) find(hashes ...string) (string, string) {
+	for _, root := range l.roots {
+		for _, h := range hashes {
+			if path, ok := root.index.paths[h]; ok && h != "" {
+				return root.status, path
+			}
+		}
+	}
+	return "none", ""
+}
+
+// departments returns the department model learned from the tasks of all
+// roots, building it on first use.
+func (l *taskLookup) departments() *departmentModel {
+	if l.model == nil {
+		var samples []departmentSample
+		for _, root := range l.roots {
+			samples = append(samples, root.index.samples...)
+		}
+		l.model = newDepartmentModel(samples)
+	}
+	return l.model
+}
+
+// MailListItem is a mail together with the task created from it, if any.
+type MailListItem struct {
+	mail.MailItem
+	TaskStatus string `json:"task_status"`
+	TaskPath   string `json:"task_path,omitempty"`
+	ThreadID   string `json:"thread_id,omitempty"`
+	// ThreadTaskPath offers the task of an earlier mail in the same thread.
+	ThreadTaskPath string `json:"thread_task_path,omitempty"`
+	AutoAppended   bool   `json:"auto_appended,omitempty"`
+	// DepartmentSuggestions rank the departments of a mail without a task,
+	// best first.
+	DepartmentSuggestions []DepartmentSuggestion `json:"department_suggestions,omitempty"`
+}
+
+// withTaskStatus looks up every mail by its canonical hash and by the hash
+// older frontends stored. Mails without a task get department suggestions.
+func withTaskStatus(items []mail.MailItem, lookup *taskLookup) []MailListItem {
+	result := make([]MailListItem, 0, len(items))
+	for _, item := range items {
+		status, path := lookup.find(
+			canonicalMailHash(item.MessageID, item.Subject, item.From, item.Date),
+			mailItemHash(item),
+		)
+		listed := MailListItem{MailItem: item, TaskStatus: status, TaskPath: path}
+		if status == "none" {
+			listed.DepartmentSuggestions = lookup.departments().suggest(mailItemSender(item), item.Subject)
+		}
+		result = append(result, listed)
+	}
+	return result
+}
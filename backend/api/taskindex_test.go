@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleMailList_TaskStatus(t *testing.T) {
+	workDir := t.TempDir()
+	archiveDir := t.TempDir()
+	router := SetupRoutes()
+	connectDemoProvider(t, router)
+
+	for _, id := range []string{"1", "2"} {
+		rr := postFolderCreate(t, router, FolderRequest{
+			MailID:     id,
+			Subject:    "demo " + id,
+			BasePath:   workDir,
+			FolderName: "task_" + id,
+			Source:     "email",
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("create task %s: %d %s", id, rr.Code, rr.Body.String())
+		}
+	}
+	if err := os.Rename(filepath.Join(workDir, "task_2"), filepath.Join(archiveDir, "task_2")); err != nil {
+		t.Fatal(err)
+	}
+	// Moving by hand bypasses the API, so drop the cached index ourselves.
+	invalidateTaskIndex()
+
+	q := url.Values{"limit": {"20"}, "days": {"30"}, "scan_path": {workDir}, "archive_path": {archiveDir}}
+	req := httptest.NewRequest(http.MethodGet, "/api/mail/list?"+q.Encode(), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d, body=%s", rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Data []MailListItem `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"1": "working", "2": "archived"}
+	for _, item := range resp.Data {
+		expected := want[item.ID]
+		if expected == "" {
+			expected = "none"
+		}
+		if item.TaskStatus != expected {
+			t.Fatalf("mail %s: expected %s, got %s", item.ID, expected, item.TaskStatus)
+		}
+		if expected != "none" && item.TaskPath == "" {
+			t.Fatalf("mail %s: missing task path", item.ID)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	templatesFileName = "templates.json"
+	// defaultTemplateID names the template used when neither the request nor
+	// the department picks one. Saving a template with this ID replaces the
+	// built-in skeleton.
+	defaultTemplateID = "default"
+)
+
+// TaskTemplate is the skeleton of a new task folder: the directories to
+// create, seed files and the Markdown of 工作记录.md. File contents and the
+// work record may use the variables listed in templateVariables.
+type TaskTemplate struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Departments use this template when a request does not name one.
+	Departments []string       `json:"departments"`
+	Dirs        []string       `json:"dirs"`
+	Files       []TemplateFile `json:"files"`
+	// WorkRecord is the Markdown of the work record. The keys the app relies
+	// on (type, title, hash, department, ...) are always written to its
+	// frontmatter; other frontmatter keys of the template are kept.
+	WorkRecord string `json:"work_record"`
+}
+
+// TemplateFile is a seed file, relative to the task folder.
+type TemplateFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// templateVariables are the {{name}} placeholders templates may use.
+var templateVariables = []string{
+	"title", "subject", "date", "YYYY", "MM", "DD", "sender", "department",
+	"hash", "source", "folder_name", "project_path", "source_summary", "meeting",
+	"derived_from",
+}
+
+const defaultWorkRecordTemplate = `# {{title}}
+
+## 工作内容
+
+围绕“{{title}}”开展任务资料整理与输出准备工作。
+
+{{source_summary}}{{meeting}}## 工作过程
+
+- {{date}}：创建任务文件夹并完成基础材料归集。
+
+## 当前进展
+
+已完成任务初始化，正在持续完善过程记录与输出内容。
+
+## 下一步
+
+继续补充过程材料，完成成果文件并放入 20_成果输出。
+`
+
+var (
+	templatesMu             sync.Mutex
+	templateVariablePattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+)
+
+// builtinTaskTemplate is the standard skeleton used until a template with
+// defaultTemplateID is saved.
+func builtinTaskTemplate() TaskTemplate {
+	return TaskTemplate{
+		ID:          defaultTemplateID,
+		Name:        "标准任务",
+		Departments: []string{},
+		Dirs:        []string{taskSourceDirName, taskProcessDirName, taskOutputDirName},
+		Files:       []TemplateFile{},
+		WorkRecord:  defaultWorkRecordTemplate,
+	}
+}
+
+func templatesFilePath() string {
+	return filepath.Join(appDataDir(), templatesFileName)
+}
+
+func loadTaskTemplates() ([]TaskTemplate, error) {
+	templates := []TaskTemplate{}
+	if err := loadJSONFile(templatesFilePath(), &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// selectTaskTemplate returns the template named by id, else the first one
+// assigned to department, else the default.
+func selectTaskTemplate(id, department string) (TaskTemplate, error) {
+	templatesMu.Lock()
+	templates, err := loadTaskTemplates()
+	templatesMu.Unlock()
+	if err != nil {
+		return TaskTemplate{}, fmt.Errorf("读取任务模板失败: %v", err)
+	}
+
+	if id = strings.TrimSpace(id); id != "" {
+		for _, t := range templates {
+			if t.ID == id {
+				return t, nil
+			}
+		}
+		if id == defaultTemplateID {
+			return builtinTaskTemplate(), nil
+		}
+		return TaskTemplate{}, fmt.Errorf("未知的任务模板: %s", id)
+	}
+	if department = strings.TrimSpace(department); department != "" {
+		for _, t := range templates {
+			for _, d := range t.Departments {
+				if strings.TrimSpace(d) == department {
+					return t, nil
+				}
+			}
+		}
+	}
+	for _, t := range templates {
+		if t.ID == defaultTemplateID {
+			return t, nil
+		}
+	}
+	return builtinTaskTemplate(), nil
+}
+
+// cleanTemplatePath checks that a template path stays inside the task
+// folder and returns it in OS form.
+func cleanTemplatePath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	if strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) || filepath.IsAbs(p) || filepath.VolumeName(p) != "" {
+		return "", fmt.Errorf("path must be relative: %s", p)
+	}
+	for _, part := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", fmt.Errorf("path must stay inside the task folder: %s", p)
+		}
+	}
+	return filepath.Clean(filepath.FromSlash(strings.ReplaceAll(p, `\`, "/"))), nil
+}
+
+func validateTaskTemplate(t TaskTemplate) error {
+	if strings.TrimSpace(t.ID) == "" {
+		return fmt.Errorf("template id is required")
+	}
+	for _, d := range t.Dirs {
+		if _, err := cleanTemplatePath(d); err != nil {
+			return fmt.Errorf("template %s: %v", t.ID, err)
+		}
+	}
+	for _, f := range t.Files {
+		p, err := cleanTemplatePath(f.Path)
+		if err != nil {
+			return fmt.Errorf("template %s: %v", t.ID, err)
+		}
+		if p == workRecordFileName {
+			return fmt.Errorf("template %s: %s is written from work_record", t.ID, workRecordFileName)
+		}
+	}
+	return nil
+}
+
+// taskTemplateVars are the values of templateVariables for a new task.
+// attachments are the saved mail attachments, or for a manual task the
+// source files, listed in source_summary.
+func taskTemplateVars(req FolderRequest, folderName, folderPath, sourceType string, attachments []string, now time.Time) map[string]string {
+	title := strings.TrimSpace(req.Subject)
+	if title == "" {
+		title = folderName
+	}
+	sender := strings.TrimSpace(req.Sender)
+	if sender == "" {
+		sender = strings.TrimSpace(req.FromAddr)
+	}
+
+	sourceSummary := ""
+	if sourceType == "email" {
+		sourceSummary = buildSourceSummary(req, attachments) + "\n"
+	} else if len(attachments) > 0 {
+		sourceSummary = buildManualSourceSummary(attachments) + "\n"
+	}
+	derivedFrom := ""
+	if req.derivedFrom != "" {
+		derivedFrom = filepath.ToSlash(req.derivedFrom)
+		sourceSummary = buildDerivedSummary(req.derivedFrom, folderPath) + "\n" + sourceSummary
+	}
+	meeting := ""
+	if event := taskEvent(req.Events); event != nil {
+		meeting = buildMeetingSection(event) + "\n"
+	}
+
+	return map[string]string{
+		"title":          title,
+		"subject":        strings.TrimSpace(req.Subject),
+		"date":           now.Format("2006-01-02"),
+		"YYYY":           now.Format("2006"),
+		"MM":             now.Format("01"),
+		"DD":             now.Format("02"),
+		"sender":         sender,
+		"department":     strings.TrimSpace(req.Department),
+		"hash":           strings.TrimSpace(req.Hash),
+		"source":         sourceType,
+		"folder_name":    folderName,
+		"project_path":   filepath.ToSlash(folderPath),
+		"source_summary": sourceSummary,
+		"meeting":        meeting,
+		"derived_from":   derivedFrom,
+	}
+}
+
+// renderTemplateText fills in the {{name}} placeholders of text. Unknown
+// names are left as they are.
+func renderTemplateText(text string, vars map[string]string) string {
+	return templateVariablePattern.ReplaceAllStringFunc(text, func(m string) string {
+		name := templateVariablePattern.FindStringSubmatch(m)[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// workRecordFrontmatter is the frontmatter every work record starts with.
+func workRecordFrontmatter(req FolderRequest, vars map[string]string) []string {
+	front := []string{
+		"type: task",
+		"schema_version: 3",
+		"title: " + vars["title"],
+		"status: active",
+		"created: " + vars["date"],
+		"updated: " + vars["date"],
+		"source: " + vars["source"],
+		"department: " + vars["department"],
+		"project_path: " + vars["project_path"],
+		"folder_name: " + vars["folder_name"],
+		"archive_status: local_active",
+		"hash: " + vars["hash"],
+	}
+	if vars["derived_from"] != "" {
+		front = append(front, "derived_from: "+vars["derived_from"])
+	}
+	// An invitation or deadline in the mail dates the task.
+	if event := taskEvent(req.Events); event != nil {
+		front = append(front, "due: "+eventDue(event))
+		if meetingTime := eventMeetingTime(event); meetingTime != "" {
+			front = append(front, "meeting_time: "+meetingTime)
+		}
+	}
+	return front
+}
+
+// renderWorkRecord renders the work record of tpl. Frontmatter keys of the
+// template that the app also writes are replaced; its other keys follow the
+// app's, and tags default to 工作材料.
+func renderWorkRecord(tpl TaskTemplate, req FolderRequest, vars map[string]string) string {
+	text := tpl.WorkRecord
+	if strings.TrimSpace(text) == "" {
+		text = defaultWorkRecordTemplate
+	}
+	templateFront, body, _ := splitFrontmatter(renderTemplateText(text, vars))
+
+	front := workRecordFrontmatter(req, vars)
+	owned := map[string]bool{}
+	for _, line := range front {
+		owned[normalizeKey(strings.SplitN(line, ":", 2)[0])] = true
+	}
+	hasTags := false
+	skipping := false
+	for _, line := range templateFront {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "-") {
+			// Continuation of the previous key, e.g. a list item.
+			if !skipping {
+				front = append(front, line)
+			}
+			continue
+		}
+		key := normalizeKey(strings.SplitN(line, ":", 2)[0])
+		skipping = owned[key] || key == ""
+		if key == "tags" {
+			hasTags = true
+		}
+		if !skipping {
+			front = append(front, line)
+		}
+	}
+	if !hasTags {
+		front = append(front, "tags:", "  - 工作材料")
+	}
+
+	return fmt.Sprintf("---\n%s\n---\n\n%s", strings.Join(front, "\n"), strings.TrimLeft(body, "\n"))
+}
+
+// createTaskStructure creates the directories of tpl in folderPath.
+func createTaskStructure(folderPath string, tpl TaskTemplate) error {
+	for _, d := range tpl.Dirs {
+		rel, err := cleanTemplatePath(d)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(folderPath, rel), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTemplateFiles writes the seed files of tpl, keeping files that
+// already exist.
+func writeTemplateFiles(folderPath string, tpl TaskTemplate, vars map[string]string) error {
+	for _, f := range tpl.Files {
+		rel, err := cleanTemplatePath(f.Path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(folderPath, rel)
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, []byte(renderTemplateText(f.Content, vars)), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleGetTemplates(w http.ResponseWriter, r *http.Request) {
+	templatesMu.Lock()
+	templates, err := loadTaskTemplates()
+	templatesMu.Unlock()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("读取任务模板失败: %v", err))
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"data":      templates,
+		"builtin":   builtinTaskTemplate(),
+		"variables": templateVariables,
+	})
+}
+
+func handleSaveTemplates(w http.ResponseWriter, r *http.Request) {
+	var templates []TaskTemplate
+	if err := json.NewDecoder(r.Body).Decode(&templates); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, t := range templates {
+		if err := validateTaskTemplate(t); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if seen[t.ID] {
+			jsonError(w, http.StatusBadRequest, fmt.Sprintf("duplicate template id: %s", t.ID))
+			return
+		}
+		seen[t.ID] = true
+	}
+	if templates == nil {
+		templates = []TaskTemplate{}
+	}
+
+	templatesMu.Lock()
+	err := saveJSONFile(templatesFilePath(), templates)
+	templatesMu.Unlock()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("保存任务模板失败: %v", err))
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"success": true, "count": len(templates)})
+}
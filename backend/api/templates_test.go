@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func putTemplates(t *testing.T, router http.Handler, templates []TaskTemplate) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, _ := json.Marshal(templates)
+	req := httptest.NewRequest(http.MethodPut, "/api/templates", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRenderWorkRecord_MergesTemplateFrontmatter(t *testing.T) {
+	tpl := TaskTemplate{ID: "contract", WorkRecord: "---\ntitle: ignored\ncontract_no: C-{{hash}}\ntags:\n  - 合同\n---\n# 合同审查：{{title}}\n\n发件人：{{sender}}\n{{unknown}}\n"}
+	req := FolderRequest{Subject: "采购合同", FromAddr: "legal@example.com", Department: "法务部", Hash: "abc"}
+	vars := taskTemplateVars(req, "f", "/tmp/f", "manual", nil, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	record := renderWorkRecord(tpl, req, vars)
+	front, body, ok := splitFrontmatter(record)
+	if !ok {
+		t.Fatalf("expected frontmatter, got %q", record)
+	}
+	values := parseFrontmatterValues(front)
+	if values["title"] != "采购合同" || values["department"] != "法务部" || values["hash"] != "abc" || values["contractno"] != "C-abc" {
+		t.Fatalf("unexpected frontmatter: %q", front)
+	}
+	if strings.Contains(record, "工作材料") || !strings.Contains(record, "  - 合同") {
+		t.Fatalf("expected the template's tags, got %q", record)
+	}
+	if !strings.Contains(body, "# 合同审查：采购合同") || !strings.Contains(body, "发件人：legal@example.com") || !strings.Contains(body, "{{unknown}}") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestHandleCreateFolder_Template(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	workDir := t.TempDir()
+	router := SetupRoutes()
+
+	if rr := putTemplates(t, router, []TaskTemplate{{ID: "bad", Dirs: []string{"../outside"}}}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a path outside the task, got %d", rr.Code)
+	}
+	rr := putTemplates(t, router, []TaskTemplate{{
+		ID:          "contract",
+		Name:        "合同审查",
+		Departments: []string{"法务部"},
+		Dirs:        []string{"01_合同原件", "02_审查意见"},
+		Files:       []TemplateFile{{Path: "02_审查意见/审查要点.md", Content: "# {{title}} 审查要点\n部门：{{department}}\n"}},
+		WorkRecord:  "# 合同审查：{{title}}\n",
+	}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("save templates: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// The department picks the template.
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "采购合同", BasePath: workDir, FolderName: "contract", Department: "法务部", Source: "manual"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create task: %d %s", rr.Code, rr.Body.String())
+	}
+	taskDir := filepath.Join(workDir, "contract")
+	if _, err := os.Stat(filepath.Join(taskDir, "01_合同原件")); err != nil {
+		t.Fatalf("expected template directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(taskDir, taskProcessDirName)); !os.IsNotExist(err) {
+		t.Fatalf("expected no standard process directory, got %v", err)
+	}
+	seed, err := os.ReadFile(filepath.Join(taskDir, "02_审查意见", "审查要点.md"))
+	if err != nil || string(seed) != "# 采购合同 审查要点\n部门：法务部\n" {
+		t.Fatalf("unexpected seed file %q: %v", seed, err)
+	}
+	info, err := parseWorkRecord(filepath.Join(taskDir, workRecordFileName))
+	if err != nil || info.Department != "法务部" || !strings.Contains(info.RawContent, "# 合同审查：采购合同") {
+		t.Fatalf("unexpected work record %+v: %v", info, err)
+	}
+
+	// The request overrides the department's template.
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "例会", BasePath: workDir, FolderName: "meeting", Department: "法务部", Template: "default", Source: "manual"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create task: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "meeting", taskOutputDirName)); err != nil {
+		t.Fatalf("expected the standard skeleton: %v", err)
+	}
+
+	rr = postFolderCreate(t, router, FolderRequest{Subject: "x", BasePath: workDir, FolderName: "x", Template: "missing", Source: "manual"})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown template, got %d", rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "x")); !os.IsNotExist(err) {
+		t.Fatal("expected no folder for an unknown template")
+	}
+}
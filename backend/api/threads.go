@@ -0,0 +1,131 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"knot-backend/mail"
+)
+
+// MailThreadGroup is one conversation of the thread-grouped mail list.
+type MailThreadGroup struct {
+	mail.Thread
+	TaskStatus string         `json:"task_status"`
+	TaskPath   string         `json:"task_path,omitempty"`
+	Mails      []MailListItem `json:"mails"`
+}
+
+// mailThreads threads items, using the server's THREAD results when the
+// provider supports them. Must be called with mailMu held.
+func mailThreads(items []mail.MailItem) []mail.Thread {
+	var groups [][]string
+	if threader, ok := mailProvider.(mail.ServerThreader); ok {
+		ids := make([]string, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		g, supported, err := threader.ThreadGroups(ids)
+		if err != nil {
+			log.Printf("Server threading failed, threading locally: %v", err)
+		} else if supported {
+			groups = g
+		}
+	}
+	return mail.ThreadMails(items, groups)
+}
+
+func listItemTime(item MailListItem) time.Time {
+	return time.Unix(item.Timestamp, 0)
+}
+
+// linkThreadTasks sets the thread of every mail. Mails without a task that
+// arrived after a mail of the same thread got a working task are offered
+// that task as thread_task_path; with autoAppend they are appended to it as
+// follow-ups right away. Must be called with mailMu held.
+func linkThreadTasks(list []MailListItem, threads []mail.Thread, autoAppend bool) {
+	index := make(map[string]int, len(list))
+	for i := range list {
+		index[list[i].ID] = i
+	}
+
+	for _, thread := range threads {
+		taskPath := ""
+		var taskTime time.Time
+		for _, id := range thread.MailIDs {
+			i, ok := index[id]
+			if !ok {
+				continue
+			}
+			list[i].ThreadID = thread.ID
+			if list[i].TaskStatus == "working" && taskPath == "" {
+				// MailIDs are newest first: this is the latest mail with a task.
+				taskPath, taskTime = list[i].TaskPath, listItemTime(list[i])
+			}
+		}
+		if taskPath == "" {
+			continue
+		}
+
+		// Oldest first, so appended entries keep the conversation order.
+		for n := len(thread.MailIDs) - 1; n >= 0; n-- {
+			i, ok := index[thread.MailIDs[n]]
+			if !ok || list[i].TaskStatus != "none" || !listItemTime(list[i]).After(taskTime) {
+				continue
+			}
+			if !autoAppend {
+				list[i].ThreadTaskPath = taskPath
+				continue
+			}
+			item := list[i]
+			_, err := appendMailToTask(AppendMailRequest{
+				FolderPath:          taskPath,
+				MailID:              item.ID,
+				Subject:             item.Subject,
+				Date:                item.Date,
+				FromAddr:            item.From,
+				MessageID:           item.MessageID,
+				DownloadAttachments: true,
+			})
+			if err != nil {
+				log.Printf("Auto-append of mail %s to %s failed: %v", item.ID, taskPath, err)
+				list[i].ThreadTaskPath = taskPath
+				continue
+			}
+			list[i].TaskStatus = "working"
+			list[i].TaskPath = taskPath
+			list[i].AutoAppended = true
+		}
+	}
+}
+
+// groupByThread arranges the list by conversation, newest activity first.
+// Threads with no mail left in list are omitted.
+func groupByThread(list []MailListItem, threads []mail.Thread) []MailThreadGroup {
+	index := make(map[string]int, len(list))
+	for i := range list {
+		index[list[i].ID] = i
+	}
+
+	groups := make([]MailThreadGroup, 0, len(threads))
+	for _, thread := range threads {
+		group := MailThreadGroup{Thread: thread, TaskStatus: "none", Mails: []MailListItem{}}
+		for _, id := range thread.MailIDs {
+			i, ok := index[id]
+			if !ok {
+				continue
+			}
+			item := list[i]
+			group.Mails = append(group.Mails, item)
+			switch {
+			case item.TaskStatus == "working" && group.TaskStatus != "working":
+				group.TaskStatus, group.TaskPath = "working", item.TaskPath
+			case item.TaskStatus == "archived" && group.TaskStatus == "none":
+				group.TaskStatus, group.TaskPath = "archived", item.TaskPath
+			}
+		}
+		if len(group.Mails) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
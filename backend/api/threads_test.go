@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func threadTestEML(messageID, inReplyTo, subject, date string) string {
+	eml := "From: Zhang San <zhangsan@example.com>\r\n" +
+		"To: team@example.com\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + date + "\r\n" +
+		"Message-ID: <" + messageID + ">\r\n"
+	if inReplyTo != "" {
+		eml += "In-Reply-To: <" + inReplyTo + ">\r\nReferences: <" + inReplyTo + ">\r\n"
+	}
+	return eml + "\r\nbody of " + messageID + "\r\n"
+}
+
+func TestHandleMailList_Threads(t *testing.T) {
+	t.Setenv("KNOT_DATA_DIR", t.TempDir())
+	mailDir := t.TempDir()
+	workDir := t.TempDir()
+	files := map[string]string{
+		"orig.eml":  threadTestEML("m1@example.com", "", "Budget", "Mon, 20 Apr 2026 09:00:00 +0800"),
+		"reply.eml": threadTestEML("m2@example.com", "m1@example.com", "Re: Budget", "Tue, 21 Apr 2026 09:00:00 +0800"),
+		"other.eml": threadTestEML("m3@example.com", "", "Travel", "Wed, 22 Apr 2026 09:00:00 +0800"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(mailDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := SetupRoutes()
+	raw, _ := json.Marshal(MailConfig{Provider: "maildir", Path: mailDir})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/mail/connect", bytes.NewReader(raw)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("connect failed: %d %s", rr.Code, rr.Body.String())
+	}
+	t.Cleanup(func() { mailProvider = nil })
+
+	fetch := func(method string, extra url.Values) []byte {
+		q := url.Values{"days": {"0"}, "scan_path": {workDir}}
+		for k, v := range extra {
+			q[k] = v
+		}
+		rr := httptest.NewRecorder()
+		path := "/api/mail/list?"
+		if method == http.MethodPost {
+			path = "/api/mail/sync?"
+		}
+		router.ServeHTTP(rr, httptest.NewRequest(method, path+q.Encode(), nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list failed: %d %s", rr.Code, rr.Body.String())
+		}
+		return rr.Body.Bytes()
+	}
+	list := func(extra url.Values) []byte { return fetch(http.MethodGet, extra) }
+	items := func(body []byte) map[string]MailListItem {
+		var resp struct {
+			Data []MailListItem `json:"data"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		bySubject := map[string]MailListItem{}
+		for _, item := range resp.Data {
+			bySubject[item.Subject] = item
+		}
+		return bySubject
+	}
+
+	first := items(list(nil))
+	rr = postFolderCreate(t, router, FolderRequest{
+		MailID:     first["Budget"].ID,
+		Subject:    "Budget",
+		BasePath:   workDir,
+		FolderName: "2026.04.20_Budget",
+		Source:     "email",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create task failed: %d %s", rr.Code, rr.Body.String())
+	}
+	taskPath := filepath.Join(workDir, "2026.04.20_Budget")
+
+	offered := items(list(nil))
+	if offered["Re: Budget"].ThreadID == "" || offered["Re: Budget"].ThreadID != offered["Budget"].ThreadID {
+		t.Fatalf("reply not threaded with the original: %+v", offered)
+	}
+	if offered["Re: Budget"].ThreadTaskPath != taskPath || offered["Travel"].ThreadTaskPath != "" {
+		t.Fatalf("unexpected thread task offers: %+v", offered)
+	}
+
+	var grouped struct {
+		Data []MailThreadGroup `json:"data"`
+	}
+	_ = json.Unmarshal(list(url.Values{"group_by": {"thread"}}), &grouped)
+	if len(grouped.Data) != 2 {
+		t.Fatalf("expected 2 threads, got %+v", grouped.Data)
+	}
+	budget := grouped.Data[1]
+	if len(budget.Mails) != 2 || budget.TaskStatus != "working" || budget.TaskPath != taskPath {
+		t.Fatalf("unexpected budget thread: %+v", budget)
+	}
+
+	// The list only reads, whatever the query says.
+	if offered = items(list(url.Values{"auto_append": {"true"}})); offered["Re: Budget"].AutoAppended || offered["Re: Budget"].ThreadTaskPath != taskPath {
+		t.Fatalf("expected the list not to append: %+v", offered["Re: Budget"])
+	}
+	if wr, _ := os.ReadFile(filepath.Join(taskPath, workRecordFileName)); strings.Contains(string(wr), "追加邮件") {
+		t.Fatalf("expected no follow-up entry from the list:\n%s", wr)
+	}
+
+	appended := items(fetch(http.MethodPost, url.Values{"auto_append": {"true"}}))
+	if !appended["Re: Budget"].AutoAppended || appended["Re: Budget"].TaskStatus != "working" {
+		t.Fatalf("reply not auto-appended: %+v", appended["Re: Budget"])
+	}
+	wr, _ := os.ReadFile(filepath.Join(taskPath, workRecordFileName))
+	if strings.Count(string(wr), "追加邮件「Re: Budget」") != 1 {
+		t.Fatalf("expected one follow-up entry:\n%s", wr)
+	}
+
+	// The appended reply now resolves to the task and is not appended again.
+	again := items(fetch(http.MethodPost, url.Values{"auto_append": {"true"}}))
+	if again["Re: Budget"].AutoAppended || again["Re: Budget"].TaskPath != taskPath {
+		t.Fatalf("unexpected state after append: %+v", again["Re: Budget"])
+	}
+}
@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxAttachmentMB = 100
+	// maxAttachmentNameBytes leaves room for a " (N)" suffix below the 255
+	// byte limit of common file systems.
+	maxAttachmentNameBytes = 200
+	maxAttachmentCopies    = 999
+)
+
+// ErrAttachmentTooLarge is returned for a file above MaxAttachmentBytes.
+var ErrAttachmentTooLarge = errors.New("attachment too large")
+
+// MaxAttachmentBytes is the largest file saved into a task folder, whether
+// it is a mail attachment or an uploaded source file.
+// KNOT_MAX_ATTACHMENT_MB overrides the default.
+func MaxAttachmentBytes() int64 {
+	mb := int64(defaultMaxAttachmentMB)
+	if v, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("KNOT_MAX_ATTACHMENT_MB")), 10, 64); err == nil && v > 0 {
+		mb = v
+	}
+	return mb << 20
+}
+
+// AttachmentFileName is the name a file is saved under: path separators,
+// characters Windows does not allow and control characters become "_",
+// surrounding dots and spaces are dropped and long names are shortened
+// keeping the extension.
+func AttachmentFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "attachment"
+	}
+	if len(name) > maxAttachmentNameBytes {
+		ext := filepath.Ext(name)
+		if len(ext) > maxAttachmentNameBytes/2 {
+			ext = ""
+		}
+		base := name[:maxAttachmentNameBytes-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = strings.TrimRight(base, ". ") + ext
+	}
+	return name
+}
+
+// SaveAttachment writes r into dir under the cleaned name, as "name (2).ext"
+// when the name is taken, and returns the name used. A file larger than
+// MaxAttachmentBytes is not kept and ErrAttachmentTooLarge returned.
+func SaveAttachment(dir, name string, r io.Reader) (string, error) {
+	name = AttachmentFileName(name)
+	f, saved, err := createUniqueFile(dir, name)
+	if err != nil {
+		return "", err
+	}
+	limit := MaxAttachmentBytes()
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("%s: %w (over %d MB)", name, ErrAttachmentTooLarge, limit>>20)
+	}
+	if err != nil {
+		os.Remove(filepath.Join(dir, saved))
+		return "", err
+	}
+	return saved, nil
+}
+
+func createUniqueFile(dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			return f, candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) || n > maxAttachmentCopies {
+			return nil, "", err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+}
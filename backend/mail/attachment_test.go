@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAttachmentFileName(t *testing.T) {
+	cases := map[string]string{
+		"../报告.pdf":       "_报告.pdf",
+		"a/b\\c:d?.txt":   "a_b_c_d_.txt",
+		" .hidden. ":      "hidden",
+		"line\nbreak.doc": "line_break.doc",
+		"":                "attachment",
+		"..":              "attachment",
+		"季度预算（终版）.xlsx":   "季度预算（终版）.xlsx",
+	}
+	for in, want := range cases {
+		if got := AttachmentFileName(in); got != want {
+			t.Errorf("AttachmentFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	long := AttachmentFileName(strings.Repeat("预算", 100) + ".xlsx")
+	if len(long) > maxAttachmentNameBytes || !utf8.ValidString(long) || !strings.HasSuffix(long, ".xlsx") {
+		t.Fatalf("unexpected long name %q (%d bytes)", long, len(long))
+	}
+}
+
+func TestSaveAttachment(t *testing.T) {
+	t.Setenv("KNOT_MAX_ATTACHMENT_MB", "1")
+	dir := t.TempDir()
+
+	for _, want := range []string{"plan.txt", "plan (2).txt"} {
+		got, err := SaveAttachment(dir, "plan.txt", strings.NewReader("x"))
+		if err != nil || got != want {
+			t.Fatalf("expected %s, got %q: %v", want, got, err)
+		}
+	}
+
+	big := strings.NewReader(strings.Repeat("x", 1<<20+1))
+	if _, err := SaveAttachment(dir, "big.bin", big); !errors.Is(err, ErrAttachmentTooLarge) {
+		t.Fatalf("expected ErrAttachmentTooLarge, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the oversized file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheKey names a message on an IMAP server. A UID is only stable within
+// one UIDVALIDITY of a mailbox, so all four parts are needed.
+type CacheKey struct {
+	Account     string
+	Mailbox     string
+	UIDValidity uint32
+	UID         uint32
+}
+
+func (k CacheKey) valid() bool {
+	return k.UIDValidity != 0 && k.UID != 0
+}
+
+func (k CacheKey) hash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d", k.Account, k.Mailbox, k.UIDValidity, k.UID)))
+	return hex.EncodeToString(sum[:])
+}
+
+// MessageCache keeps raw fetched messages on disk so a message is
+// downloaded once, no matter how often its detail is shown or its
+// attachments are saved. Bodies are stored under the SHA-256 of their
+// content in objects/, and keys/ maps every CacheKey to a body. When the
+// bodies grow past maxBytes the least recently used ones are evicted.
+type MessageCache struct {
+	dir      string
+	maxBytes int64
+
+	mu     sync.Mutex
+	used   int64
+	loaded bool
+}
+
+func NewMessageCache(dir string, maxBytes int64) *MessageCache {
+	return &MessageCache{dir: dir, maxBytes: maxBytes}
+}
+
+func (c *MessageCache) keyPath(key CacheKey) string {
+	return filepath.Join(c.dir, "keys", key.hash())
+}
+
+func (c *MessageCache) objectPath(sum string) string {
+	return filepath.Join(c.dir, "objects", sum[:2], sum)
+}
+
+// Get returns the cached raw message of key. A body that no longer matches
+// its hash is dropped and reported as a miss.
+func (c *MessageCache) Get(key CacheKey) ([]byte, bool) {
+	if c == nil || !key.valid() {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ref, err := os.ReadFile(c.keyPath(key))
+	if err != nil {
+		return nil, false
+	}
+	sum := strings.TrimSpace(string(ref))
+	if len(sum) != sha256.Size*2 {
+		_ = os.Remove(c.keyPath(key))
+		return nil, false
+	}
+	path := c.objectPath(sum)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		_ = os.Remove(c.keyPath(key))
+		return nil, false
+	}
+	if got := sha256.Sum256(raw); hex.EncodeToString(got[:]) != sum {
+		c.removeObject(path, int64(len(raw)))
+		_ = os.Remove(c.keyPath(key))
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return raw, true
+}
+
+// Put stores raw as the message of key and evicts old bodies if the cache
+// has grown too large. Messages larger than the whole cache are not kept.
+func (c *MessageCache) Put(key CacheKey, raw []byte) error {
+	if c == nil || !key.valid() || len(raw) == 0 {
+		return nil
+	}
+	if c.maxBytes > 0 && int64(len(raw)) > c.maxBytes {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded {
+		c.used = c.objectsSize()
+		c.loaded = true
+	}
+
+	sum := sha256.Sum256(raw)
+	hash := hex.EncodeToString(sum[:])
+	path := c.objectPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+	} else {
+		if err := writeFileAtomic(path, raw); err != nil {
+			return err
+		}
+		c.used += int64(len(raw))
+	}
+	if err := writeFileAtomic(c.keyPath(key), []byte(hash)); err != nil {
+		return err
+	}
+	c.evict(path)
+	return nil
+}
+
+// Size is the number of bytes of message bodies in the cache.
+func (c *MessageCache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.objectsSize()
+}
+
+type cacheObject struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *MessageCache) objects() []cacheObject {
+	var objects []cacheObject
+	_ = filepath.Walk(filepath.Join(c.dir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		objects = append(objects, cacheObject{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return objects
+}
+
+func (c *MessageCache) objectsSize() int64 {
+	var total int64
+	for _, o := range c.objects() {
+		total += o.size
+	}
+	return total
+}
+
+func (c *MessageCache) removeObject(path string, size int64) {
+	if err := os.Remove(path); err == nil {
+		c.used -= size
+	}
+}
+
+// evict removes the least recently used bodies until the cache fits in
+// maxBytes, then drops the keys left pointing at removed bodies. keep is
+// the body just stored.
+func (c *MessageCache) evict(keep string) {
+	if c.maxBytes <= 0 || c.used <= c.maxBytes {
+		return
+	}
+	objects := c.objects()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].modTime.Before(objects[j].modTime)
+	})
+	c.used = 0
+	for _, o := range objects {
+		c.used += o.size
+	}
+	for _, o := range objects {
+		if c.used <= c.maxBytes {
+			break
+		}
+		if o.path == keep {
+			continue
+		}
+		c.removeObject(o.path, o.size)
+	}
+
+	keysDir := filepath.Join(c.dir, "keys")
+	entries, err := os.ReadDir(keysDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		ref, err := os.ReadFile(filepath.Join(keysDir, e.Name()))
+		sum := strings.TrimSpace(string(ref))
+		if err != nil || len(sum) != sha256.Size*2 {
+			continue
+		}
+		if _, err := os.Stat(c.objectPath(sum)); os.IsNotExist(err) {
+			_ = os.Remove(filepath.Join(keysDir, e.Name()))
+		}
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package mail
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestMessageCache_GetPut(t *testing.T) {
+	c := NewMessageCache(t.TempDir(), 1<<20)
+	key := CacheKey{Account: "user@imap.example.com:993", Mailbox: "INBOX", UIDValidity: 7, UID: 42}
+
+	if _, ok := c.Get(key); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+	if err := c.Put(key, []byte(sampleEML)); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	raw, ok := c.Get(key)
+	if !ok || string(raw) != sampleEML {
+		t.Fatalf("expected hit with stored message, got %v %q", ok, raw)
+	}
+
+	// A new UIDVALIDITY means the UID may name another message.
+	other := key
+	other.UIDValidity = 8
+	if _, ok := c.Get(other); ok {
+		t.Fatal("expected miss for another UIDVALIDITY")
+	}
+	if _, ok := c.Get(CacheKey{Account: key.Account, Mailbox: key.Mailbox, UID: 42}); ok {
+		t.Fatal("expected miss without UIDVALIDITY")
+	}
+}
+
+func TestMessageCache_SharesIdenticalBodies(t *testing.T) {
+	dir := t.TempDir()
+	c := NewMessageCache(dir, 1<<20)
+	a := CacheKey{Account: "a", Mailbox: "INBOX", UIDValidity: 1, UID: 1}
+	b := CacheKey{Account: "a", Mailbox: "Archive", UIDValidity: 3, UID: 9}
+	_ = c.Put(a, []byte(sampleEML))
+	_ = c.Put(b, []byte(sampleEML))
+
+	if got := c.Size(); got != int64(len(sampleEML)) {
+		t.Fatalf("expected one stored body of %d bytes, got %d", len(sampleEML), got)
+	}
+	if _, ok := c.Get(b); !ok {
+		t.Fatal("expected hit for second key")
+	}
+}
+
+func TestMessageCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewMessageCache(t.TempDir(), 250)
+	keys := []CacheKey{
+		{Account: "a", Mailbox: "INBOX", UIDValidity: 1, UID: 1},
+		{Account: "a", Mailbox: "INBOX", UIDValidity: 1, UID: 2},
+		{Account: "a", Mailbox: "INBOX", UIDValidity: 1, UID: 3},
+	}
+	for i, key := range keys[:2] {
+		if err := c.Put(key, bytes.Repeat([]byte{byte('a' + i)}, 100)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	// Use the first message so the second one is the oldest.
+	past := time.Now().Add(-time.Hour)
+	_ = os.Chtimes(c.objectPath(mustRef(t, c, keys[1])), past, past)
+	if _, ok := c.Get(keys[0]); !ok {
+		t.Fatal("expected hit for first message")
+	}
+
+	if err := c.Put(keys[2], bytes.Repeat([]byte{'c'}, 100)); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if got := c.Size(); got > 250 {
+		t.Fatalf("expected cache within limit, got %d bytes", got)
+	}
+	if _, ok := c.Get(keys[1]); ok {
+		t.Fatal("expected least recently used message to be evicted")
+	}
+	if _, err := os.Stat(c.keyPath(keys[1])); !os.IsNotExist(err) {
+		t.Fatal("expected key of evicted message to be removed")
+	}
+	for _, key := range []CacheKey{keys[0], keys[2]} {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("expected %d to stay cached", key.UID)
+		}
+	}
+
+	if err := c.Put(CacheKey{Account: "a", Mailbox: "INBOX", UIDValidity: 1, UID: 4}, bytes.Repeat([]byte{'d'}, 300)); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if _, ok := c.Get(CacheKey{Account: "a", Mailbox: "INBOX", UIDValidity: 1, UID: 4}); ok {
+		t.Fatal("expected message larger than the cache not to be kept")
+	}
+}
+
+func TestMessageCache_DropsCorruptBody(t *testing.T) {
+	c := NewMessageCache(t.TempDir(), 1<<20)
+	key := CacheKey{Account: "a", Mailbox: "INBOX", UIDValidity: 1, UID: 1}
+	_ = c.Put(key, []byte(sampleEML))
+	if err := os.WriteFile(c.objectPath(mustRef(t, c, key)), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(key); ok {
+		t.Fatal("expected corrupt body to be a miss")
+	}
+}
+
+func mustRef(t *testing.T, c *MessageCache, key CacheKey) string {
+	t.Helper()
+	ref, err := os.ReadFile(c.keyPath(key))
+	if err != nil {
+		t.Fatalf("key not stored: %v", err)
+	}
+	return strings.TrimSpace(string(ref))
+}
+
+func TestMailClient_ServesRepeatFetchesFromCache(t *testing.T) {
+	be := memory.New()
+	srv := server.New(be)
+	srv.AllowInsecureAuth = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	c := NewMailClient(addr.IP.String(), addr.Port, "username", "password", false)
+	cacheDir := t.TempDir()
+	c.SetCache(NewMessageCache(cacheDir, 1<<20))
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	detail, err := c.FetchMailDetail("6")
+	if err != nil {
+		t.Fatalf("detail failed: %v", err)
+	}
+	if body, _ := detail["body"].(string); !strings.Contains(body, "Hi there") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+
+	// Remove the message on the server: later fetches can only succeed from
+	// the cache.
+	user, _ := be.Login(nil, "username", "password")
+	mbox, _ := user.GetMailbox("INBOX")
+	mbox.(*memory.Mailbox).Messages = nil
+
+	again, err := c.FetchMailDetail("6")
+	if err != nil {
+		t.Fatalf("cached detail failed: %v", err)
+	}
+	if again["body"] != detail["body"] {
+		t.Fatalf("cached body differs: %q vs %q", again["body"], detail["body"])
+	}
+	if _, err := c.DownloadAttachments("6", t.TempDir()); err != nil {
+		t.Fatalf("cached download failed: %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(cacheDir, "keys"))
+	if len(entries) != 1 {
+		t.Fatalf("expected one cached key, got %d", len(entries))
+	}
+}
@@ -0,0 +1,243 @@
+package mail
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CalendarEvent is one VEVENT of a text/calendar part, as sent with meeting
+// invitations and deadline reminders.
+type CalendarEvent struct {
+	UID         string    `json:"uid"`
+	Summary     string    `json:"summary"`
+	Description string    `json:"description"`
+	Location    string    `json:"location"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// AllDay is set for DATE-valued events; Start and End are then local
+	// midnights.
+	AllDay    bool    `json:"all_day"`
+	Organizer Address `json:"organizer"`
+	RRule     string  `json:"rrule"`
+	Status    string  `json:"status"`
+	// Method is the calendar's METHOD (REQUEST, CANCEL, ...).
+	Method string `json:"method"`
+}
+
+// Cancelled reports whether the event was called off.
+func (e CalendarEvent) Cancelled() bool {
+	return strings.EqualFold(e.Status, "CANCELLED") || strings.EqualFold(e.Method, "CANCEL")
+}
+
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfoldICalendar joins continuation lines (RFC 5545 section 3.1).
+func unfoldICalendar(data string) []string {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func parseICalendarLine(line string) (icalProperty, bool) {
+	// The value starts at the first colon outside a quoted parameter value.
+	colon, quoted := -1, false
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icalProperty{}, false
+	}
+
+	segments := splitUnquoted(line[:colon], ';')
+	prop := icalProperty{
+		name:   strings.ToUpper(strings.TrimSpace(segments[0])),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, seg := range segments[1:] {
+		if k, v, ok := strings.Cut(seg, "="); ok {
+			prop.params[strings.ToUpper(strings.TrimSpace(k))] = strings.Trim(v, `"`)
+		}
+	}
+	return prop, true
+}
+
+func splitUnquoted(s string, sep rune) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == sep && !quoted {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var icalTextReplacer = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+// parseICalendarTime reads a DATE or DATE-TIME value. UTC values end in Z,
+// TZID values use that zone when it is known and floating times are local.
+func parseICalendarTime(prop icalProperty) (time.Time, bool, bool) {
+	value := strings.TrimSpace(prop.value)
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err == nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err == nil
+	}
+	loc := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err == nil
+}
+
+var icalDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+func parseICalendarDuration(value string) (time.Duration, bool) {
+	m := icalDurationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return 0, false
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if n, err := strconv.Atoi(m[i+2]); err == nil {
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, true
+}
+
+// ParseICalendar returns the VEVENTs of an iCalendar object. Properties of
+// nested components such as VALARM are ignored. Events without a start are
+// dropped.
+func ParseICalendar(data []byte) []CalendarEvent {
+	var events []CalendarEvent
+	var current *CalendarEvent
+	var duration time.Duration
+	hasDuration := false
+	method := ""
+	depth := 0 // nesting below the current VEVENT
+
+	for _, line := range unfoldICalendar(string(data)) {
+		prop, ok := parseICalendarLine(line)
+		if !ok {
+			continue
+		}
+		value := strings.ToUpper(strings.TrimSpace(prop.value))
+		switch {
+		case prop.name == "BEGIN" && value == "VEVENT" && current == nil:
+			current = &CalendarEvent{}
+			hasDuration, depth = false, 0
+			continue
+		case prop.name == "BEGIN" && current != nil:
+			depth++
+			continue
+		case prop.name == "END" && current != nil && depth > 0:
+			depth--
+			continue
+		case prop.name == "END" && value == "VEVENT" && current != nil:
+			if current.End.IsZero() && !current.Start.IsZero() {
+				switch {
+				case hasDuration:
+					current.End = current.Start.Add(duration)
+				case current.AllDay:
+					current.End = current.Start.AddDate(0, 0, 1)
+				default:
+					current.End = current.Start
+				}
+			}
+			if !current.Start.IsZero() {
+				events = append(events, *current)
+			}
+			current = nil
+			continue
+		}
+
+		if current == nil {
+			if prop.name == "METHOD" {
+				method = value
+			}
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+
+		text := icalTextReplacer.Replace(prop.value)
+		switch prop.name {
+		case "UID":
+			current.UID = text
+		case "SUMMARY":
+			current.Summary = text
+		case "DESCRIPTION":
+			current.Description = text
+		case "LOCATION":
+			current.Location = text
+		case "STATUS":
+			current.Status = value
+		case "RRULE":
+			current.RRule = strings.TrimSpace(prop.value)
+		case "ORGANIZER":
+			addr := strings.TrimSpace(prop.value)
+			if len(addr) >= 7 && strings.EqualFold(addr[:7], "mailto:") {
+				addr = addr[7:]
+			}
+			current.Organizer = Address{Name: prop.params["CN"], Address: addr}
+		case "DTSTART":
+			if t, allDay, ok := parseICalendarTime(prop); ok {
+				current.Start, current.AllDay = t, allDay
+			}
+		case "DTEND", "DUE":
+			if t, _, ok := parseICalendarTime(prop); ok {
+				current.End = t
+			}
+		case "DURATION":
+			duration, hasDuration = parseICalendarDuration(prop.value)
+		}
+	}
+
+	for i := range events {
+		events[i].Method = method
+	}
+	return events
+}
+
+// isCalendarPart reports whether a part carries iCalendar data.
+func isCalendarPart(contentType, filename string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "text/calendar") ||
+		strings.HasPrefix(strings.ToLower(contentType), "application/ics") ||
+		strings.HasSuffix(strings.ToLower(filename), ".ics")
+}
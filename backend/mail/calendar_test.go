@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleInvite = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Asia/Shanghai\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19700101T000000\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meet-1@example.com\r\n" +
+	"SUMMARY:预算评审会\\, 第二轮\r\n" +
+	"DTSTART;TZID=Asia/Shanghai:20260425T140000\r\n" +
+	"DURATION:PT1H30M\r\n" +
+	"LOCATION:3楼会议室\r\n" +
+	"ORGANIZER;CN=\"Zhang, San\":mailto:zhangsan@example.com\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=3\r\n" +
+	"DESCRIPTION:请提前准备材料\\n带上电脑\r\n" +
+	" 。\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"DESCRIPTION:reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:提交截止\r\n" +
+	"DTSTART;VALUE=DATE:20260430\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalendar(t *testing.T) {
+	events := ParseICalendar([]byte(sampleInvite))
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+
+	meeting := events[0]
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	wantStart := time.Date(2026, 4, 25, 14, 0, 0, 0, shanghai)
+	if !meeting.Start.Equal(wantStart) || !meeting.End.Equal(wantStart.Add(90*time.Minute)) || meeting.AllDay {
+		t.Fatalf("unexpected meeting time: %v - %v", meeting.Start, meeting.End)
+	}
+	if meeting.Summary != "预算评审会, 第二轮" || meeting.Location != "3楼会议室" || meeting.RRule != "FREQ=WEEKLY;COUNT=3" {
+		t.Fatalf("unexpected meeting: %+v", meeting)
+	}
+	if meeting.Organizer != (Address{Name: "Zhang, San", Address: "zhangsan@example.com"}) {
+		t.Fatalf("unexpected organizer: %+v", meeting.Organizer)
+	}
+	if meeting.Description != "请提前准备材料\n带上电脑。" || meeting.Method != "REQUEST" || meeting.Cancelled() {
+		t.Fatalf("unexpected description or method: %+v", meeting)
+	}
+
+	deadline := events[1]
+	if !deadline.AllDay || deadline.Start.Format("2006-01-02") != "2026-04-30" || !deadline.End.Equal(deadline.Start.AddDate(0, 0, 1)) {
+		t.Fatalf("unexpected all-day event: %+v", deadline)
+	}
+}
+
+func TestParseMessageDetail_CalendarPart(t *testing.T) {
+	eml := "From: Zhang San <zhangsan@example.com>\r\n" +
+		"Subject: Invitation\r\n" +
+		"Date: Mon, 20 Apr 2026 10:00:00 +0800\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"see invite\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/calendar; charset=utf-8; method=REQUEST\r\n" +
+		"\r\n" +
+		sampleInvite +
+		"--b1--\r\n"
+
+	detail, err := parseMessageDetail(strings.NewReader(eml), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ := detail["events"].([]CalendarEvent)
+	if len(events) != 2 || events[0].UID != "meet-1@example.com" {
+		t.Fatalf("unexpected events: %+v", detail["events"])
+	}
+}
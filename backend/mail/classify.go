@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Mail categories returned in MailItem.Category.
+const (
+	CategoryPersonal     = "personal"
+	CategoryBulk         = "bulk"
+	CategoryAutomated    = "automated"
+	CategoryNotification = "notification"
+)
+
+// Categories lists every mail category.
+var Categories = []string{CategoryPersonal, CategoryBulk, CategoryAutomated, CategoryNotification}
+
+// classifyHeaderFields are the headers ClassifyMail looks at. The IMAP client
+// fetches just these for the list.
+var classifyHeaderFields = []string{
+	"List-Unsubscribe", "List-Id", "List-Post", "Precedence", "Auto-Submitted",
+	"X-Autoreply", "X-Autorespond", "X-Campaign", "X-Mailchimp-Campaign", "Feedback-ID",
+}
+
+var (
+	automatedSenderPattern    = regexp.MustCompile(`(?i)^(mailer-daemon|postmaster|auto-?reply|autoresponder)@`)
+	notificationSenderPattern = regexp.MustCompile(`(?i)^(no-?reply|do-?not-?reply|donotreply|notifications?|notify|alerts?|system|monitor(ing)?|bounces?|info|news(letter)?|service|support)([._+-][^@]*)?@`)
+	autoReplySubjectPattern   = regexp.MustCompile(`(?i)^\s*(自动回复|自动答复|automatic reply|auto[- ]?reply|out of (the )?office|undeliverable|delivery status notification|退信|系统退信)`)
+)
+
+type headerGetter interface {
+	Get(key string) string
+}
+
+// ClassifyMail sorts a mail into one of the Categories from its headers,
+// sender address and subject. Auto-replies and bounces are automated,
+// mailing-list and campaign mail is bulk, mail from no-reply style system
+// senders is a notification and everything else is personal.
+func ClassifyMail(h headerGetter, fromAddr, subject string) string {
+	get := func(key string) string {
+		if h == nil {
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(h.Get(key)))
+	}
+
+	if v := get("Auto-Submitted"); v != "" && v != "no" {
+		return CategoryAutomated
+	}
+	if get("X-Autoreply") != "" || get("X-Autorespond") != "" || get("Precedence") == "auto_reply" ||
+		automatedSenderPattern.MatchString(fromAddr) || autoReplySubjectPattern.MatchString(subject) {
+		return CategoryAutomated
+	}
+
+	switch get("Precedence") {
+	case "bulk", "list", "junk":
+		return CategoryBulk
+	}
+	for _, key := range []string{"List-Unsubscribe", "List-Id", "List-Post", "X-Campaign", "X-Mailchimp-Campaign"} {
+		if get(key) != "" {
+			return CategoryBulk
+		}
+	}
+
+	if notificationSenderPattern.MatchString(fromAddr) || get("Feedback-ID") != "" {
+		return CategoryNotification
+	}
+	return CategoryPersonal
+}
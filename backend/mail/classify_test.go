@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+func TestClassifyMail(t *testing.T) {
+	header := func(kv ...string) *textproto.Header {
+		var h textproto.Header
+		for i := 0; i+1 < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return &h
+	}
+	cases := []struct {
+		name     string
+		h        *textproto.Header
+		from     string
+		subject  string
+		category string
+	}{
+		{"colleague", header(), "zhangsan@example.com", "预算", CategoryPersonal},
+		{"auto-submitted", header("Auto-Submitted", "auto-replied"), "lisi@example.com", "Re: 预算", CategoryAutomated},
+		{"auto-submitted no", header("Auto-Submitted", "no"), "lisi@example.com", "预算", CategoryPersonal},
+		{"out of office", header(), "lisi@example.com", "自动回复: 预算", CategoryAutomated},
+		{"bounce", header(), "MAILER-DAEMON@example.com", "failure notice", CategoryAutomated},
+		{"newsletter", header("List-Unsubscribe", "<mailto:u@example.com>"), "news@example.com", "Weekly", CategoryBulk},
+		{"precedence bulk", header("Precedence", "bulk"), "hr@example.com", "通知", CategoryBulk},
+		{"no-reply sender", header(), "no-reply@github.com", "[repo] build failed", CategoryNotification},
+		{"notifications sender", header(), "notifications.oa@example.com", "审批提醒", CategoryNotification},
+	}
+	for _, c := range cases {
+		if got := ClassifyMail(c.h, c.from, c.subject); got != c.category {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.category)
+		}
+	}
+	if got := ClassifyMail(nil, "zhangsan@example.com", "hi"); got != CategoryPersonal {
+		t.Errorf("nil header: got %s", got)
+	}
+}
+
+func TestMaildirProvider_Category(t *testing.T) {
+	root := t.TempDir()
+	newsletter := "From: News <news@example.com>\r\n" +
+		"Subject: Weekly\r\n" +
+		"Date: Mon, 20 Apr 2026 10:00:00 +0800\r\n" +
+		"List-Unsubscribe: <https://example.com/u>\r\n" +
+		"\r\n" +
+		"hello\r\n"
+	_ = os.WriteFile(filepath.Join(root, "a.eml"), []byte(sampleEML), 0o644)
+	_ = os.WriteFile(filepath.Join(root, "b.eml"), []byte(newsletter), 0o644)
+
+	p := NewMaildirProvider(root)
+	_ = p.Connect()
+	items, err := p.FetchMailList(10, 0)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("list: %v %v", items, err)
+	}
+	got := map[string]string{}
+	for _, item := range items {
+		got[item.Subject] = item.Category
+	}
+	if got["测试"] != CategoryPersonal || got["Weekly"] != CategoryBulk {
+		t.Fatalf("unexpected categories: %v", got)
+	}
+}
@@ -1,7 +1,16 @@
 package mail
 
 import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
 	"testing"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+
+	"knot-backend/proxy"
 )
 
 func TestNewMailClient(t *testing.T) {
@@ -119,3 +128,57 @@ func TestDecodeRFC2047_InvalidEncoding(t *testing.T) {
 		t.Error("did not expect empty string for invalid encoding")
 	}
 }
+
+func TestMailClient_ConnectThroughProxy(t *testing.T) {
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	proxyLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyLn.Close()
+	tunneled := make(chan string, 1)
+	go func() {
+		conn, err := proxyLn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		tunneled <- req.Host
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			return
+		}
+		defer target.Close()
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+		go io.Copy(target, br)
+		io.Copy(conn, target)
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	c := NewMailClient(addr.IP.String(), addr.Port, "username", "password", false)
+	c.SetProxy(proxy.Config{URL: "http://" + proxyLn.Addr().String()})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect through proxy failed: %v", err)
+	}
+	defer c.Disconnect()
+	if host := <-tunneled; host != ln.Addr().String() {
+		t.Fatalf("expected tunnel to %s, got %s", ln.Addr(), host)
+	}
+	items, err := c.FetchMailList(10, 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("unexpected list through proxy: %v %v", items, err)
+	}
+}
@@ -0,0 +1,576 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// A minimal reader of CMS (PKCS #7) as used by S/MIME: SignedData
+// verification and EnvelopedData decryption with an RSA key.
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAttrContentType      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAESOAEP            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidECPublicKey          = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidEd25519              = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidDESEDE3CBC           = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1WithRSA          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA1        = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidECDSAWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidDigestSHA1           = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	errCMSUnsupported       = errors.New("unsupported CMS content")
+	errNoMatchingRecipient  = errors.New("no recipient matches the key")
+	errCMSSignatureMismatch = errors.New("signature does not match the content")
+)
+
+// cmsContentInfo is a ContentInfo or EncapsulatedContentInfo. Content is
+// the [0] EXPLICIT wrapper; its Bytes hold the content element.
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      cmsContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type cmsEnvelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo cmsEncryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsKeyTransRecipient struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type cmsEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// cmsSignature is the outcome of checking one SignedData.
+type cmsSignature struct {
+	Signer     *x509.Certificate
+	SigningAt  time.Time
+	Content    []byte // encapsulated content, empty for detached signatures
+	Chain      []*x509.Certificate
+	Err        error // the signature itself does not hold
+	TrustError error // the signature holds but the signer is not trusted
+}
+
+// parseContentInfo decodes a BER or DER ContentInfo.
+func parseContentInfo(der []byte) (cmsContentInfo, error) {
+	var info cmsContentInfo
+	der, err := berToDER(der)
+	if err != nil {
+		return info, err
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return info, fmt.Errorf("parse CMS: %w", err)
+	}
+	return info, nil
+}
+
+// verifySignedData checks a SignedData against content (nil when the
+// content is encapsulated) and the signer against roots.
+func verifySignedData(der []byte, content []byte, roots *x509.CertPool) (*cmsSignature, error) {
+	info, err := parseContentInfo(der)
+	if err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, errCMSUnsupported
+	}
+	var sd cmsSignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("parse SignedData: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("SignedData has no signer")
+	}
+
+	result := &cmsSignature{}
+	if len(sd.ContentInfo.Content.Bytes) > 0 {
+		var octets asn1.RawValue
+		if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &octets); err != nil {
+			return nil, fmt.Errorf("parse content: %w", err)
+		}
+		if result.Content, err = cmsOctets(octets); err != nil {
+			return nil, err
+		}
+	}
+	if content == nil {
+		content = result.Content
+	}
+
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		if certs, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, fmt.Errorf("parse certificates: %w", err)
+		}
+	}
+
+	si := sd.SignerInfos[0]
+	result.Signer = findSignerCert(certs, si.SID)
+	if result.Signer == nil {
+		result.Err = errors.New("signer certificate not found")
+		return result, nil
+	}
+
+	hash, ok := digestHash(si.DigestAlgorithm.Algorithm)
+	if !ok {
+		result.Err = fmt.Errorf("unsupported digest %v", si.DigestAlgorithm.Algorithm)
+		return result, nil
+	}
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	signed := content
+	if len(si.SignedAttrs.Bytes) > 0 {
+		attrs, err := parseAttributes(si.SignedAttrs.Bytes)
+		if err != nil {
+			result.Err = err
+			return result, nil
+		}
+		want, ok := attrs[oidAttrMessageDigest.String()]
+		if !ok {
+			result.Err = errors.New("message digest attribute missing")
+			return result, nil
+		}
+		var md []byte
+		if _, err := asn1.Unmarshal(want, &md); err != nil || !bytes.Equal(md, digest) {
+			result.Err = errCMSSignatureMismatch
+			return result, nil
+		}
+		if raw, ok := attrs[oidAttrSigningTime.String()]; ok {
+			var t time.Time
+			if _, err := asn1.Unmarshal(raw, &t); err == nil {
+				result.SigningAt = t
+			}
+		}
+		// The signature covers the DER of the attributes as a SET OF.
+		signed = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	}
+
+	if err := checkSignature(result.Signer, hash, si.SignatureAlgorithm.Algorithm, signed, si.Signature); err != nil {
+		result.Err = err
+		return result, nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		if c != result.Signer {
+			intermediates.AddCert(c)
+		}
+	}
+	at := result.SigningAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	chains, err := result.Signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		result.TrustError = err
+	} else if len(chains) > 0 {
+		result.Chain = chains[0]
+	}
+	return result, nil
+}
+
+func findSignerCert(certs []*x509.Certificate, sid asn1.RawValue) *x509.Certificate {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, sid.Bytes) {
+				return c
+			}
+		}
+		return nil
+	}
+	var ias cmsIssuerAndSerial
+	if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+		return nil
+	}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) && c.SerialNumber.Cmp(ias.Serial) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// parseAttributes maps attribute OIDs to the DER of their first value.
+func parseAttributes(set []byte) (map[string][]byte, error) {
+	attrs := map[string][]byte{}
+	for len(set) > 0 {
+		var attr cmsAttribute
+		rest, err := asn1.Unmarshal(set, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("parse signed attributes: %w", err)
+		}
+		var value asn1.RawValue
+		if _, err := asn1.Unmarshal(attr.Values.Bytes, &value); err == nil {
+			attrs[attr.Type.String()] = value.FullBytes
+		}
+		set = rest
+	}
+	return attrs, nil
+}
+
+func digestHash(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, true
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, true
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, true
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// checkSignature verifies sig over signed with the key of cert. Signature
+// algorithm OIDs that name a digest must agree with hash.
+func checkSignature(cert *x509.Certificate, hash crypto.Hash, alg asn1.ObjectIdentifier, signed, sig []byte) error {
+	switch {
+	case alg.Equal(oidRSAEncryption), alg.Equal(oidECPublicKey), alg.Equal(oidEd25519):
+	case alg.Equal(oidSHA1WithRSA), alg.Equal(oidECDSAWithSHA1):
+		hash = crypto.SHA1
+	case alg.Equal(oidSHA256WithRSA), alg.Equal(oidECDSAWithSHA256):
+		hash = crypto.SHA256
+	case alg.Equal(oidSHA384WithRSA), alg.Equal(oidECDSAWithSHA384):
+		hash = crypto.SHA384
+	case alg.Equal(oidSHA512WithRSA), alg.Equal(oidECDSAWithSHA512):
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %v", alg)
+	}
+
+	if pub, ok := cert.PublicKey.(ed25519.PublicKey); ok {
+		if !ed25519.Verify(pub, signed, sig) {
+			return errCMSSignatureMismatch
+		}
+		return nil
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return errCMSSignatureMismatch
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return errCMSSignatureMismatch
+		}
+	default:
+		return fmt.Errorf("unsupported public key %T", cert.PublicKey)
+	}
+	return nil
+}
+
+// decryptEnvelopedData decrypts an EnvelopedData with key. cert, when
+// known, picks the matching recipient; otherwise every RSA recipient is
+// tried.
+func decryptEnvelopedData(der []byte, key *rsa.PrivateKey, cert *x509.Certificate) ([]byte, error) {
+	info, err := parseContentInfo(der)
+	if err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidEnvelopedData) {
+		return nil, errCMSUnsupported
+	}
+	var ed cmsEnvelopedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &ed); err != nil {
+		return nil, fmt.Errorf("parse EnvelopedData: %w", err)
+	}
+	encrypted, err := cmsOctets(ed.EncryptedContentInfo.EncryptedContent)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []cmsKeyTransRecipient
+	for _, raw := range ed.RecipientInfos {
+		var ktri cmsKeyTransRecipient
+		if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			continue
+		}
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ktri); err != nil {
+			continue
+		}
+		if cert != nil && findSignerCert([]*x509.Certificate{cert}, ktri.RID) != nil {
+			recipients = append([]cmsKeyTransRecipient{ktri}, recipients...)
+		} else {
+			recipients = append(recipients, ktri)
+		}
+	}
+
+	for _, r := range recipients {
+		cek, err := decryptContentKey(key, r)
+		if err != nil {
+			continue
+		}
+		plain, err := decryptContent(ed.EncryptedContentInfo.ContentEncryptionAlgorithm, cek, encrypted)
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errNoMatchingRecipient
+}
+
+func decryptContentKey(key *rsa.PrivateKey, r cmsKeyTransRecipient) ([]byte, error) {
+	alg := r.KeyEncryptionAlgorithm
+	switch {
+	case alg.Algorithm.Equal(oidRSAEncryption):
+		return rsa.DecryptPKCS1v15(nil, key, r.EncryptedKey)
+	case alg.Algorithm.Equal(oidRSAESOAEP):
+		hash := crypto.SHA1
+		var params struct {
+			Hash pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+		}
+		if len(alg.Parameters.FullBytes) > 0 {
+			if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err == nil && params.Hash.Algorithm != nil {
+				if h, ok := digestHash(params.Hash.Algorithm); ok {
+					hash = h
+				}
+			}
+		}
+		return rsa.DecryptOAEP(hash.New(), nil, key, r.EncryptedKey, nil)
+	}
+	return nil, fmt.Errorf("unsupported key encryption %v", alg.Algorithm)
+}
+
+func decryptContent(alg pkix.AlgorithmIdentifier, key, data []byte) ([]byte, error) {
+	var block cipher.Block
+	var err error
+	switch {
+	case alg.Algorithm.Equal(oidAES128CBC), alg.Algorithm.Equal(oidAES192CBC), alg.Algorithm.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(key)
+	case alg.Algorithm.Equal(oidDESEDE3CBC):
+		block, err = des.NewTripleDESCipher(key)
+	default:
+		return nil, fmt.Errorf("unsupported content encryption %v", alg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+		return nil, errors.New("invalid IV")
+	}
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() || pad > len(plain) {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// cmsOctets returns the bytes of an OCTET STRING, joining the segments of
+// a constructed (BER) one.
+func cmsOctets(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		return v.Bytes, nil
+	}
+	var out []byte
+	rest := v.Bytes
+	for len(rest) > 0 {
+		var seg asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &seg); err != nil {
+			return nil, err
+		}
+		b, err := cmsOctets(seg)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// berToDER rewrites indefinite and non-minimal lengths, which mail
+// clients commonly emit, into the definite minimal form encoding/asn1
+// requires.
+func berToDER(ber []byte) ([]byte, error) {
+	out, rest, err := berElement(ber)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 && !bytes.Equal(rest, make([]byte, len(rest))) {
+		return nil, errors.New("trailing data after CMS structure")
+	}
+	return out, nil
+}
+
+func berElement(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, errors.New("truncated BER element")
+	}
+	tagLen := 1
+	if b[0]&0x1f == 0x1f {
+		for tagLen < len(b) && b[tagLen]&0x80 != 0 {
+			tagLen++
+		}
+		tagLen++
+		if tagLen >= len(b) {
+			return nil, nil, errors.New("truncated BER tag")
+		}
+	}
+	tag := b[:tagLen]
+	constructed := b[0]&0x20 != 0
+	b = b[tagLen:]
+
+	var content []byte
+	if b[0] == 0x80 {
+		if !constructed {
+			return nil, nil, errors.New("indefinite length on primitive element")
+		}
+		b = b[1:]
+		for {
+			if len(b) < 2 {
+				return nil, nil, errors.New("unterminated indefinite length")
+			}
+			if b[0] == 0 && b[1] == 0 {
+				b = b[2:]
+				break
+			}
+			child, rest, err := berElement(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			content = append(content, child...)
+			b = rest
+		}
+	} else {
+		length := 0
+		if b[0]&0x80 == 0 {
+			length = int(b[0])
+			b = b[1:]
+		} else {
+			n := int(b[0] & 0x7f)
+			if n > 4 || len(b) < 1+n {
+				return nil, nil, errors.New("invalid BER length")
+			}
+			for _, c := range b[1 : 1+n] {
+				length = length<<8 | int(c)
+			}
+			b = b[1+n:]
+		}
+		if length < 0 || length > len(b) {
+			return nil, nil, errors.New("BER length exceeds data")
+		}
+		body := b[:length]
+		b = b[length:]
+		if constructed {
+			for len(body) > 0 {
+				child, rest, err := berElement(body)
+				if err != nil {
+					return nil, nil, err
+				}
+				content = append(content, child...)
+				body = rest
+			}
+		} else {
+			content = body
+		}
+	}
+
+	out := append([]byte{}, tag...)
+	out = append(out, derLength(len(content))...)
+	out = append(out, content...)
+	return out, b, nil
+}
+
+func derLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var digits []byte
+	for v := n; v > 0; v >>= 8 {
+		digits = append([]byte{byte(v)}, digits...)
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
@@ -0,0 +1,250 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPKI struct {
+	ca   *x509.Certificate
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "测试根证书"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "张三"},
+		EmailAddresses: []string{"zhangsan@example.gov.cn"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testPKI{ca: ca, cert: cert, key: key}
+}
+
+func (p *testPKI) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.ca)
+	return pool
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func explicitTag0(b []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+func (p *testPKI) issuerAndSerial(t *testing.T) asn1.RawValue {
+	return asn1.RawValue{FullBytes: mustMarshal(t, cmsIssuerAndSerial{
+		Issuer: asn1.RawValue{FullBytes: p.cert.RawIssuer},
+		Serial: p.cert.SerialNumber,
+	})}
+}
+
+// sign builds a SignedData over content, detached or with the content
+// encapsulated.
+func (p *testPKI) sign(t *testing.T, content []byte, detached bool, at time.Time) []byte {
+	t.Helper()
+	digest := sha256.Sum256(content)
+	attr := func(oid asn1.ObjectIdentifier, value interface{}) []byte {
+		return mustMarshal(t, cmsAttribute{
+			Type:   oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: mustMarshal(t, value)},
+		})
+	}
+	var set []byte
+	set = append(set, attr(oidAttrContentType, oidData)...)
+	set = append(set, attr(oidAttrSigningTime, at.UTC())...)
+	set = append(set, attr(oidAttrMessageDigest, digest[:])...)
+
+	signed := mustMarshal(t, asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: set})
+	sum := sha256.Sum256(signed)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encap := cmsContentInfo{ContentType: oidData}
+	if !detached {
+		encap.Content = explicitTag0(mustMarshal(t, content))
+	}
+	sd := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidDigestSHA256}},
+		ContentInfo:      encap,
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: p.cert.Raw},
+		SignerInfos: []cmsSignerInfo{{
+			Version:            1,
+			SID:                p.issuerAndSerial(t),
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: set},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption},
+			Signature:          sig,
+		}},
+	}
+	return mustMarshal(t, cmsContentInfo{ContentType: oidSignedData, Content: explicitTag0(mustMarshal(t, sd))})
+}
+
+// encrypt builds an AES-256-CBC EnvelopedData of content for the test
+// certificate.
+func (p *testPKI) encrypt(t *testing.T, content []byte) []byte {
+	t.Helper()
+	cek := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	_, _ = rand.Read(cek)
+	_, _ = rand.Read(iv)
+	pad := aes.BlockSize - len(content)%aes.BlockSize
+	plain := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, _ := aes.NewCipher(cek)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, p.cert.PublicKey.(*rsa.PublicKey), cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := mustMarshal(t, cmsKeyTransRecipient{
+		RID:                    p.issuerAndSerial(t),
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption},
+		EncryptedKey:           encryptedKey,
+	})
+	ed := cmsEnvelopedData{
+		RecipientInfos: []asn1.RawValue{{FullBytes: recipient}},
+		EncryptedContentInfo: cmsEncryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidAES256CBC,
+				Parameters: asn1.RawValue{FullBytes: mustMarshal(t, iv)},
+			},
+			EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: encrypted},
+		},
+	}
+	return mustMarshal(t, cmsContentInfo{ContentType: oidEnvelopedData, Content: explicitTag0(mustMarshal(t, ed))})
+}
+
+func TestBerToDER(t *testing.T) {
+	ber := []byte{0x30, 0x80, 0x02, 0x01, 0x05, 0x04, 0x81, 0x01, 'x', 0x00, 0x00}
+	der, err := berToDER(ber)
+	if err != nil {
+		t.Fatalf("berToDER failed: %v", err)
+	}
+	want := []byte{0x30, 0x06, 0x02, 0x01, 0x05, 0x04, 0x01, 'x'}
+	if !bytes.Equal(der, want) {
+		t.Fatalf("got % x, want % x", der, want)
+	}
+
+	if _, err := berToDER([]byte{0x30, 0x80, 0x02, 0x01}); err == nil {
+		t.Fatal("expected error for truncated input")
+	}
+}
+
+func TestCMSOctets_Constructed(t *testing.T) {
+	der, err := berToDER([]byte{0x24, 0x80, 0x04, 0x02, 'a', 'b', 0x04, 0x01, 'c', 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cmsOctets(v)
+	if err != nil || string(got) != "abc" {
+		t.Fatalf("got %q %v", got, err)
+	}
+}
+
+func TestVerifySignedData(t *testing.T) {
+	pki := newTestPKI(t)
+	content := []byte("Content-Type: text/plain\r\n\r\nhello\r\n")
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	detached := pki.sign(t, content, true, at)
+	result, err := verifySignedData(detached, content, pki.roots())
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if result.Err != nil || result.TrustError != nil {
+		t.Fatalf("expected valid signature, got %v / %v", result.Err, result.TrustError)
+	}
+	if result.Signer.Subject.CommonName != "张三" || !result.SigningAt.Equal(at) {
+		t.Fatalf("unexpected signer %q at %v", result.Signer.Subject.CommonName, result.SigningAt)
+	}
+
+	result, _ = verifySignedData(detached, []byte("tampered"), pki.roots())
+	if result.Err != errCMSSignatureMismatch {
+		t.Fatalf("expected mismatch for tampered content, got %v", result.Err)
+	}
+
+	result, _ = verifySignedData(detached, content, x509.NewCertPool())
+	if result.Err != nil || result.TrustError == nil {
+		t.Fatalf("expected untrusted signer, got %v / %v", result.Err, result.TrustError)
+	}
+
+	opaque := pki.sign(t, content, false, at)
+	result, err = verifySignedData(opaque, nil, pki.roots())
+	if err != nil || result.Err != nil || !bytes.Equal(result.Content, content) {
+		t.Fatalf("unexpected opaque result: %+v %v", result, err)
+	}
+}
+
+func base64Lines(b []byte) string {
+	s := base64.StdEncoding.EncodeToString(b)
+	var out strings.Builder
+	for len(s) > 76 {
+		out.WriteString(s[:76] + "\r\n")
+		s = s[76:]
+	}
+	out.WriteString(s + "\r\n")
+	return out.String()
+}
@@ -0,0 +1,443 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DemoProvider serves a fixed set of sample mails, the same ones the frontend
+// keeps in mockData.js. Dates are anchored to the day Connect is called so the
+// "last N days" filter keeps working.
+type DemoProvider struct {
+	anchor    time.Time
+	connected bool
+}
+
+type demoAttachment struct {
+	filename    string
+	size        int
+	contentType string
+}
+
+type demoMail struct {
+	id          string
+	subject     string
+	fromName    string
+	fromAddr    string
+	daysAgo     int
+	hour        int
+	minute      int
+	body        string
+	attachments []demoAttachment
+}
+
+func NewDemoProvider() *DemoProvider {
+	return &DemoProvider{}
+}
+
+func (p *DemoProvider) Connect() error {
+	now := time.Now()
+	p.anchor = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	p.connected = true
+	return nil
+}
+
+func (p *DemoProvider) Disconnect() {
+	p.connected = false
+}
+
+// Status reports whether the demo mailbox is open.
+func (p *DemoProvider) Status() ConnectionStatus {
+	return localStatus("demo", "demo", "INBOX", p.connected)
+}
+
+// Ping checks that the demo mailbox is open.
+func (p *DemoProvider) Ping() error {
+	return p.ensureConnection()
+}
+
+func (p *DemoProvider) ensureConnection() error {
+	if !p.connected {
+		return fmt.Errorf("not connected")
+	}
+	return nil
+}
+
+func (p *DemoProvider) item(m demoMail) MailItem {
+	date := p.anchor.AddDate(0, 0, -m.daysAgo).Add(time.Duration(m.hour)*time.Hour + time.Duration(m.minute)*time.Minute)
+	size := int64(len(m.body))
+	for _, a := range m.attachments {
+		size += int64(a.size)
+	}
+	return MailItem{
+		ID:              m.id,
+		Subject:         m.subject,
+		From:            m.fromName,
+		FromAddresses:   []Address{{Name: m.fromName, Address: m.fromAddr}},
+		To:              []Address{},
+		Cc:              []Address{},
+		Date:            date.Format(time.RFC1123Z),
+		Timestamp:       date.Unix(),
+		AttachmentCount: len(m.attachments),
+		HasAttachments:  len(m.attachments) > 0,
+		MessageID:       demoMessageID(m),
+		Flags:           []string{},
+		Size:            size,
+		Snippet:         makeSnippet(m.body, false),
+		Category:        ClassifyMail(nil, m.fromAddr, m.subject),
+	}
+}
+
+func findDemoMail(mailID string) (demoMail, error) {
+	for _, m := range demoMails {
+		if m.id == mailID {
+			return m, nil
+		}
+	}
+	return demoMail{}, fmt.Errorf("message not found")
+}
+
+func (p *DemoProvider) FetchMailList(limit int, days int) ([]MailItem, error) {
+	return p.Search(SearchCriteria{Days: days, Limit: limit})
+}
+
+func (p *DemoProvider) Search(criteria SearchCriteria) ([]MailItem, error) {
+	if err := p.ensureConnection(); err != nil {
+		return nil, err
+	}
+
+	results := []MailItem{}
+	for _, m := range demoMails {
+		item := p.item(m)
+		if matchesCriteria(item, m.body, criteria) {
+			results = append(results, item)
+		}
+	}
+	sortMailItems(results)
+	return limitMailItems(results, criteria.Limit), nil
+}
+
+func (p *DemoProvider) FetchMailDetail(mailID string) (map[string]interface{}, error) {
+	if err := p.ensureConnection(); err != nil {
+		return nil, err
+	}
+	m, err := findDemoMail(mailID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"body":        m.body,
+		"new_content": ExtractNewContent(m.body),
+		"html_body":   "",
+		"attachments": demoAttachmentMaps(m),
+		"from":        []Address{{Name: m.fromName, Address: m.fromAddr}},
+		"to":          []Address{},
+		"cc":          []Address{},
+		"message_id":  demoMessageID(m),
+		"events":      []CalendarEvent{},
+		"security":    (*MessageSecurity)(nil),
+		"raw_content": "",
+	}, nil
+}
+
+func demoMessageID(m demoMail) string {
+	return fmt.Sprintf("demo-%s@knot.local", m.id)
+}
+
+func demoAttachmentMaps(m demoMail) []map[string]interface{} {
+	attachments := make([]map[string]interface{}, 0, len(m.attachments))
+	for _, a := range m.attachments {
+		attachments = append(attachments, map[string]interface{}{
+			"filename":     a.filename,
+			"size":         a.size,
+			"content_type": a.contentType,
+		})
+	}
+	return attachments
+}
+
+func (p *DemoProvider) FetchAttachments(mailID string) ([]map[string]interface{}, error) {
+	if err := p.ensureConnection(); err != nil {
+		return nil, err
+	}
+	m, err := findDemoMail(mailID)
+	if err != nil {
+		return nil, err
+	}
+	return demoAttachmentMaps(m), nil
+}
+
+// DownloadAttachments writes a short placeholder file for every attachment.
+func (p *DemoProvider) DownloadAttachments(mailID string, savePath string) ([]string, error) {
+	if err := p.ensureConnection(); err != nil {
+		return nil, err
+	}
+	m, err := findDemoMail(mailID)
+	if err != nil {
+		return nil, err
+	}
+
+	var downloaded []string
+	for _, a := range m.attachments {
+		content := fmt.Sprintf("Knot 演示附件：%s\n", a.filename)
+		if saved, err := SaveAttachment(savePath, a.filename, strings.NewReader(content)); err == nil {
+			downloaded = append(downloaded, saved)
+		}
+	}
+	return downloaded, nil
+}
+
+func (p *DemoProvider) ListFolders() ([]string, error) {
+	if err := p.ensureConnection(); err != nil {
+		return nil, err
+	}
+	return []string{"INBOX"}, nil
+}
+
+func (p *DemoProvider) SelectFolder(name string) error {
+	if err := p.ensureConnection(); err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if name != "" && !strings.EqualFold(name, "INBOX") {
+		return fmt.Errorf("select %s error: folder not found", name)
+	}
+	return nil
+}
+
+var demoMails = []demoMail{
+	{
+		id:       "1",
+		subject:  "关于2025年度预算审批的通知",
+		fromName: "财务部",
+		fromAddr: "caiwu@company.com",
+		daysAgo:  0,
+		hour:     10,
+		minute:   30,
+		body: `各部门：
+
+根据公司年度工作计划，现将2025年度预算审批工作安排通知如下：
+
+一、预算编制要求
+1. 各部门需按照统一模板编制2025年度预算
+2. 预算编制应遵循"量入为出、统筹兼顾"的原则
+3. 重点项目需附详细说明
+
+二、时间安排
+1. 1月20日前：各部门提交预算初稿
+2. 1月25日前：财务部汇总审核
+3. 2月1日前：提交公司审批
+
+请各部门按时完成预算编制工作。
+
+附件：2025年度预算表、预算说明
+
+财务部
+2025年1月19日`,
+		attachments: []demoAttachment{
+			{"2025年度预算表.xlsx", 156000, "application/vnd.ms-excel"},
+			{"预算说明.docx", 45000, "application/msword"},
+		},
+	},
+	{
+		id:       "2",
+		subject:  "科数部系统升级方案讨论",
+		fromName: "科数部 张三",
+		fromAddr: "zhangsan@company.com",
+		daysAgo:  1,
+		hour:     14,
+		minute:   20,
+		body: `各位同事：
+
+关于数字化办公平台系统升级方案，现将讨论要点整理如下：
+
+1. 升级目标
+   - 提升系统响应速度30%以上
+   - 优化用户界面体验
+   - 增加移动端适配
+
+2. 技术方案
+   - 前端：升级至React 18
+   - 后端：优化数据库查询
+   - 部署：采用容器化方案
+
+3. 实施计划
+   - 第一阶段：环境准备（1周）
+   - 第二阶段：开发测试（2周）
+   - 第三阶段：上线部署（3天）
+
+请查阅附件中的详细方案，如有问题请及时反馈。
+
+张三
+科数部`,
+		attachments: []demoAttachment{
+			{"系统升级方案v2.pdf", 2340000, "application/pdf"},
+		},
+	},
+	{
+		id:       "3",
+		subject:  "本周工作周报模板更新",
+		fromName: "办公室",
+		fromAddr: "office@company.com",
+		daysAgo:  2,
+		hour:     9,
+		minute:   0,
+		body: `各部门：
+
+为规范周报填写，现对周报模板进行更新，主要变更如下：
+
+1. 新增"下周计划"栏目
+2. 优化"本周完成"格式
+3. 增加项目进度跟踪表
+
+请各部门自本周起使用新模板提交周报。
+
+办公室
+2025年1月17日`,
+		attachments: []demoAttachment{
+			{"周报模板2025.docx", 32000, "application/msword"},
+		},
+	},
+	{
+		id:       "4",
+		subject:  "关于组织部门团建活动的通知",
+		fromName: "人事部",
+		fromAddr: "hr@company.com",
+		daysAgo:  3,
+		hour:     16,
+		minute:   45,
+		body: `各位同事：
+
+为增进部门间交流，丰富员工文化生活，公司定于2025年2月举办团建活动。
+
+活动安排：
+- 时间：2025年2月15日（周六）
+- 地点：待定
+- 形式：户外拓展 + 聚餐
+
+请各部门于1月25日前统计参加人数并报送人事部。
+
+人事部
+2025年1月16日`,
+	},
+	{
+		id:       "5",
+		subject:  "信息安全培训材料",
+		fromName: "信息中心",
+		fromAddr: "it@company.com",
+		daysAgo:  4,
+		hour:     11,
+		minute:   30,
+		body: `各位同事：
+
+根据公司信息安全管理要求，现发布信息安全培训材料，请认真学习。
+
+培训内容包括：
+1. 网络安全基础知识
+2. 密码安全管理
+3. 钓鱼邮件识别
+4. 数据保护规范
+
+培训完成后请参加在线测试，测试成绩将计入年度考核。
+
+信息中心
+2025年1月15日`,
+		attachments: []demoAttachment{
+			{"信息安全培训PPT.pptx", 5600000, "application/vnd.ms-powerpoint"},
+			{"安全知识测试题.docx", 28000, "application/msword"},
+			{"网络安全手册.pdf", 1200000, "application/pdf"},
+		},
+	},
+	{
+		id:       "6",
+		subject:  "项目进度汇报 - 数字化办公平台",
+		fromName: "项目组 李四",
+		fromAddr: "lisi@company.com",
+		daysAgo:  5,
+		hour:     15,
+		minute:   0,
+		body: `项目组成员：
+
+本周项目进度汇报如下：
+
+已完成工作：
+1. 用户管理模块开发完成
+2. 权限系统联调通过
+3. 前端界面优化
+
+进行中工作：
+1. 报表模块开发（60%）
+2. 接口文档编写
+
+下周计划：
+1. 完成报表模块
+2. 开始集成测试
+
+详见附件。
+
+李四
+项目组`,
+		attachments: []demoAttachment{
+			{"项目进度表.xlsx", 89000, "application/vnd.ms-excel"},
+			{"需求变更说明.docx", 56000, "application/msword"},
+		},
+	},
+	{
+		id:       "7",
+		subject:  "会议纪要：部门协调会",
+		fromName: "综合部",
+		fromAddr: "zonghe@company.com",
+		daysAgo:  6,
+		hour:     17,
+		minute:   20,
+		body: `各部门负责人：
+
+现将1月13日部门协调会会议纪要发送如下：
+
+会议主题：2025年第一季度工作协调
+
+主要议题：
+1. 各部门Q1工作计划汇报
+2. 跨部门协作事项确认
+3. 资源调配讨论
+
+会议决议：
+1. 各部门于1月20日前提交详细计划
+2. 建立周例会制度
+3. 设立项目协调专员
+
+综合部
+2025年1月13日`,
+		attachments: []demoAttachment{
+			{"会议纪要20250113.docx", 34000, "application/msword"},
+		},
+	},
+	{
+		id:       "8",
+		subject:  "新员工入职培训安排",
+		fromName: "人事部",
+		fromAddr: "hr@company.com",
+		daysAgo:  7,
+		hour:     9,
+		minute:   30,
+		body: `各位新同事：
+
+欢迎加入公司！现将入职培训安排通知如下：
+
+培训时间：2025年1月20日-22日
+培训地点：3楼培训室
+
+培训内容：
+- 公司文化与制度
+- 业务流程介绍
+- 办公系统使用
+- 安全生产培训
+
+请准时参加，如有问题请联系人事部。
+
+人事部
+2025年1月12日`,
+	},
+}
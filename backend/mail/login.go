@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// CheckLogin logs in to an IMAP or POP3 server over an already open
+// connection and logs out again. The connection test uses it once the
+// server has been reached, possibly through a proxy. conn is closed.
+func CheckLogin(conn net.Conn, protocol, username, password string) error {
+	switch strings.ToLower(strings.TrimSpace(protocol)) {
+	case "pop3", "pop":
+		pc := newPOP3Conn(conn, pop3Timeout)
+		defer pc.close()
+		pc.extendDeadline()
+		if _, err := pc.readStatus(); err != nil {
+			return fmt.Errorf("greeting error: %w", err)
+		}
+		if _, err := pc.cmd("USER %s", username); err != nil {
+			return fmt.Errorf("login error: %w", err)
+		}
+		if _, err := pc.cmd("PASS %s", password); err != nil {
+			return fmt.Errorf("login error: %w", err)
+		}
+		_, _ = pc.cmd("QUIT")
+		return nil
+	default:
+		_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+		c, err := client.New(conn)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("greeting error: %w", err)
+		}
+		defer c.Logout()
+		if err := c.Login(username, password); err != nil {
+			return fmt.Errorf("login error: %w", err)
+		}
+		return nil
+	}
+}
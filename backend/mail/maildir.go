@@ -0,0 +1,421 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// MaildirProvider reads mail from a local Maildir (cur/new) or from a plain
+// directory of .eml files. Sub-folders are Maildir++ ".Name" directories or
+// ordinary sub-directories.
+type MaildirProvider struct {
+	root      string
+	folder    string
+	index     map[string]string
+	connected bool
+	security  *Security
+}
+
+func NewMaildirProvider(root string) *MaildirProvider {
+	return &MaildirProvider{
+		root:   filepath.Clean(root),
+		folder: "INBOX",
+		index:  map[string]string{},
+	}
+}
+
+// SetSecurity sets the trust store and key signed and encrypted mail is
+// checked and opened with.
+func (p *MaildirProvider) SetSecurity(sec *Security) {
+	p.security = sec
+}
+
+func (p *MaildirProvider) Connect() error {
+	fi, err := os.Stat(p.root)
+	if err != nil {
+		return fmt.Errorf("open maildir error: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("open maildir error: %s is not a directory", p.root)
+	}
+	p.connected = true
+	return nil
+}
+
+func (p *MaildirProvider) Disconnect() {
+	p.connected = false
+	p.index = map[string]string{}
+}
+
+// Status reports whether the Maildir is open.
+func (p *MaildirProvider) Status() ConnectionStatus {
+	return localStatus("maildir", p.root, p.folder, p.connected)
+}
+
+// Ping checks that the Maildir is open.
+func (p *MaildirProvider) Ping() error {
+	return p.ensureConnection()
+}
+
+func (p *MaildirProvider) ensureConnection() error {
+	if !p.connected {
+		return fmt.Errorf("not connected")
+	}
+	return nil
+}
+
+func isMaildir(dir string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if fi, err := os.Stat(filepath.Join(dir, sub)); err == nil && fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// folderDir maps a folder name to its directory. A name is a single path
+// element, so a mailbox from a request or a rule's move_to cannot reach
+// outside the root.
+func (p *MaildirProvider) folderDir(name string) (string, error) {
+	if name == "" || strings.EqualFold(name, "INBOX") {
+		return p.root, nil
+	}
+	switch {
+	case strings.HasPrefix(name, "."), strings.Contains(name, ".."), strings.ContainsAny(name, `/\`),
+		name == "cur", name == "new", name == "tmp", filepath.Base(name) != name:
+		return "", fmt.Errorf("invalid folder name %q", name)
+	}
+	dir := filepath.Join(p.root, "."+name)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		dir = filepath.Join(p.root, name)
+	}
+	if rel, err := filepath.Rel(p.root, dir); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid folder name %q", name)
+	}
+	return dir, nil
+}
+
+// messageFiles lists the message files of one folder, without descending
+// into sub-folders.
+func messageFiles(dir string) []string {
+	var files []string
+	if isMaildir(dir) {
+		for _, sub := range []string{"cur", "new"} {
+			entries, err := os.ReadDir(filepath.Join(dir, sub))
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+					files = append(files, filepath.Join(dir, sub, e.Name()))
+				}
+			}
+		}
+		return files
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".eml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files
+}
+
+// messageKey derives a stable, URL-safe ID from a message file path. It
+// covers the folder and the base file name only: a message moves from new/
+// to cur/ and its flags after ":2," change when it is read or tagged.
+func (p *MaildirProvider) messageKey(path string) string {
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if sub := filepath.Base(dir); sub == "cur" || sub == "new" {
+		dir = filepath.Dir(dir)
+	}
+	if i := strings.Index(name, ":2,"); i >= 0 {
+		name = name[:i]
+	}
+	rel, err := filepath.Rel(p.root, filepath.Join(dir, name))
+	if err != nil {
+		rel = filepath.Join(dir, name)
+	}
+	h := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+	return hex.EncodeToString(h[:])[:16]
+}
+
+func (p *MaildirProvider) loadItem(path string) (MailItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return MailItem{}, err
+	}
+	defer f.Close()
+
+	mr, err := mail.CreateReader(f)
+	if err != nil {
+		return MailItem{}, err
+	}
+	item := headerMailItem(p.messageKey(path), mr.Header)
+	item.AttachmentCount, item.Snippet = scanParts(mr)
+	item.HasAttachments = item.AttachmentCount > 0
+	item.setFlags(maildirFileFlags(path))
+	if fi, err := f.Stat(); err == nil {
+		item.Size = fi.Size()
+	}
+	return item, nil
+}
+
+// maildirFileFlags reads the info letters after ":2," of a Maildir file name
+// as IMAP system flags.
+func maildirFileFlags(path string) []string {
+	base := filepath.Base(path)
+	i := strings.Index(base, ":2,")
+	if i < 0 {
+		return nil
+	}
+	info := base[i+3:]
+	var flags []string
+	for _, flag := range []string{"\\Seen", "\\Answered", "\\Flagged", "\\Draft"} {
+		if strings.Contains(info, maildirFlags[strings.ToLower(flag)]) {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+func (p *MaildirProvider) scanFolder() ([]MailItem, map[string]string) {
+	var items []MailItem
+	paths := map[string]string{}
+	dir, err := p.folderDir(p.folder)
+	if err != nil {
+		return nil, paths
+	}
+	for _, path := range messageFiles(dir) {
+		item, err := p.loadItem(path)
+		if err != nil {
+			continue
+		}
+		paths[item.ID] = path
+		items = append(items, item)
+	}
+	sortMailItems(items)
+	return items, paths
+}
+
+func (p *MaildirProvider) FetchMailList(limit int, days int) ([]MailItem, error) {
+	return p.Search(SearchCriteria{Days: days, Limit: limit})
+}
+
+func (p *MaildirProvider) Search(criteria SearchCriteria) ([]MailItem, error) {
+	if err := p.ensureConnection(); err != nil {
+		return nil, err
+	}
+
+	items, paths := p.scanFolder()
+	for id, path := range paths {
+		p.index[id] = path
+	}
+
+	results := []MailItem{}
+	for _, item := range items {
+		body := ""
+		if strings.TrimSpace(criteria.Text) != "" {
+			if detail, err := p.FetchMailDetail(item.ID); err == nil {
+				body, _ = detail["body"].(string)
+			}
+		}
+		if matchesCriteria(item, body, criteria) {
+			results = append(results, item)
+		}
+	}
+	return limitMailItems(results, criteria.Limit), nil
+}
+
+func (p *MaildirProvider) messagePath(mailID string) (string, error) {
+	if err := p.ensureConnection(); err != nil {
+		return "", err
+	}
+	if path, ok := p.index[mailID]; ok {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	// The file may have moved between cur/new or been renamed with new flags.
+	_, paths := p.scanFolder()
+	for id, path := range paths {
+		p.index[id] = path
+	}
+	if path, ok := paths[mailID]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("message not found")
+}
+
+func (p *MaildirProvider) FetchMailDetail(mailID string) (map[string]interface{}, error) {
+	path, err := p.messagePath(mailID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMessageDetail(f, p.security)
+}
+
+func (p *MaildirProvider) FetchAttachments(mailID string) ([]map[string]interface{}, error) {
+	detail, err := p.FetchMailDetail(mailID)
+	if err != nil {
+		return nil, err
+	}
+	if att, ok := detail["attachments"].([]map[string]interface{}); ok {
+		return att, nil
+	}
+	return []map[string]interface{}{}, nil
+}
+
+func (p *MaildirProvider) DownloadAttachments(mailID string, savePath string) ([]string, error) {
+	path, err := p.messagePath(mailID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return saveMessageAttachments(f, savePath, p.security)
+}
+
+func (p *MaildirProvider) ListFolders() ([]string, error) {
+	if err := p.ensureConnection(); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(p.root)
+	if err != nil {
+		return nil, err
+	}
+	folders := []string{"INBOX"}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		switch name {
+		case "cur", "new", "tmp":
+			continue
+		}
+		folders = append(folders, strings.TrimPrefix(name, "."))
+	}
+	sort.Strings(folders[1:])
+	return folders, nil
+}
+
+func (p *MaildirProvider) SelectFolder(name string) error {
+	if err := p.ensureConnection(); err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "INBOX"
+	}
+	dir, err := p.folderDir(name)
+	if err != nil {
+		return fmt.Errorf("select %s error: %v", name, err)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("select %s error: folder not found", name)
+	}
+	p.folder = name
+	return nil
+}
+
+// maildirFlags maps IMAP system flags to Maildir info letters.
+var maildirFlags = map[string]string{
+	"\\seen":     "S",
+	"\\answered": "R",
+	"\\flagged":  "F",
+	"\\draft":    "D",
+}
+
+// TagMail sets a Maildir info flag on the message file. Only the IMAP system
+// flags with a Maildir letter are supported; plain .eml files cannot be tagged.
+func (p *MaildirProvider) TagMail(mailID string, tag string) error {
+	path, err := p.messagePath(mailID)
+	if err != nil {
+		return err
+	}
+	dir, err := p.folderDir(p.folder)
+	if err != nil {
+		return err
+	}
+	letter, ok := maildirFlags[strings.ToLower(strings.TrimSpace(tag))]
+	if !ok || !isMaildir(dir) {
+		return fmt.Errorf("tag %s is not supported by maildir", tag)
+	}
+
+	base := filepath.Base(path)
+	flags := ""
+	if i := strings.Index(base, ":2,"); i >= 0 {
+		flags = base[i+3:]
+		base = base[:i]
+	}
+	if strings.Contains(flags, letter) {
+		return nil
+	}
+	letters := strings.Split(flags+letter, "")
+	sort.Strings(letters)
+
+	target := filepath.Join(dir, "cur", base+":2,"+strings.Join(letters, ""))
+	if err := os.Rename(path, target); err != nil {
+		return err
+	}
+	p.index[mailID] = target
+	return nil
+}
+
+// MoveMail moves the message file into another folder, creating a Maildir++
+// folder when the target does not exist yet.
+func (p *MaildirProvider) MoveMail(mailID string, folder string) error {
+	path, err := p.messagePath(mailID)
+	if err != nil {
+		return err
+	}
+
+	dir, err := p.folderDir(folder)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if isMaildir(p.root) {
+			dir = filepath.Join(p.root, "."+folder)
+		}
+	}
+	if isMaildir(p.root) {
+		for _, sub := range []string{"cur", "new", "tmp"} {
+			if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+				return err
+			}
+		}
+		dir = filepath.Join(dir, "cur")
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		return err
+	}
+	delete(p.index, mailID)
+	return nil
+}
@@ -0,0 +1,329 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// parseMessageDetail reads a full RFC 822 message and returns the detail map
+// shared by every provider. Signed and encrypted mail is unwrapped first and
+// "security" tells what was found.
+func parseMessageDetail(r io.Reader, sec *Security) (map[string]interface{}, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raw, security := openSecureMessage(raw, sec)
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	detail := readMessageDetail(mr)
+	detail["security"] = security
+	return detail, nil
+}
+
+func readMessageDetail(mr *mail.Reader) map[string]interface{} {
+	var body, htmlBody string
+	var attachments []map[string]interface{}
+	events := []CalendarEvent{}
+
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Error reading part: %v", err)
+			break
+		}
+
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			b, _ := io.ReadAll(p.Body)
+			contentType, _, _ := h.ContentType()
+			if strings.HasPrefix(contentType, "text/html") {
+				htmlBody = string(b)
+			} else if strings.HasPrefix(contentType, "text/plain") {
+				body = string(b)
+			} else if isCalendarPart(contentType, "") {
+				events = append(events, ParseICalendar(b)...)
+			}
+		case *mail.AttachmentHeader:
+			filename, _ := h.Filename()
+			b, _ := io.ReadAll(p.Body)
+			contentType, _, _ := h.ContentType()
+			if isSignaturePart(contentType) {
+				continue
+			}
+			if isCalendarPart(contentType, filename) {
+				events = append(events, ParseICalendar(b)...)
+			}
+			attachments = append(attachments, map[string]interface{}{
+				"filename":     decodeRFC2047(filename),
+				"size":         len(b),
+				"content_type": h.Get("Content-Type"),
+			})
+		}
+	}
+
+	// Simple HTML tag stripping for body if body is empty
+	if body == "" && htmlBody != "" {
+		body = htmlBody // Simplified, real stripping can be added
+	}
+	messageID, _ := mr.Header.MessageID()
+
+	return map[string]interface{}{
+		"body":        body,
+		"new_content": ExtractNewContent(body),
+		"html_body":   htmlBody,
+		"attachments": attachments,
+		"from":        headerAddressList(mr.Header, "From"),
+		"to":          headerAddressList(mr.Header, "To"),
+		"cc":          headerAddressList(mr.Header, "Cc"),
+		"message_id":  messageID,
+		"events":      events,
+		"raw_content": "", // left blank for brevity right now
+	}
+}
+
+// saveMessageAttachments writes every attachment of a full RFC 822 message
+// into savePath and returns the saved file names. Attachments of encrypted
+// mail are saved decrypted when sec can open it.
+func saveMessageAttachments(r io.Reader, savePath string, sec *Security) ([]string, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ = openSecureMessage(raw, sec)
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	return writeAttachments(mr, savePath)
+}
+
+// writeAttachments saves the attachments of mr with SaveAttachment. Empty
+// attachments are skipped; the others are saved even when one fails, and
+// the failures are returned together.
+func writeAttachments(mr *mail.Reader, savePath string) ([]string, error) {
+	var downloaded []string
+	var failed []string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+
+		h, ok := p.Header.(*mail.AttachmentHeader)
+		if !ok {
+			continue
+		}
+		if contentType, _, _ := h.ContentType(); isSignaturePart(contentType) {
+			continue
+		}
+		filename, _ := h.Filename()
+		filename = decodeRFC2047(filename)
+
+		body := bufio.NewReader(p.Body)
+		if _, err := body.Peek(1); err != nil {
+			continue
+		}
+		saved, err := SaveAttachment(savePath, filename, body)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		downloaded = append(downloaded, saved)
+	}
+	if len(failed) > 0 {
+		return downloaded, fmt.Errorf("save attachments: %s", strings.Join(failed, "; "))
+	}
+	return downloaded, nil
+}
+
+// Address is one mailbox of an address header.
+type Address struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// String formats the address as "Name <addr>", or the bare address when
+// there is no name.
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Address
+	}
+	return fmt.Sprintf("%s <%s>", a.Name, a.Address)
+}
+
+func headerAddressList(h mail.Header, key string) []Address {
+	result := []Address{}
+	addrs, err := h.AddressList(key)
+	if err != nil {
+		return result
+	}
+	for _, a := range addrs {
+		result = append(result, Address{Name: decodeRFC2047(a.Name), Address: a.Address})
+	}
+	return result
+}
+
+// displayName is what the list shows for a sender: the name, or the
+// address when there is none.
+func displayName(addrs []Address) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	if addrs[0].Name != "" {
+		return addrs[0].Name
+	}
+	return addrs[0].Address
+}
+
+const (
+	snippetLength    = 140
+	snippetReadBytes = 4096
+)
+
+var (
+	htmlTagPattern    = regexp.MustCompile(`(?s)<(style|script)[^>]*>.*?</(style|script)>|<[^>]*>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// makeSnippet turns the start of a text or HTML body into a one-line preview.
+func makeSnippet(text string, isHTML bool) string {
+	if isHTML {
+		text = html.UnescapeString(htmlTagPattern.ReplaceAllString(text, " "))
+	}
+	text = strings.TrimSpace(whitespacePattern.ReplaceAllString(text, " "))
+	runes := []rune(text)
+	if len(runes) > snippetLength {
+		return string(runes[:snippetLength]) + "…"
+	}
+	return text
+}
+
+// headerMailItem builds a list item from the top-level header of a message.
+// The attachment count and snippet are only known when the caller walks the
+// parts.
+func headerMailItem(id string, h mail.Header) MailItem {
+	subject, _ := h.Subject()
+	date, _ := h.Date()
+	messageID, _ := h.MessageID()
+	inReplyTo, _ := h.MsgIDList("In-Reply-To")
+	references, _ := h.MsgIDList("References")
+	from := headerAddressList(h, "From")
+
+	item := MailItem{
+		ID:            id,
+		Subject:       decodeRFC2047(subject),
+		From:          displayName(from),
+		FromAddresses: from,
+		To:            headerAddressList(h, "To"),
+		Cc:            headerAddressList(h, "Cc"),
+		Date:          date.Format(time.RFC1123Z),
+		Timestamp:     date.Unix(),
+		MessageID:     messageID,
+		References:    references,
+		Flags:         []string{},
+	}
+	fromAddr := ""
+	if len(from) > 0 {
+		fromAddr = from[0].Address
+	}
+	item.Category = ClassifyMail(&h, fromAddr, item.Subject)
+	if len(inReplyTo) > 0 {
+		item.InReplyTo = inReplyTo[0]
+	}
+	return item
+}
+
+// scanParts walks a message and returns the number of attachment parts and
+// a snippet of its text, preferring text/plain over text/html.
+func scanParts(mr *mail.Reader) (int, string) {
+	count := 0
+	plain, htmlText := "", ""
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		switch h := p.Header.(type) {
+		case *mail.AttachmentHeader:
+			if contentType, _, _ := h.ContentType(); !isSignaturePart(contentType) {
+				count++
+			}
+		case *mail.InlineHeader:
+			contentType, _, _ := h.ContentType()
+			if plain != "" || (!strings.HasPrefix(contentType, "text/plain") && !strings.HasPrefix(contentType, "text/html")) {
+				continue
+			}
+			b, _ := io.ReadAll(io.LimitReader(p.Body, snippetReadBytes))
+			if strings.HasPrefix(contentType, "text/plain") {
+				plain = makeSnippet(string(b), false)
+			} else if htmlText == "" {
+				htmlText = makeSnippet(string(b), true)
+			}
+		}
+	}
+	if plain != "" {
+		return count, plain
+	}
+	return count, htmlText
+}
+
+// setFlags fills the flag fields from IMAP-style system flags.
+func (item *MailItem) setFlags(flags []string) {
+	item.Flags = append([]string{}, flags...)
+	for _, f := range flags {
+		switch strings.ToLower(f) {
+		case "\\seen":
+			item.Seen = true
+		case "\\answered":
+			item.Answered = true
+		case "\\flagged":
+			item.Flagged = true
+		}
+	}
+}
+
+// matchesCriteria reports whether an item satisfies the text parts of a
+// search. Providers without server-side search use it to filter locally.
+func matchesCriteria(item MailItem, body string, criteria SearchCriteria) bool {
+	contains := func(haystack, needle string) bool {
+		return strings.Contains(strings.ToLower(haystack), strings.ToLower(needle))
+	}
+
+	if q := strings.TrimSpace(criteria.From); q != "" && !contains(item.From, q) {
+		return false
+	}
+	if q := strings.TrimSpace(criteria.Subject); q != "" && !contains(item.Subject, q) {
+		return false
+	}
+	if q := strings.TrimSpace(criteria.Text); q != "" {
+		if !contains(item.Subject, q) && !contains(item.From, q) && !contains(body, q) {
+			return false
+		}
+	}
+	if criteria.Days > 0 && item.Timestamp < time.Now().AddDate(0, 0, -criteria.Days).Unix() {
+		return false
+	}
+	return true
+}
+
+func parseUID(mailID string) (uint32, error) {
+	var uid uint32
+	if _, err := fmt.Sscanf(mailID, "%d", &uid); err != nil {
+		return 0, err
+	}
+	return uid, nil
+}
@@ -0,0 +1,402 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"knot-backend/proxy"
+)
+
+// POP3Client downloads mail over POP3 into a local Maildir store and serves
+// list, detail and attachment calls from that store. Messages stay on the
+// server; the UIDL of every downloaded message is used to skip it next time.
+type POP3Client struct {
+	server   string
+	port     int
+	username string
+	password string
+	useSSL   bool
+	storeDir string
+	store    *MaildirProvider
+	security *Security
+	proxy    proxy.Config
+	status   connState
+	// allowInsecure skips the certificate check and permits a cleartext
+	// login when the server does not offer STLS.
+	allowInsecure bool
+	// rootCAs verifies the server certificate; nil is the system roots.
+	rootCAs *x509.CertPool
+	// timeout bounds the dial and every command, so a stalled server
+	// cannot hang a sync.
+	timeout time.Duration
+}
+
+// pop3Timeout is the default for POP3Client.timeout.
+const pop3Timeout = 30 * time.Second
+
+func NewPOP3Client(server string, port int, username, password string, useSSL bool, storeDir string) *POP3Client {
+	return &POP3Client{
+		server:   server,
+		port:     port,
+		username: username,
+		password: password,
+		useSSL:   useSSL,
+		storeDir: storeDir,
+		timeout:  pop3Timeout,
+	}
+}
+
+type pop3Conn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+}
+
+func newPOP3Conn(conn net.Conn, timeout time.Duration) *pop3Conn {
+	return &pop3Conn{conn: conn, text: textproto.NewConn(conn), timeout: timeout}
+}
+
+// extendDeadline gives the next exchange a fresh timeout.
+func (pc *pop3Conn) extendDeadline() {
+	_ = pc.conn.SetDeadline(time.Now().Add(pc.timeout))
+}
+
+// errNoSTLS refuses a cleartext login to a server without STLS.
+var errNoSTLS = errors.New("server does not support STLS; enable SSL or allow insecure connections to log in without encryption")
+
+// startTLS verifies the server certificate against its name unless
+// allowInsecure is set.
+func (c *POP3Client) startTLS(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: c.server, RootCAs: c.rootCAs, InsecureSkipVerify: c.allowInsecure})
+	_ = tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// dial connects and returns after the greeting. Without useSSL the
+// connection is upgraded with STLS before any credentials are sent.
+func (c *POP3Client) dial() (*pop3Conn, error) {
+	addr := net.JoinHostPort(c.server, strconv.Itoa(c.port))
+	dialer := &proxy.Dialer{Config: c.proxy, Timeout: c.timeout}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect error: %w", err)
+	}
+	if c.useSSL {
+		tlsConn, err := c.startTLS(conn, dialer.Timeout)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("connect error: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	pc := newPOP3Conn(conn, c.timeout)
+	pc.extendDeadline()
+	if _, err := pc.readStatus(); err != nil {
+		pc.close()
+		return nil, fmt.Errorf("connect error: %w", err)
+	}
+	if c.useSSL {
+		return pc, nil
+	}
+
+	if _, err := pc.cmd("STLS"); err != nil {
+		var refused pop3StatusError
+		if errors.As(err, &refused) && c.allowInsecure {
+			return pc, nil
+		}
+		pc.close()
+		if errors.As(err, &refused) {
+			err = errNoSTLS
+		}
+		return nil, fmt.Errorf("connect error: %w", err)
+	}
+	tlsConn, err := c.startTLS(conn, dialer.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect error: %w", err)
+	}
+	return newPOP3Conn(tlsConn, c.timeout), nil
+}
+
+func (pc *pop3Conn) readStatus() (string, error) {
+	line, err := pc.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "+OK") {
+		return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+	}
+	return "", pop3StatusError(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+}
+
+// pop3StatusError is an -ERR reply, as opposed to a network failure.
+type pop3StatusError string
+
+func (e pop3StatusError) Error() string { return string(e) }
+
+func (pc *pop3Conn) cmd(format string, args ...interface{}) (string, error) {
+	pc.extendDeadline()
+	if err := pc.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return pc.readStatus()
+}
+
+// cmdLines runs a command with a multi-line, dot-terminated response.
+func (pc *pop3Conn) cmdLines(format string, args ...interface{}) ([]string, error) {
+	if _, err := pc.cmd(format, args...); err != nil {
+		return nil, err
+	}
+	pc.extendDeadline()
+	return pc.text.ReadDotLines()
+}
+
+func (pc *pop3Conn) retr(num int) ([]byte, error) {
+	if _, err := pc.cmd("RETR %d", num); err != nil {
+		return nil, err
+	}
+	pc.extendDeadline()
+	return io.ReadAll(pc.text.DotReader())
+}
+
+func (pc *pop3Conn) close() {
+	_ = pc.conn.Close()
+}
+
+func (pc *pop3Conn) quit() {
+	_, _ = pc.cmd("QUIT")
+	pc.close()
+}
+
+func (c *POP3Client) login() (*pop3Conn, error) {
+	pc, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range []string{"USER " + c.username, "PASS " + c.password} {
+		if _, err := pc.cmd("%s", cmd); err != nil {
+			pc.close()
+			var refused pop3StatusError
+			if errors.As(err, &refused) {
+				err = &AuthError{Err: err}
+			}
+			return nil, fmt.Errorf("login error: %w", err)
+		}
+	}
+	return pc, nil
+}
+
+// SetSecurity sets the trust store and key signed and encrypted mail is
+// checked and opened with.
+func (c *POP3Client) SetSecurity(sec *Security) {
+	c.security = sec
+	if c.store != nil {
+		c.store.SetSecurity(sec)
+	}
+}
+
+// SetAllowInsecure turns off the certificate check and allows a cleartext
+// login to servers without STLS. It is meant only for servers on a trusted
+// network that cannot be fixed.
+func (c *POP3Client) SetAllowInsecure(allow bool) {
+	c.allowInsecure = allow
+}
+
+// SetProxy makes Connect reach the server through cfg.
+func (c *POP3Client) SetProxy(cfg proxy.Config) {
+	c.proxy = cfg
+}
+
+// Connect logs in, downloads new messages into the local store and logs out.
+func (c *POP3Client) Connect() error {
+	for _, sub := range []string{"tmp", "cur", "new"} {
+		if err := os.MkdirAll(filepath.Join(c.storeDir, sub), 0755); err != nil {
+			return fmt.Errorf("open store error: %w", err)
+		}
+	}
+
+	if _, err := c.Sync(); err != nil {
+		return err
+	}
+
+	c.store = NewMaildirProvider(c.storeDir)
+	c.store.SetSecurity(c.security)
+	return c.store.Connect()
+}
+
+func (c *POP3Client) Disconnect() {
+	if c.store != nil {
+		c.store.Disconnect()
+		c.store = nil
+	}
+	c.status.disconnected()
+}
+
+// Status reports the state of the last sync with the server. POP3 logs in
+// for every sync, so latency is that of the last login.
+func (c *POP3Client) Status() ConnectionStatus {
+	return c.status.snapshot("pop3", fmt.Sprintf("%s@%s:%d", c.username, c.server, c.port), "INBOX")
+}
+
+// Ping syncs with the server when a sync is due.
+func (c *POP3Client) Ping() error {
+	if err := c.ensureConnection(); err != nil {
+		return err
+	}
+	if !c.status.due() {
+		return c.status.retry()
+	}
+	_, err := c.Sync()
+	return err
+}
+
+// uidlFileName maps a server UIDL to a stable file name in the store.
+func uidlFileName(uidl string) string {
+	h := sha256.Sum256([]byte(uidl))
+	return hex.EncodeToString(h[:])[:24] + ".eml"
+}
+
+func (c *POP3Client) hasUIDL(uidl string) bool {
+	name := uidlFileName(uidl)
+	for _, sub := range []string{"cur", "new"} {
+		if _, err := os.Stat(filepath.Join(c.storeDir, sub, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Sync downloads every message whose UIDL is not yet in the local store and
+// returns the number of new messages.
+func (c *POP3Client) Sync() (int, error) {
+	start := time.Now()
+	pc, err := c.login()
+	if err != nil {
+		c.status.failed(err)
+		return 0, err
+	}
+	defer pc.quit()
+	c.status.active(time.Since(start))
+
+	lines, err := pc.cmdLines("UIDL")
+	if err != nil {
+		return 0, fmt.Errorf("uidl error: %w", err)
+	}
+
+	added := 0
+	for _, line := range lines {
+		var num int
+		var uidl string
+		if _, err := fmt.Sscanf(line, "%d %s", &num, &uidl); err != nil {
+			continue
+		}
+		if c.hasUIDL(uidl) {
+			continue
+		}
+
+		raw, err := pc.retr(num)
+		if err != nil {
+			log.Printf("POP3 RETR %d failed: %v", num, err)
+			continue
+		}
+
+		// Write into tmp first so a partial download never shows up in the list.
+		name := uidlFileName(uidl)
+		tmpPath := filepath.Join(c.storeDir, "tmp", name)
+		if err := os.WriteFile(tmpPath, raw, 0644); err != nil {
+			return added, fmt.Errorf("store error: %w", err)
+		}
+		if err := os.Rename(tmpPath, filepath.Join(c.storeDir, "cur", name)); err != nil {
+			return added, fmt.Errorf("store error: %w", err)
+		}
+		added++
+	}
+	return added, nil
+}
+
+func (c *POP3Client) ensureConnection() error {
+	if c.store == nil {
+		return fmt.Errorf("not connected")
+	}
+	return nil
+}
+
+// FetchMailList syncs new messages first so the list reflects the server.
+func (c *POP3Client) FetchMailList(limit int, days int) ([]MailItem, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+	// After a failed sync the server is retried with backoff.
+	if !c.status.due() {
+		log.Printf("POP3 sync skipped, listing local store: %v", c.status.retry())
+	} else if _, err := c.Sync(); err != nil {
+		log.Printf("POP3 sync failed, listing local store: %v", err)
+	}
+	return c.store.FetchMailList(limit, days)
+}
+
+func (c *POP3Client) FetchMailDetail(mailID string) (map[string]interface{}, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+	return c.store.FetchMailDetail(mailID)
+}
+
+func (c *POP3Client) FetchAttachments(mailID string) ([]map[string]interface{}, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+	return c.store.FetchAttachments(mailID)
+}
+
+func (c *POP3Client) DownloadAttachments(mailID string, savePath string) ([]string, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+	return c.store.DownloadAttachments(mailID, savePath)
+}
+
+// ListFolders always reports INBOX: POP3 has no server-side folders.
+func (c *POP3Client) ListFolders() ([]string, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+	return []string{"INBOX"}, nil
+}
+
+func (c *POP3Client) SelectFolder(name string) error {
+	if err := c.ensureConnection(); err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if name != "" && !strings.EqualFold(name, "INBOX") {
+		return fmt.Errorf("select %s error: folder not found", name)
+	}
+	return nil
+}
+
+func (c *POP3Client) Search(criteria SearchCriteria) ([]MailItem, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+	return c.store.Search(criteria)
+}
@@ -0,0 +1,224 @@
+package mail
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePOP3Server serves the given UIDL -> message map and counts RETR calls.
+func fakePOP3Server(t *testing.T, messages []string, uidls []string) (string, int, *int) {
+	t.Helper()
+	host, port, retrCount, _ := fakePOP3ServerWithSTLS(t, messages, uidls, nil)
+	return host, port, retrCount
+}
+
+// fakePOP3ServerWithSTLS also offers STLS when stls is set, and counts the
+// passwords received without TLS.
+func fakePOP3ServerWithSTLS(t *testing.T, messages []string, uidls []string, stls *tls.Config) (string, int, *int, *int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	retrCount, plainPasswords := 0, 0
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { conn.Close() }()
+				r := bufio.NewReader(conn)
+				encrypted := false
+				fmt.Fprint(conn, "+OK ready\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(strings.TrimSpace(line))
+					if len(fields) == 0 {
+						continue
+					}
+					switch strings.ToUpper(fields[0]) {
+					case "STLS":
+						if stls == nil {
+							fmt.Fprint(conn, "-ERR not supported\r\n")
+							continue
+						}
+						fmt.Fprint(conn, "+OK begin TLS\r\n")
+						conn = tls.Server(conn, stls)
+						r = bufio.NewReader(conn)
+						encrypted = true
+					case "USER":
+						fmt.Fprint(conn, "+OK\r\n")
+					case "PASS":
+						if !encrypted {
+							plainPasswords++
+						}
+						if len(fields) < 2 || fields[1] != "secret" {
+							fmt.Fprint(conn, "-ERR auth failed\r\n")
+							continue
+						}
+						fmt.Fprint(conn, "+OK\r\n")
+					case "UIDL":
+						fmt.Fprint(conn, "+OK\r\n")
+						for i, u := range uidls {
+							fmt.Fprintf(conn, "%d %s\r\n", i+1, u)
+						}
+						fmt.Fprint(conn, ".\r\n")
+					case "RETR":
+						var n int
+						fmt.Sscanf(fields[1], "%d", &n)
+						retrCount++
+						fmt.Fprint(conn, "+OK\r\n"+messages[n-1]+".\r\n")
+					case "QUIT":
+						fmt.Fprint(conn, "+OK bye\r\n")
+						return
+					default:
+						fmt.Fprint(conn, "-ERR unknown\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, &retrCount, &plainPasswords
+}
+
+func TestPOP3Client_SyncDedupesByUIDL(t *testing.T) {
+	host, port, retrCount := fakePOP3Server(t, []string{sampleEML}, []string{"uid-001"})
+	store := t.TempDir()
+
+	c := NewPOP3Client(host, port, "user", "secret", false, store)
+	c.SetAllowInsecure(true)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	items, err := c.FetchMailList(10, 0)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(items) != 1 || items[0].Subject != "测试" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+	if *retrCount != 1 {
+		t.Fatalf("expected 1 RETR across connect and list, got %d", *retrCount)
+	}
+
+	saved, err := c.DownloadAttachments(items[0].ID, t.TempDir())
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("unexpected download result: %v %v", saved, err)
+	}
+}
+
+func TestPOP3Client_AuthFailure(t *testing.T) {
+	host, port, _ := fakePOP3Server(t, nil, nil)
+	c := NewPOP3Client(host, port, "user", "wrong", false, t.TempDir())
+	c.SetAllowInsecure(true)
+	err := c.Connect()
+	if err == nil || !strings.Contains(err.Error(), "login error") {
+		t.Fatalf("expected login error, got %v", err)
+	}
+	if _, err := c.FetchMailList(10, 0); err == nil || err.Error() != "not connected" {
+		t.Fatalf("expected 'not connected', got %v", err)
+	}
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected an AuthError, got %T", err)
+	}
+	if st := c.Status(); st.State != StateAuthFailed || !strings.Contains(st.LastError, "auth failed") {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestPOP3Client_RequiresVerifiedTLS(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	stls := tlsServer.TLS.Clone()
+	stls.NextProtos = nil
+	trusted := x509.NewCertPool()
+	trusted.AddCert(tlsServer.Certificate())
+
+	// Without STLS the password is not sent in cleartext unless allowed.
+	host, port, _, plain := fakePOP3ServerWithSTLS(t, []string{sampleEML}, []string{"uid-001"}, nil)
+	c := NewPOP3Client(host, port, "user", "secret", false, t.TempDir())
+	if err := c.Connect(); err == nil || !errors.Is(err, errNoSTLS) || *plain != 0 {
+		t.Fatalf("expected a refused cleartext login, got %v with %d passwords", err, *plain)
+	}
+
+	host, port, _, plain = fakePOP3ServerWithSTLS(t, []string{sampleEML}, []string{"uid-001"}, stls)
+	c = NewPOP3Client(host, port, "user", "secret", false, t.TempDir())
+	var unknown x509.UnknownAuthorityError
+	if err := c.Connect(); !errors.As(err, &unknown) {
+		t.Fatalf("expected an untrusted certificate to be refused, got %v", err)
+	}
+
+	c = NewPOP3Client(host, port, "user", "secret", false, t.TempDir())
+	c.rootCAs = trusted
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect over STLS failed: %v", err)
+	}
+	defer c.Disconnect()
+	if items, _ := c.FetchMailList(10, 0); len(items) != 1 || *plain != 0 {
+		t.Fatalf("expected one mail and no cleartext password, got %v and %d", items, *plain)
+	}
+}
+
+func TestPOP3Client_TimesOutOnStalledServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	// The server logs in and then never answers UIDL.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "+OK ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(strings.TrimSpace(line)) {
+			case "UIDL":
+			case "STLS":
+				fmt.Fprint(conn, "-ERR not supported\r\n")
+			default:
+				fmt.Fprint(conn, "+OK\r\n")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	c := NewPOP3Client(addr.IP.String(), addr.Port, "user", "secret", false, t.TempDir())
+	c.SetAllowInsecure(true)
+	c.timeout = 200 * time.Millisecond
+	start := time.Now()
+	var netErr net.Error
+	if err := c.Connect(); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("sync took %v", elapsed)
+	}
+}
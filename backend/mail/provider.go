@@ -0,0 +1,92 @@
+package mail
+
+import (
+	"io"
+	"sort"
+
+	"knot-backend/proxy"
+)
+
+// Provider is a source of mail the API layer can list, read and download from.
+// MailClient (IMAP), POP3Client, MaildirProvider and DemoProvider implement it.
+type Provider interface {
+	Connect() error
+	Disconnect()
+	FetchMailList(limit int, days int) ([]MailItem, error)
+	FetchMailDetail(mailID string) (map[string]interface{}, error)
+	FetchAttachments(mailID string) ([]map[string]interface{}, error)
+	DownloadAttachments(mailID string, savePath string) ([]string, error)
+	ListFolders() ([]string, error)
+	SelectFolder(name string) error
+	Search(criteria SearchCriteria) ([]MailItem, error)
+}
+
+// Organizer is implemented by providers that can flag or move messages on
+// the source side. Rules use it for their tag and move actions.
+type Organizer interface {
+	TagMail(mailID string, tag string) error
+	MoveMail(mailID string, folder string) error
+}
+
+// Proxied is implemented by providers that connect to a mail server and
+// can do so through a proxy.
+type Proxied interface {
+	SetProxy(cfg proxy.Config)
+}
+
+// Tracer is implemented by providers that can log their protocol exchange
+// for debugging a server.
+type Tracer interface {
+	SetTrace(w io.Writer)
+}
+
+// SearchCriteria narrows a search within the selected folder. Empty fields
+// are ignored.
+type SearchCriteria struct {
+	Text    string `json:"text"`
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Days    int    `json:"days"`
+	Limit   int    `json:"limit"`
+}
+
+var (
+	_ Provider = (*MailClient)(nil)
+	_ Provider = (*POP3Client)(nil)
+	_ Provider = (*MaildirProvider)(nil)
+	_ Provider = (*DemoProvider)(nil)
+
+	_ Organizer = (*MailClient)(nil)
+	_ Organizer = (*MaildirProvider)(nil)
+
+	_ ServerThreader = (*MailClient)(nil)
+
+	_ SecureReader = (*MailClient)(nil)
+	_ SecureReader = (*POP3Client)(nil)
+	_ SecureReader = (*MaildirProvider)(nil)
+
+	_ Proxied = (*MailClient)(nil)
+	_ Proxied = (*POP3Client)(nil)
+
+	_ Tracer = (*MailClient)(nil)
+
+	_ StatusReporter = (*MailClient)(nil)
+	_ StatusReporter = (*POP3Client)(nil)
+	_ StatusReporter = (*MaildirProvider)(nil)
+	_ StatusReporter = (*DemoProvider)(nil)
+)
+
+// sortMailItems orders items newest first.
+func sortMailItems(items []MailItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Timestamp > items[j].Timestamp
+	})
+}
+
+// limitMailItems keeps the newest limit items of an already sorted slice.
+func limitMailItems(items []MailItem, limit int) []MailItem {
+	if limit > 0 && len(items) > limit {
+		return items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,227 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleEML = "From: Zhang San <zhangsan@example.com>\r\n" +
+	"To: ops@example.com\r\n" +
+	"Subject: =?UTF-8?B?5rWL6K+V?=\r\n" +
+	"Date: Mon, 20 Apr 2026 10:00:00 +0800\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"quarterly budget draft\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"plan.txt\"\r\n" +
+	"\r\n" +
+	"plan body\r\n" +
+	"--b1--\r\n"
+
+func TestMaildirProvider_EMLDirectory(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "a.eml"), []byte(sampleEML), 0o644)
+	_ = os.MkdirAll(filepath.Join(root, "Archive"), 0o755)
+
+	p := NewMaildirProvider(root)
+	if _, err := p.FetchMailList(10, 0); err == nil || err.Error() != "not connected" {
+		t.Fatalf("expected 'not connected', got %v", err)
+	}
+	if err := p.Connect(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+
+	items, err := p.FetchMailList(10, 0)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected 1 mail, got %d", len(items))
+	}
+	if items[0].Subject != "测试" || items[0].From != "Zhang San" || items[0].AttachmentCount != 1 {
+		t.Fatalf("unexpected item: %+v", items[0])
+	}
+
+	detail, err := p.FetchMailDetail(items[0].ID)
+	if err != nil {
+		t.Fatalf("detail failed: %v", err)
+	}
+	if !strings.Contains(detail["body"].(string), "quarterly budget") {
+		t.Fatalf("unexpected body: %v", detail["body"])
+	}
+	if from, _ := detail["from"].([]Address); len(from) != 1 || from[0] != items[0].FromAddresses[0] {
+		t.Fatalf("expected the detail to carry the same sender as the list, got %v", detail["from"])
+	}
+
+	saveDir := t.TempDir()
+	saved, err := p.DownloadAttachments(items[0].ID, saveDir)
+	if err != nil || len(saved) != 1 || saved[0] != "plan.txt" {
+		t.Fatalf("unexpected download result: %v %v", saved, err)
+	}
+
+	folders, _ := p.ListFolders()
+	if len(folders) != 2 || folders[0] != "INBOX" || folders[1] != "Archive" {
+		t.Fatalf("unexpected folders: %v", folders)
+	}
+}
+
+func TestMaildirProvider_MaildirLayoutAndSearch(t *testing.T) {
+	root := t.TempDir()
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		_ = os.MkdirAll(filepath.Join(root, sub), 0o755)
+	}
+	_ = os.WriteFile(filepath.Join(root, "cur", "1700000000.1.host:2,S"), []byte(sampleEML), 0o644)
+
+	p := NewMaildirProvider(root)
+	_ = p.Connect()
+
+	hits, err := p.Search(SearchCriteria{Text: "budget"})
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("expected one hit, got %v %v", hits, err)
+	}
+	item := hits[0]
+	if len(item.FromAddresses) != 1 || item.FromAddresses[0] != (Address{Name: "Zhang San", Address: "zhangsan@example.com"}) ||
+		len(item.To) != 1 || item.To[0].Address != "ops@example.com" {
+		t.Fatalf("unexpected addresses: %+v", item)
+	}
+	if !item.Seen || item.Flagged || len(item.Flags) != 1 || item.Flags[0] != "\\Seen" {
+		t.Fatalf("unexpected flags: %+v", item)
+	}
+	if item.Size != int64(len(sampleEML)) || item.Timestamp != 1776650400 || item.Snippet != "quarterly budget draft" {
+		t.Fatalf("unexpected size, timestamp or snippet: %+v", item)
+	}
+	misses, _ := p.Search(SearchCriteria{From: "lisi"})
+	if len(misses) != 0 {
+		t.Fatalf("expected no hits, got %v", misses)
+	}
+}
+
+func TestMaildirProvider_IDSurvivesTagging(t *testing.T) {
+	root := t.TempDir()
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		_ = os.MkdirAll(filepath.Join(root, sub), 0o755)
+	}
+	_ = os.WriteFile(filepath.Join(root, "new", "1700000000.2.host"), []byte(sampleEML), 0o644)
+
+	p := NewMaildirProvider(root)
+	_ = p.Connect()
+	items, _ := p.FetchMailList(10, 0)
+	if len(items) != 1 {
+		t.Fatalf("expected one mail, got %v", items)
+	}
+	id := items[0].ID
+
+	if err := p.TagMail(id, "\\Flagged"); err != nil {
+		t.Fatalf("tag failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cur", "1700000000.2.host:2,F")); err != nil {
+		t.Fatalf("expected the message in cur/: %v", err)
+	}
+
+	// A fresh provider has no index of the old path.
+	p = NewMaildirProvider(root)
+	_ = p.Connect()
+	if _, err := p.FetchMailDetail(id); err != nil {
+		t.Fatalf("expected the ID to survive the move to cur/: %v", err)
+	}
+	items, _ = p.FetchMailList(10, 0)
+	if len(items) != 1 || items[0].ID != id || !items[0].Flagged {
+		t.Fatalf("unexpected mail after tagging: %+v", items)
+	}
+}
+
+func TestMaildirProvider_RejectsFolderNamesOutsideRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "mail")
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		_ = os.MkdirAll(filepath.Join(root, sub), 0o755)
+	}
+	_ = os.WriteFile(filepath.Join(root, "cur", "1700000000.3.host:2,S"), []byte(sampleEML), 0o644)
+
+	p := NewMaildirProvider(root)
+	_ = p.Connect()
+	items, _ := p.FetchMailList(10, 0)
+	if len(items) != 1 {
+		t.Fatalf("expected one mail, got %v", items)
+	}
+
+	for _, name := range []string{"..", "../..", "../x", ".hidden", "a/b", `a\b`, "cur"} {
+		if err := p.SelectFolder(name); err == nil {
+			t.Errorf("expected select %q to be refused", name)
+		}
+		if err := p.MoveMail(items[0].ID, name); err == nil {
+			t.Errorf("expected move to %q to be refused", name)
+		}
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+		t.Fatalf("expected nothing created next to the root, found %d entries", len(entries))
+	}
+
+	if err := p.MoveMail(items[0].ID, "Archive"); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if err := p.SelectFolder("Archive"); err != nil {
+		t.Fatalf("select failed: %v", err)
+	}
+	if items, _ = p.FetchMailList(10, 0); len(items) != 1 {
+		t.Fatalf("expected the moved mail in Archive, got %v", items)
+	}
+}
+
+func TestDemoProvider_ListDetailAndDownload(t *testing.T) {
+	p := NewDemoProvider()
+	_ = p.Connect()
+
+	items, err := p.FetchMailList(50, 30)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(items) != len(demoMails) {
+		t.Fatalf("expected %d mails, got %d", len(demoMails), len(items))
+	}
+	if items[0].ID != "1" {
+		t.Fatalf("expected newest mail first, got %s", items[0].ID)
+	}
+
+	attachments, _ := p.FetchAttachments("5")
+	if len(attachments) != 3 {
+		t.Fatalf("expected 3 attachments, got %d", len(attachments))
+	}
+
+	saved, err := p.DownloadAttachments("1", t.TempDir())
+	if err != nil || len(saved) != 2 {
+		t.Fatalf("unexpected download result: %v %v", saved, err)
+	}
+
+	if _, err := p.FetchMailDetail("999"); err == nil {
+		t.Fatal("expected error for unknown mail")
+	}
+}
+
+func TestMakeSnippet(t *testing.T) {
+	html := "<style>p{}</style><p>Hello&nbsp;<b>team</b>,</p>\n\n<p>see   below</p>"
+	if got := makeSnippet(html, true); got != "Hello\u00a0 team , see below" {
+		t.Fatalf("unexpected html snippet: %q", got)
+	}
+	long := strings.Repeat("字", snippetLength+10)
+	if got := []rune(makeSnippet(long, false)); len(got) != snippetLength+1 {
+		t.Fatalf("expected truncated snippet, got %d runes", len(got))
+	}
+}
+
+func TestDecodePartText(t *testing.T) {
+	// A partial fetch can cut base64 in the middle of a quantum.
+	if got := decodePartText([]byte("5rWL6K+V\r\n5rWL6K"), "base64", "utf-8"); got != "测试测" {
+		t.Fatalf("unexpected base64 text: %q", got)
+	}
+	if got := decodePartText([]byte("caf=C3=A9 ok"), "quoted-printable", ""); got != "café ok" {
+		t.Fatalf("unexpected quoted-printable text: %q", got)
+	}
+}
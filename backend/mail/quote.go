@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// quoteSeparatorPatterns start the quoted history of a reply or forward.
+	quoteSeparatorPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^-{2,}\s*(原始邮件|原邮件|转发邮件|转发的邮件|original message|forwarded message)\s*-{2,}$`),
+		regexp.MustCompile(`^_{10,}$`),
+		attributionPattern,
+		regexp.MustCompile(`^(在.+|.+于.+)写道\s*[:：]$`),
+	}
+	attributionPattern = regexp.MustCompile(`(?i)^on\s.+\swrote:$`)
+	// quoteHeaderPattern and quoteHeaderNextPattern match the header block
+	// Outlook and Foxmail put above a quoted mail instead of a separator.
+	quoteHeaderPattern     = regexp.MustCompile(`(?i)^\*?(from|发件人)\s*\*?\s*[:：]`)
+	quoteHeaderNextPattern = regexp.MustCompile(`(?i)^\*?(sent|date|to|subject|发送时间|日期|时间|收件人|主题)\s*\*?\s*[:：]`)
+	// signaturePatterns start a signature block.
+	signaturePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^--\s?$`),
+		regexp.MustCompile(`^—{2,}\s*$`),
+		regexp.MustCompile(`(?i)^(sent from my|get outlook for|发自我的|来自我的|从我的).*$`),
+	}
+)
+
+// ExtractNewContent returns what the sender wrote in body, without the quoted
+// history of earlier mails and without the signature. Lines quoted with ">"
+// are dropped. When nothing is left, for example for a bare forward, the
+// whole body is returned.
+func ExtractNewContent(body string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+
+	var kept []string
+cut:
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		for _, p := range quoteSeparatorPatterns {
+			if p.MatchString(line) {
+				break cut
+			}
+		}
+		// "On <date>, <name> <addr>" is often wrapped before "wrote:".
+		if i+1 < len(lines) && strings.HasPrefix(strings.ToLower(line), "on ") &&
+			attributionPattern.MatchString(line+" "+strings.TrimSpace(lines[i+1])) {
+			break cut
+		}
+		if quoteHeaderPattern.MatchString(line) && quoteHeaderFollows(lines[i+1:]) {
+			break cut
+		}
+		for _, p := range signaturePatterns {
+			if p.MatchString(line) {
+				break cut
+			}
+		}
+		kept = append(kept, lines[i])
+	}
+
+	content := strings.TrimSpace(strings.Join(kept, "\n"))
+	if content == "" {
+		return strings.TrimSpace(body)
+	}
+	return content
+}
+
+// quoteHeaderFollows reports whether the lines after a From: line continue a
+// quoted header block.
+func quoteHeaderFollows(lines []string) bool {
+	for i := 0; i < len(lines) && i < 3; i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		return quoteHeaderNextPattern.MatchString(line)
+	}
+	return false
+}
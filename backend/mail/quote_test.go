@@ -0,0 +1,55 @@
+package mail
+
+import "testing"
+
+func TestExtractNewContent(t *testing.T) {
+	cases := []struct {
+		name, body, want string
+	}{
+		{
+			name: "chinese original message",
+			body: "收到，我周五前提交。\r\n\r\n-----原始邮件-----\r\n发件人: 张三\r\n主题: 预算\r\n\r\n请提交预算。\r\n",
+			want: "收到，我周五前提交。",
+		},
+		{
+			name: "wrapped english attribution",
+			body: "Sounds good.\n\nOn Mon, Apr 20, 2026 at 10:00 AM Zhang San <\nzhangsan@example.com> wrote:\n> Can we meet?\n",
+			want: "Sounds good.",
+		},
+		{
+			name: "foxmail attribution",
+			body: "好的\n\n在 2026年4月20日 10:00，张三 写道：\n请确认\n",
+			want: "好的",
+		},
+		{
+			name: "outlook header block",
+			body: "Please see below.\n\nFrom: Zhang San\nSent: Monday, April 20, 2026 10:00\nTo: ops\nSubject: budget\n\nold text\n",
+			want: "Please see below.",
+		},
+		{
+			name: "interleaved quotes and signature",
+			body: "> question one?\nanswer one\n> question two?\nanswer two\n\n-- \nLi Si\nOps team\n",
+			want: "answer one\nanswer two",
+		},
+		{
+			name: "mobile signature",
+			body: "同意\n\n发自我的iPhone\n",
+			want: "同意",
+		},
+		{
+			name: "from line in content is kept",
+			body: "From: the list above, pick one.\nThanks\n",
+			want: "From: the list above, pick one.\nThanks",
+		},
+		{
+			name: "bare forward keeps everything",
+			body: "---------- Forwarded message ---------\nFrom: a\n\nhello\n",
+			want: "---------- Forwarded message ---------\nFrom: a\n\nhello",
+		},
+	}
+	for _, c := range cases {
+		if got := ExtractNewContent(c.body); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
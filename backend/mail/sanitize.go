@@ -0,0 +1,407 @@
+package mail
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SanitizeOptions controls how SanitizeHTML treats remote images.
+type SanitizeOptions struct {
+	// ImageProxy, when set, is the URL prefix remote images are loaded
+	// through; the escaped image URL is appended to it. When empty, remote
+	// images are blocked.
+	ImageProxy string
+}
+
+// SanitizeResult is the safe HTML of a mail and what was removed from it.
+type SanitizeResult struct {
+	HTML string `json:"html"`
+	// RemoteImages is the number of remote images that were blocked or
+	// routed through the proxy.
+	RemoteImages int `json:"remote_images"`
+	// TrackingPixels is the number of 1x1 images that were dropped.
+	TrackingPixels int `json:"tracking_pixels"`
+}
+
+// sanitizeAllowedTags are kept with their allowed attributes. Every other
+// tag is dropped, but its content is kept unless it is in sanitizeDropContent.
+var sanitizeAllowedTags = map[string]bool{
+	"a": true, "abbr": true, "address": true, "b": true, "big": true, "blockquote": true,
+	"br": true, "caption": true, "center": true, "cite": true, "code": true, "col": true,
+	"colgroup": true, "dd": true, "del": true, "div": true, "dl": true, "dt": true,
+	"em": true, "font": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true, "li": true,
+	"ol": true, "p": true, "pre": true, "q": true, "s": true, "small": true, "span": true,
+	"strike": true, "strong": true, "style": true, "sub": true, "sup": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "tr": true,
+	"tt": true, "u": true, "ul": true,
+}
+
+// sanitizeDropContent are removed together with everything inside them.
+var sanitizeDropContent = map[string]bool{
+	"script": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "noscript": true, "noembed": true, "noframes": true,
+	"template": true, "textarea": true, "select": true, "button": true, "svg": true,
+	"math": true, "title": true, "xmp": true, "plaintext": true,
+}
+
+// sanitizeRawText elements hold text that must not be parsed as markup.
+var sanitizeRawText = map[string]bool{
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+}
+
+var sanitizeVoidTags = map[string]bool{"br": true, "col": true, "hr": true, "img": true}
+
+var sanitizeAllowedAttrs = map[string]bool{
+	"align": true, "alt": true, "bgcolor": true, "border": true, "cellpadding": true,
+	"cellspacing": true, "class": true, "color": true, "colspan": true, "dir": true,
+	"face": true, "height": true, "lang": true, "nowrap": true, "rowspan": true,
+	"size": true, "span": true, "start": true, "style": true, "title": true,
+	"type": true, "valign": true, "width": true,
+}
+
+var (
+	cssDangerPattern  = regexp.MustCompile(`(?i)url\s*\(|expression\s*\(|javascript:|vbscript:|behavior\s*:|-moz-binding|@import`)
+	dataImagePattern  = regexp.MustCompile(`(?i)^data:image/(png|gif|jpe?g|webp|bmp);base64,[a-z0-9+/=\s]+$`)
+	tagNamePattern    = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9:-]*`)
+	attrNameTerminals = " \t\r\n\f/>="
+)
+
+// SanitizeHTML reduces a mail's HTML to an allowlist of formatting tags and
+// attributes. Scripts, event handlers, forms, frames and non-http(s) links
+// are removed, CSS that can load resources is dropped and remote images are
+// blocked or routed through opts.ImageProxy. Tracking pixels are always
+// removed.
+func SanitizeHTML(src string, opts SanitizeOptions) SanitizeResult {
+	var out strings.Builder
+	var result SanitizeResult
+	var open []string // allowed tags currently open
+	dropDepth := 0    // >0 while inside a sanitizeDropContent element
+
+	i := 0
+	for i < len(src) {
+		lt := strings.IndexByte(src[i:], '<')
+		if lt < 0 {
+			if dropDepth == 0 {
+				out.WriteString(escapeText(src[i:]))
+			}
+			break
+		}
+		if lt > 0 && dropDepth == 0 {
+			out.WriteString(escapeText(src[i : i+lt]))
+		}
+		i += lt
+
+		rest := src[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += 4 + end + 3
+			}
+			continue
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 1
+			}
+			continue
+		}
+
+		closing := strings.HasPrefix(rest, "</")
+		nameStart := 1
+		if closing {
+			nameStart = 2
+		}
+		name := strings.ToLower(tagNamePattern.FindString(rest[nameStart:]))
+		if name == "" {
+			// A lone "<" is text.
+			if dropDepth == 0 {
+				out.WriteString("&lt;")
+			}
+			i++
+			continue
+		}
+
+		attrs, selfClosing, n := parseTagAttributes(rest[nameStart+len(name):])
+		i += nameStart + len(name) + n
+
+		if closing {
+			if sanitizeDropContent[name] {
+				if dropDepth > 0 {
+					dropDepth--
+				}
+				continue
+			}
+			if dropDepth > 0 || !sanitizeAllowedTags[name] {
+				continue
+			}
+			// Close up to the matching open tag so the output stays balanced.
+			for k := len(open) - 1; k >= 0; k-- {
+				if open[k] == name {
+					for len(open) > k {
+						out.WriteString("</" + open[len(open)-1] + ">")
+						open = open[:len(open)-1]
+					}
+					break
+				}
+			}
+			continue
+		}
+
+		if sanitizeRawText[name] && !selfClosing {
+			// Skip to the end tag; the content is never parsed as markup.
+			content, consumed := rawTextContent(src[i:], name)
+			i += consumed
+			if name == "style" && dropDepth == 0 {
+				out.WriteString("<style>" + sanitizeCSS(content) + "</style>")
+			}
+			continue
+		}
+		if sanitizeDropContent[name] {
+			if !selfClosing {
+				dropDepth++
+			}
+			continue
+		}
+		if dropDepth > 0 || !sanitizeAllowedTags[name] {
+			continue
+		}
+
+		tag, ok := sanitizeTag(name, attrs, opts, &result)
+		if !ok {
+			continue
+		}
+		out.WriteString(tag)
+		switch {
+		case sanitizeVoidTags[name]:
+		case selfClosing:
+			out.WriteString("</" + name + ">")
+		default:
+			open = append(open, name)
+		}
+	}
+
+	for k := len(open) - 1; k >= 0; k-- {
+		out.WriteString("</" + open[k] + ">")
+	}
+	result.HTML = out.String()
+	return result
+}
+
+type htmlAttr struct {
+	name, value string
+}
+
+// parseTagAttributes reads the attributes of a start tag up to and including
+// its closing ">". It returns the number of bytes consumed.
+func parseTagAttributes(s string) ([]htmlAttr, bool, int) {
+	var attrs []htmlAttr
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '>':
+			return attrs, i > 0 && s[i-1] == '/', i + 1
+		case strings.IndexByte(" \t\r\n\f/", c) >= 0:
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(s) && strings.IndexByte(attrNameTerminals, s[i]) < 0 {
+			i++
+		}
+		if i == start {
+			// A stray "=": skip it.
+			i++
+			continue
+		}
+		attr := htmlAttr{name: strings.ToLower(s[start:i])}
+		for i < len(s) && strings.IndexByte(" \t\r\n\f", s[i]) >= 0 {
+			i++
+		}
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && strings.IndexByte(" \t\r\n\f", s[i]) >= 0 {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				quote := s[i]
+				end := strings.IndexByte(s[i+1:], quote)
+				if end < 0 {
+					attr.value = s[i+1:]
+					i = len(s)
+				} else {
+					attr.value = s[i+1 : i+1+end]
+					i += end + 2
+				}
+			} else {
+				vs := i
+				for i < len(s) && strings.IndexByte(" \t\r\n\f>", s[i]) < 0 {
+					i++
+				}
+				attr.value = s[vs:i]
+			}
+		}
+		attr.value = html.UnescapeString(attr.value)
+		attrs = append(attrs, attr)
+	}
+	return attrs, false, len(s)
+}
+
+// rawTextContent returns the text of a raw-text element up to its end tag and
+// the number of bytes consumed, end tag included.
+func rawTextContent(s, name string) (string, int) {
+	lower := strings.ToLower(s)
+	end := strings.Index(lower, "</"+name)
+	if end < 0 {
+		return s, len(s)
+	}
+	gt := strings.IndexByte(s[end:], '>')
+	if gt < 0 {
+		return s[:end], len(s)
+	}
+	return s[:end], end + gt + 1
+}
+
+func sanitizeTag(name string, attrs []htmlAttr, opts SanitizeOptions, result *SanitizeResult) (string, bool) {
+	var b strings.Builder
+	b.WriteString("<" + name)
+	write := func(key, value string) {
+		b.WriteString(" " + key + `="` + html.EscapeString(value) + `"`)
+	}
+
+	var src, width, height string
+	for _, a := range attrs {
+		switch {
+		case name == "a" && a.name == "href":
+			if href, ok := safeLinkURL(a.value); ok {
+				write("href", href)
+			}
+		case name == "img" && a.name == "src":
+			src = strings.TrimSpace(a.value)
+		case a.name == "style":
+			if css := sanitizeCSS(a.value); strings.TrimSpace(css) != "" {
+				write("style", css)
+			}
+		case sanitizeAllowedAttrs[a.name]:
+			if name == "img" && a.name == "width" {
+				width = a.value
+			}
+			if name == "img" && a.name == "height" {
+				height = a.value
+			}
+			write(a.name, a.value)
+		}
+	}
+
+	switch name {
+	case "a":
+		b.WriteString(` target="_blank" rel="noopener noreferrer nofollow"`)
+	case "img":
+		if isTrackingPixel(width, height) {
+			result.TrackingPixels++
+			return "", false
+		}
+		switch lower := strings.ToLower(src); {
+		case strings.HasPrefix(lower, "cid:"), dataImagePattern.MatchString(src):
+			write("src", src)
+		case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "//"):
+			result.RemoteImages++
+			if strings.HasPrefix(src, "//") {
+				src = "https:" + src
+			}
+			if opts.ImageProxy != "" {
+				write("src", opts.ImageProxy+url.QueryEscape(src))
+			} else {
+				write("data-remote-src", src)
+			}
+		default:
+			// Other schemes and relative URLs have nothing to load.
+		}
+	}
+	b.WriteString(">")
+	return b.String(), true
+}
+
+// safeLinkURL accepts web, mail and phone links and in-page anchors.
+func safeLinkURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "#") {
+		return raw, true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto", "tel":
+		return u.String(), true
+	}
+	return "", false
+}
+
+func isTrackingPixel(width, height string) bool {
+	dim := func(v string) (int, bool) {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px"))
+		return n, err == nil
+	}
+	w, wok := dim(width)
+	h, hok := dim(height)
+	return (wok && w <= 1) && (hok && h <= 1)
+}
+
+// sanitizeCSS drops declarations and rules that can run code or load
+// resources. Layout and colours are left alone.
+func sanitizeCSS(css string) string {
+	css = strings.NewReplacer("<", "", ">", "").Replace(css)
+	if !cssDangerPattern.MatchString(css) {
+		return css
+	}
+	// Drop each offending declaration, whether inside a rule or inline.
+	var out strings.Builder
+	for _, decl := range splitCSSDeclarations(css) {
+		if cssDangerPattern.MatchString(decl) {
+			// Keep the braces that structure the stylesheet.
+			out.WriteString(strings.Map(func(r rune) rune {
+				if r == '{' || r == '}' {
+					return r
+				}
+				return -1
+			}, decl))
+			continue
+		}
+		out.WriteString(decl)
+	}
+	return out.String()
+}
+
+// splitCSSDeclarations splits css after every ';', '{' and '}' so that each
+// piece holds at most one declaration.
+func splitCSSDeclarations(css string) []string {
+	var parts []string
+	start := 0
+	for i, r := range css {
+		if r == ';' || r == '{' || r == '}' {
+			parts = append(parts, css[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(css) {
+		parts = append(parts, css[start:])
+	}
+	return parts
+}
+
+func escapeText(s string) string {
+	return html.EscapeString(html.UnescapeString(s))
+}
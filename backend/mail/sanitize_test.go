@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"script", `<p>hi<script>alert("<p>x</p>")</script></p>`, `<p>hi</p>`},
+		{"event handler", `<img src="cid:logo" onerror="alert(1)" alt="logo">`, `<img alt="logo" src="cid:logo">`},
+		{"javascript link", `<a href=" JaVaScRiPt:alert(1)" onclick="x()">x</a>`, `<a target="_blank" rel="noopener noreferrer nofollow">x</a>`},
+		{"entity-encoded javascript link", `<a href="&#106;avascript:alert(1)">x</a>`, `<a target="_blank" rel="noopener noreferrer nofollow">x</a>`},
+		{"web link", `<a href="https://example.com/a?b=1&amp;c=2">x</a>`, `<a href="https://example.com/a?b=1&amp;c=2" target="_blank" rel="noopener noreferrer nofollow">x</a>`},
+		{"form", `<form action="https://evil"><input name="pw"><button>Go</button>text</form>`, `text`},
+		{"iframe", `<iframe src="https://evil"></iframe><div>ok</div>`, `<div>ok</div>`},
+		{"comment and doctype", `<!DOCTYPE html><!-- <script>x</script> --><b>ok</b>`, `<b>ok</b>`},
+		{"unknown tags keep text", `<o:p>内容</o:p><center>c</center>`, `内容<center>c</center>`},
+		{"css url", `<div style="color: red; background: url(https://t.example/p.gif); width: 10px">x</div>`, `<div style="color: red; width: 10px">x</div>`},
+		{"style block", `<style>body{color:red}@import url(x.css);.a{background:url(y)}</style>`, `<style>body{color:red}.a{}</style>`},
+		{"unbalanced", `<div><b>x</div>y`, `<div><b>x</b></div>y`},
+		{"text escaping", `a < b &amp; c > d`, `a &lt; b &amp; c &gt; d`},
+		{"svg", `<svg><script>x</script><a xlink:href="javascript:1">t</a></svg>after`, `after`},
+		{"data image", `<img src="data:image/png;base64,iVBORw0KGgo=">`, `<img src="data:image/png;base64,iVBORw0KGgo=">`},
+		{"data html", `<img src="data:text/html;base64,PHNjcmlwdD4=">`, `<img>`},
+	}
+	for _, c := range cases {
+		if got := SanitizeHTML(c.in, SanitizeOptions{}).HTML; got != c.want {
+			t.Errorf("%s:\n got %s\nwant %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSanitizeHTML_RemoteImages(t *testing.T) {
+	in := `<img src="https://cdn.example.com/a.png" width="200">` +
+		`<img src="//cdn.example.com/b.png">` +
+		`<img src="https://t.example.com/open.gif" width="1" height="1">`
+
+	blocked := SanitizeHTML(in, SanitizeOptions{})
+	if blocked.RemoteImages != 2 || blocked.TrackingPixels != 1 {
+		t.Fatalf("unexpected counts: %+v", blocked)
+	}
+	if strings.Contains(blocked.HTML, " src=") || strings.Contains(blocked.HTML, "open.gif") ||
+		!strings.Contains(blocked.HTML, `data-remote-src="https://cdn.example.com/b.png"`) {
+		t.Fatalf("remote images not blocked: %s", blocked.HTML)
+	}
+
+	proxied := SanitizeHTML(in, SanitizeOptions{ImageProxy: "http://127.0.0.1:8000/api/mail/image-proxy?url="})
+	want := `<img width="200" src="http://127.0.0.1:8000/api/mail/image-proxy?url=https%3A%2F%2Fcdn.example.com%2Fa.png">`
+	if !strings.HasPrefix(proxied.HTML, want) || strings.Contains(proxied.HTML, "open.gif") {
+		t.Fatalf("unexpected proxied html: %s", proxied.HTML)
+	}
+}
@@ -0,0 +1,414 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Security holds what providers need to check signed mail and open
+// encrypted mail: the roots signer certificates must chain to and the
+// user's S/MIME decryption key. A nil *Security verifies against the
+// system roots and cannot decrypt.
+type Security struct {
+	roots *x509.CertPool
+	key   *rsa.PrivateKey
+	cert  *x509.Certificate
+}
+
+// SecureReader is implemented by providers that verify and decrypt S/MIME
+// mail when reading a message.
+type SecureReader interface {
+	SetSecurity(sec *Security)
+}
+
+// LoadSecurity reads a trust store and a decryption key. trustStore is a
+// PEM or DER certificate file or a directory of them; empty means the
+// system roots. keyFile is a PEM file with the RSA private key and,
+// optionally, its certificate; empty means mail is not decrypted.
+func LoadSecurity(trustStore, keyFile string) (*Security, error) {
+	sec := &Security{}
+	if trustStore = strings.TrimSpace(trustStore); trustStore != "" {
+		pool, err := loadCertPool(trustStore)
+		if err != nil {
+			return nil, err
+		}
+		sec.roots = pool
+	}
+	if keyFile = strings.TrimSpace(keyFile); keyFile != "" {
+		key, cert, err := loadDecryptionKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		sec.key, sec.cert = key, cert
+	}
+	return sec, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("trust store: %w", err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("trust store: %w", err)
+		}
+		files = files[:0]
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".pem", ".crt", ".cer", ".der":
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	count := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("trust store: %w", err)
+		}
+		if bytes.Contains(data, []byte("-----BEGIN")) {
+			for {
+				var block *pem.Block
+				block, data = pem.Decode(data)
+				if block == nil {
+					break
+				}
+				if block.Type != "CERTIFICATE" {
+					continue
+				}
+				if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+					pool.AddCert(cert)
+					count++
+				}
+			}
+		} else if cert, err := x509.ParseCertificate(data); err == nil {
+			pool.AddCert(cert)
+			count++
+		}
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("trust store %s contains no certificate", path)
+	}
+	return pool, nil
+}
+
+func loadDecryptionKey(path string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decryption key: %w", err)
+	}
+	var key *rsa.PrivateKey
+	var cert *x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+				key = k
+			}
+		case "PRIVATE KEY":
+			if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+				if rk, ok := k.(*rsa.PrivateKey); ok {
+					key = rk
+				}
+			}
+		case "CERTIFICATE":
+			if c, err := x509.ParseCertificate(block.Bytes); err == nil && cert == nil {
+				cert = c
+			}
+		}
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("decryption key %s contains no RSA private key", path)
+	}
+	return key, cert, nil
+}
+
+func (s *Security) trustRoots() *x509.CertPool {
+	if s != nil && s.roots != nil {
+		return s.roots
+	}
+	// nil makes x509 use the system roots.
+	return nil
+}
+
+// MessageSecurity describes the signing and encryption of a message. It is
+// nil in the detail of plain mail.
+type MessageSecurity struct {
+	// Protocol is "smime" or "pgp".
+	Protocol  string         `json:"protocol"`
+	Signed    bool           `json:"signed"`
+	Encrypted bool           `json:"encrypted"`
+	Decrypted bool           `json:"decrypted"`
+	Signature *SignatureInfo `json:"signature,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// SignatureInfo is the result of verifying a signature. Valid means the
+// content is unchanged and the signer chains to a trusted root.
+type SignatureInfo struct {
+	Valid    bool   `json:"valid"`
+	Signer   string `json:"signer"`
+	Email    string `json:"email"`
+	Issuer   string `json:"issuer"`
+	SignedAt string `json:"signed_at,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+const maxSecureNesting = 3
+
+// openSecureMessage unwraps signed and encrypted layers of raw. It returns
+// the message to display, with the outer headers kept and the innermost
+// content in place of the secured body, and what it found on the way.
+func openSecureMessage(raw []byte, sec *Security) ([]byte, *MessageSecurity) {
+	var info *MessageSecurity
+	ensure := func(protocol string) {
+		if info == nil {
+			info = &MessageSecurity{}
+		}
+		info.Protocol = protocol
+	}
+
+	for depth := 0; depth < maxSecureNesting; depth++ {
+		header, body, err := splitEntity(raw)
+		if err != nil {
+			return raw, info
+		}
+		mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		protocol := strings.ToLower(params["protocol"])
+
+		var inner []byte
+		switch {
+		case mediaType == "multipart/signed":
+			parts := splitMultipartRaw(canonicalCRLF(body), params["boundary"])
+			if len(parts) < 2 {
+				return raw, info
+			}
+			ensure("smime")
+			info.Signed = true
+			if strings.Contains(protocol, "pgp") {
+				info.Protocol = "pgp"
+				info.Signature = &SignatureInfo{Error: "暂不支持验证 PGP 签名"}
+			} else if sig, err := entityBody(parts[1]); err != nil {
+				info.Signature = &SignatureInfo{Error: fmt.Sprintf("无法读取签名: %v", err)}
+			} else {
+				info.Signature = signatureInfo(verifySignedData(sig, parts[0], sec.trustRoots()))
+			}
+			inner = parts[0]
+
+		case mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime":
+			der, err := entityBody(raw)
+			if err != nil {
+				return raw, info
+			}
+			ensure("smime")
+			if strings.EqualFold(params["smime-type"], "signed-data") || isSignedData(der) {
+				info.Signed = true
+				result, err := verifySignedData(der, nil, sec.trustRoots())
+				info.Signature = signatureInfo(result, err)
+				if result == nil || len(result.Content) == 0 {
+					return raw, info
+				}
+				inner = result.Content
+				break
+			}
+			info.Encrypted = true
+			if sec == nil || sec.key == nil {
+				info.Error = "邮件已加密，未配置解密私钥"
+				return raw, info
+			}
+			plain, err := decryptEnvelopedData(der, sec.key, sec.cert)
+			if err != nil {
+				info.Error = fmt.Sprintf("解密失败: %v", err)
+				return raw, info
+			}
+			info.Decrypted = true
+			inner = plain
+
+		case mediaType == "multipart/encrypted" && strings.Contains(protocol, "pgp"):
+			ensure("pgp")
+			info.Encrypted = true
+			info.Error = "暂不支持解密 PGP 邮件"
+			return raw, info
+
+		default:
+			if info == nil {
+				if bytes.Contains(body, []byte("-----BEGIN PGP MESSAGE-----")) {
+					ensure("pgp")
+					info.Encrypted = true
+					info.Error = "暂不支持解密 PGP 邮件"
+				} else if bytes.Contains(body, []byte("-----BEGIN PGP SIGNED MESSAGE-----")) {
+					ensure("pgp")
+					info.Signed = true
+					info.Signature = &SignatureInfo{Error: "暂不支持验证 PGP 签名"}
+				}
+			}
+			return raw, info
+		}
+		raw = mergeEntity(header, inner)
+	}
+	return raw, info
+}
+
+func isSignedData(der []byte) bool {
+	info, err := parseContentInfo(der)
+	return err == nil && info.ContentType.Equal(oidSignedData)
+}
+
+func signatureInfo(result *cmsSignature, err error) *SignatureInfo {
+	if err != nil {
+		return &SignatureInfo{Error: fmt.Sprintf("无法解析签名: %v", err)}
+	}
+	info := &SignatureInfo{}
+	if !result.SigningAt.IsZero() {
+		info.SignedAt = result.SigningAt.Format(time.RFC3339)
+	}
+	if cert := result.Signer; cert != nil {
+		info.Email = certEmail(cert)
+		info.Signer = cert.Subject.CommonName
+		if info.Signer == "" {
+			info.Signer = info.Email
+		}
+		info.Issuer = cert.Issuer.CommonName
+		if info.Issuer == "" {
+			info.Issuer = cert.Issuer.String()
+		}
+	}
+	switch {
+	case result.Err != nil && errors.Is(result.Err, errCMSSignatureMismatch):
+		info.Error = "签名与内容不符，邮件可能被篡改"
+	case result.Err != nil:
+		info.Error = fmt.Sprintf("签名无效: %v", result.Err)
+	case result.TrustError != nil:
+		info.Error = fmt.Sprintf("签名证书不受信任: %v", result.TrustError)
+	default:
+		info.Valid = true
+	}
+	return info
+}
+
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+func certEmail(cert *x509.Certificate) string {
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	for _, name := range cert.Subject.Names {
+		if name.Type.Equal(oidEmailAddress) {
+			if s, ok := name.Value.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// isSignaturePart reports whether a part is a detached signature rather
+// than an attachment the user sent.
+func isSignaturePart(contentType string) bool {
+	switch strings.ToLower(contentType) {
+	case "application/pkcs7-signature", "application/x-pkcs7-signature", "application/pgp-signature":
+		return true
+	}
+	return false
+}
+
+// splitEntity separates the header of a MIME entity from its raw body.
+func splitEntity(raw []byte) (textproto.Header, []byte, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return header, nil, err
+	}
+	body, err := io.ReadAll(br)
+	return header, body, err
+}
+
+// entityBody returns the body of a MIME entity with its transfer encoding
+// removed.
+func entityBody(raw []byte) ([]byte, error) {
+	e, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	return io.ReadAll(e.Body)
+}
+
+// mergeEntity puts inner in place of the body of a message with header,
+// dropping the Content-* fields that described the secured body.
+func mergeEntity(header textproto.Header, inner []byte) []byte {
+	h := header.Copy()
+	fields := h.Fields()
+	for fields.Next() {
+		if strings.HasPrefix(strings.ToLower(fields.Key()), "content-") {
+			fields.Del()
+		}
+	}
+	var b bytes.Buffer
+	_ = textproto.WriteHeader(&b, h)
+	out := bytes.TrimSuffix(b.Bytes(), []byte("\r\n"))
+	return append(out, inner...)
+}
+
+// canonicalCRLF turns every line ending into CRLF, the form S/MIME
+// signatures are computed over.
+func canonicalCRLF(b []byte) []byte {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))
+}
+
+// splitMultipartRaw returns the parts of a CRLF multipart body byte for
+// byte, as a signature covers them.
+func splitMultipartRaw(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+	delim := []byte("\r\n--" + boundary)
+	body = append([]byte("\r\n"), body...)
+
+	var parts [][]byte
+	start := -1
+	for {
+		i := bytes.Index(body, delim)
+		if i < 0 {
+			return parts
+		}
+		if start >= 0 {
+			parts = append(parts, body[:i])
+		}
+		rest := body[i+len(delim):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			return parts
+		}
+		eol := bytes.Index(rest, []byte("\r\n"))
+		if eol < 0 {
+			return parts
+		}
+		body = rest[eol+2:]
+		start = 0
+	}
+}
@@ -0,0 +1,201 @@
+package mail
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const signedBodyPart = "Content-Type: text/plain; charset=utf-8\r\n\r\n请于周五前提交材料。\r\n"
+
+func signedEML(pki *testPKI, t *testing.T, content string) string {
+	sig := pki.sign(t, []byte(content), true, time.Now().Add(-time.Minute))
+	return "From: 张三 <zhangsan@example.gov.cn>\r\n" +
+		"To: ops@example.com\r\n" +
+		"Subject: 材料提交通知\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"sig\"\r\n" +
+		"\r\n" +
+		"--sig\r\n" + content + "\r\n--sig\r\n" +
+		"Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"smime.p7s\"\r\n" +
+		"\r\n" + base64Lines(sig) + "--sig--\r\n"
+}
+
+func pkcs7MimeEML(smimeType string, der []byte) string {
+	return "From: 张三 <zhangsan@example.gov.cn>\r\n" +
+		"To: ops@example.com\r\n" +
+		"Subject: 加密通知\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: application/pkcs7-mime; smime-type=" + smimeType + "; name=\"smime.p7m\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"smime.p7m\"\r\n" +
+		"\r\n" + base64Lines(der)
+}
+
+func TestParseMessageDetail_SignedMail(t *testing.T) {
+	pki := newTestPKI(t)
+	sec := &Security{roots: pki.roots()}
+	eml := signedEML(pki, t, signedBodyPart)
+
+	detail, err := parseMessageDetail(strings.NewReader(eml), sec)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	info, _ := detail["security"].(*MessageSecurity)
+	if info == nil || !info.Signed || info.Protocol != "smime" || info.Signature == nil {
+		t.Fatalf("expected S/MIME signature, got %+v", info)
+	}
+	sig := info.Signature
+	if !sig.Valid || sig.Signer != "张三" || sig.Email != "zhangsan@example.gov.cn" || sig.SignedAt == "" || sig.Error != "" {
+		t.Fatalf("unexpected signature: %+v", sig)
+	}
+	if body, _ := detail["body"].(string); !strings.Contains(body, "请于周五前提交材料") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	if att := detail["attachments"].([]map[string]interface{}); len(att) != 0 {
+		t.Fatalf("expected smime.p7s not to be listed, got %v", att)
+	}
+
+	// Maildir files often have bare LF line endings.
+	lf := strings.ReplaceAll(eml, "\r\n", "\n")
+	detail, _ = parseMessageDetail(strings.NewReader(lf), sec)
+	if info := detail["security"].(*MessageSecurity); !info.Signature.Valid {
+		t.Fatalf("expected LF message to verify, got %+v", info.Signature)
+	}
+}
+
+func TestParseMessageDetail_SignatureProblems(t *testing.T) {
+	pki := newTestPKI(t)
+	eml := signedEML(pki, t, signedBodyPart)
+
+	tampered := strings.Replace(eml, "周五", "周六", 1)
+	detail, _ := parseMessageDetail(strings.NewReader(tampered), &Security{roots: pki.roots()})
+	sig := detail["security"].(*MessageSecurity).Signature
+	if sig.Valid || !strings.Contains(sig.Error, "篡改") {
+		t.Fatalf("expected tampered signature, got %+v", sig)
+	}
+
+	detail, _ = parseMessageDetail(strings.NewReader(eml), &Security{roots: x509.NewCertPool()})
+	sig = detail["security"].(*MessageSecurity).Signature
+	if sig.Valid || !strings.Contains(sig.Error, "不受信任") || sig.Signer != "张三" {
+		t.Fatalf("expected untrusted signer, got %+v", sig)
+	}
+}
+
+func TestParseMessageDetail_OpaqueSigned(t *testing.T) {
+	pki := newTestPKI(t)
+	der := pki.sign(t, []byte(signedBodyPart), false, time.Now())
+	detail, err := parseMessageDetail(strings.NewReader(pkcs7MimeEML("signed-data", der)), &Security{roots: pki.roots()})
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	info := detail["security"].(*MessageSecurity)
+	if !info.Signed || !info.Signature.Valid {
+		t.Fatalf("expected valid opaque signature, got %+v", info)
+	}
+	if body, _ := detail["body"].(string); !strings.Contains(body, "请于周五前提交材料") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestParseMessageDetail_EncryptedMail(t *testing.T) {
+	pki := newTestPKI(t)
+	inner := "Content-Type: multipart/mixed; boundary=\"mix\"\r\n\r\n" +
+		"--mix\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n机密内容\r\n" +
+		"--mix\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"plan.pdf\"\r\n\r\n%PDF-1.4\r\n" +
+		"--mix--\r\n"
+	eml := pkcs7MimeEML("enveloped-data", pki.encrypt(t, []byte(inner)))
+
+	detail, _ := parseMessageDetail(strings.NewReader(eml), nil)
+	info := detail["security"].(*MessageSecurity)
+	if !info.Encrypted || info.Decrypted || !strings.Contains(info.Error, "未配置解密私钥") {
+		t.Fatalf("expected undecrypted mail without a key, got %+v", info)
+	}
+
+	sec := &Security{key: pki.key, cert: pki.cert}
+	detail, err := parseMessageDetail(strings.NewReader(eml), sec)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	info = detail["security"].(*MessageSecurity)
+	if !info.Encrypted || !info.Decrypted || info.Error != "" {
+		t.Fatalf("expected decrypted mail, got %+v", info)
+	}
+	if body, _ := detail["body"].(string); !strings.Contains(body, "机密内容") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	if att := detail["attachments"].([]map[string]interface{}); len(att) != 1 || att[0]["filename"] != "plan.pdf" {
+		t.Fatalf("unexpected attachments: %v", att)
+	}
+
+	dir := t.TempDir()
+	saved, err := saveMessageAttachments(strings.NewReader(eml), dir, sec)
+	if err != nil || len(saved) != 1 || saved[0] != "plan.pdf" {
+		t.Fatalf("unexpected saved attachments: %v %v", saved, err)
+	}
+}
+
+func TestOpenSecureMessage_PGP(t *testing.T) {
+	signed := "From: a@example.com\r\nContent-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256; boundary=\"b\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nhello\r\n--b\r\nContent-Type: application/pgp-signature\r\n\r\n-----BEGIN PGP SIGNATURE-----\r\nxx\r\n-----END PGP SIGNATURE-----\r\n--b--\r\n"
+	raw, info := openSecureMessage([]byte(signed), nil)
+	if info == nil || info.Protocol != "pgp" || !info.Signed || info.Signature.Valid {
+		t.Fatalf("unexpected PGP signed info: %+v", info)
+	}
+	if !strings.Contains(string(raw), "hello") || strings.Contains(string(raw), "PGP SIGNATURE") {
+		t.Fatalf("expected signed content only, got %q", raw)
+	}
+
+	encrypted := "From: a@example.com\r\nContent-Type: text/plain\r\n\r\n-----BEGIN PGP MESSAGE-----\r\nxx\r\n-----END PGP MESSAGE-----\r\n"
+	if _, info := openSecureMessage([]byte(encrypted), nil); info == nil || !info.Encrypted || info.Protocol != "pgp" {
+		t.Fatalf("unexpected PGP encrypted info: %+v", info)
+	}
+
+	if _, info := openSecureMessage([]byte(sampleEML), nil); info != nil {
+		t.Fatalf("expected no security info for plain mail, got %+v", info)
+	}
+}
+
+func TestLoadSecurity(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	trust := filepath.Join(dir, "roots")
+	if err := os.Mkdir(trust, 0755); err != nil {
+		t.Fatal(err)
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.ca.Raw})
+	if err := os.WriteFile(filepath.Join(trust, "ca.pem"), caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "me.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pki.key)})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.cert.Raw})
+	if err := os.WriteFile(keyFile, append(keyPEM, certPEM...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sec, err := LoadSecurity(trust, keyFile)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if sec.key == nil || sec.cert == nil || sec.roots == nil {
+		t.Fatalf("expected key, certificate and roots, got %+v", sec)
+	}
+	detail, _ := parseMessageDetail(strings.NewReader(signedEML(pki, t, signedBodyPart)), sec)
+	if sig := detail["security"].(*MessageSecurity).Signature; !sig.Valid {
+		t.Fatalf("expected signature trusted by loaded store, got %+v", sig)
+	}
+
+	if _, err := LoadSecurity(filepath.Join(dir, "missing"), ""); err == nil {
+		t.Fatal("expected error for missing trust store")
+	}
+	if _, err := LoadSecurity("", filepath.Join(trust, "ca.pem")); err == nil {
+		t.Fatal("expected error for key file without a private key")
+	}
+}
@@ -0,0 +1,246 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Connection states reported in ConnectionStatus.State.
+const (
+	StateDisconnected = "disconnected"
+	StateConnected    = "connected"
+	StateIdle         = "idle"         // connected, no request for idleAfter
+	StateReconnecting = "reconnecting" // lost, retried with backoff
+	StateAuthFailed   = "auth_failed"  // login refused, no automatic retry
+)
+
+const (
+	idleAfter      = 5 * time.Minute
+	minRetryDelay  = 2 * time.Second
+	maxRetryDelay  = 5 * time.Minute
+	retryDelayBase = 2
+)
+
+// ConnectionStatus describes the connection of a provider to its account.
+// Times are RFC 3339; LatencyMS is the last measured round trip.
+type ConnectionStatus struct {
+	Provider     string   `json:"provider"`
+	Account      string   `json:"account"`
+	State        string   `json:"state"`
+	Mailbox      string   `json:"mailbox,omitempty"`
+	ConnectedAt  string   `json:"connected_at,omitempty"`
+	LastActivity string   `json:"last_activity,omitempty"`
+	LastError    string   `json:"last_error,omitempty"`
+	LastErrorAt  string   `json:"last_error_at,omitempty"`
+	Attempts     int      `json:"reconnect_attempts"`
+	NextRetry    string   `json:"next_retry,omitempty"`
+	Capabilities []string `json:"capabilities"`
+	LatencyMS    int64    `json:"latency_ms"`
+}
+
+// StatusReporter is implemented by providers that report their connection
+// state. Ping checks the connection, reconnecting when it is due, and
+// measures the latency.
+type StatusReporter interface {
+	Status() ConnectionStatus
+	Ping() error
+}
+
+// AuthError is a login the server refused. Connections that fail this way
+// are not retried until the account is connected again.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// ReconnectPendingError is returned while a lost connection waits for its
+// next reconnect attempt.
+type ReconnectPendingError struct {
+	Retry   time.Time
+	LastErr string
+}
+
+func (e *ReconnectPendingError) Error() string {
+	wait := time.Until(e.Retry).Round(time.Second)
+	if wait < 0 {
+		wait = 0
+	}
+	return fmt.Sprintf("connection lost, next reconnect in %s: %s", wait, e.LastErr)
+}
+
+// connState tracks the connection of a network provider and the backoff
+// between reconnect attempts. Attempt n waits minRetryDelay *
+// retryDelayBase^(n-1), capped at maxRetryDelay.
+type connState struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	state        string
+	connectedAt  time.Time
+	lastActivity time.Time
+	lastError    string
+	lastErrorAt  time.Time
+	attempts     int
+	nextRetry    time.Time
+	latency      time.Duration
+	caps         []string
+}
+
+func (s *connState) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *connState) connected(latency time.Duration, caps []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	s.state = StateConnected
+	s.connectedAt = now
+	s.lastActivity = now
+	s.attempts = 0
+	s.nextRetry = time.Time{}
+	s.latency = latency
+	s.caps = caps
+}
+
+// active records a successful round trip with the server.
+func (s *connState) active(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	if s.state != StateConnected {
+		s.connectedAt = now
+	}
+	s.state = StateConnected
+	s.lastActivity = now
+	s.attempts = 0
+	s.nextRetry = time.Time{}
+	if latency > 0 {
+		s.latency = latency
+	}
+}
+
+// failed records a failed connect or reconnect and schedules the next
+// attempt. A refused login stops retrying.
+func (s *connState) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	s.lastError = err.Error()
+	s.lastErrorAt = now
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		s.state = StateAuthFailed
+		s.nextRetry = time.Time{}
+		return
+	}
+	s.state = StateReconnecting
+	s.attempts++
+	delay := minRetryDelay
+	for i := 1; i < s.attempts && delay < maxRetryDelay; i++ {
+		delay *= retryDelayBase
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	s.nextRetry = now.Add(delay)
+}
+
+// lost records a broken connection; the first reconnect is immediate.
+func (s *connState) lost(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = StateReconnecting
+	s.lastError = err.Error()
+	s.lastErrorAt = s.clock()
+	s.nextRetry = time.Time{}
+}
+
+func (s *connState) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = StateDisconnected
+	s.attempts = 0
+	s.nextRetry = time.Time{}
+}
+
+// due reports whether the server may be contacted: the connection is up
+// or the next reconnect attempt is due.
+func (s *connState) due() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.state {
+	case StateConnected:
+		return true
+	case StateReconnecting:
+		return !s.clock().Before(s.nextRetry)
+	}
+	return false
+}
+
+// retry reports whether a reconnect may be attempted now.
+func (s *connState) retry() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.state == StateAuthFailed:
+		return &AuthError{Err: errors.New(s.lastError)}
+	case s.state != StateReconnecting:
+		return errors.New("not connected")
+	case s.clock().Before(s.nextRetry):
+		return &ReconnectPendingError{Retry: s.nextRetry, LastErr: s.lastError}
+	}
+	return nil
+}
+
+func (s *connState) snapshot(provider, account, mailbox string) ConnectionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := ConnectionStatus{
+		Provider:     provider,
+		Account:      account,
+		State:        s.state,
+		Mailbox:      mailbox,
+		LastError:    s.lastError,
+		Attempts:     s.attempts,
+		Capabilities: append([]string{}, s.caps...),
+		LatencyMS:    s.latency.Milliseconds(),
+	}
+	if st.State == "" {
+		st.State = StateDisconnected
+	}
+	if st.State == StateConnected && s.clock().Sub(s.lastActivity) >= idleAfter {
+		st.State = StateIdle
+	}
+	st.ConnectedAt = formatStatusTime(s.connectedAt)
+	st.LastActivity = formatStatusTime(s.lastActivity)
+	st.LastErrorAt = formatStatusTime(s.lastErrorAt)
+	if st.State == StateReconnecting {
+		st.NextRetry = formatStatusTime(s.nextRetry)
+	}
+	return st
+}
+
+// localStatus is the status of a provider that reads local files and has
+// no server connection to lose.
+func localStatus(provider, account, mailbox string, connected bool) ConnectionStatus {
+	state := StateDisconnected
+	if connected {
+		state = StateConnected
+	}
+	return ConnectionStatus{Provider: provider, Account: account, State: state, Mailbox: mailbox, Capabilities: []string{}}
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,186 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestConnState_Backoff(t *testing.T) {
+	clock := time.Date(2026, 4, 20, 9, 0, 0, 0, time.UTC)
+	s := &connState{now: func() time.Time { return clock }}
+	s.connected(30*time.Millisecond, []string{"IMAP4rev1"})
+
+	s.lost(errors.New("EOF"))
+	if err := s.retry(); err != nil {
+		t.Fatalf("expected an immediate first reconnect, got %v", err)
+	}
+
+	for i, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		s.failed(errors.New("connection refused"))
+		var pending *ReconnectPendingError
+		if err := s.retry(); !errors.As(err, &pending) || !pending.Retry.Equal(clock.Add(want)) {
+			t.Fatalf("attempt %d: expected retry after %v, got %v", i+1, want, err)
+		}
+		clock = clock.Add(want)
+		if err := s.retry(); err != nil {
+			t.Fatalf("attempt %d: expected retry to be due, got %v", i+1, err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		s.failed(errors.New("connection refused"))
+	}
+	if st := s.snapshot("imap", "a", "INBOX"); st.State != StateReconnecting || st.Attempts != 23 ||
+		st.NextRetry != clock.Add(maxRetryDelay).Format(time.RFC3339) {
+		t.Fatalf("expected capped backoff, got %+v", st)
+	}
+
+	s.active(20 * time.Millisecond)
+	clock = clock.Add(idleAfter)
+	if st := s.snapshot("imap", "a", "INBOX"); st.State != StateIdle || st.Attempts != 0 || st.LatencyMS != 20 {
+		t.Fatalf("expected idle connection, got %+v", st)
+	}
+
+	s.failed(&AuthError{Err: errors.New("invalid credentials")})
+	var authErr *AuthError
+	if err := s.retry(); !errors.As(err, &authErr) || s.due() {
+		t.Fatalf("expected no retry after a refused login, got %v", err)
+	}
+}
+
+func startMemoryIMAP(t *testing.T, addr string) (*server.Server, string) {
+	t.Helper()
+	srv := server.New(memory.New())
+	srv.AllowInsecureAuth = true
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	return srv, ln.Addr().String()
+}
+
+func TestMailClient_ReconnectsWithBackoff(t *testing.T) {
+	srv, addr := startMemoryIMAP(t, "127.0.0.1:0")
+	tcp, _ := net.ResolveTCPAddr("tcp", addr)
+	c := NewMailClient(tcp.IP.String(), tcp.Port, "username", "password", false)
+	clock := time.Now()
+	c.status.now = func() time.Time { return clock }
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	st := c.Status()
+	if st.State != StateConnected || st.Account != "username@"+addr || st.Mailbox != "INBOX" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if !strings.Contains(strings.Join(st.Capabilities, " "), "IMAP4rev1") {
+		t.Fatalf("expected server capabilities, got %v", st.Capabilities)
+	}
+
+	srv.Close()
+	if _, err := c.FetchMailList(10, 0); err == nil || !strings.Contains(err.Error(), "reconnect failed") {
+		t.Fatalf("expected failed reconnect, got %v", err)
+	}
+	var pending *ReconnectPendingError
+	if _, err := c.FetchMailList(10, 0); !errors.As(err, &pending) {
+		t.Fatalf("expected the next attempt to wait, got %v", err)
+	}
+	if st := c.Status(); st.State != StateReconnecting || st.Attempts != 1 || st.NextRetry == "" {
+		t.Fatalf("unexpected status while reconnecting: %+v", st)
+	}
+
+	srv, _ = startMemoryIMAP(t, addr)
+	defer srv.Close()
+	clock = clock.Add(minRetryDelay)
+	if err := c.Ping(); err != nil {
+		t.Fatalf("expected reconnect once due, got %v", err)
+	}
+	if st := c.Status(); st.State != StateConnected || st.Attempts != 0 {
+		t.Fatalf("unexpected status after reconnect: %+v", st)
+	}
+}
+
+func TestMailClient_AuthFailed(t *testing.T) {
+	srv, addr := startMemoryIMAP(t, "127.0.0.1:0")
+	defer srv.Close()
+	tcp, _ := net.ResolveTCPAddr("tcp", addr)
+	c := NewMailClient(tcp.IP.String(), tcp.Port, "username", "wrong", false)
+
+	var authErr *AuthError
+	if err := c.Connect(); !errors.As(err, &authErr) {
+		t.Fatalf("expected an AuthError, got %v", err)
+	}
+	if st := c.Status(); st.State != StateAuthFailed || st.LastError == "" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if err := c.Ping(); !errors.As(err, &authErr) {
+		t.Fatalf("expected no reconnect after a refused login, got %v", err)
+	}
+}
+
+func TestTraceWriter_RedactsCredentials(t *testing.T) {
+	var out bytes.Buffer
+	client, srv := newTraceWriters(&out)
+	for _, chunk := range []string{
+		"a1 LOGIN user@example.com s3cret\r\n",
+		"a2 LOGIN \"user name\" \"pa ss\\\"word\"\r\n",
+		"a3 LOGIN ",
+		"bob {8}\r\n",
+		"pässwd\r\n",
+		"a4 LOGIN {4}\r\nuser {6}\r\nhunter\r\n",
+		"a5 AUTHENTICATE PLAIN AHVzZXIAcGFzcw==\r\n",
+		"a6 SELECT INBOX\r\n",
+	} {
+		client.Write([]byte(chunk))
+	}
+	srv.Write([]byte("* OK [CAPABILITY IMAP4rev1] ready\r\n"))
+
+	trace := out.String()
+	for _, secret := range []string{"s3cret", "pa ss", "pässwd", "hunter", "AHVzZXIAcGFzcw=="} {
+		if strings.Contains(trace, secret) {
+			t.Fatalf("trace leaks %q:\n%s", secret, trace)
+		}
+	}
+	for _, want := range []string{
+		"C: a1 LOGIN user@example.com ****",
+		"C: a2 LOGIN \"user name\" ****",
+		"C: a3 LOGIN bob ****",
+		"C: a4 LOGIN ****",
+		"C: a5 AUTHENTICATE ****",
+		"C: a6 SELECT INBOX",
+		"S: * OK [CAPABILITY IMAP4rev1] ready",
+	} {
+		if !strings.Contains(trace, want) {
+			t.Fatalf("trace is missing %q:\n%s", want, trace)
+		}
+	}
+}
+
+func TestMailClient_Trace(t *testing.T) {
+	srv, addr := startMemoryIMAP(t, "127.0.0.1:0")
+	defer srv.Close()
+	tcp, _ := net.ResolveTCPAddr("tcp", addr)
+	c := NewMailClient(tcp.IP.String(), tcp.Port, "username", "password", false)
+	var out bytes.Buffer
+	c.SetTrace(&out)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	c.Disconnect()
+
+	trace := out.String()
+	if !strings.Contains(trace, `LOGIN "username" ****`) || strings.Contains(trace, "password") {
+		t.Fatalf("unexpected login trace:\n%s", trace)
+	}
+	if !strings.Contains(trace, "C: ") || !strings.Contains(trace, "S: ") || !strings.Contains(trace, "SELECT INBOX") {
+		t.Fatalf("expected both directions in trace:\n%s", trace)
+	}
+}
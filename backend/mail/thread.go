@@ -0,0 +1,181 @@
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// Thread is one conversation among a set of mails.
+type Thread struct {
+	ID              string   `json:"thread_id"`
+	Subject         string   `json:"subject"`
+	Latest          string   `json:"latest_date"`
+	LatestTimestamp int64    `json:"latest_timestamp"`
+	MailIDs         []string `json:"mail_ids"` // newest first
+}
+
+// ServerThreader is implemented by providers that can thread on the server
+// (IMAP THREAD=REFERENCES). ok is false when the server lacks the extension,
+// in which case callers thread locally.
+type ServerThreader interface {
+	ThreadGroups(mailIDs []string) (groups [][]string, ok bool, err error)
+}
+
+var replyPrefixPattern = regexp.MustCompile(`(?i)^\s*(re|fw|fwd|aw|sv|回复|答复|转发)\s*(\[\d+\])?\s*[:：]\s*`)
+
+// baseSubject strips reply and forward prefixes so a reply can be matched to
+// the mail it answers.
+func baseSubject(subject string) (string, bool) {
+	reply := false
+	for {
+		next := replyPrefixPattern.ReplaceAllString(subject, "")
+		if next == subject {
+			break
+		}
+		reply = true
+		subject = next
+	}
+	return strings.ToLower(strings.Join(strings.Fields(subject), " ")), reply
+}
+
+// parseMsgIDs reads an In-Reply-To or References value into bare IDs.
+func parseMsgIDs(v string) []string {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	var h mail.Header
+	h.Set("References", v)
+	ids, _ := h.MsgIDList("References")
+	return ids
+}
+
+type threadSet struct {
+	parent map[string]string
+}
+
+func (s *threadSet) find(k string) string {
+	if _, ok := s.parent[k]; !ok {
+		s.parent[k] = k
+	}
+	for s.parent[k] != k {
+		s.parent[k] = s.parent[s.parent[k]]
+		k = s.parent[k]
+	}
+	return k
+}
+
+func (s *threadSet) union(a, b string) {
+	ra, rb := s.find(a), s.find(b)
+	if ra != rb {
+		s.parent[rb] = ra
+	}
+}
+
+func itemKey(item MailItem) string {
+	if item.MessageID != "" {
+		return "<" + item.MessageID + ">"
+	}
+	return "uid:" + item.ID
+}
+
+// ThreadMails groups items into conversations. Mails are linked through
+// their Message-ID, In-Reply-To and References headers as in JWZ threading,
+// so a thread stays together even when intermediate messages are missing
+// from items. groups, when given, are server-computed threads that are
+// merged in first. Replies without reference headers are joined to the
+// earliest mail with the same base subject.
+func ThreadMails(items []MailItem, groups [][]string) []Thread {
+	set := &threadSet{parent: map[string]string{}}
+	byID := make(map[string]MailItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+		key := set.find(itemKey(item))
+		for _, ref := range item.References {
+			set.union(key, "<"+ref+">")
+		}
+		if item.InReplyTo != "" {
+			set.union(key, "<"+item.InReplyTo+">")
+		}
+	}
+	for _, group := range groups {
+		var first string
+		for _, id := range group {
+			item, ok := byID[id]
+			if !ok {
+				continue
+			}
+			if first == "" {
+				first = itemKey(item)
+				continue
+			}
+			set.union(first, itemKey(item))
+		}
+	}
+
+	ordered := append([]MailItem{}, items...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Timestamp < ordered[j].Timestamp
+	})
+	firstBySubject := map[string]string{}
+	for _, item := range ordered {
+		base, reply := baseSubject(item.Subject)
+		if base == "" {
+			continue
+		}
+		if first, ok := firstBySubject[base]; ok {
+			if reply && item.InReplyTo == "" && len(item.References) == 0 {
+				set.union(first, itemKey(item))
+			}
+			continue
+		}
+		firstBySubject[base] = itemKey(item)
+	}
+
+	// Collect members per root, oldest first.
+	members := map[string][]MailItem{}
+	var roots []string
+	for _, item := range ordered {
+		root := set.find(itemKey(item))
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], item)
+	}
+
+	threads := make([]Thread, 0, len(roots))
+	for _, root := range roots {
+		list := members[root]
+		oldest := list[0]
+		// The first reference of the oldest mail is the conversation's root
+		// message, which stays the same however much of the thread is loaded.
+		idKey := itemKey(oldest)
+		if len(oldest.References) > 0 {
+			idKey = "<" + oldest.References[0] + ">"
+		} else if oldest.InReplyTo != "" {
+			idKey = "<" + oldest.InReplyTo + ">"
+		}
+		sum := sha256.Sum256([]byte(idKey))
+
+		ids := make([]string, 0, len(list))
+		for i := len(list) - 1; i >= 0; i-- {
+			ids = append(ids, list[i].ID)
+		}
+		threads = append(threads, Thread{
+			ID:              hex.EncodeToString(sum[:])[:16],
+			Subject:         oldest.Subject,
+			Latest:          list[len(list)-1].Date,
+			LatestTimestamp: list[len(list)-1].Timestamp,
+			MailIDs:         ids,
+		})
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		return threads[i].LatestTimestamp > threads[j].LatestTimestamp
+	})
+	return threads
+}
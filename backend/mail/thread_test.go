@@ -0,0 +1,85 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestThreadMails(t *testing.T) {
+	items := []MailItem{
+		{ID: "1", Subject: "Budget", Date: "Mon, 20 Apr 2026 09:00:00 +0800", MessageID: "a@x"},
+		// The middle message of the conversation is not loaded; References still links it.
+		{ID: "3", Subject: "Re: Re: Budget", Date: "Wed, 22 Apr 2026 09:00:00 +0800", MessageID: "c@x", InReplyTo: "b@x", References: []string{"a@x", "b@x"}},
+		// A reply from a client that drops reference headers.
+		{ID: "4", Subject: "回复：Budget", Date: "Thu, 23 Apr 2026 09:00:00 +0800", MessageID: "d@x"},
+		{ID: "5", Subject: "Budget", Date: "Fri, 24 Apr 2026 09:00:00 +0800", MessageID: "e@x"},
+		{ID: "6", Subject: "Travel", Date: "Tue, 21 Apr 2026 09:00:00 +0800", MessageID: "f@x"},
+	}
+	for i := range items {
+		date, _ := time.Parse(time.RFC1123Z, items[i].Date)
+		items[i].Timestamp = date.Unix()
+	}
+
+	threads := ThreadMails(items, nil)
+	if len(threads) != 3 {
+		t.Fatalf("expected 3 threads, got %+v", threads)
+	}
+	// Newest activity first: the unrelated "Budget" (5) stays on its own.
+	if !reflect.DeepEqual(threads[0].MailIDs, []string{"5"}) {
+		t.Fatalf("unexpected first thread: %+v", threads[0])
+	}
+	if !reflect.DeepEqual(threads[1].MailIDs, []string{"4", "3", "1"}) || threads[1].Subject != "Budget" {
+		t.Fatalf("unexpected conversation: %+v", threads[1])
+	}
+
+	// The thread ID only depends on the root message.
+	again := ThreadMails(items[1:3], nil)
+	if again[0].ID != threads[1].ID {
+		t.Fatalf("thread ID changed with the loaded window: %s vs %s", again[0].ID, threads[1].ID)
+	}
+
+	// Server-computed groups are honored.
+	merged := ThreadMails(items, [][]string{{"6", "5"}})
+	if len(merged) != 2 {
+		t.Fatalf("expected server groups to merge threads, got %+v", merged)
+	}
+}
+
+func TestFlattenThreadTree(t *testing.T) {
+	tree := []interface{}{"3", "6", []interface{}{"4", "23"}, []interface{}{"44", "7", "96"}}
+	got := flattenThreadTree(tree, nil)
+	want := []string{"3", "6", "4", "23", "44", "7", "96"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestMaildirProvider_ReplyHeaders(t *testing.T) {
+	root := t.TempDir()
+	reply := "From: Li Si <lisi@example.com>\r\n" +
+		"Subject: Re: test\r\n" +
+		"Date: Tue, 21 Apr 2026 10:00:00 +0800\r\n" +
+		"Message-ID: <r1@example.com>\r\n" +
+		"In-Reply-To: <m1@example.com>\r\n" +
+		"References: <m0@example.com> <m1@example.com>\r\n" +
+		"\r\n" +
+		"ok\r\n"
+	_ = os.WriteFile(filepath.Join(root, "r.eml"), []byte(reply), 0o644)
+
+	p := NewMaildirProvider(root)
+	if err := p.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	items, err := p.FetchMailList(10, 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("list: %v %v", items, err)
+	}
+	item := items[0]
+	if item.MessageID != "r1@example.com" || item.InReplyTo != "m1@example.com" ||
+		!reflect.DeepEqual(item.References, []string{"m0@example.com", "m1@example.com"}) {
+		t.Fatalf("unexpected reply headers: %+v", item)
+	}
+}
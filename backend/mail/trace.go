@@ -0,0 +1,89 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	// traceLoginLine keeps the tag, command and user name of a LOGIN line.
+	traceLoginLine = regexp.MustCompile(`(?i)^(\S+ LOGIN (?:"(?:[^"\\]|\\.)*"|[^\s{"]+))(?: .*)?$`)
+	// traceAuthLine matches LOGIN lines with a literal user name and
+	// AUTHENTICATE, whose arguments are all hidden.
+	traceAuthLine  = regexp.MustCompile(`(?i)^(\S+ (?:LOGIN|AUTHENTICATE))\b.*$`)
+	traceLiteralAt = regexp.MustCompile(`\{\d+\+?\}$`)
+)
+
+// traceSink serializes trace lines of both directions into one writer.
+type traceSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+func (s *traceSink) line(prefix string, line []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	fmt.Fprintf(s.w, "%s %s%s\n", now().Format("2006-01-02 15:04:05.000"), prefix, line)
+}
+
+// traceWriter turns one direction of an IMAP session into trace lines.
+// On the client side the credentials of LOGIN and AUTHENTICATE, including
+// literals sent on the following lines, are replaced with ****.
+type traceWriter struct {
+	sink   *traceSink
+	prefix string
+	redact bool
+	buf    []byte
+	secret bool // the next line continues a redacted command
+}
+
+func (t *traceWriter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(t.buf[:i], "\r")
+		t.sink.line(t.prefix, t.redactLine(line))
+		t.buf = t.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (t *traceWriter) redactLine(line []byte) []byte {
+	if !t.redact {
+		return line
+	}
+	continues := traceLiteralAt.Match(line)
+	if t.secret {
+		t.secret = continues
+		return []byte("****")
+	}
+	if m := traceLoginLine.FindSubmatch(line); m != nil {
+		t.secret = continues
+		return append(append([]byte{}, m[1]...), " ****"...)
+	}
+	if m := traceAuthLine.FindSubmatch(line); m != nil {
+		t.secret = continues
+		return append(append([]byte{}, m[1]...), " ****"...)
+	}
+	return line
+}
+
+// newTraceWriters returns the client and server side writers of a trace
+// to w.
+func newTraceWriters(w io.Writer) (client, server io.Writer) {
+	sink := &traceSink{w: w}
+	return &traceWriter{sink: sink, prefix: "C: ", redact: true},
+		&traceWriter{sink: sink, prefix: "S: "}
+}
@@ -9,6 +9,7 @@ import (
 
 func main() {
 	router := api.SetupRoutes()
+	api.StartScheduler()
 
 	port := "18000"
 	log.Printf("Starting Go backend server on port %s...", port)
@@ -0,0 +1,547 @@
+// Package proxy connects the backend's mail and AI clients through an HTTP
+// CONNECT or SOCKS5 proxy. A Config can come from an account, the global
+// network settings or the HTTPS_PROXY / ALL_PROXY / NO_PROXY environment.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Hops of a connection, named in a HopError.
+const (
+	HopProxy  = "proxy"  // reaching the proxy server
+	HopTunnel = "tunnel" // the proxy opening the connection to the target
+	HopTarget = "target" // reaching the target directly
+)
+
+// Config selects a proxy. URL is a socks5://, socks5h://, http:// or
+// https:// proxy, optionally with user:password; empty or "direct" means
+// no proxy. NoProxy is a comma-separated list of hosts reached directly:
+// names (matching sub-domains too), ".domain" suffixes, IPs, CIDRs or "*".
+type Config struct {
+	URL     string `json:"url"`
+	NoProxy string `json:"no_proxy"`
+}
+
+// FromEnvironment reads the proxy from HTTPS_PROXY, falling back to
+// ALL_PROXY, and the exceptions from NO_PROXY, upper or lower case.
+// Loopback addresses are always exempt.
+func FromEnvironment() Config {
+	env := func(names ...string) string {
+		for _, name := range names {
+			if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	c := Config{
+		URL:     env("HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy"),
+		NoProxy: env("NO_PROXY", "no_proxy"),
+	}
+	if c.URL != "" {
+		// Like net/http, never send loopback traffic to an environment proxy.
+		c.NoProxy = strings.TrimPrefix(c.NoProxy+","+loopbackNoProxy, ",")
+	}
+	return c
+}
+
+const loopbackNoProxy = "localhost,127.0.0.0/8,::1"
+
+// Resolve picks the proxy of a connection: the account's when it sets
+// one, else the global one, else the environment's.
+func Resolve(account *Config, global Config) Config {
+	if account != nil && strings.TrimSpace(account.URL) != "" {
+		return *account
+	}
+	if strings.TrimSpace(global.URL) != "" {
+		return global
+	}
+	return FromEnvironment()
+}
+
+// Direct reports whether c never uses a proxy.
+func (c Config) Direct() bool {
+	u := strings.TrimSpace(c.URL)
+	return u == "" || strings.EqualFold(u, "direct")
+}
+
+// Validate checks that the proxy URL can be used.
+func (c Config) Validate() error {
+	_, err := c.parse()
+	return err
+}
+
+func (c Config) parse() (*url.URL, error) {
+	if c.Direct() {
+		return nil, nil
+	}
+	raw := strings.TrimSpace(c.URL)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.New("invalid proxy url")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy url has no host")
+	}
+	if u.Port() == "" {
+		port := "1080"
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// ProxyURL returns the proxy for a connection to addr ("host" or
+// "host:port"), or nil when addr is reached directly.
+func (c Config) ProxyURL(addr string) (*url.URL, error) {
+	u, err := c.parse()
+	if err != nil || u == nil {
+		return nil, err
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if c.bypass(host) {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// bypass reports whether host is in the NoProxy list.
+func (c Config) bypass(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(c.NoProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.Trim(entry, "[]")
+		if ip != nil {
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted hides the password of a proxy URL for error messages.
+func redacted(raw string) string {
+	if u, err := url.Parse(strings.TrimSpace(raw)); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	if strings.Contains(raw, "@") {
+		return "(invalid proxy url)"
+	}
+	return raw
+}
+
+// HopError tells which hop of a connection failed.
+type HopError struct {
+	Hop  string
+	Addr string
+	Err  error
+}
+
+func (e *HopError) Error() string {
+	switch e.Hop {
+	case HopProxy:
+		return fmt.Sprintf("proxy %s unreachable: %v", e.Addr, e.Err)
+	case HopTunnel:
+		return fmt.Sprintf("proxy could not connect to %s: %v", e.Addr, e.Err)
+	default:
+		return fmt.Sprintf("connect %s: %v", e.Addr, e.Err)
+	}
+}
+
+func (e *HopError) Unwrap() error { return e.Err }
+
+// Dialer opens TCP connections through the proxy of its Config. Its Dial
+// method fits the dialer interface of the IMAP client.
+type Dialer struct {
+	Config  Config
+	Timeout time.Duration
+}
+
+// NewDialer returns a Dialer for c with the default timeout.
+func NewDialer(c Config) *Dialer {
+	return &Dialer{Config: c, Timeout: defaultTimeout}
+}
+
+func (d *Dialer) timeout() time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	return defaultTimeout
+}
+
+// Dial connects to addr within the dialer's timeout.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout())
+	defer cancel()
+	return d.DialContext(ctx, network, addr)
+}
+
+// DialContext connects to addr. Failures are *HopError.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyURL, err := d.Config.ProxyURL(addr)
+	if err != nil {
+		return nil, &HopError{Hop: HopProxy, Addr: redacted(d.Config.URL), Err: err}
+	}
+	if proxyURL == nil {
+		conn, err := (&net.Dialer{Timeout: d.timeout()}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, &HopError{Hop: HopTarget, Addr: addr, Err: err}
+		}
+		return conn, nil
+	}
+
+	conn, err := d.dialProxy(ctx, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err = d.tunnel(ctx, conn, proxyURL, addr); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialProxy connects to the proxy server itself.
+func (d *Dialer) dialProxy(ctx context.Context, proxyURL *url.URL) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: d.timeout()}).DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, &HopError{Hop: HopProxy, Addr: proxyURL.Host, Err: err}
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, &HopError{Hop: HopProxy, Addr: proxyURL.Host, Err: fmt.Errorf("tls handshake: %w", err)}
+		}
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// tunnel asks the proxy on conn to connect to addr.
+func (d *Dialer) tunnel(ctx context.Context, conn net.Conn, proxyURL *url.URL, addr string) (net.Conn, error) {
+	deadline := time.Now().Add(d.timeout())
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetDeadline(deadline)
+
+	var err error
+	var tunneled net.Conn
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Connect(ctx, conn, proxyURL, addr)
+		tunneled = conn
+	default:
+		tunneled, err = httpConnect(conn, proxyURL, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &HopError{Hop: HopTunnel, Addr: addr, Err: err}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tunneled, nil
+}
+
+func httpConnect(conn net.Conn, proxyURL *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		token := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+token)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy responded %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn hands out bytes the CONNECT response reader read ahead.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+var socks5Replies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect runs the RFC 1928 CONNECT handshake, with RFC 1929
+// username/password authentication when the proxy URL has a user. socks5
+// resolves the target locally; socks5h lets the proxy resolve it.
+func socks5Connect(ctx context.Context, conn net.Conn, proxyURL *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{0x00}
+	if proxyURL.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if proxyURL.User == nil {
+			return errors.New("proxy requires authentication")
+		}
+		user := proxyURL.User.Username()
+		password, _ := proxyURL.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("proxy credentials too long")
+		}
+		auth := []byte{0x01, byte(len(user))}
+		auth = append(auth, user...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("proxy authentication failed")
+		}
+	default:
+		return errors.New("proxy accepts none of the offered authentication methods")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil && proxyURL.Scheme == "socks5" {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 {
+			return fmt.Errorf("no address for %s", host)
+		}
+		ip = addrs[0].IP
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return errors.New("host name too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, 0x01)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		if msg, ok := socks5Replies[head[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("proxy replied %d", head[1])
+	}
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("invalid address type %d in proxy reply", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// Transport is an HTTP transport that sends requests through the proxy
+// of c.
+func (c Config) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		return c.ProxyURL(req.URL.Host)
+	}
+	return t
+}
+
+// Hop is one step of a connection test.
+type Hop struct {
+	Hop        string `json:"hop"`
+	Address    string `json:"address"`
+	OK         bool   `json:"ok"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Probe connects to addr step by step, through the proxy when there is
+// one and then with TLS when useTLS is set, and reports every hop it
+// tried. The last hop is the one that failed, if any. The connection is
+// returned open on success so the caller can test the protocol on top.
+func Probe(ctx context.Context, c Config, addr string, useTLS bool) ([]Hop, net.Conn) {
+	d := NewDialer(c)
+	var hops []Hop
+	step := func(name, address string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		hop := Hop{Hop: name, Address: address, OK: err == nil, DurationMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			var he *HopError
+			if errors.As(err, &he) {
+				err = he.Err
+			}
+			hop.Error = err.Error()
+		}
+		hops = append(hops, hop)
+		return err == nil
+	}
+
+	proxyURL, err := c.ProxyURL(addr)
+	if err != nil {
+		step(HopProxy, redacted(c.URL), func() error { return err })
+		return hops, nil
+	}
+
+	var conn net.Conn
+	if proxyURL == nil {
+		if !step(HopTarget, addr, func() error {
+			var err error
+			conn, err = d.DialContext(ctx, "tcp", addr)
+			return err
+		}) {
+			return hops, nil
+		}
+	} else {
+		if !step(HopProxy, proxyURL.Host, func() error {
+			var err error
+			conn, err = d.dialProxy(ctx, proxyURL)
+			return err
+		}) {
+			return hops, nil
+		}
+		if !step(HopTunnel, addr, func() error {
+			var err error
+			conn, err = d.tunnel(ctx, conn, proxyURL, addr)
+			return err
+		}) {
+			return hops, nil
+		}
+	}
+
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if !step("tls", addr, func() error { return tlsConn.HandshakeContext(ctx) }) {
+			conn.Close()
+			return hops, nil
+		}
+		conn = tlsConn
+	}
+	return hops, conn
+}
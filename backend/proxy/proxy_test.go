@@ -0,0 +1,316 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startEcho starts a TCP server that greets and echoes one line.
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, "hello\n")
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				io.WriteString(conn, line)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startConnectProxy starts an HTTP CONNECT proxy. Requests are recorded
+// in hosts.
+func startConnectProxy(t *testing.T, hosts *[]string, mu *sync.Mutex) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				*hosts = append(*hosts, req.Host+" "+req.Header.Get("Proxy-Authorization"))
+				mu.Unlock()
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(target, br)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startSOCKS5 starts a SOCKS5 proxy that requires user/secret.
+func startSOCKS5(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	io.ReadFull(conn, make([]byte, head[1]))
+	conn.Write([]byte{0x05, 0x02})
+
+	b := make([]byte, 2)
+	io.ReadFull(conn, b)
+	user := make([]byte, b[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, b[:1])
+	password := make([]byte, b[0])
+	io.ReadFull(conn, password)
+	if string(user) != "user" || string(password) != "secret" {
+		conn.Write([]byte{0x01, 0x01})
+		return
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	req := make([]byte, 4)
+	io.ReadFull(conn, req)
+	var host string
+	switch req[3] {
+	case 0x01:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 0x03:
+		io.ReadFull(conn, b[:1])
+		name := make([]byte, b[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	if greeting, _ := br.ReadString('\n'); greeting != "hello\n" {
+		t.Fatalf("unexpected greeting %q", greeting)
+	}
+	io.WriteString(conn, "ping\n")
+	if echo, _ := br.ReadString('\n'); echo != "ping\n" {
+		t.Fatalf("unexpected echo %q", echo)
+	}
+}
+
+func TestDialer_HTTPConnect(t *testing.T) {
+	target := startEcho(t)
+	var mu sync.Mutex
+	var hosts []string
+	proxyAddr := startConnectProxy(t, &hosts, &mu)
+
+	conn, err := NewDialer(Config{URL: "http://user:secret@" + proxyAddr}).Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	assertEcho(t, conn)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hosts) != 1 || hosts[0] != target+" Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatalf("unexpected CONNECT requests: %q", hosts)
+	}
+}
+
+func TestDialer_SOCKS5(t *testing.T) {
+	target := startEcho(t)
+	proxyAddr := startSOCKS5(t)
+
+	conn, err := NewDialer(Config{URL: "socks5h://user:secret@" + proxyAddr}).Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	assertEcho(t, conn)
+
+	_, err = NewDialer(Config{URL: "socks5://user:wrong@" + proxyAddr}).Dial("tcp", target)
+	var he *HopError
+	if !errors.As(err, &he) || he.Hop != HopTunnel || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("expected tunnel authentication error, got %v", err)
+	}
+}
+
+func TestDialer_ReportsFailedHop(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := ln.Addr().String()
+	ln.Close()
+
+	_, err := NewDialer(Config{URL: "http://" + closed}).Dial("tcp", "example.com:993")
+	var he *HopError
+	if !errors.As(err, &he) || he.Hop != HopProxy || he.Addr != closed {
+		t.Fatalf("expected proxy hop error, got %v", err)
+	}
+
+	var mu sync.Mutex
+	var hosts []string
+	proxyAddr := startConnectProxy(t, &hosts, &mu)
+	_, err = NewDialer(Config{URL: proxyAddr}).Dial("tcp", closed)
+	if !errors.As(err, &he) || he.Hop != HopTunnel || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("expected tunnel hop error, got %v", err)
+	}
+
+	_, err = NewDialer(Config{}).Dial("tcp", closed)
+	if !errors.As(err, &he) || he.Hop != HopTarget {
+		t.Fatalf("expected target hop error, got %v", err)
+	}
+}
+
+func TestConfig_NoProxy(t *testing.T) {
+	c := Config{URL: "socks5://proxy.local", NoProxy: "localhost, .corp.example, mail.example.com, 10.0.0.0/8, 192.168.1.5"}
+	for _, addr := range []string{"localhost:993", "imap.corp.example:993", "corp.example", "mail.example.com", "sub.mail.example.com:993", "10.1.2.3:110", "192.168.1.5:993"} {
+		if u, err := c.ProxyURL(addr); err != nil || u != nil {
+			t.Fatalf("expected %s to bypass the proxy, got %v %v", addr, u, err)
+		}
+	}
+	u, err := c.ProxyURL("imap.example.org:993")
+	if err != nil || u == nil || u.Host != "proxy.local:1080" {
+		t.Fatalf("expected proxy with default port, got %v %v", u, err)
+	}
+	if u, _ := (Config{URL: "http://p", NoProxy: "*"}).ProxyURL("a.b:1"); u != nil {
+		t.Fatal("expected * to bypass everything")
+	}
+	if err := (Config{URL: "ftp://p"}).Validate(); err == nil {
+		t.Fatal("expected error for unsupported scheme")
+	}
+	if u, _ := (Config{URL: "direct"}).ProxyURL("a.b:1"); u != nil {
+		t.Fatal("expected direct to use no proxy")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env:3128")
+	t.Setenv("NO_PROXY", "localhost")
+
+	global := Config{URL: "socks5://global:1080"}
+	if got := Resolve(&Config{URL: "http://account:8080"}, global); got.URL != "http://account:8080" {
+		t.Fatalf("expected account proxy, got %+v", got)
+	}
+	if got := Resolve(&Config{}, global); got.URL != global.URL {
+		t.Fatalf("expected global proxy, got %+v", got)
+	}
+	if got := Resolve(nil, Config{}); got.URL != "http://env:3128" || got.NoProxy != "localhost,"+loopbackNoProxy {
+		t.Fatalf("expected environment proxy, got %+v", got)
+	}
+	if u, _ := Resolve(nil, Config{}).ProxyURL("127.0.0.1:11434"); u != nil {
+		t.Fatal("expected loopback to bypass the environment proxy")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var seen []string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.String())
+		mu.Unlock()
+		resp, err := http.Get(r.URL.String())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(w, resp.Body)
+	}))
+	defer proxyServer.Close()
+
+	client := &http.Client{Transport: Config{URL: proxyServer.URL}.Transport()}
+	resp, err := client.Get(ts.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" || len(seen) != 1 || seen[0] != ts.URL+"/v1/models" {
+		t.Fatalf("expected request through proxy, got %q %q", body, seen)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	target := startEcho(t)
+	proxyAddr := startSOCKS5(t)
+
+	hops, conn := Probe(context.Background(), Config{URL: "socks5://user:secret@" + proxyAddr}, target, false)
+	if conn == nil || len(hops) != 2 || hops[0].Hop != HopProxy || hops[1].Hop != HopTunnel || !hops[1].OK {
+		t.Fatalf("unexpected hops: %+v", hops)
+	}
+	assertEcho(t, conn)
+
+	hops, conn = Probe(context.Background(), Config{URL: "socks5://user:bad@" + proxyAddr}, target, false)
+	if conn != nil || len(hops) != 2 || !hops[0].OK || hops[1].OK || hops[1].Error == "" {
+		t.Fatalf("expected failed tunnel hop, got %+v", hops)
+	}
+}
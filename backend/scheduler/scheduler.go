@@ -0,0 +1,410 @@
+// Package scheduler runs background jobs on cron-like schedules inside the
+// backend process. Last-run state is persisted, so runs missed while the
+// machine was asleep or the app was closed are caught up on the next check.
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	checkInterval   = 30 * time.Second
+	maxRunHistory   = 20
+	catchUpLateness = 2 * time.Minute
+)
+
+// JobFunc does the work of a job. The returned string is a short summary
+// kept with the run history.
+type JobFunc func(params map[string]string) (string, error)
+
+// RunRecord describes one finished run.
+type RunRecord struct {
+	Trigger    string `json:"trigger"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at"`
+	Status     string `json:"status"`
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// JobStatus is the public view of a job.
+type JobStatus struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Spec       string            `json:"spec"`
+	Paused     bool              `json:"paused"`
+	Running    bool              `json:"running"`
+	Params     map[string]string `json:"params"`
+	LastRun    string            `json:"last_run"`
+	LastStatus string            `json:"last_status"`
+	LastError  string            `json:"last_error,omitempty"`
+	NextRun    string            `json:"next_run"`
+	RunCount   int               `json:"run_count"`
+	History    []RunRecord       `json:"history,omitempty"`
+}
+
+// jobState is what survives restarts.
+type jobState struct {
+	Spec       string            `json:"spec"`
+	Paused     bool              `json:"paused"`
+	Params     map[string]string `json:"params"`
+	Since      time.Time         `json:"since"`
+	LastRun    time.Time         `json:"last_run"`
+	LastStatus string            `json:"last_status"`
+	LastError  string            `json:"last_error"`
+	RunCount   int               `json:"run_count"`
+	History    []RunRecord       `json:"history"`
+}
+
+type job struct {
+	id       string
+	name     string
+	fn       JobFunc
+	schedule Schedule
+	running  bool
+	state    *jobState
+}
+
+type Scheduler struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	order     []string
+	statePath string
+	now       func() time.Time
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// New returns a scheduler that keeps its state in statePath.
+func New(statePath string) *Scheduler {
+	return &Scheduler{
+		jobs:      map[string]*job{},
+		statePath: statePath,
+		now:       time.Now,
+	}
+}
+
+// clock is the current wall-clock time. The monotonic reading is stripped:
+// it stops while the laptop is suspended, so comparing against it would
+// delay catching up after a wake by the time spent asleep.
+func (s *Scheduler) clock() time.Time {
+	return s.now().Round(0)
+}
+
+func (s *Scheduler) loadStates() map[string]*jobState {
+	states := map[string]*jobState{}
+	data, err := os.ReadFile(s.statePath)
+	if err != nil {
+		return states
+	}
+	if err := json.Unmarshal(data, &states); err != nil {
+		log.Printf("Scheduler state unreadable, starting fresh: %v", err)
+		return map[string]*jobState{}
+	}
+	return states
+}
+
+// save must be called with s.mu held.
+func (s *Scheduler) save() {
+	states := make(map[string]*jobState, len(s.jobs))
+	for id, j := range s.jobs {
+		states[id] = j.state
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0755); err != nil {
+		log.Printf("Failed to save scheduler state: %v", err)
+		return
+	}
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, s.statePath)
+	}
+	if err != nil {
+		log.Printf("Failed to save scheduler state: %v", err)
+	}
+}
+
+// Add registers a job. A spec or params saved by an earlier run take
+// precedence over the defaults given here.
+func (s *Scheduler) Add(id, name, defaultSpec string, defaultParams map[string]string, fn JobFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[id]; exists {
+		return fmt.Errorf("job %s already registered", id)
+	}
+
+	state := s.loadStates()[id]
+	if state == nil {
+		state = &jobState{Spec: defaultSpec, Since: s.clock()}
+	}
+	if state.Params == nil {
+		state.Params = map[string]string{}
+	}
+	for k, v := range defaultParams {
+		if _, ok := state.Params[k]; !ok {
+			state.Params[k] = v
+		}
+	}
+
+	schedule, err := ParseSchedule(state.Spec)
+	if err != nil {
+		log.Printf("Saved schedule for %s is invalid (%v), using default", id, err)
+		state.Spec = defaultSpec
+		if schedule, err = ParseSchedule(defaultSpec); err != nil {
+			return err
+		}
+	}
+
+	s.jobs[id] = &job{id: id, name: name, fn: fn, schedule: schedule, state: state}
+	s.order = append(s.order, id)
+	s.save()
+	return nil
+}
+
+// Start checks for due jobs immediately and then periodically until Stop.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stop = make(chan struct{})
+	stop := s.stop
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		s.runDue()
+		for {
+			select {
+			case <-ticker.C:
+				s.runDue()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the check loop and waits for running jobs to finish.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (j *job) nextRun() time.Time {
+	base := j.state.LastRun
+	if base.IsZero() {
+		base = j.state.Since
+	}
+	return j.schedule.Next(base)
+}
+
+// runDue starts every job whose next run time has passed. A job that missed
+// several runs (e.g. while the laptop slept) runs once to catch up.
+func (s *Scheduler) runDue() {
+	s.mu.Lock()
+	now := s.clock()
+	var due []*job
+	var triggers []string
+	for _, id := range s.order {
+		j := s.jobs[id]
+		if j.state.Paused || j.running {
+			continue
+		}
+		next := j.nextRun()
+		if next.IsZero() || now.Before(next) {
+			continue
+		}
+		trigger := "schedule"
+		if now.Sub(next) > catchUpLateness {
+			trigger = "catch-up"
+		}
+		j.running = true
+		due = append(due, j)
+		triggers = append(triggers, trigger)
+	}
+	s.mu.Unlock()
+
+	for i, j := range due {
+		s.wg.Add(1)
+		go func(j *job, trigger string) {
+			defer s.wg.Done()
+			s.execute(j, trigger)
+		}(j, triggers[i])
+	}
+}
+
+// execute runs j; j.running must already be set.
+func (s *Scheduler) execute(j *job, trigger string) {
+	s.mu.Lock()
+	params := make(map[string]string, len(j.state.Params))
+	for k, v := range j.state.Params {
+		params[k] = v
+	}
+	s.mu.Unlock()
+
+	started := s.clock()
+	result, err := j.fn(params)
+	finished := s.clock()
+
+	record := RunRecord{
+		Trigger:    trigger,
+		StartedAt:  started.Format(time.RFC3339),
+		FinishedAt: finished.Format(time.RFC3339),
+		Status:     "ok",
+		Result:     result,
+	}
+	if err != nil {
+		record.Status = "error"
+		record.Error = err.Error()
+		log.Printf("Job %s failed: %v", j.id, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.running = false
+	j.state.LastRun = started
+	j.state.LastStatus = record.Status
+	j.state.LastError = record.Error
+	j.state.RunCount++
+	j.state.History = append([]RunRecord{record}, j.state.History...)
+	if len(j.state.History) > maxRunHistory {
+		j.state.History = j.state.History[:maxRunHistory]
+	}
+	s.save()
+}
+
+func (s *Scheduler) status(j *job, withHistory bool) JobStatus {
+	st := JobStatus{
+		ID:         j.id,
+		Name:       j.name,
+		Spec:       j.state.Spec,
+		Paused:     j.state.Paused,
+		Running:    j.running,
+		Params:     map[string]string{},
+		LastStatus: j.state.LastStatus,
+		LastError:  j.state.LastError,
+		RunCount:   j.state.RunCount,
+	}
+	for k, v := range j.state.Params {
+		st.Params[k] = v
+	}
+	if !j.state.LastRun.IsZero() {
+		st.LastRun = j.state.LastRun.Format(time.RFC3339)
+	}
+	if next := j.nextRun(); !next.IsZero() && !j.state.Paused {
+		st.NextRun = next.Format(time.RFC3339)
+	}
+	if withHistory {
+		st.History = append([]RunRecord{}, j.state.History...)
+	}
+	return st
+}
+
+// Jobs lists all jobs in registration order.
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]JobStatus, 0, len(s.order))
+	for _, id := range s.order {
+		result = append(result, s.status(s.jobs[id], false))
+	}
+	return result
+}
+
+// Job returns one job including its recent run history.
+func (s *Scheduler) Job(id string) (JobStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return s.status(j, true), true
+}
+
+// SetPaused pauses or resumes a job.
+func (s *Scheduler) SetPaused(id string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+	j.state.Paused = paused
+	if !paused && j.state.LastRun.IsZero() {
+		// Don't fire immediately for every slot missed while paused.
+		j.state.Since = s.clock()
+	}
+	s.save()
+	return nil
+}
+
+// Update changes a job's schedule and merges params. Empty spec keeps the
+// current schedule.
+func (s *Scheduler) Update(id, spec string, params map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+	if spec != "" {
+		schedule, err := ParseSchedule(spec)
+		if err != nil {
+			return err
+		}
+		j.schedule = schedule
+		j.state.Spec = spec
+	}
+	for k, v := range params {
+		j.state.Params[k] = v
+	}
+	s.save()
+	return nil
+}
+
+// Trigger starts a job now, outside its schedule. With wait it blocks until
+// the run has finished.
+func (s *Scheduler) Trigger(id string, wait bool) error {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("job %s not found", id)
+	}
+	if j.running {
+		s.mu.Unlock()
+		return fmt.Errorf("job %s is already running", id)
+	}
+	j.running = true
+	s.mu.Unlock()
+
+	if wait {
+		s.execute(j, "manual")
+		return nil
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(j, "manual")
+	}()
+	return nil
+}
@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_CronNext(t *testing.T) {
+	s, err := ParseSchedule("*/15 9-17 * * 1-5")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	// Friday 17:50 -> Monday 09:00
+	from := time.Date(2026, 4, 24, 17, 50, 0, 0, time.UTC)
+	got := s.Next(from)
+	want := time.Date(2026, 4, 27, 9, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParseSchedule_MacrosAndErrors(t *testing.T) {
+	s, err := ParseSchedule("@daily")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	got := s.Next(time.Date(2026, 4, 20, 10, 0, 0, 0, time.UTC))
+	if !got.Equal(time.Date(2026, 4, 21, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected next for @daily: %v", got)
+	}
+
+	every, err := ParseSchedule("@every 15m")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	base := time.Date(2026, 4, 20, 10, 0, 0, 0, time.UTC)
+	if !every.Next(base).Equal(base.Add(15 * time.Minute)) {
+		t.Fatal("unexpected next for @every")
+	}
+
+	for _, bad := range []string{"", "* * *", "61 * * * *", "@every 5s", "*/0 * * * *"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestScheduler_CatchesUpMissedRunOnce(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "jobs.json")
+	clock := time.Date(2026, 4, 20, 8, 0, 0, 0, time.UTC)
+
+	var mu sync.Mutex
+	runs := 0
+	s := New(statePath)
+	s.now = func() time.Time { return clock }
+	_ = s.Add("sync", "Mail sync", "@hourly", nil, func(map[string]string) (string, error) {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		return "ok", nil
+	})
+
+	s.runDue()
+	s.wg.Wait()
+	if runs != 0 {
+		t.Fatalf("expected no run before first slot, got %d", runs)
+	}
+
+	// Laptop slept through several hourly slots.
+	clock = clock.Add(5*time.Hour + 10*time.Minute)
+	s.runDue()
+	s.wg.Wait()
+	s.runDue()
+	s.wg.Wait()
+	if runs != 1 {
+		t.Fatalf("expected exactly one catch-up run, got %d", runs)
+	}
+
+	job, _ := s.Job("sync")
+	if job.RunCount != 1 || job.History[0].Trigger != "catch-up" {
+		t.Fatalf("unexpected job status: %+v", job)
+	}
+
+	// State survives a restart.
+	restarted := New(statePath)
+	restarted.now = func() time.Time { return clock }
+	_ = restarted.Add("sync", "Mail sync", "@hourly", nil, func(map[string]string) (string, error) { return "", nil })
+	job, _ = restarted.Job("sync")
+	if job.RunCount != 1 || job.LastRun == "" {
+		t.Fatalf("expected persisted state, got %+v", job)
+	}
+}
+
+func TestScheduler_PauseTriggerAndUpdate(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "jobs.json"))
+	var got map[string]string
+	_ = s.Add("report", "Daily report", "0 18 * * *", map[string]string{"scan_path": ""}, func(params map[string]string) (string, error) {
+		got = params
+		return "", errors.New("scan_path not configured")
+	})
+
+	if err := s.SetPaused("report", true); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	if jobs := s.Jobs(); !jobs[0].Paused || jobs[0].NextRun != "" {
+		t.Fatalf("expected paused job without next run: %+v", jobs[0])
+	}
+
+	if err := s.Update("report", "bad spec", nil); err == nil {
+		t.Fatal("expected invalid spec error")
+	}
+	if err := s.Update("report", "", map[string]string{"scan_path": "/tmp/work"}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	if err := s.Trigger("report", true); err != nil {
+		t.Fatalf("trigger failed: %v", err)
+	}
+	if got["scan_path"] != "/tmp/work" {
+		t.Fatalf("expected updated params, got %v", got)
+	}
+	job, _ := s.Job("report")
+	if job.LastStatus != "error" || job.LastError != "scan_path not configured" {
+		t.Fatalf("unexpected job status: %+v", job)
+	}
+
+	if err := s.Trigger("missing", false); err == nil {
+		t.Fatal("expected error for unknown job")
+	}
+}
+
+func TestScheduler_UsesWallClock(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "jobs.json"))
+	s.now = time.Now
+	_ = s.Add("sync", "Mail sync", "@every 15m", nil, func(map[string]string) (string, error) { return "", nil })
+	if err := s.Trigger("sync", true); err != nil {
+		t.Fatalf("trigger failed: %v", err)
+	}
+
+	// A monotonic reading does not advance during suspend; time.Time
+	// prints it as "m=".
+	s.mu.Lock()
+	state := s.jobs["sync"].state
+	times := map[string]time.Time{"since": state.Since, "last run": state.LastRun, "next run": s.jobs["sync"].nextRun()}
+	s.mu.Unlock()
+	for name, tm := range times {
+		if tm.IsZero() || strings.Contains(tm.String(), "m=") {
+			t.Errorf("expected a wall-clock %s, got %s", name, tm)
+		}
+	}
+}
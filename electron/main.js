@@ -2,6 +2,7 @@ const { app, BrowserWindow, shell, Menu, ipcMain, dialog, safeStorage, session }
 const fs = require('fs')
 const path = require('path')
 const { spawn } = require('child_process')
+const crypto = require('crypto')
 
 // 禁用开发时的 CSP 警告
 if (process.env.NODE_ENV === 'development' || !app.isPackaged) {
@@ -11,6 +12,9 @@ if (process.env.NODE_ENV === 'development' || !app.isPackaged) {
 let mainWindow
 let backendProcess
 
+// 每次启动生成的令牌：后端只接受带有此令牌的请求，其他网页无法冒充应用窗口
+const appToken = crypto.randomBytes(32).toString('hex')
+
 function createWindow() {
   // 隐藏菜单栏
   Menu.setApplicationMenu(null)
@@ -127,7 +131,8 @@ function startBackend() {
   }
 
   backendProcess = spawn(backendPath, [], {
-    stdio: ['pipe', 'pipe', 'pipe']
+    stdio: ['pipe', 'pipe', 'pipe'],
+    env: { ...process.env, KNOT_APP_TOKEN: appToken }
   })
 
   backendProcess.stdout.on('data', (data) => {
@@ -229,6 +234,10 @@ if (!gotTheLock) {
       event.returnValue = app.getVersion()
     })
 
+    ipcMain.on('get-app-token', (event) => {
+      event.returnValue = appToken
+    })
+
     // 窗口控制事件
     ipcMain.handle('window-minimize', () => {
       if (mainWindow) mainWindow.minimize()
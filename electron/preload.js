@@ -3,6 +3,9 @@ const { contextBridge, ipcRenderer } = require('electron')
 // 获取版本号（同步方式，在预加载时获取一次即可保证最新）
 const appVersion = ipcRenderer.sendSync('get-app-version')
 
+// 后端要求的本次启动令牌
+const appToken = ipcRenderer.sendSync('get-app-token')
+
 // 暴露安全的 API 给渲染进程
 contextBridge.exposeInMainWorld('electronAPI', {
   // 获取平台信息
@@ -11,6 +14,9 @@ contextBridge.exposeInMainWorld('electronAPI', {
   // 获取版本号
   version: appVersion,
 
+  // 请求后端时放在 X-Knot-Token 头中
+  appToken,
+
   // 获取桌面路径
   getDesktopPath: () => {
     const os = require('os')
@@ -21,8 +21,8 @@ import DailyReport from './components/DailyReport'
 import MailList from './components/MailList'
 import QuickCreate from './components/QuickCreate'
 import Settings from './components/Settings'
-import { USE_MOCK } from './services/api'
-import { getSettings } from './services/settings'
+import { rootsApi, USE_MOCK } from './services/api'
+import { getDepartments, getSettings } from './services/settings'
 import './App.css'
 
 const { Header, Sider, Content } = Layout
@@ -50,6 +50,25 @@ function App() {
     }
   }, [])
 
+  // 首次使用工作区目录限制时，把已配置的工作目录和部门归档目录登记为允许访问的目录
+  useEffect(() => {
+    if (USE_MOCK) return
+    const registerRoots = async () => {
+      try {
+        const result = await rootsApi.getRoots()
+        if (result.configured) return
+        const s = getSettings()
+        await rootsApi.saveRoots({
+          workspace: [s.folderPath].filter(Boolean),
+          archive: getDepartments().map(d => d.archivePath).filter(Boolean)
+        })
+      } catch (e) {
+        console.error('登记工作区目录失败:', e)
+      }
+    }
+    registerRoots()
+  }, [])
+
   useEffect(() => {
     if (!window.electronAPI) return undefined
 
@@ -1,11 +1,17 @@
 import { useEffect, useMemo, useState } from 'react'
-import { Button, Empty, Input, message, Modal, Select, Space, Spin, Tag, Tooltip } from 'antd'
+import { Button, Checkbox, Empty, Input, message, Modal, Select, Space, Spin, Tag, Tooltip } from 'antd'
 import { SearchOutlined } from '@ant-design/icons'
-import { archiveApi } from '../services/api'
+import { archiveApi, folderApi } from '../services/api'
 import { getDepartmentById, getDepartments, getSettings } from '../services/settings'
 import FolderCard, { getDisplayTitle } from './FolderCard'
 import './AutoArchive.css'
 
+// 克隆任务时可选择复制内容的子目录；成果输出不会被复制
+const CLONE_DIR_OPTIONS = [
+  { label: '10_过程文件', value: '10_过程文件' },
+  { label: '00_来源资料', value: '00_来源资料' }
+]
+
 const SOURCE_LABEL_MAP = {
   manual: '手动',
   email: '邮件'
@@ -32,6 +38,11 @@ function AutoArchive() {
   const [editTitle, setEditTitle] = useState('')
   const [editContent, setEditContent] = useState('')
 
+  const [cloneFolder, setCloneFolder] = useState(null)
+  const [cloneTitle, setCloneTitle] = useState('')
+  const [cloneDirs, setCloneDirs] = useState(['10_过程文件'])
+  const [cloning, setCloning] = useState(false)
+
   useEffect(() => {
     setDepartments(getDepartments())
     handleScan(true)
@@ -260,6 +271,34 @@ function AutoArchive() {
     }
   }
 
+  const handleClone = (folder) => {
+    setCloneFolder(folder)
+    setCloneTitle(getDisplayTitle(folder) || '')
+    setCloneDirs(['10_过程文件'])
+  }
+
+  const handleCloneSave = async () => {
+    if (!cloneFolder) return
+    setCloning(true)
+    try {
+      const s = getSettings()
+      const result = await folderApi.clone({
+        source_path: cloneFolder.path,
+        title: cloneTitle.trim(),
+        include_dirs: cloneDirs,
+        base_path: s.folderPath,
+        folder_name_format: s.folderNameFormat
+      })
+      message.success(result.message)
+      setCloneFolder(null)
+      handleScan(true)
+    } catch (error) {
+      message.error(error.response?.data?.detail || '克隆失败')
+    } finally {
+      setCloning(false)
+    }
+  }
+
   const handleOpenFolder = async (folder) => {
     if (!window.electronAPI?.openFolder) {
       message.info('请在 Electron 客户端中打开目录')
@@ -323,6 +362,7 @@ function AutoArchive() {
                     onEditDept={handleEditDept}
                     onViewContent={handleViewContent}
                     onOpenFolder={handleOpenFolder}
+                    onClone={handleClone}
                   />
                 </div>
               ))}
@@ -393,6 +433,40 @@ function AutoArchive() {
         )}
       </Modal>
 
+      <Modal
+        title="克隆任务"
+        open={!!cloneFolder}
+        onOk={handleCloneSave}
+        onCancel={() => setCloneFolder(null)}
+        okText="创建"
+        cancelText="取消"
+        confirmLoading={cloning}
+        width={480}
+      >
+        {cloneFolder && (
+          <div>
+            <p style={{ marginBottom: 12 }}>
+              以 <strong>{getDisplayTitle(cloneFolder)}</strong> 为模板创建新任务，复制目录结构并生成新的工作记录。
+            </p>
+            <div style={{ marginBottom: 12 }}>
+              <label style={{ display: 'block', marginBottom: 6, color: '#666' }}>新任务标题</label>
+              <Input
+                value={cloneTitle}
+                onChange={(e) => setCloneTitle(e.target.value)}
+                placeholder="留空则沿用原任务标题"
+              />
+            </div>
+            <label style={{ display: 'block', marginBottom: 6, color: '#666' }}>同时复制文件</label>
+            <Checkbox.Group
+              options={CLONE_DIR_OPTIONS}
+              value={cloneDirs}
+              onChange={setCloneDirs}
+            />
+            <p style={{ marginTop: 8, fontSize: 12, color: '#888' }}>20_成果输出 只保留空目录，不会复制其中的文件。</p>
+          </div>
+        )}
+      </Modal>
+
       <Modal
         title={contentFolder ? `工作记录 - ${getDisplayTitle(contentFolder)}` : '工作记录'}
         open={contentVisible}
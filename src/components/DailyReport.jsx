@@ -2,7 +2,7 @@ import { useMemo, useState } from 'react'
 import { Button, Card, Checkbox, DatePicker, Empty, Input, List, message, Space, Tag, Typography } from 'antd'
 import { CopyOutlined, FolderOpenOutlined, ReloadOutlined, RobotOutlined } from '@ant-design/icons'
 import dayjs from 'dayjs'
-import { archiveApi, reportApi } from '../services/api'
+import { archiveApi, reportApi, proxyConfig } from '../services/api'
 import { getDepartments, getSettings } from '../services/settings'
 import { normalizePathKey, optimizeRecursiveScanDirectories } from '../services/path'
 import './DailyReport.css'
@@ -195,7 +195,8 @@ function DailyReport() {
         enabled: !!settings.enableAiDailyReport,
         api_url: settings.aiApiUrl || '',
         api_key: '',
-        model: settings.aiModel || ''
+        model: settings.aiModel || '',
+        proxy: proxyConfig(settings.aiProxyUrl)
       }
 
       if (aiConfig.enabled) {
@@ -1,25 +1,63 @@
 import { useState, useEffect } from 'react'
-import { Modal, Select, Empty, Button, message } from 'antd'
+import { Modal, Select, Empty, Button, Tag, Tooltip, message } from 'antd'
 import { getDepartments, getDefaultDepartment } from '../services/settings'
+import { templateApi, USE_MOCK } from '../services/api'
 import './DepartmentSelectModal.css'
 
+// 建议置信度达到该值时默认选中建议的部门
+const AUTO_SELECT_CONFIDENCE = 0.5
+
+const BASIS_LABELS = { sender: '发件人', domain: '域名', keyword: '关键词' }
+
+const formatBasis = (basis = []) => basis
+  .map(b => {
+    const [kind, ...rest] = b.split(':')
+    return `${BASIS_LABELS[kind] || kind}：${rest.join(':')}`
+  })
+  .join('；')
+
 function DepartmentSelectModal({ open, mail, onConfirm, onCancel, title, description }) {
   const [departments, setDepartments] = useState([])
   const [selectedDeptId, setSelectedDeptId] = useState(null)
+  const [templates, setTemplates] = useState([])
+  // 空字符串表示按部门自动选择模板
+  const [templateId, setTemplateId] = useState('')
 
   useEffect(() => {
     if (open) {
       const depts = getDepartments()
       setDepartments(depts)
-      // 默认选中默认部门
+      // 根据历史任务推荐的部门足够可信时优先选中，否则选中默认部门
+      const top = mail?.department_suggestions?.[0]
+      const suggestedDept = top && top.confidence >= AUTO_SELECT_CONFIDENCE
+        ? depts.find(d => d.name === top.department)
+        : null
       const defaultDept = getDefaultDepartment()
-      if (defaultDept) {
+      if (suggestedDept) {
+        setSelectedDeptId(suggestedDept.id)
+      } else if (defaultDept) {
         setSelectedDeptId(defaultDept.id)
       } else if (depts.length > 0) {
         setSelectedDeptId(depts[0].id)
       }
+
+      setTemplateId('')
+      if (!USE_MOCK) {
+        templateApi.getTemplates()
+          .then(result => {
+            const saved = result.data || []
+            const builtin = saved.some(t => t.id === result.builtin?.id) ? [] : [result.builtin].filter(Boolean)
+            setTemplates([...builtin, ...saved])
+          })
+          .catch(e => console.error('读取任务模板失败:', e))
+      }
     }
-  }, [open])
+  }, [open, mail])
+
+  // 只展示设置中仍存在的部门
+  const suggestions = (mail?.department_suggestions || [])
+    .map(s => ({ ...s, dept: departments.find(d => d.name === s.department) }))
+    .filter(s => s.dept)
 
   const handleConfirm = () => {
     if (!selectedDeptId) {
@@ -27,12 +65,12 @@ function DepartmentSelectModal({ open, mail, onConfirm, onCancel, title, descrip
       return
     }
     const selectedDept = departments.find(d => d.id === selectedDeptId)
-    onConfirm(selectedDept)
+    onConfirm(selectedDept, templateId)
   }
 
   const handleSkip = () => {
     // 暂不填写归属部门，仍会生成工作记录.md（部门留空）
-    onConfirm(null)
+    onConfirm(null, templateId)
   }
 
   return (
@@ -95,6 +133,36 @@ function DepartmentSelectModal({ open, mail, onConfirm, onCancel, title, descrip
                 </div>
               )}
             />
+            {suggestions.length > 0 && (
+              <div className="dept-suggestions">
+                <span className="select-hint">推荐：</span>
+                {suggestions.map(s => (
+                  <Tooltip key={s.department} title={`依据 ${formatBasis(s.basis)}`}>
+                    <Tag
+                      color={s.dept.id === selectedDeptId ? 'blue' : 'default'}
+                      className="dept-suggestion"
+                      onClick={() => setSelectedDeptId(s.dept.id)}
+                    >
+                      {s.department} {Math.round(s.confidence * 100)}%
+                    </Tag>
+                  </Tooltip>
+                ))}
+              </div>
+            )}
+            {templates.length > 1 && (
+              <>
+                <label className="select-label template-label">任务模板：</label>
+                <Select
+                  style={{ width: '100%' }}
+                  value={templateId}
+                  onChange={setTemplateId}
+                  options={[
+                    { label: '按部门自动选择', value: '' },
+                    ...templates.map(t => ({ label: t.name || t.id, value: t.id }))
+                  ]}
+                />
+              </>
+            )}
             <p className="select-hint">
               选择部门后，将写入"工作记录.md"的归属部门字段，便于后续归档
             </p>
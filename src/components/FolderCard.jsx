@@ -1,6 +1,7 @@
 import { Button, Card, Space, Tag, Tooltip } from 'antd'
 import {
   ClockCircleOutlined,
+  CopyOutlined,
   EditOutlined,
   FileTextOutlined,
   FolderOutlined,
@@ -23,6 +24,8 @@ const UNKNOWN_LABEL = '\u672a\u77e5'
 const UNSET_DEPARTMENT_LABEL = '\u672a\u6307\u5b9a\u90e8\u95e8'
 const BTN_RECORD = '\u8bb0\u5f55'
 const BTN_ARCHIVE = '\u5f52\u6863'
+const BTN_CLONE = '\u514b\u9686'
+const CLONE_TIP = '\u4ee5\u6b64\u4efb\u52a1\u4e3a\u6a21\u677f\u521b\u5efa\u65b0\u4efb\u52a1'
 const OPEN_FOLDER_PREFIX = '\u6253\u5f00\u6587\u4ef6\u5939\uff1a'
 const EDIT_DEPT_TIP = '\u70b9\u51fb\u7f16\u8f91\u6240\u5c5e\u90e8\u95e8'
 const FILE_UNIT = '\u4e2a\u6587\u4ef6'
@@ -40,7 +43,7 @@ function getSourceLabel(source) {
   return SOURCE_LABEL_MAP[key] || source || UNKNOWN_LABEL
 }
 
-function FolderCard({ folder, onArchive, onEditDept, onViewContent, onOpenFolder }) {
+function FolderCard({ folder, onArchive, onEditDept, onViewContent, onOpenFolder, onClone }) {
   const title = getDisplayTitle(folder)
   const hasDept = Boolean(folder?.department && folder.department.trim())
   const departmentLabel = hasDept ? folder.department : UNSET_DEPARTMENT_LABEL
@@ -68,6 +71,13 @@ function FolderCard({ folder, onArchive, onEditDept, onViewContent, onOpenFolder
               <Button size="small" icon={<EditOutlined />} onClick={() => onViewContent(folder)}>
                 {BTN_RECORD}
               </Button>
+              {onClone && (
+                <Tooltip title={CLONE_TIP}>
+                  <Button size="small" icon={<CopyOutlined />} onClick={() => onClone(folder)}>
+                    {BTN_CLONE}
+                  </Button>
+                </Tooltip>
+              )}
               <Button
                 size="small"
                 type="primary"
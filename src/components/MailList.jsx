@@ -1,12 +1,44 @@
 import { useState, useEffect, useMemo, useRef } from 'react'
 import { List, Card, Button, Tag, Collapse, message, Spin, Empty, Tooltip, Modal, Alert } from 'antd'
-import { FolderAddOutlined, PaperClipOutlined, ReloadOutlined, EyeOutlined, SettingOutlined, CheckCircleOutlined, InboxOutlined } from '@ant-design/icons'
-import { mailApi, folderApi, archiveApi, USE_MOCK } from '../services/api'
+import { FolderAddOutlined, PaperClipOutlined, ReloadOutlined, EyeOutlined, SettingOutlined, CheckCircleOutlined, InboxOutlined, StarFilled } from '@ant-design/icons'
+import { mailApi, folderApi, proxyConfig, USE_MOCK } from '../services/api'
 import { getSettings, formatFolderName, cleanSubjectForFolder, generateMailHash, getDepartments } from '../services/settings'
 import { readMailCache, saveMailCache } from '../services/mailCache'
 import DepartmentSelectModal from './DepartmentSelectModal'
 import './MailList.css'
 
+// 非个人邮件在列表中标注分类
+const MAIL_CATEGORY_TAGS = {
+  notification: { label: '通知', color: 'cyan' },
+  bulk: { label: '群发', color: 'purple' },
+  automated: { label: '自动', color: 'default' }
+}
+
+// 签名 / 加密状态标签，悬停显示签名人和失败原因
+function renderSecurityTags(security) {
+  const protocol = security.protocol === 'pgp' ? 'PGP' : 'S/MIME'
+  const tags = []
+  const sig = security.signature
+  if (security.signed && sig) {
+    const signer = [sig.signer, sig.email && sig.email !== sig.signer ? `<${sig.email}>` : ''].filter(Boolean).join(' ')
+    const tip = [sig.error, signer && `签名人：${signer}`, sig.issuer && `颁发者：${sig.issuer}`, sig.signed_at && `签名时间：${new Date(sig.signed_at).toLocaleString()}`]
+      .filter(Boolean).join('\n')
+    tags.push(
+      <Tooltip key="sig" title={<span style={{ whiteSpace: 'pre-line' }}>{tip}</span>}>
+        <Tag color={sig.valid ? 'green' : 'red'}>{protocol} 签名{sig.valid ? '有效' : '无效'}</Tag>
+      </Tooltip>
+    )
+  }
+  if (security.encrypted) {
+    tags.push(
+      <Tooltip key="enc" title={security.error}>
+        <Tag color={security.decrypted ? 'blue' : 'orange'}>{protocol} {security.decrypted ? '已解密' : '已加密'}</Tag>
+      </Tooltip>
+    )
+  }
+  return tags
+}
+
 function MailList() {
   const [mails, setMails] = useState([])
   const [loading, setLoading] = useState(true)
@@ -23,10 +55,8 @@ function MailList() {
   const [selectedMailForFolder, setSelectedMailForFolder] = useState(null)
   // 连接状态
   const [connectionError, setConnectionError] = useState(null)
-  // 已生成的邮件 hash 映射：{ mailHash: 'working' | 'archived' }
-  const [generatedHashMap, setGeneratedHashMap] = useState({})
-  // 邮件 id -> hash 缓存（用于同步渲染中查找状态）
-  const [mailHashCache, setMailHashCache] = useState({})
+  // 本次会话中新建了任务的邮件：{ mailId: 'working' }，其余状态来自后端返回的 task_status
+  const [createdTaskMap, setCreatedTaskMap] = useState({})
 
   // 记录最近一次获取数据的天数范围
   const [fetchDays, setFetchDays] = useState(7)
@@ -86,7 +116,11 @@ function MailList() {
               port: settings.mailPort || 993,
               username: settings.mailUsername,
               password: password,
-              use_ssl: settings.mailUseSsl !== false
+              use_ssl: settings.mailUseSsl !== false,
+              trust_store: settings.mailTrustStore || '',
+              smime_key: settings.mailSmimeKey || '',
+              proxy: proxyConfig(settings.mailProxyUrl),
+              trace: !!settings.mailTrace
             })
           }
         } catch (connectError) {
@@ -115,9 +149,6 @@ function MailList() {
       if (showHint) {
         showRefreshHint(refreshedAt)
       }
-
-      // 加载已生成状态：通过后端扫描工作目录获取已有的 hash
-      loadGeneratedHashes(mailData, settings)
     } catch (error) {
       // 如果是过时的请求，忽略其错误
       if (currentFetchId !== fetchIdRef.current) return
@@ -151,7 +182,6 @@ function MailList() {
       setFetchDays(cached.mailDays)
       setLastRefreshAt(cached.cachedAt || 0)
       setLoading(false)
-      loadGeneratedHashes(cached.mails || [], settings)
       fetchMails()
       return
     }
@@ -167,51 +197,6 @@ function MailList() {
     }
   }, [])
 
-  // 加载已生成状态：扫描工作目录和归档目录，构建 hash -> status 映射
-  const loadGeneratedHashes = async (mailList, settings) => {
-    try {
-      // 为所有邮件预计算 hash
-      const hashCacheEntries = await Promise.all(
-        mailList.map(async (mail) => {
-          const hash = await generateMailHash(mail)
-          return [mail.id, hash]
-        })
-      )
-      const newMailHashCache = Object.fromEntries(hashCacheEntries)
-      setMailHashCache(newMailHashCache)
-
-      // 扫描工作目录
-      const scanResult = await archiveApi.scan(settings.scanPath || settings.folderPath)
-      const hashMap = {}
-      if (scanResult.success && scanResult.folders) {
-        scanResult.folders.forEach(f => {
-          if (f.hash) hashMap[f.hash] = 'working'
-        })
-      }
-
-      // 扫描各部门归档目录
-      const departments = getDepartments()
-      for (const dept of departments) {
-        if (dept.archivePath) {
-          try {
-            const archiveResult = await archiveApi.scan(dept.archivePath, true)
-            if (archiveResult.success && archiveResult.folders) {
-              archiveResult.folders.forEach(f => {
-                if (f.hash) hashMap[f.hash] = 'archived'
-              })
-            }
-          } catch {
-            // 归档目录不存在时忽略
-          }
-        }
-      }
-
-      setGeneratedHashMap(hashMap)
-    } catch (err) {
-      console.error('加载已生成状态失败:', err)
-    }
-  }
-
   // 计算时间轴数据
   const timelineData = useMemo(() => {
     if (!mails || mails.length === 0) return []
@@ -327,42 +312,65 @@ function MailList() {
 
   // 打开部门选择弹窗（先检查重复，再加载邮件详情）
   const openDeptModal = async (mail) => {
-    // 检查是否已生成过
-    try {
-      const mailHash = await generateMailHash(mail)
-      const settings = getSettings()
-      const departments = getDepartments()
-      const archivePaths = departments.map(d => d.archivePath).filter(Boolean)
-
-      const checkResult = await folderApi.checkHash(
-        mailHash,
-        settings.scanPath || settings.folderPath,
-        archivePaths
-      )
-
-      if (checkResult.found) {
-        const match = checkResult.matches[0]
-        const statusText = match.status === 'archived' ? '已归档' : '工作中'
-        const confirmed = await new Promise(resolve => {
-          Modal.confirm({
-            title: '该邮件已生成过工作目录',
-            content: (
-              <div>
-                <p>已存在目录：<strong>{match.name}</strong>（{statusText}）</p>
-                <p>再次生成将创建新的工作目录，原有工作记录不受影响。是否继续？</p>
-              </div>
-            ),
-            okText: '继续生成',
-            cancelText: '取消',
-            onOk: () => resolve(true),
-            onCancel: () => resolve(false)
-          })
+    // 同一会话已有任务：优先提供追加到该任务
+    if (!createdTaskMap[mail.id] && mail.task_status === 'none' && mail.thread_task_path) {
+      const choice = await new Promise(resolve => {
+        Modal.confirm({
+          title: '该邮件所在会话已有工作目录',
+          content: (
+            <div>
+              <p>会话任务：<strong>{mail.thread_task_path}</strong></p>
+              <p>可将此回复追加到该任务，或为它新建工作目录。</p>
+            </div>
+          ),
+          okText: '追加到该任务',
+          cancelText: '新建任务',
+          onOk: () => resolve('append'),
+          onCancel: () => resolve('create')
         })
-        if (!confirmed) return
+      })
+      if (choice === 'append') {
+        await handleAppendToTask(mail, mail.thread_task_path)
+        return
       }
-    } catch (err) {
-      console.error('查重失败:', err)
-      // 查重失败不阻断流程
+    }
+
+    // 检查是否已生成过（列表接口已返回 task_status）
+    const status = createdTaskMap[mail.id] || mail.task_status
+    if (status === 'working' || status === 'archived') {
+      const statusText = status === 'archived' ? '已归档' : '工作中'
+      const canAppend = status === 'working' && !!mail.task_path
+      const choice = await new Promise(resolve => {
+        const modal = Modal.confirm({
+          title: '该邮件已生成过工作目录',
+          content: (
+            <div>
+              <p>已存在目录：<strong>{mail.task_path || '-'}</strong>（{statusText}）</p>
+              <p>再次生成将创建新的工作目录，原有工作记录不受影响。是否继续？</p>
+            </div>
+          ),
+          okText: '继续生成',
+          cancelText: '取消',
+          onOk: () => resolve('create'),
+          onCancel: () => resolve(null),
+          footer: (_, { OkBtn, CancelBtn }) => (
+            <>
+              <CancelBtn />
+              {canAppend && (
+                <Button onClick={() => { modal.destroy(); resolve('append') }}>追加到该任务</Button>
+              )}
+              <OkBtn />
+            </>
+          )
+        })
+      })
+      if (!choice) return
+      if (choice === 'append') {
+        await handleAppendToTask(mail, mail.task_path)
+        return
+      }
+      // 用户确认重复生成，后端不再以 409 拒绝
+      mail = { ...mail, forceCreate: true }
     }
 
     // 如果邮件还没有正文，先加载详情
@@ -374,10 +382,10 @@ function MailList() {
           // 更新邮件列表中的这封邮件
           setMails(prevMails => prevMails.map(m =>
             m.id === mail.id
-              ? { ...m, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content }
+              ? { ...m, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content, events: result.data.events, new_content: result.data.new_content, sanitized_html: result.data.sanitized_html, remote_images_blocked: result.data.remote_images_blocked, security: result.data.security }
               : m
           ))
-          mail = { ...mail, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content }
+          mail = { ...mail, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content, events: result.data.events, new_content: result.data.new_content, sanitized_html: result.data.sanitized_html, remote_images_blocked: result.data.remote_images_blocked, security: result.data.security }
         }
       } catch (error) {
         console.error('加载邮件详情失败:', error)
@@ -390,16 +398,16 @@ function MailList() {
   }
 
   // 确认选择部门后创建文件夹
-  const handleDeptConfirm = async (department) => {
+  const handleDeptConfirm = async (department, template) => {
     setDeptModalOpen(false)
     if (selectedMailForFolder) {
-      await handleCreateFolder(selectedMailForFolder, department)
+      await handleCreateFolder(selectedMailForFolder, department, template)
     }
     setSelectedMailForFolder(null)
   }
 
   // 创建文件夹（始终包含附件下载）
-  const handleCreateFolder = async (mail, department = null) => {
+  const handleCreateFolder = async (mail, department = null, template = '') => {
     setCreating(prev => ({ ...prev, [mail.id]: true }))
     try {
       // 如果邮件没有正文，先加载详情
@@ -408,7 +416,7 @@ function MailList() {
         try {
           const result = await mailApi.getMailDetail(mail.id)
           if (result.success && result.data) {
-            mailData = { ...mail, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content }
+            mailData = { ...mail, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content, events: result.data.events, new_content: result.data.new_content, sanitized_html: result.data.sanitized_html, remote_images_blocked: result.data.remote_images_blocked, security: result.data.security }
             // 更新邮件列表
             setMails(prevMails => prevMails.map(m =>
               m.id === mail.id ? mailData : m
@@ -422,6 +430,7 @@ function MailList() {
       const settings = getSettings()
       const folderName = formatFolderName(settings.folderNameFormat, mailData)
       const mailHash = await generateMailHash(mailData)
+      const archivePaths = getDepartments().map(d => d.archivePath).filter(Boolean)
 
       const requestData = {
         mail_id: mailData.id,
@@ -429,8 +438,11 @@ function MailList() {
         date: mailData.date,
         from_addr: mailData.from,
         body: mailData.body || '',
+        new_content: mailData.new_content || '',
         base_path: settings.folderPath,
         folder_name: folderName,
+        // 由后端按格式生成最终名称（支持部门、序号等变量，重名时自动加后缀）
+        folder_name_format: settings.folderNameFormat,
         use_sub_folder: settings.useSubFolder,
         sub_folder_name: settings.subFolderName,
         save_mail_content: settings.saveMailContent,
@@ -440,16 +452,29 @@ function MailList() {
         attachments: mailData.attachments || [],
         // 部门信息
         department: department ? department.name : null,
+        // 任务模板，留空时按部门选择
+        template: template || '',
         source: '邮件',
-        hash: mailHash
+        hash: mailHash,
+        archive_paths: archivePaths,
+        force: !!mail.forceCreate,
+        // 会议邀请 / 截止日期，后端据此填写 due 和 meeting_time
+        events: mailData.events || [],
+        // 签名 / 加密信息，写入工作记录的来源摘要
+        security: mailData.security || null
       }
 
       // 始终使用 createWithAttachments，如果有附件会自动下载
       const result = await folderApi.createWithAttachments(requestData)
-      message.success(result.message)
+      // 附件下载失败不影响任务创建，但需要提示用户
+      if ((result.steps || []).some(step => !step.ok)) {
+        message.warning(result.message)
+      } else {
+        message.success(result.message)
+      }
 
-      // 更新已生成 hash 映射
-      setGeneratedHashMap(prev => ({ ...prev, [mailHash]: 'working' }))
+      // 标记为已生成
+      setCreatedTaskMap(prev => ({ ...prev, [mail.id]: 'working' }))
     } catch (error) {
       message.error(error.response?.data?.detail || '创建文件夹失败')
     } finally {
@@ -457,14 +482,40 @@ function MailList() {
     }
   }
 
+  // 将邮件追加到已有任务
+  const handleAppendToTask = async (mail, folderPath) => {
+    setCreating(prev => ({ ...prev, [mail.id]: true }))
+    try {
+      const result = await folderApi.appendMail({
+        folder_path: folderPath,
+        mail_id: mail.id,
+        subject: mail.subject,
+        date: mail.date,
+        from_addr: mail.from,
+        body: mail.body || '',
+        download_attachments: true
+      })
+      if (result.attachments_error) {
+        message.warning(result.message)
+      } else {
+        message.success(result.message)
+      }
+      setCreatedTaskMap(prev => ({ ...prev, [mail.id]: 'working' }))
+    } catch (error) {
+      message.error(error.response?.data?.detail || '追加邮件失败')
+    } finally {
+      setCreating(prev => ({ ...prev, [mail.id]: false }))
+    }
+  }
+
   // 预览邮件（先加载详情）
   const handlePreviewMail = async (mail) => {
     if (!mail.body) {
       setLoadingDetail(true)
       try {
-        const result = await mailApi.getMailDetail(mail.id)
+        const result = await mailApi.getMailDetail(mail.id, { loadImages: !!getSettings().loadRemoteImages })
         if (result.success && result.data) {
-          const updatedMail = { ...mail, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content }
+          const updatedMail = { ...mail, body: result.data.body, attachments: result.data.attachments, raw_content: result.data.raw_content, events: result.data.events, new_content: result.data.new_content, sanitized_html: result.data.sanitized_html, remote_images_blocked: result.data.remote_images_blocked, security: result.data.security }
           // 更新邮件列表
           setMails(prevMails => prevMails.map(m =>
             m.id === mail.id ? updatedMail : m
@@ -485,6 +536,19 @@ function MailList() {
     }
   }
 
+  // 通过后端代理加载预览中被屏蔽的远程图片
+  const handleLoadPreviewImages = async () => {
+    if (!previewMail) return
+    try {
+      const result = await mailApi.getMailDetail(previewMail.id, { loadImages: true })
+      if (result.success && result.data) {
+        setPreviewMail(prev => ({ ...prev, sanitized_html: result.data.sanitized_html, remote_images_blocked: false }))
+      }
+    } catch (error) {
+      message.error('加载图片失败')
+    }
+  }
+
   const formatDate = (dateStr) => {
     if (!dateStr) return ''
     try {
@@ -597,22 +661,26 @@ function MailList() {
                 <Card className="mail-item" key={mail.id} id={`mail-${mail.id}`}>
                   <div className="mail-content">
                     <div className="mail-info">
-                      <div className="mail-subject">{mail.subject}</div>
+                      <div className={`mail-subject${mail.seen === false ? ' mail-unread' : ''}`}>
+                        {mail.flagged && <StarFilled className="mail-flagged" />}
+                        {mail.subject}
+                      </div>
                       <div className="mail-meta">
-                        <span className="mail-from">{mail.from}</span>
+                        <Tooltip title={mail.from_addresses?.[0]?.address}>
+                          <span className="mail-from">{mail.from}</span>
+                        </Tooltip>
                         <span className="mail-date">{formatDate(mail.date)}</span>
                       </div>
-                      {mail.body && (
+                      {(mail.body || mail.snippet) && (
                         <div className="mail-body-preview">
-                          {getBodyPreview(mail.body)}
+                          {getBodyPreview(mail.body || mail.snippet)}
                         </div>
                       )}
                     </div>
 
                     <div className="mail-actions">
                       {(() => {
-                        const mailHash = mailHashCache[mail.id]
-                        const status = mailHash && generatedHashMap[mailHash]
+                        const status = createdTaskMap[mail.id] || mail.task_status
                         if (status === 'archived') {
                           return (
                             <Tag icon={<InboxOutlined />} color="default">
@@ -622,13 +690,25 @@ function MailList() {
                         } else if (status === 'working') {
                           return (
                             <Tag icon={<CheckCircleOutlined />} color="success">
-                              已生成
+                              {mail.auto_appended ? '已追加' : '已生成'}
                             </Tag>
                           )
+                        } else if (mail.thread_task_path) {
+                          return (
+                            <Tooltip title={mail.thread_task_path}>
+                              <Tag color="processing">会话已有任务</Tag>
+                            </Tooltip>
+                          )
                         }
                         return null
                       })()}
 
+                      {MAIL_CATEGORY_TAGS[mail.category] && (
+                        <Tag color={MAIL_CATEGORY_TAGS[mail.category].color}>
+                          {MAIL_CATEGORY_TAGS[mail.category].label}
+                        </Tag>
+                      )}
+
                       {(mail.attachment_count > 0 || mail.has_attachments) && (
                         <Tag icon={<PaperClipOutlined />} color="blue">
                           {mail.attachment_count > 0 ? `${mail.attachment_count} 个附件` : '有附件'}
@@ -746,6 +826,21 @@ function MailList() {
                 <span className="label">日期：</span>
                 <span className="value">{formatDate(previewMail.date)}</span>
               </div>
+              {previewMail.events?.length > 0 && (
+                <div className="preview-meta">
+                  <span className="label">会议：</span>
+                  <span className="value">
+                    {previewMail.events[0].summary} {formatDate(previewMail.events[0].start)}
+                    {previewMail.events[0].location ? ` @ ${previewMail.events[0].location}` : ''}
+                  </span>
+                </div>
+              )}
+              {previewMail.security && (
+                <div className="preview-meta">
+                  <span className="label">安全：</span>
+                  <span className="value">{renderSecurityTags(previewMail.security)}</span>
+                </div>
+              )}
               {previewMail.attachment_count > 0 && (
                 <div className="preview-meta">
                   <span className="label">附件：</span>
@@ -753,9 +848,28 @@ function MailList() {
                 </div>
               )}
             </div>
-            <div className="preview-body">
-              {previewMail.body || '(无正文内容)'}
-            </div>
+            {previewMail.remote_images_blocked && (
+              <Alert
+                type="info"
+                showIcon
+                style={{ marginBottom: 12 }}
+                message="已屏蔽远程图片"
+                action={<Button size="small" onClick={handleLoadPreviewImages}>显示图片</Button>}
+              />
+            )}
+            {previewMail.sanitized_html ? (
+              // 后端已清理过的 HTML，仍放在无脚本权限的沙箱 iframe 中展示
+              <iframe
+                className="preview-html"
+                title="邮件正文"
+                sandbox="allow-popups allow-popups-to-escape-sandbox"
+                srcDoc={previewMail.sanitized_html}
+              />
+            ) : (
+              <div className="preview-body">
+                {previewMail.body || '(无正文内容)'}
+              </div>
+            )}
             {previewMail.attachments && previewMail.attachments.length > 0 && (
               <div className="preview-attachments">
                 <div className="attachments-title">附件列表：</div>
@@ -1,6 +1,6 @@
 import { useMemo, useState } from 'react'
-import { Button, Card, DatePicker, Divider, Input, message, Typography } from 'antd'
-import { CalendarOutlined, FolderAddOutlined } from '@ant-design/icons'
+import { Button, Card, DatePicker, Divider, Input, message, Typography, Upload } from 'antd'
+import { CalendarOutlined, FolderAddOutlined, InboxOutlined } from '@ant-design/icons'
 import dayjs from 'dayjs'
 import { folderApi } from '../services/api'
 import { formatFolderName, generateFolderHash, getSettings } from '../services/settings'
@@ -9,6 +9,7 @@ import './QuickCreate.css'
 
 const { Title, Text } = Typography
 const { TextArea } = Input
+const { Dragger } = Upload
 
 function buildQuickCreateFolderName(settings, workContent, selectedDate) {
   const content = workContent.trim()
@@ -26,6 +27,8 @@ function QuickCreate() {
   const [selectedDate, setSelectedDate] = useState(dayjs())
   const [creating, setCreating] = useState(false)
   const [deptModalOpen, setDeptModalOpen] = useState(false)
+  // 交接的来源文件，创建时一并上传到 00_来源资料
+  const [sourceFiles, setSourceFiles] = useState([])
 
   const folderPreview = useMemo(() => {
     const settings = getSettings()
@@ -40,12 +43,12 @@ function QuickCreate() {
     setDeptModalOpen(true)
   }
 
-  const handleDeptConfirm = async (department) => {
+  const handleDeptConfirm = async (department, template) => {
     setDeptModalOpen(false)
-    await createFolder(department)
+    await createFolder(department, template)
   }
 
-  const createFolder = async (department = null) => {
+  const createFolder = async (department = null, template = '') => {
     setCreating(true)
     try {
       const settings = getSettings()
@@ -58,6 +61,8 @@ function QuickCreate() {
       const requestData = {
         base_path: settings.folderPath,
         folder_name: folderName,
+        // 由后端按格式生成最终名称（支持部门、序号等变量，重名时自动加后缀）
+        folder_name_format: settings.folderNameFormat,
         mail_id: null,
         subject: workContent.trim(),
         date: selectedDate.toISOString(),
@@ -67,14 +72,21 @@ function QuickCreate() {
         save_mail_content: false,
         attachments: [],
         department: department ? department.name : null,
+        // 任务模板，留空时按部门选择
+        template: template || '',
         source: 'manual',
         hash: await generateFolderHash(folderName)
       }
 
-      const result = await folderApi.create(requestData)
-      message.success(result.message || `文件夹已创建: ${folderName}`)
+      const result = await folderApi.create(requestData, sourceFiles)
+      if ((result.steps || []).some(step => !step.ok)) {
+        message.warning(result.message)
+      } else {
+        message.success(result.message || `文件夹已创建: ${folderName}`)
+      }
       setWorkContent('')
       setSelectedDate(dayjs())
+      setSourceFiles([])
     } catch (error) {
       message.error(error.response?.data?.detail || '创建文件夹失败')
     } finally {
@@ -115,6 +127,25 @@ function QuickCreate() {
             />
           </div>
 
+          <div className="form-item">
+            <label>来源文件（可选）</label>
+            <Dragger
+              multiple
+              fileList={sourceFiles}
+              beforeUpload={(file) => {
+                setSourceFiles(prev => [...prev, file])
+                return false
+              }}
+              onRemove={(file) => {
+                setSourceFiles(prev => prev.filter(f => f.uid !== file.uid))
+              }}
+            >
+              <p className="ant-upload-drag-icon"><InboxOutlined /></p>
+              <p className="ant-upload-text">点击或拖拽文件到此处</p>
+              <p className="ant-upload-hint">文件将保存到 00_来源资料 并记录在工作记录中</p>
+            </Dragger>
+          </div>
+
           {folderPreview && (
             <div className="folder-preview">
               <label>文件夹名称预览</label>
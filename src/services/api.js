@@ -1,10 +1,15 @@
 import axios from 'axios'
 import { mockApi } from './mockData'
-import { getSettings, formatFolderName } from './settings'
+import { getSettings, getDepartments, formatFolderName } from './settings'
 
 // 生产环境下直接指向后端端口，开发环境下走 Vite 代理
 const API_BASE = import.meta.env.DEV ? '/api' : 'http://localhost:18000/api'
 
+// 由应用启动的后端只接受带有本次启动令牌的请求
+if (window.electronAPI?.appToken) {
+  axios.defaults.headers.common['X-Knot-Token'] = window.electronAPI.appToken
+}
+
 // 是否使用 Mock 模式（外网开发时设为 true）
 // Mock 模式只影响邮件获取，文件夹创建仍通过后端实现
 export const USE_MOCK = false
@@ -17,17 +22,28 @@ export const mailApi = {
     return response.data
   },
 
-  // 获取邮件列表
+  // 同步并获取邮件列表：后端先对拉取到的邮件执行邮件规则，再返回列表
   getMailList: async (limit = 50, days = 7) => {
     if (USE_MOCK) return mockApi.getMailList()
-    const response = await axios.get(`${API_BASE}/mail/list`, { params: { limit, days } })
+    // 同时传入工作目录和归档目录，后端据此返回每封邮件的 task_status
+    const settings = getSettings()
+    const params = new URLSearchParams({ limit, days, scan_path: settings.scanPath || settings.folderPath || '' })
+    getDepartments().forEach(d => {
+      if (d.archivePath) params.append('archive_path', d.archivePath)
+    })
+    if (settings.autoAppendReplies) params.append('auto_append', 'true')
+    if (settings.mailCategories?.length) params.append('category', settings.mailCategories.join(','))
+    const response = await axios.post(`${API_BASE}/mail/sync?${params.toString()}`)
     return response.data
   },
 
   // 获取邮件详情（正文和附件信息）
-  getMailDetail: async (mailId) => {
+  // loadImages 为 true 时，远程图片经后端代理加载（默认屏蔽）
+  getMailDetail: async (mailId, { loadImages = false } = {}) => {
     if (USE_MOCK) return mockApi.getMailDetail ? mockApi.getMailDetail(mailId) : { success: true, data: { body: '', attachments: [] } }
-    const response = await axios.get(`${API_BASE}/mail/${mailId}/detail`)
+    const response = await axios.get(`${API_BASE}/mail/${mailId}/detail`, {
+      params: loadImages ? { load_images: true } : {}
+    })
     return response.data
   },
 
@@ -36,14 +52,34 @@ export const mailApi = {
     if (USE_MOCK) return mockApi.getAttachments(mailId)
     const response = await axios.get(`${API_BASE}/mail/${mailId}/attachments`)
     return response.data
+  },
+
+  // 连接状态（连接中/空闲/重连中/登录失败、最近错误、服务器能力、延迟）
+  // check 为 true 时先检测连接，必要时按退避策略重连
+  getStatus: async (check = false) => {
+    const response = await axios.get(`${API_BASE}/mail/status`, { params: check ? { check: true } : {} })
+    return response.data
+  },
+
+  // IMAP 协议跟踪日志（不含密码）的末尾部分
+  getTrace: async () => {
+    const response = await axios.get(`${API_BASE}/mail/trace`, { responseType: 'text' })
+    return response.data
   }
 }
 
 export const folderApi = {
   // 创建文件夹 - 始终调用后端 API 以真正创建文件夹
-  create: async (requestData) => {
+  // files 为来源文件（File 列表），有文件时以 multipart 上传
+  create: async (requestData, files = []) => {
     try {
-      const response = await axios.post(`${API_BASE}/folder/create`, requestData)
+      let body = requestData
+      if (files.length > 0) {
+        body = new FormData()
+        body.append('request', JSON.stringify(requestData))
+        files.forEach(file => body.append('files', file, file.name))
+      }
+      const response = await axios.post(`${API_BASE}/folder/create`, body)
       return response.data
     } catch (error) {
       // 如果后端不可用，返回模拟结果（仅用于 UI 测试）
@@ -77,6 +113,18 @@ export const folderApi = {
     }
   },
 
+  // 以已有任务为模板创建新任务：复制目录结构和选中的子目录（不含成果输出），生成新的工作记录
+  clone: async (requestData) => {
+    const response = await axios.post(`${API_BASE}/folder/clone`, requestData)
+    return response.data
+  },
+
+  // 将邮件追加到已有任务（保存到 00_来源资料 下的日期子目录并记录工作过程）
+  appendMail: async (requestData) => {
+    const response = await axios.post(`${API_BASE}/folder/append-mail`, requestData)
+    return response.data
+  },
+
   // 检查 hash 是否已存在（查重）
   checkHash: async (hash, scanPath, archivePaths = []) => {
     const params = new URLSearchParams({ hash, scan_path: scanPath })
@@ -88,6 +136,19 @@ export const folderApi = {
   }
 }
 
+export const templateApi = {
+  // 任务模板（目录结构、预置文件、工作记录模板），同时返回内置模板和可用变量
+  getTemplates: async () => {
+    const response = await axios.get(`${API_BASE}/templates`)
+    return response.data
+  },
+
+  saveTemplates: async (templates) => {
+    const response = await axios.put(`${API_BASE}/templates`, templates)
+    return response.data
+  }
+}
+
 export const archiveApi = {
   // 扫描工作文件夹
   scan: async (scanPath, recursive = false) => {
@@ -135,6 +196,41 @@ export const archiveApi = {
   }
 }
 
+// 允许后端读写的工作区和归档根目录，范围之外的路径会被拒绝
+export const rootsApi = {
+  getRoots: async () => {
+    const response = await axios.get(`${API_BASE}/settings/roots`)
+    return response.data
+  },
+
+  saveRoots: async (roots) => {
+    const response = await axios.put(`${API_BASE}/settings/roots`, roots)
+    return response.data
+  }
+}
+
+// 全局网络代理设置保存在后端，邮箱和 AI 未单独设置代理时使用
+export const networkApi = {
+  getSettings: async () => {
+    const response = await axios.get(`${API_BASE}/settings/network`)
+    return response.data
+  },
+
+  saveSettings: async (settings) => {
+    const response = await axios.put(`${API_BASE}/settings/network`, settings)
+    return response.data
+  },
+
+  // 逐跳测试连接（代理 → 转发 → TLS → 登录/请求），返回失败的环节
+  testConnection: async (request) => {
+    const response = await axios.post(`${API_BASE}/network/test`, request)
+    return response.data
+  }
+}
+
+// 单独设置的代理地址转换为后端的 proxy 字段，留空则不传，使用全局代理
+export const proxyConfig = (url) => (url && url.trim() ? { url: url.trim() } : undefined)
+
 export const reportApi = {
   // 生成日报日志
   generateDaily: async (requestData) => {
@@ -26,9 +26,24 @@ const DEFAULT_SETTINGS = {
   mailUsername: '',
   mailPasswordEncrypted: null,  // 加密存储的密码
   mailUseSsl: true,
+  // S/MIME 信任证书（文件或目录）和解密私钥路径，留空分别表示系统证书 / 不解密
+  mailTrustStore: '',
+  mailSmimeKey: '',
+  // 邮箱账户代理（socks5:// 或 http://），留空使用全局代理
+  mailProxyUrl: '',
+  // 记录 IMAP 协议跟踪日志（不含密码），用于排查服务器问题
+  mailTrace: false,
   // 邮件获取设置
   mailLimit: 50,  // 获取邮件数量限制
   mailDays: 7,    // 获取最近多少天的邮件（0表示不限制）
+  // 同一会话的回复到达时，自动追加到已有任务
+  autoAppendReplies: false,
+  // 邮件列表只显示这些分类（personal / notification / bulk / automated），空数组表示全部
+  mailCategories: [],
+  // 预览 HTML 邮件时加载远程图片（经后端代理，默认屏蔽以防追踪）
+  loadRemoteImages: false,
+  // AI 接口代理，留空使用全局代理
+  aiProxyUrl: '',
   // 部门列表
   // { id: 'uuid', name: '部门名称', archivePath: '归档路径' }
   departments: [],